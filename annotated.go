@@ -0,0 +1,109 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// An AnnotatedStep is a sibling in an AnnotatedProof, tagged with the side
+// that it is on.
+type AnnotatedStep struct {
+	Hash []byte
+	Side SiblingSide
+}
+
+// An AnnotatedProof is a Proof whose siblings are tagged with the side that
+// they are on, so that it can be verified by folding the steps without any
+// index arithmetic. Index and NumLeaves are kept so that the proof can be
+// converted back into a Proof, but VerifyAnnotated does not use them.
+type AnnotatedProof struct {
+	Root      []byte
+	Data      []byte
+	Steps     []AnnotatedStep
+	Index     uint64
+	NumLeaves uint64
+}
+
+// AnnotateProof computes the side of every sibling of 'p' from its index and
+// number of leaves. An error is returned if VerifyProof would reject the proof
+// set for its length alone. Like VerifyProof, AnnotateProof accepts a proof
+// set that leaves out trailing siblings to the left of the largest complete
+// subtree containing the leaf, so that an annotated proof verifies if and only
+// if the original does.
+func AnnotateProof(p Proof) (AnnotatedProof, error) {
+	positions, err := ProofPositions(p.Index, p.Index+1, p.NumLeaves)
+	if err != nil {
+		return AnnotatedProof{}, err
+	}
+	min, max := proofLenRange(p.Index, p.NumLeaves)
+	if len(p.Set) < min {
+		return AnnotatedProof{}, ErrProofTooShort
+	} else if len(p.Set) > max {
+		return AnnotatedProof{}, ErrProofTooLong
+	}
+	ap := AnnotatedProof{
+		Root:      p.Root,
+		Data:      p.Set[0],
+		Steps:     make([]AnnotatedStep, len(p.Set)-1),
+		Index:     p.Index,
+		NumLeaves: p.NumLeaves,
+	}
+	for i := range ap.Steps {
+		ap.Steps[i] = AnnotatedStep{Hash: p.Set[i+1], Side: positions[i].Side}
+	}
+	return ap, nil
+}
+
+// Proof converts an annotated proof back into a Proof. An error is returned if
+// the sides of the steps are not the ones that AnnotateProof computes for the
+// index and number of leaves.
+func (ap AnnotatedProof) Proof() (Proof, error) {
+	positions, err := ProofPositions(ap.Index, ap.Index+1, ap.NumLeaves)
+	if err != nil {
+		return Proof{}, err
+	}
+	min, max := proofLenRange(ap.Index, ap.NumLeaves)
+	if len(ap.Steps) < min-1 || len(ap.Steps) > max-1 {
+		return Proof{}, errors.New("annotated proof has the wrong number of steps for its index")
+	}
+	set := make([][]byte, 1, len(ap.Steps)+1)
+	set[0] = ap.Data
+	for i, step := range ap.Steps {
+		if step.Side != positions[i].Side {
+			return Proof{}, errors.New("annotated proof step is on the wrong side for its index")
+		}
+		set = append(set, step.Hash)
+	}
+	return Proof{
+		Root:      ap.Root,
+		Set:       set,
+		Index:     ap.Index,
+		NumLeaves: ap.NumLeaves,
+	}, nil
+}
+
+// VerifyAnnotated returns true if folding the steps onto the leaf sum of
+// 'leafData' produces 'root'. Each step is hashed with the node built so far,
+// on the side that the step names. Steps whose hash is not the size of the
+// hash's output are rejected, as VerifyProof rejects them.
+func VerifyAnnotated(h hash.Hash, root []byte, leafData []byte, steps []AnnotatedStep) bool {
+	if root == nil {
+		return false
+	}
+	sum := leafSum(h, leafData)
+	for _, step := range steps {
+		if len(step.Hash) != h.Size() {
+			return false
+		}
+		switch step.Side {
+		case SiblingLeft:
+			sum = appendNodeSum(sum[:0], h, step.Hash, sum)
+		case SiblingRight:
+			sum = appendNodeSum(sum[:0], h, sum, step.Hash)
+		default:
+			return false
+		}
+	}
+	return bytes.Equal(sum, root)
+}
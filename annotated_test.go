@@ -0,0 +1,77 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// TestAnnotatedProof runs every proof of the MerkleTester, with every index
+// and number of leaves, through AnnotateProof and VerifyAnnotated, and checks
+// that the annotated proof verifies if and only if the original does.
+func TestAnnotatedProof(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	for i := 1; i < 17; i++ {
+		for j := 0; j < i; j++ {
+			for index := uint64(0); index < 18; index++ {
+				for numLeaves := uint64(0); numLeaves < 18; numLeaves++ {
+					p := Proof{Root: mt.roots[i], Set: mt.proofSets[i][j], Index: index, NumLeaves: numLeaves}
+					valid := p.Verify(sha256.New())
+					ap, err := AnnotateProof(p)
+					annotatedValid := err == nil && VerifyAnnotated(sha256.New(), ap.Root, ap.Data, ap.Steps)
+					if valid != annotatedValid {
+						t.Fatal("annotated proof disagrees with VerifyProof", i, j, index, numLeaves, valid)
+					}
+					if err != nil {
+						continue
+					}
+					back, err := ap.Proof()
+					if err != nil || !proofsEqual(back, p) {
+						t.Error("proof did not survive the annotation round trip", i, j, index, numLeaves, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestAnnotatedProofBadInputs checks that VerifyAnnotated and
+// AnnotatedProof.Proof reject corrupted steps.
+func TestAnnotatedProofBadInputs(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	ap, err := AnnotateProof(Proof{Root: mt.roots[15], Set: mt.proofSets[15][10], Index: 10, NumLeaves: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyAnnotated(sha256.New(), ap.Root, ap.Data, ap.Steps) {
+		t.Fatal("valid annotated proof did not verify")
+	}
+	if VerifyAnnotated(sha256.New(), nil, ap.Data, ap.Steps) {
+		t.Error("annotated proof verified against a nil root")
+	}
+	for i := range ap.Steps {
+		steps := append([]AnnotatedStep(nil), ap.Steps...)
+		steps[i].Side = 1 - steps[i].Side
+		if VerifyAnnotated(sha256.New(), ap.Root, ap.Data, steps) {
+			t.Error("annotated proof with a flipped side verified", i)
+		}
+		bad := ap
+		bad.Steps = steps
+		if _, err := bad.Proof(); err == nil {
+			t.Error("converted an annotated proof with a flipped side", i)
+		}
+		steps[i].Side = 2
+		if VerifyAnnotated(sha256.New(), ap.Root, ap.Data, steps) {
+			t.Error("annotated proof with an invalid side verified", i)
+		}
+		steps = append([]AnnotatedStep(nil), ap.Steps...)
+		steps[i].Hash = steps[i].Hash[:31]
+		if VerifyAnnotated(sha256.New(), ap.Root, ap.Data, steps) {
+			t.Error("annotated proof with a short hash verified", i)
+		}
+	}
+	bad := ap
+	bad.Steps = bad.Steps[1:]
+	if _, err := bad.Proof(); err == nil {
+		t.Error("converted an annotated proof with a missing step")
+	}
+}
@@ -0,0 +1,194 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// An AppendableCachedTree is a CachedTree that keeps every interior node of
+// the tree made of its cached roots. Appending a cached root costs O(log n)
+// hashes, and the root and a proof of any cached root can be built at any
+// time with O(log n) hashes, without pushing all of the cached roots again.
+// The retained nodes take O(n) memory.
+type AppendableCachedTree struct {
+	cachedNodeHeight uint64
+	hash             hash.Hash
+
+	// levels[k] holds the sums of the complete subtrees of height k, in
+	// order. levels[0] holds the cached roots.
+	levels [][][]byte
+
+	// nodeHook, if set, is called with every node that Append adds to the
+	// tree.
+	nodeHook func(height int, index uint64, sum []byte) error
+}
+
+// NewAppendableCachedTree creates an empty AppendableCachedTree. As with
+// CachedTree, every root appended to it is the root of a full tree of
+// 2^cachedNodeHeight leaves.
+func NewAppendableCachedTree(h hash.Hash, cachedNodeHeight uint64) *AppendableCachedTree {
+	return &AppendableCachedTree{
+		cachedNodeHeight: cachedNodeHeight,
+		hash:             h,
+	}
+}
+
+// LoadAppendableCachedTree recreates an AppendableCachedTree from levels that
+// were saved from Levels or from the nodes passed to a node hook. The shape of
+// the levels is checked, but the sums are not hashed again, so they must come
+// from a trusted source. The sums are shared with 'levels' rather than
+// copied.
+func LoadAppendableCachedTree(h hash.Hash, cachedNodeHeight uint64, levels [][][]byte) (*AppendableCachedTree, error) {
+	for k, level := range levels {
+		if k+1 < len(levels) && len(levels[k+1]) != len(level)/2 {
+			return nil, errors.New("level does not hold one node for every pair of nodes below it")
+		}
+		if k+1 == len(levels) && len(level) > 1 {
+			return nil, errors.New("top level holds nodes that should have been joined")
+		}
+		for _, sum := range level {
+			if len(sum) != h.Size() {
+				return nil, errors.New("node sum has the wrong size for the hash")
+			}
+		}
+	}
+	// The level slices are shared with 'levels', not copied. Their capacity
+	// is capped so that Append reallocates a level before adding to it,
+	// instead of writing into storage that the caller still holds.
+	act := &AppendableCachedTree{
+		cachedNodeHeight: cachedNodeHeight,
+		hash:             h,
+		levels:           make([][][]byte, len(levels)),
+	}
+	for k, level := range levels {
+		act.levels[k] = level[:len(level):len(level)]
+	}
+	return act, nil
+}
+
+// SetNodeHook sets a function that Append calls with every node it adds to
+// the tree, identified by its height and its index within that height, so
+// that the retained nodes can be persisted as they are created. If the hook
+// returns an error, Append returns it and the tree is left unchanged.
+func (act *AppendableCachedTree) SetNodeHook(hook func(height int, index uint64, sum []byte) error) {
+	act.nodeHook = hook
+}
+
+// Levels returns the retained nodes of the tree, where Levels()[k] holds the
+// sums of the complete subtrees of height k. The returned slices are shared
+// with the tree and must be treated as read-only.
+func (act *AppendableCachedTree) Levels() [][][]byte {
+	return act.levels
+}
+
+// NumLeaves returns the number of leaves in the tree. Every cached root counts
+// as 2^cachedNodeHeight leaves.
+func (act *AppendableCachedTree) NumLeaves() uint64 {
+	return act.numRoots() << act.cachedNodeHeight
+}
+
+// numRoots returns the number of cached roots in the tree.
+func (act *AppendableCachedTree) numRoots() uint64 {
+	if len(act.levels) == 0 {
+		return 0
+	}
+	return uint64(len(act.levels[0]))
+}
+
+// Append adds a cached root to the tree and returns the new Merkle root.
+func (act *AppendableCachedTree) Append(root []byte) ([]byte, error) {
+	if len(root) != act.hash.Size() {
+		return nil, errors.New("cached node root has the wrong size for the hash")
+	}
+
+	// Work out every node that the new root completes before changing the
+	// tree, so that a failing hook leaves the tree unchanged.
+	nodes := [][]byte{root}
+	index := act.numRoots()
+	for k := 0; index%2 == 1; k++ {
+		left := act.levels[k][index-1]
+		nodes = append(nodes, nodeSum(act.hash, left, nodes[k]))
+		index /= 2
+	}
+	if act.nodeHook != nil {
+		index := act.numRoots()
+		for k, sum := range nodes {
+			if err := act.nodeHook(k, index, sum); err != nil {
+				return nil, err
+			}
+			index /= 2
+		}
+	}
+	for k, sum := range nodes {
+		if k == len(act.levels) {
+			act.levels = append(act.levels, nil)
+		}
+		act.levels[k] = append(act.levels[k], sum)
+	}
+	return act.Root(), nil
+}
+
+// rangeSum returns the sum of the cached roots in [start, end), which must be
+// the range of a node of the tree.
+func (act *AppendableCachedTree) rangeSum(r nodeRange) []byte {
+	// Reading the retained levels can't fail.
+	sum, _ := nodeRangeSum(act.hash, r, func(level int, index uint64) ([]byte, error) {
+		return act.levels[level][index], nil
+	})
+	return sum
+}
+
+// nodeRangeSum returns the sum of the node covering 'r' in a tree whose
+// complete subtrees are read with 'get', by height and index within the
+// height. The node is either a complete subtree, which is read, or the orphan
+// made of every leaf from 'r.start' to the end of the tree, which is built
+// from the complete subtrees that cover it.
+func nodeRangeSum(h hash.Hash, r nodeRange, get func(level int, index uint64) ([]byte, error)) ([]byte, error) {
+	var sum []byte
+	end := r.end
+	for end > r.start {
+		// The rightmost complete subtree is the largest one that ends at
+		// 'end', is aligned to its size, and fits in the range.
+		size := end & -end
+		for size > end-r.start {
+			size /= 2
+		}
+		node, err := get(bits.TrailingZeros64(size), (end-size)/size)
+		if err != nil {
+			return nil, err
+		}
+		if sum == nil {
+			sum = node
+		} else {
+			sum = nodeSum(h, node, sum)
+		}
+		end -= size
+	}
+	return sum, nil
+}
+
+// Root returns the Merkle root of the tree, or nil if the tree is empty.
+func (act *AppendableCachedTree) Root() []byte {
+	if act.numRoots() == 0 {
+		return nil
+	}
+	return act.rangeSum(nodeRange{0, act.numRoots()})
+}
+
+// Prove returns the Merkle root and the cached level proof of the cached root
+// at index 'i', in the same form as RootCache.ProveCachedIndex: the first
+// element of the proof set is the cached root. See VerifyCachedProof for
+// combining it with the proof of a leaf within the cached node.
+func (act *AppendableCachedTree) Prove(i uint64) (merkleRoot []byte, cachedProof [][]byte, err error) {
+	if i >= act.numRoots() {
+		return nil, nil, ErrProofIndexOutOfRange
+	}
+	ranges := proofNodeRanges(i, act.numRoots())
+	cachedProof = make([][]byte, 0, len(ranges)+1)
+	cachedProof = append(cachedProof, act.levels[0][i])
+	for _, r := range ranges {
+		cachedProof = append(cachedProof, act.rangeSum(r))
+	}
+	return act.Root(), cachedProof, nil
+}
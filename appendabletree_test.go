@@ -0,0 +1,151 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestAppendableCachedTree appends cached roots one at a time, checking after
+// every append that the root matches a CachedTree built from scratch, and
+// that proofs match those of the Tree embedded in a CachedTree.
+func TestAppendableCachedTree(t *testing.T) {
+	numRoots := 3000
+	if testing.Short() {
+		numRoots = 300
+	}
+	act := NewAppendableCachedTree(sha256.New(), 2)
+	var roots [][]byte
+	for n := 1; n <= numRoots; n++ {
+		roots = append(roots, fastrand.Bytes(sha256.Size))
+		root, err := act.Append(roots[n-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := NewCachedTree(sha256.New(), 2)
+		for _, r := range roots {
+			ct.Push(r)
+		}
+		if !bytes.Equal(root, ct.Root()) || !bytes.Equal(act.Root(), ct.Root()) {
+			t.Fatal("root does not match a rebuilt CachedTree", n)
+		}
+		if act.NumLeaves() != uint64(n)<<2 {
+			t.Fatal("wrong number of leaves", n)
+		}
+
+		// Checking every proof after every append is cubic in the number of
+		// appends, so past small trees only a few proofs are checked.
+		indices := []uint64{0, uint64(n) / 2, uint64(n) - 1, fastrand.Uint64n(uint64(n))}
+		if n <= 70 {
+			indices = indices[:0]
+			for i := uint64(0); i < uint64(n); i++ {
+				indices = append(indices, i)
+			}
+		} else if n%97 != 0 {
+			continue
+		}
+		for _, i := range indices {
+			merkleRoot, cachedProof, err := act.Prove(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ct := NewCachedTree(sha256.New(), 0)
+			if err := ct.SetIndex(i); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range roots {
+				ct.Push(r)
+			}
+			expectedRoot, expectedProof, _, _ := ct.Tree.Prove()
+			if !bytes.Equal(merkleRoot, expectedRoot) || len(cachedProof) != len(expectedProof) {
+				t.Fatal("proof does not match a rebuilt CachedTree", n, i)
+			}
+			for j := range cachedProof {
+				if !bytes.Equal(cachedProof[j], expectedProof[j]) {
+					t.Fatal("proof set does not match a rebuilt CachedTree", n, i, j)
+				}
+			}
+		}
+	}
+
+	if _, _, err := act.Prove(uint64(numRoots)); err != ErrProofIndexOutOfRange {
+		t.Error("expected ErrProofIndexOutOfRange, got", err)
+	}
+	if _, err := act.Append(make([]byte, sha256.Size-1)); err == nil {
+		t.Error("able to append a root of the wrong size")
+	}
+	if NewAppendableCachedTree(sha256.New(), 0).Root() != nil {
+		t.Error("empty tree should have a nil root")
+	}
+}
+
+// TestAppendableCachedTreePersistence checkpoints a tree with the node hook
+// and with Levels, and checks that a tree loaded from either continues where
+// the original left off.
+func TestAppendableCachedTreePersistence(t *testing.T) {
+	act := NewAppendableCachedTree(sha256.New(), 0)
+	var saved [][][]byte
+	act.SetNodeHook(func(height int, index uint64, sum []byte) error {
+		if height == len(saved) {
+			saved = append(saved, nil)
+		}
+		if index != uint64(len(saved[height])) {
+			return errors.New("node hook called out of order")
+		}
+		saved[height] = append(saved[height], sum)
+		return nil
+	})
+	for i := 0; i < 37; i++ {
+		if _, err := act.Append(fastrand.Bytes(sha256.Size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, levels := range [][][][]byte{saved, act.Levels()} {
+		loaded, err := LoadAppendableCachedTree(sha256.New(), 0, levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded.Root(), act.Root()) {
+			t.Error("loaded tree has the wrong root")
+		}
+		next := fastrand.Bytes(sha256.Size)
+		loadedRoot, err := loaded.Append(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := NewCachedTree(sha256.New(), 0)
+		for _, r := range append(append([][]byte(nil), act.Levels()[0]...), next) {
+			ct.Push(r)
+		}
+		if !bytes.Equal(loadedRoot, ct.Root()) {
+			t.Error("loaded tree has the wrong root after an append")
+		}
+	}
+
+	// A failing hook leaves the tree unchanged.
+	root := act.Root()
+	act.SetNodeHook(func(int, uint64, []byte) error { return errors.New("disk full") })
+	if _, err := act.Append(fastrand.Bytes(sha256.Size)); err == nil {
+		t.Error("hook error was not returned")
+	}
+	if !bytes.Equal(act.Root(), root) || act.NumLeaves() != 37 {
+		t.Error("failed append changed the tree")
+	}
+
+	// Levels with the wrong shape are rejected.
+	levels := act.Levels()
+	bad := [][][][]byte{
+		{levels[0], levels[1][:len(levels[1])-1]},
+		{levels[0][:4], levels[1][:2]},
+		{{make([]byte, 31)}},
+	}
+	for i, levels := range bad {
+		if _, err := LoadAppendableCachedTree(sha256.New(), 0, levels); err == nil {
+			t.Error("loaded levels with the wrong shape", i)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"runtime"
+	"sync"
+)
+
+// minPairsPerWorker is the smallest number of node pairs that will be handed
+// to a worker goroutine. Levels with fewer pairs than this are hashed on the
+// calling goroutine, because the cost of coordination would exceed the cost of
+// hashing.
+const minPairsPerWorker = 256
+
+// A BatchHasher is a hash that can compute many independent sums at once,
+// typically faster than computing them one at a time. SumBatch returns the
+// hash of prefix || inputs[i] for every input, in order.
+//
+// BatchHasher is an optional interface: Tree.ReadAll, BuildFromLeaves and
+// BuildLevelsFromLeaves check whether the hash they were given implements it,
+// and fall back to hashing one input at a time if it does not. Tree.Push
+// hashes a single leaf, so it never batches.
+type BatchHasher interface {
+	SumBatch(prefix byte, inputs [][]byte) [][]byte
+}
+
+// BuildFromLeaves computes the Merkle root of a set of leaf hashes that are
+// already in memory. Rather than pushing the leaves one at a time, the tree is
+// built level by level from the bottom up, and the pairs of each level are
+// split between 'workers' goroutines. If 'workers' is less than 1, GOMAXPROCS
+// workers are used. Each worker gets its own hash from 'newHash'.
+//
+// An odd node at the end of a level is promoted to the next level unchanged,
+// which produces the same shape as the orphan handling in Tree. The result is
+// therefore identical to pushing each leaf hash into a Tree as a subtree of
+// height 0. The root of an empty set of leaves is nil.
+func BuildFromLeaves(newHash func() hash.Hash, leafHashes [][]byte, workers int) (root []byte, err error) {
+	// A single worker doesn't need the levels to be kept.
+	if workers == 1 {
+		return RootFromLeafHashes(newHash(), leafHashes)
+	}
+	levels, err := BuildLevelsFromLeaves(newHash, leafHashes, workers)
+	if err != nil || len(levels) == 0 {
+		return nil, err
+	}
+	return levels[len(levels)-1][0], nil
+}
+
+// BuildLevelsFromLeaves is the same as BuildFromLeaves, but returns every level
+// of the tree instead of only the root. levels[0] is the input leaf hashes and
+// the final level contains only the Merkle root. The interior levels can be
+// used to export cached subtree roots. No levels are returned for an empty set
+// of leaves.
+func BuildLevelsFromLeaves(newHash func() hash.Hash, leafHashes [][]byte, workers int) (levels [][][]byte, err error) {
+	if len(leafHashes) == 0 {
+		return nil, nil
+	}
+	if err := checkLeafHashSizes(leafHashes); err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	levels = append(levels, leafHashes)
+	hashes := []hash.Hash{newHash()}
+	for level := leafHashes; len(level) > 1; level = levels[len(levels)-1] {
+		next := make([][]byte, (len(level)+1)/2)
+		pairs := len(level) / 2
+
+		// Split the pairs of the level into contiguous ranges, one per worker.
+		// Every worker writes to a disjoint range of 'next', so no locking is
+		// needed.
+		n := pairs / minPairsPerWorker
+		if n > workers {
+			n = workers
+		}
+		if n < 2 {
+			joinLevel(hashes[0], level, next, 0, pairs)
+		} else {
+			for len(hashes) < n {
+				hashes = append(hashes, newHash())
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(h hash.Hash, start, end int) {
+					joinLevel(h, level, next, start, end)
+					wg.Done()
+				}(hashes[i], pairs*i/n, pairs*(i+1)/n)
+			}
+			wg.Wait()
+		}
+
+		// Promote the orphan, if there is one.
+		if len(level)%2 == 1 {
+			next[len(next)-1] = level[len(level)-1]
+		}
+		levels = append(levels, next)
+	}
+	return levels, nil
+}
+
+// RootFromLeafHashes computes the Merkle root of a set of leaf hashes that are
+// already in memory on the calling goroutine, folding each level into the
+// next from the bottom up. An odd node at the end of a level is promoted to
+// the next level unchanged, so the root is the same as the root of a Tree with
+// each leaf hash pushed as a subtree of height 0. 'leafHashes' is not
+// modified. The root of an empty set of leaves is nil. An error is returned
+// if the leaf hashes are not all the same size.
+func RootFromLeafHashes(h hash.Hash, leafHashes [][]byte) ([]byte, error) {
+	if len(leafHashes) == 0 {
+		return nil, nil
+	}
+	if err := checkLeafHashSizes(leafHashes); err != nil {
+		return nil, err
+	}
+	// Each level is written over the start of the one below it, which is
+	// safe because node i is written after leaves 2i and 2i+1 are read.
+	level := append([][]byte(nil), leafHashes...)
+	for len(level) > 1 {
+		next := level[:(len(level)+1)/2]
+		joinLevel(h, level, next, 0, len(level)/2)
+		if len(level)%2 == 1 {
+			next[len(next)-1] = level[len(level)-1]
+		}
+		level = next
+	}
+	return level[0], nil
+}
+
+// checkLeafHashSizes returns an error if the leaf hashes are not all the same
+// size.
+func checkLeafHashSizes(leafHashes [][]byte) error {
+	for _, leaf := range leafHashes {
+		if len(leaf) != len(leafHashes[0]) {
+			return errors.New("all leaf hashes must have the same size")
+		}
+	}
+	return nil
+}
+
+// BuildProofFromLeafHashes returns the proof set of the leaf at 'proofIndex'
+// in the tree of 'leafHashes', without its first element, which is the form
+// taken by VerifyProofWithLeafHash. The tree is built level by level from the
+// bottom up, promoting the odd node at the end of a level as BuildFromLeaves
+// does, and the sibling of the proven node is taken from each level that has
+// one. This avoids pushing the leaves through a Tree, which would hash the
+// leaf hashes again as leaf data.
+func BuildProofFromLeafHashes(h hash.Hash, leafHashes [][]byte, proofIndex uint64) ([][]byte, error) {
+	if proofIndex >= uint64(len(leafHashes)) {
+		return nil, ErrProofIndexOutOfRange
+	}
+	if err := checkLeafHashSizes(leafHashes); err != nil {
+		return nil, err
+	}
+
+	proofSet := make([][]byte, 0, proofLen(proofIndex, uint64(len(leafHashes)))-1)
+	level := leafHashes
+	for len(level) > 1 {
+		if sibling := proofIndex ^ 1; sibling < uint64(len(level)) {
+			proofSet = append(proofSet, level[sibling])
+		}
+		next := make([][]byte, (len(level)+1)/2)
+		joinLevel(h, level, next, 0, len(level)/2)
+		if len(level)%2 == 1 {
+			next[len(next)-1] = level[len(level)-1]
+		}
+		level = next
+		proofIndex /= 2
+	}
+	return proofSet, nil
+}
+
+// joinLevel combines the pairs [start, end) of 'level' into 'next'. If 'h' is
+// a BatchHasher, all of the pairs are hashed in a single batch.
+func joinLevel(h hash.Hash, level, next [][]byte, start, end int) {
+	if bh, ok := h.(BatchHasher); ok {
+		size := 2 * len(level[0])
+		buf := make([]byte, 0, (end-start)*size)
+		inputs := make([][]byte, end-start)
+		for i := range inputs {
+			buf = append(buf, level[2*(start+i)]...)
+			buf = append(buf, level[2*(start+i)+1]...)
+			inputs[i] = buf[i*size : (i+1)*size]
+		}
+		copy(next[start:end], bh.SumBatch(nodeHashPrefix[0], inputs))
+		return
+	}
+	for i := start; i < end; i++ {
+		next[i] = nodeSum(h, level[2*i], level[2*i+1])
+	}
+}
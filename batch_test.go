@@ -0,0 +1,373 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// sha256Batcher is a reference BatchHasher that wraps sha256. It counts the
+// calls to SumBatch and the inputs passed to it, so that tests can check that
+// batching happened. If
+// 'width' is greater than 1, SumBatch simulates a hasher that hashes 'width'
+// messages in parallel by splitting the inputs between 'width' goroutines.
+type sha256Batcher struct {
+	hash.Hash
+	width   int
+	batches int
+	hashed  int
+}
+
+// newSHA256Batcher returns a sha256Batcher with the given width.
+func newSHA256Batcher(width int) *sha256Batcher {
+	return &sha256Batcher{Hash: sha256.New(), width: width}
+}
+
+// SumBatch implements BatchHasher.
+func (sb *sha256Batcher) SumBatch(prefix byte, inputs [][]byte) [][]byte {
+	sb.batches++
+	sb.hashed += len(inputs)
+	sums := make([][]byte, len(inputs))
+	hashRange := func(start, end int) {
+		h := sha256.New()
+		for i := start; i < end; i++ {
+			h.Reset()
+			h.Write([]byte{prefix})
+			h.Write(inputs[i])
+			sums[i] = h.Sum(nil)
+		}
+	}
+	if sb.width <= 1 {
+		hashRange(0, len(inputs))
+		return sums
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < sb.width; i++ {
+		wg.Add(1)
+		go func(start, end int) {
+			hashRange(start, end)
+			wg.Done()
+		}(len(inputs)*i/sb.width, len(inputs)*(i+1)/sb.width)
+	}
+	wg.Wait()
+	return sums
+}
+
+// TestBuildFromLeaves compares the roots produced by BuildFromLeaves against
+// the roots produced by Tree for every tree size up to 300, and for a few
+// larger trees where the work is split between multiple workers.
+func TestBuildFromLeaves(t *testing.T) {
+	sizes := make([]int, 0, 305)
+	for i := 0; i <= 300; i++ {
+		sizes = append(sizes, i)
+	}
+	sizes = append(sizes, 1<<12, 1<<12+1, 5000, 12345)
+
+	for _, size := range sizes {
+		tree := New(sha256.New())
+		leafHashes := make([][]byte, size)
+		for i := range leafHashes {
+			data := []byte(strconv.Itoa(i))
+			tree.Push(data)
+			leafHashes[i] = leafSum(sha256.New(), data)
+		}
+
+		for _, workers := range []int{0, 1, 4} {
+			root, err := BuildFromLeaves(sha256.New, leafHashes, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(root, tree.Root()) {
+				t.Error("BuildFromLeaves root does not match Tree root for", size, workers)
+			}
+		}
+	}
+}
+
+// TestRootFromLeafHashes compares the roots produced by RootFromLeafHashes
+// against the roots produced by Tree for every tree size up to 300, and
+// against the roots of the MerkleTester.
+func TestRootFromLeafHashes(t *testing.T) {
+	for size := 0; size <= 300; size++ {
+		tree := New(sha256.New())
+		leafHashes := make([][]byte, size)
+		for i := range leafHashes {
+			data := []byte(strconv.Itoa(i))
+			tree.Push(data)
+			leafHashes[i] = leafSum(sha256.New(), data)
+		}
+		input := append([][]byte(nil), leafHashes...)
+		for _, h := range []hash.Hash{sha256.New(), newSHA256Batcher(4)} {
+			root, err := RootFromLeafHashes(h, leafHashes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(root, tree.Root()) {
+				t.Error("RootFromLeafHashes root does not match Tree root for", size)
+			}
+		}
+		for i := range input {
+			if !bytes.Equal(input[i], leafHashes[i]) {
+				t.Fatal("RootFromLeafHashes modified its input", size, i)
+			}
+		}
+	}
+
+	mt := CreateMerkleTester(t)
+	for size, root := range mt.roots {
+		if r, err := RootFromLeafHashes(sha256.New(), mt.leaves[:size]); err != nil || !bytes.Equal(r, root) {
+			t.Error("RootFromLeafHashes root does not match the MerkleTester root for", size, err)
+		}
+	}
+
+	leafHashes := [][]byte{make([]byte, 32), make([]byte, 31)}
+	if _, err := RootFromLeafHashes(sha256.New(), leafHashes); err == nil {
+		t.Error("expected an error for mismatched leaf hash sizes")
+	}
+}
+
+// TestBuildLevelsFromLeaves checks that the interior levels returned by
+// BuildLevelsFromLeaves are the roots of the corresponding subtrees.
+func TestBuildLevelsFromLeaves(t *testing.T) {
+	leafHashes := make([][]byte, 11)
+	for i := range leafHashes {
+		leafHashes[i] = leafSum(sha256.New(), []byte{byte(i)})
+	}
+	levels, err := BuildLevelsFromLeaves(sha256.New, leafHashes, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 5 {
+		t.Fatal("wrong number of levels:", len(levels))
+	}
+
+	// Every full node at height 2 should be the root of a 4 leaf subtree.
+	for i := 0; i < 2; i++ {
+		subTree := New(sha256.New())
+		for j := 0; j < 4; j++ {
+			subTree.Push([]byte{byte(4*i + j)})
+		}
+		if !bytes.Equal(levels[2][i], subTree.Root()) {
+			t.Error("interior node does not match subtree root at index", i)
+		}
+	}
+	if len(levels[4]) != 1 {
+		t.Error("final level should only contain the root")
+	}
+
+	// An empty set of leaves has no levels.
+	levels, err = BuildLevelsFromLeaves(sha256.New, nil, 1)
+	if err != nil || levels != nil {
+		t.Error("expected no levels for an empty set of leaves")
+	}
+}
+
+// TestBuildFromLeavesBadInputs checks that leaf hashes of different sizes are
+// rejected.
+func TestBuildFromLeavesBadInputs(t *testing.T) {
+	leafHashes := [][]byte{make([]byte, 32), make([]byte, 31)}
+	if _, err := BuildFromLeaves(sha256.New, leafHashes, 1); err == nil {
+		t.Error("expected an error for mismatched leaf hash sizes")
+	}
+}
+
+// TestBuildProofFromLeafHashes compares the proofs produced by
+// BuildProofFromLeafHashes against the proofs produced by Tree for every index
+// of every tree size up to 300.
+func TestBuildProofFromLeafHashes(t *testing.T) {
+	sizes := 300
+	if testing.Short() {
+		sizes = 70
+	}
+	for size := 1; size <= sizes; size++ {
+		leafHashes := make([][]byte, size)
+		for i := range leafHashes {
+			leafHashes[i] = leafSum(sha256.New(), []byte(strconv.Itoa(i)))
+		}
+		for index := 0; index < size; index++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(uint64(index)); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < size; i++ {
+				tree.Push([]byte(strconv.Itoa(i)))
+			}
+			root, proofSet, _, _ := tree.Prove()
+
+			siblings, err := BuildProofFromLeafHashes(sha256.New(), leafHashes, uint64(index))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(siblings) != len(proofSet)-1 {
+				t.Fatal("wrong proof length for", size, index)
+			}
+			for i := range siblings {
+				if !bytes.Equal(siblings[i], proofSet[i+1]) {
+					t.Fatal("proof does not match Tree proof for", size, index, i)
+				}
+			}
+			if !VerifyProofWithLeafHash(sha256.New(), root, leafHashes[index], siblings, uint64(index), uint64(size)) {
+				t.Fatal("proof does not verify for", size, index)
+			}
+		}
+	}
+
+	// A BatchHasher must produce the same proofs.
+	leafHashes := make([][]byte, 77)
+	for i := range leafHashes {
+		leafHashes[i] = leafSum(sha256.New(), []byte{byte(i)})
+	}
+	for _, index := range []uint64{0, 31, 64, 76} {
+		plain, err := BuildProofFromLeafHashes(sha256.New(), leafHashes, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batched, err := BuildProofFromLeafHashes(newSHA256Batcher(8), leafHashes, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range plain {
+			if !bytes.Equal(plain[i], batched[i]) {
+				t.Error("batched proof does not match", index, i)
+			}
+		}
+	}
+
+	if _, err := BuildProofFromLeafHashes(sha256.New(), leafHashes, 77); err != ErrProofIndexOutOfRange {
+		t.Error("wrong error for an index out of range", err)
+	}
+	if _, err := BuildProofFromLeafHashes(sha256.New(), nil, 0); err != ErrProofIndexOutOfRange {
+		t.Error("wrong error for an empty set of leaves", err)
+	}
+	if _, err := BuildProofFromLeafHashes(sha256.New(), [][]byte{make([]byte, 32), make([]byte, 31)}, 0); err == nil {
+		t.Error("expected an error for mismatched leaf hash sizes")
+	}
+}
+
+// TestBatchHasherReadAll checks that ReadAll produces the same roots and
+// proofs with a BatchHasher as without one, including when the proof index
+// falls inside a batch.
+func TestBatchHasherReadAll(t *testing.T) {
+	for _, numLeaves := range []int{0, 1, 63, 64, 65, 200} {
+		data := fastrand.Bytes(numLeaves * 16)
+		for _, proofIndex := range []int{0, numLeaves / 2, numLeaves - 1} {
+			if proofIndex < 0 {
+				continue
+			}
+			sb := newSHA256Batcher(1)
+			batched := New(sb)
+			plain := New(sha256.New())
+			if err := batched.SetIndex(uint64(proofIndex)); err != nil {
+				t.Fatal(err)
+			}
+			if err := plain.SetIndex(uint64(proofIndex)); err != nil {
+				t.Fatal(err)
+			}
+			if err := batched.ReadAll(bytes.NewReader(data), 16); err != nil {
+				t.Fatal(err)
+			}
+			if err := plain.ReadAll(bytes.NewReader(data), 16); err != nil {
+				t.Fatal(err)
+			}
+			if numLeaves > 1 && sb.batches == 0 {
+				t.Error("ReadAll did not use the BatchHasher", numLeaves)
+			}
+
+			// The leaf at the proof index is pushed on its own, and must not
+			// also be hashed and counted as part of a batch.
+			if batched.Stats() != plain.Stats() || batched.Stats().LeafHashes != uint64(numLeaves) {
+				t.Error("batched stats do not match", numLeaves, proofIndex, batched.Stats(), plain.Stats())
+			}
+			if numLeaves > 0 && sb.hashed != numLeaves-1 {
+				t.Error("BatchHasher hashed the wrong number of leaves", numLeaves, proofIndex, sb.hashed)
+			}
+
+			root, proofSet, _, _ := batched.Prove()
+			plainRoot, plainProofSet, _, _ := plain.Prove()
+			if !bytes.Equal(root, plainRoot) {
+				t.Error("batched root does not match", numLeaves, proofIndex)
+			}
+			if len(proofSet) != len(plainProofSet) {
+				t.Fatal("batched proof has the wrong length", numLeaves, proofIndex)
+			}
+			for i := range proofSet {
+				if !bytes.Equal(proofSet[i], plainProofSet[i]) {
+					t.Error("batched proof does not match", numLeaves, proofIndex, i)
+				}
+			}
+		}
+	}
+}
+
+// TestBatchHasherBuildFromLeaves checks that BuildFromLeaves produces the
+// same roots with a BatchHasher as without one.
+func TestBatchHasherBuildFromLeaves(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 100, 5000} {
+		leafHashes := make([][]byte, size)
+		for i := range leafHashes {
+			leafHashes[i] = leafSum(sha256.New(), []byte(strconv.Itoa(i)))
+		}
+		root, err := BuildFromLeaves(sha256.New, leafHashes, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, width := range []int{1, 8} {
+			batchedRoot, err := BuildFromLeaves(func() hash.Hash { return newSHA256Batcher(width) }, leafHashes, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(batchedRoot, root) {
+				t.Error("batched root does not match", size, width)
+			}
+		}
+	}
+}
+
+// benchmarkBuildFromLeaves builds a tree from 2^16 leaf hashes using the
+// provided number of workers.
+func benchmarkBuildFromLeaves(b *testing.B, workers int) {
+	leafHashes := make([][]byte, 1<<16)
+	for i := range leafHashes {
+		leafHashes[i] = leafSum(sha256.New(), []byte(strconv.Itoa(i)))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := BuildFromLeaves(sha256.New, leafHashes, workers)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBuildFromLeaves1 builds a tree from 2^16 leaf hashes on 1 worker.
+func BenchmarkBuildFromLeaves1(b *testing.B) { benchmarkBuildFromLeaves(b, 1) }
+
+// BenchmarkBuildFromLeaves2 builds a tree from 2^16 leaf hashes on 2 workers.
+func BenchmarkBuildFromLeaves2(b *testing.B) { benchmarkBuildFromLeaves(b, 2) }
+
+// BenchmarkBuildFromLeaves4 builds a tree from 2^16 leaf hashes on 4 workers.
+func BenchmarkBuildFromLeaves4(b *testing.B) { benchmarkBuildFromLeaves(b, 4) }
+
+// BenchmarkBuildFromLeaves8 builds a tree from 2^16 leaf hashes on 8 workers.
+func BenchmarkBuildFromLeaves8(b *testing.B) { benchmarkBuildFromLeaves(b, 8) }
+
+// BenchmarkReadAllBatch8 reads 4MB of data in 64 byte segments into a Tree
+// whose hash simulates an 8-wide BatchHasher.
+func BenchmarkReadAllBatch8(b *testing.B) {
+	data := fastrand.Bytes(1 << 22)
+	b.SetBytes(1 << 22)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree := New(newSHA256Batcher(8))
+		if err := tree.ReadAll(bytes.NewReader(data), 64); err != nil {
+			b.Fatal(err)
+		}
+		_ = tree.Root()
+	}
+}
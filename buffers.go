@@ -0,0 +1,71 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+)
+
+// bufferSegments splits the concatenation of 'bufs' into segments of
+// 'segmentSize' bytes and passes each of them to 'push'. The last segment may
+// be shorter. A segment that lies within a single buffer is passed as a slice
+// of that buffer; only segments that straddle buffers are copied.
+func bufferSegments(bufs [][]byte, segmentSize int, push func([]byte)) error {
+	if segmentSize <= 0 {
+		return errors.New("segment size must be positive")
+	}
+	var pending []byte
+	for _, buf := range bufs {
+		// Finish a segment that started in an earlier buffer.
+		if len(pending) > 0 {
+			n := segmentSize - len(pending)
+			if n > len(buf) {
+				n = len(buf)
+			}
+			pending = append(pending, buf[:n]...)
+			buf = buf[n:]
+			if len(pending) < segmentSize {
+				continue
+			}
+			push(pending)
+			pending = nil
+		}
+		for len(buf) >= segmentSize {
+			push(buf[:segmentSize:segmentSize])
+			buf = buf[segmentSize:]
+		}
+		if len(buf) > 0 {
+			pending = make([]byte, len(buf), segmentSize)
+			copy(pending, buf)
+		}
+	}
+	if len(pending) > 0 {
+		push(pending)
+	}
+	return nil
+}
+
+// BuffersRoot returns the same root as ReaderRoot over the concatenation of
+// 'bufs', without copying the data except for the segments that straddle two
+// or more buffers.
+func BuffersRoot(bufs [][]byte, h hash.Hash, segmentSize int) ([]byte, error) {
+	tree := New(h)
+	err := tree.pushSegments(func(push func([]byte)) error {
+		return bufferSegments(bufs, segmentSize, push)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree.Root(), nil
+}
+
+// BuildBuffersProof returns the same proof as BuildReaderProof over the
+// concatenation of 'bufs', without copying the data except for the segments
+// that straddle two or more buffers. The leaf data in the proof set may be a
+// slice of one of the buffers.
+func BuildBuffersProof(bufs [][]byte, h hash.Hash, segmentSize int, index uint64) (root []byte, proofSet [][]byte, numLeaves uint64, err error) {
+	return buildReaderProof(h, index, func(tree *Tree) error {
+		return tree.pushSegments(func(push func([]byte)) error {
+			return bufferSegments(bufs, segmentSize, push)
+		})
+	})
+}
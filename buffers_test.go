@@ -0,0 +1,109 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// splitBuffers splits 'data' into buffers at the given offsets, which may
+// repeat to produce empty buffers.
+func splitBuffers(data []byte, offsets ...int) [][]byte {
+	var bufs [][]byte
+	prev := 0
+	for _, off := range offsets {
+		bufs = append(bufs, data[prev:off])
+		prev = off
+	}
+	return append(bufs, data[prev:])
+}
+
+// TestBuffersRoot checks that BuffersRoot and BuildBuffersProof agree with
+// ReaderRoot and BuildReaderProof for adversarial splits of the data.
+func TestBuffersRoot(t *testing.T) {
+	segmentSize := 8
+	data := fastrand.Bytes(100)
+	oneByte := make([][]byte, len(data))
+	for i := range data {
+		oneByte[i] = data[i : i+1]
+	}
+	random := splitBuffers(data)
+	for len(random) < 20 {
+		last := random[len(random)-1]
+		off := fastrand.Intn(len(last) + 1)
+		random = append(random[:len(random)-1], last[:off], last[off:])
+	}
+	splits := [][][]byte{
+		{data},
+		oneByte,
+		splitBuffers(data, 8, 16, 24, 96),
+		splitBuffers(data, 0, 0, 8, 8, 50, 50, 100, 100),
+		splitBuffers(data, 7, 9, 15, 17),
+		random,
+		append([][]byte{nil, {}}, splitBuffers(data, 33)...),
+	}
+
+	root, err := ReaderRoot(bytes.NewReader(data), sha256.New(), segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, bufs := range splits {
+		for _, h := range []hash.Hash{sha256.New(), newSHA256Batcher(1)} {
+			r, err := BuffersRoot(bufs, h, segmentSize)
+			if err != nil || !bytes.Equal(r, root) {
+				t.Error("BuffersRoot does not match ReaderRoot", i, err)
+			}
+		}
+
+		for _, index := range []uint64{0, 5, 12} {
+			r, proofSet, numLeaves, err := BuildBuffersProof(bufs, sha256.New(), segmentSize, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, expected, expectedLeaves, err := BuildReaderProof(bytes.NewReader(data), sha256.New(), segmentSize, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(r, root) || numLeaves != expectedLeaves || len(proofSet) != len(expected) {
+				t.Fatal("BuildBuffersProof does not match BuildReaderProof", i, index)
+			}
+			for j := range proofSet {
+				if !bytes.Equal(proofSet[j], expected[j]) {
+					t.Error("proof set does not match BuildReaderProof", i, index, j)
+				}
+			}
+		}
+	}
+
+	// Empty inputs behave like an empty reader.
+	if r, err := BuffersRoot(nil, sha256.New(), segmentSize); err != nil || r != nil {
+		t.Error("expected a nil root for no buffers", r, err)
+	}
+	if _, err := BuffersRoot(splits[0], sha256.New(), 0); err == nil {
+		t.Error("accepted a segment size of 0")
+	}
+}
+
+// TestBufferSegmentsCopies checks that only segments straddling buffers are
+// copied.
+func TestBufferSegmentsCopies(t *testing.T) {
+	data := fastrand.Bytes(32)
+	var segments [][]byte
+	err := bufferSegments(splitBuffers(data, 8, 20), 8, func(segment []byte) {
+		segments = append(segments, segment)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 4 {
+		t.Fatal("wrong number of segments", len(segments))
+	}
+	for i, aliased := range []bool{true, true, false, true} {
+		if (&segments[i][0] == &data[8*i]) != aliased {
+			t.Error("segment was copied unnecessarily or not copied", i)
+		}
+	}
+}
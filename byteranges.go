@@ -0,0 +1,82 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"math"
+)
+
+// numSegments returns the number of leaves that 'totalBytes' bytes of data
+// split into segments of 'segmentSize' bytes make, counting a short final
+// segment as a leaf.
+func numSegments(totalBytes int64, segmentSize int) uint64 {
+	return uint64((totalBytes + int64(segmentSize) - 1) / int64(segmentSize))
+}
+
+// LeafRangeForBytes returns the range of leaves [leafBegin, leafEnd) that
+// holds the bytes [byteStart, byteEnd) of data that was split into leaves of
+// 'segmentSize' bytes, as ReaderRoot and BuildReaderProof do. 'totalBytes' is
+// the size of the data. The start is rounded down to the leaf that holds it,
+// and the end is rounded up to the end of the leaf that holds the last byte.
+// If the last byte is in the short final segment, leafEnd is the number of
+// leaves in the tree. An error is returned if the range is empty or is not
+// within the data.
+func LeafRangeForBytes(byteStart, byteEnd int64, segmentSize int, totalBytes int64) (leafBegin, leafEnd uint64, err error) {
+	if segmentSize <= 0 {
+		return 0, 0, errors.New("segment size must be positive")
+	}
+	if byteStart < 0 || byteStart >= byteEnd || byteEnd > totalBytes {
+		return 0, 0, errors.New("byte range is empty or not within the data")
+	}
+	leafBegin = uint64(byteStart / int64(segmentSize))
+	leafEnd = uint64((byteEnd-1)/int64(segmentSize)) + 1
+	return leafBegin, leafEnd, nil
+}
+
+// ByteRangeForLeaves is the inverse of LeafRangeForBytes, returning the range
+// of bytes [byteStart, byteEnd) held by the leaves [leafBegin, leafEnd). If the
+// range includes the short final segment, byteEnd is 'totalBytes'. An error
+// is returned if the range is empty or is not within the tree.
+func ByteRangeForLeaves(leafBegin, leafEnd uint64, segmentSize int, totalBytes int64) (byteStart, byteEnd int64, err error) {
+	if segmentSize <= 0 {
+		return 0, 0, errors.New("segment size must be positive")
+	}
+	if totalBytes < 0 || leafBegin >= leafEnd || leafEnd > numSegments(totalBytes, segmentSize) {
+		return 0, 0, errors.New("leaf range is empty or not within the tree")
+	}
+	byteStart = int64(leafBegin) * int64(segmentSize)
+	byteEnd = totalBytes
+	if leafEnd < numSegments(totalBytes, segmentSize) {
+		byteEnd = int64(leafEnd) * int64(segmentSize)
+	}
+	return byteStart, byteEnd, nil
+}
+
+// VerifyByteRange returns true if 'proof' is valid for 'root', and 'data' is
+// the bytes [byteStart, byteEnd) of the data that was split into leaves of
+// 'segmentSize' bytes to build the tree. A Proof covers a single leaf, so the
+// range must be within the leaf at proof.Index; it may start and end anywhere
+// inside it. Every leaf except the last must be exactly 'segmentSize' bytes,
+// and the last may be shorter, but not empty. False is returned if the range
+// is empty or is not within the proven leaf.
+func VerifyByteRange(h hash.Hash, root []byte, proof Proof, segmentSize int, byteStart, byteEnd int64, data []byte) bool {
+	if segmentSize <= 0 || len(proof.Set) == 0 || proof.Index >= proof.NumLeaves {
+		return false
+	}
+	leaf := proof.Set[0]
+	if len(leaf) > segmentSize || len(leaf) == 0 || (len(leaf) < segmentSize && proof.Index != proof.NumLeaves-1) {
+		return false
+	}
+	if proof.Index > uint64(math.MaxInt64/int64(segmentSize)) {
+		return false
+	}
+	leafStart := int64(proof.Index) * int64(segmentSize)
+	if byteStart < leafStart || byteStart >= byteEnd || byteEnd-leafStart > int64(len(leaf)) {
+		return false
+	}
+	if int64(len(data)) != byteEnd-byteStart || !bytes.Equal(data, leaf[byteStart-leafStart:byteEnd-leafStart]) {
+		return false
+	}
+	return VerifyProof(h, root, proof.Set, proof.Index, proof.NumLeaves)
+}
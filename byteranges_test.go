@@ -0,0 +1,194 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestLeafRangeForBytes checks LeafRangeForBytes and ByteRangeForLeaves
+// against a table that includes ranges ending in the short final segment.
+func TestLeafRangeForBytes(t *testing.T) {
+	tests := []struct {
+		byteStart, byteEnd  int64
+		totalBytes          int64
+		leafBegin, leafEnd  uint64
+		leafStart, leafStop int64
+	}{
+		// byteStart, byteEnd, totalBytes -> leaves -> bytes of those leaves
+		{0, 1, 100, 0, 1, 0, 8},
+		{0, 8, 100, 0, 1, 0, 8},
+		{0, 9, 100, 0, 2, 0, 16},
+		{7, 9, 100, 0, 2, 0, 16},
+		{8, 16, 100, 1, 2, 8, 16},
+		{95, 96, 100, 11, 12, 88, 96},
+		{96, 97, 100, 12, 13, 96, 100},
+		{90, 100, 100, 11, 13, 88, 100},
+		{99, 100, 100, 12, 13, 96, 100},
+		{0, 96, 96, 0, 12, 0, 96},
+		{0, 3, 3, 0, 1, 0, 3},
+	}
+	for i, test := range tests {
+		leafBegin, leafEnd, err := LeafRangeForBytes(test.byteStart, test.byteEnd, 8, test.totalBytes)
+		if err != nil || leafBegin != test.leafBegin || leafEnd != test.leafEnd {
+			t.Error("wrong leaf range", i, leafBegin, leafEnd, err)
+		}
+		byteStart, byteEnd, err := ByteRangeForLeaves(test.leafBegin, test.leafEnd, 8, test.totalBytes)
+		if err != nil || byteStart != test.leafStart || byteEnd != test.leafStop {
+			t.Error("wrong byte range", i, byteStart, byteEnd, err)
+		}
+	}
+
+	badBytes := [][3]int64{{0, 0, 100}, {5, 4, 100}, {-1, 4, 100}, {0, 101, 100}, {100, 101, 100}, {0, 1, 0}}
+	for i, b := range badBytes {
+		if _, _, err := LeafRangeForBytes(b[0], b[1], 8, b[2]); err == nil {
+			t.Error("accepted a bad byte range", i)
+		}
+	}
+	badLeaves := [][2]uint64{{0, 0}, {3, 2}, {0, 14}, {13, 14}}
+	for i, b := range badLeaves {
+		if _, _, err := ByteRangeForLeaves(b[0], b[1], 8, 100); err == nil {
+			t.Error("accepted a bad leaf range", i)
+		}
+	}
+	if _, _, err := ByteRangeForLeaves(0, 1, 8, 0); err == nil {
+		t.Error("accepted a leaf range of empty data")
+	}
+	if _, _, err := LeafRangeForBytes(0, 1, 0, 100); err == nil {
+		t.Error("accepted a segment size of 0")
+	}
+	if _, _, err := ByteRangeForLeaves(0, 1, -8, 100); err == nil {
+		t.Error("accepted a negative segment size")
+	}
+}
+
+// TestLeafRangeRoundTrip checks that converting a random leaf range to bytes
+// and back returns the same leaf range, and that the bytes of a leaf range
+// round up to the same leaf range from any byte range within it.
+func TestLeafRangeRoundTrip(t *testing.T) {
+	for i := 0; i < 10000; i++ {
+		segmentSize := fastrand.Intn(100) + 1
+		totalBytes := int64(fastrand.Intn(10000) + 1)
+		numLeaves := numSegments(totalBytes, segmentSize)
+		leafBegin := fastrand.Uint64n(numLeaves)
+		leafEnd := leafBegin + 1 + fastrand.Uint64n(numLeaves-leafBegin)
+
+		byteStart, byteEnd, err := ByteRangeForLeaves(leafBegin, leafEnd, segmentSize, totalBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		begin, end, err := LeafRangeForBytes(byteStart, byteEnd, segmentSize, totalBytes)
+		if err != nil || begin != leafBegin || end != leafEnd {
+			t.Fatal("leaf range did not survive a round trip", segmentSize, totalBytes, leafBegin, leafEnd, begin, end, err)
+		}
+
+		// Any byte range that starts in the first leaf and ends in the last
+		// leaf maps to the same leaf range.
+		firstEnd := byteStart + int64(segmentSize)
+		if firstEnd > byteEnd {
+			firstEnd = byteEnd
+		}
+		lastStart := int64(leafEnd-1) * int64(segmentSize)
+		lo := byteStart + int64(fastrand.Uint64n(uint64(firstEnd-byteStart)))
+		hi := byteEnd - int64(fastrand.Uint64n(uint64(byteEnd-lastStart)))
+		if hi <= lo {
+			hi = lo + 1
+		}
+		begin, end, err = LeafRangeForBytes(lo, hi, segmentSize, totalBytes)
+		if err != nil || begin != leafBegin || end != leafEnd {
+			t.Fatal("byte range within the leaves mapped to the wrong leaves", segmentSize, totalBytes, leafBegin, leafEnd, begin, end, err)
+		}
+	}
+}
+
+// TestVerifyByteRange checks VerifyByteRange for ranges that start and end
+// mid-leaf and at leaf boundaries, including in a short final leaf, and
+// checks that only mutations inside the requested range cause a failure.
+func TestVerifyByteRange(t *testing.T) {
+	const segmentSize = 8
+	file := fastrand.Bytes(5*segmentSize + 3)
+	proofFor := func(file []byte, index uint64) Proof {
+		root, proofSet, numLeaves, err := BuildReaderProof(bytes.NewReader(file), sha256.New(), segmentSize, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Proof{Root: root, Set: proofSet, Index: index, NumLeaves: numLeaves}
+	}
+
+	tests := []struct {
+		index      uint64
+		start, end int64
+	}{
+		{0, 0, 8},   // whole leaf
+		{0, 0, 1},   // first byte
+		{0, 3, 5},   // mid-leaf
+		{2, 16, 19}, // starts at a boundary
+		{2, 21, 24}, // ends at a boundary
+		{4, 39, 40}, // last byte of a full leaf
+		{5, 40, 43}, // whole short final leaf
+		{5, 41, 42}, // inside the short final leaf
+	}
+	for _, test := range tests {
+		proof := proofFor(file, test.index)
+		data := file[test.start:test.end]
+		if !VerifyByteRange(sha256.New(), proof.Root, proof, segmentSize, test.start, test.end, data) {
+			t.Fatal("valid byte range was rejected", test)
+		}
+
+		// Mutating a byte of the file only matters if it is in the range.
+		for i := range file {
+			mutated := append([]byte(nil), file...)
+			mutated[i]++
+			valid := VerifyByteRange(sha256.New(), proof.Root, proof, segmentSize, test.start, test.end, mutated[test.start:test.end])
+			if inRange := int64(i) >= test.start && int64(i) < test.end; valid == inRange {
+				t.Error("mutation changed the result incorrectly", test, i, inRange)
+			}
+		}
+	}
+
+	// Bad requests are rejected.
+	proof := proofFor(file, 5)
+	bad := []struct {
+		start, end int64
+		data       []byte
+	}{
+		{41, 41, nil},         // empty
+		{39, 42, file[39:42]}, // starts before the leaf
+		{41, 44, file[41:43]}, // past the end of the data
+		{41, 43, file[41:42]}, // too little data
+		{41, 42, file[41:43]}, // too much data
+		{-1, 42, nil},         // negative start
+		{43, 42, nil},         // reversed
+	}
+	for i, b := range bad {
+		if VerifyByteRange(sha256.New(), proof.Root, proof, segmentSize, b.start, b.end, b.data) {
+			t.Error("bad byte range was accepted", i)
+		}
+	}
+	if VerifyByteRange(sha256.New(), proof.Root, proof, 0, 41, 42, file[41:42]) {
+		t.Error("segment size of 0 was accepted")
+	}
+	if VerifyByteRange(sha256.New(), proof.Root, proof, 4, 41, 42, file[41:42]) {
+		t.Error("wrong segment size was accepted")
+	}
+
+	// A short leaf that is not the last leaf is rejected, even if the proof
+	// verifies.
+	tree := New(sha256.New())
+	if err := tree.SetIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	tree.Push(file[:8])
+	tree.Push(file[8:12])
+	tree.Push(file[12:20])
+	root, proofSet, _, numLeaves := tree.Prove()
+	short := Proof{Root: root, Set: proofSet, Index: 1, NumLeaves: numLeaves}
+	if !short.Verify(sha256.New()) {
+		t.Fatal("proof of a short leaf is invalid")
+	}
+	if VerifyByteRange(sha256.New(), short.Root, short, segmentSize, 8, 9, file[8:9]) {
+		t.Error("short leaf that is not the last leaf was accepted")
+	}
+}
@@ -1,8 +1,11 @@
 package merkletree
 
 import (
+	"bytes"
 	"errors"
 	"hash"
+	"io"
+	"math"
 )
 
 // A CachedTree can be used to build Merkle roots and proofs from the cached
@@ -16,8 +19,23 @@ type CachedTree struct {
 }
 
 // NewCachedTree initializes a CachedTree with a hash object, which will be
-// used when hashing the input.
+// used when hashing the input. It is the same as NewCachedTreeChecked, but
+// panics if the cached node height is greater than MaxHeight.
 func NewCachedTree(h hash.Hash, cachedNodeHeight uint64) *CachedTree {
+	ct, err := NewCachedTreeChecked(h, cachedNodeHeight)
+	if err != nil {
+		panic("wrong usage: cached node height is greater than MaxHeight")
+	}
+	return ct
+}
+
+// NewCachedTreeChecked initializes a CachedTree with a hash object, which
+// will be used when hashing the input. ErrHeightTooLarge is returned if the
+// cached node height is greater than MaxHeight.
+func NewCachedTreeChecked(h hash.Hash, cachedNodeHeight uint64) (*CachedTree, error) {
+	if cachedNodeHeight > MaxHeight {
+		return nil, ErrHeightTooLarge
+	}
 	return &CachedTree{
 		cachedNodeHeight: cachedNodeHeight,
 
@@ -26,7 +44,35 @@ func NewCachedTree(h hash.Hash, cachedNodeHeight uint64) *CachedTree {
 
 			cachedTree: true,
 		},
+	}, nil
+}
+
+// maxCachedNodes returns the number of cached nodes that the CachedTree can
+// hold before its number of leaves no longer fits in a uint64.
+func (ct *CachedTree) maxCachedNodes() uint64 {
+	return math.MaxUint64 >> ct.cachedNodeHeight
+}
+
+// Push adds the root of a cached node to the CachedTree. Every cached node
+// counts as 2^height leaves, so ErrHeightTooLarge is returned, and the
+// CachedTree is not modified, if the number of leaves would no longer fit in
+// a uint64.
+func (ct *CachedTree) Push(cachedRoot []byte) error {
+	if ct.currentIndex >= ct.maxCachedNodes() {
+		return ErrHeightTooLarge
+	}
+	ct.Tree.Push(cachedRoot)
+	return nil
+}
+
+// PushSubTree is the same as Tree.PushSubTree, where 'height' counts cached
+// nodes, so the subtree holds 2^height cached nodes. ErrHeightTooLarge is
+// returned if the number of leaves would no longer fit in a uint64.
+func (ct *CachedTree) PushSubTree(height int, sum []byte) error {
+	if height >= 0 && height <= MaxHeight && uint64(1)<<uint(height) > ct.maxCachedNodes()-ct.currentIndex {
+		return ErrHeightTooLarge
 	}
+	return ct.Tree.PushSubTree(height, sum)
 }
 
 // Prove will create a proof that the leaf at the indicated index is a part of
@@ -58,14 +104,193 @@ func (ct *CachedTree) Prove(cachedProofSet [][]byte) (merkleRoot []byte, proofSe
 	return merkleRoot, proofSet, ct.trueProofIndex, numLeaves
 }
 
+// ProveFromReader is the same as Prove, but builds the proof of the leaf within
+// its cached node itself, from the data of that cached node read from
+// 'chunkData' in segments of 'segmentSize' bytes. SetIndex must have been
+// called with the index of the leaf in the full tree, and the cached node
+// containing it must already have been pushed. An error is returned if the
+// data does not produce the cached root that was pushed.
+//
+// The data must hold exactly 2^height leaves, except when the cached node is
+// the last one pushed, in which case it may hold fewer. Such a final chunk
+// can be pushed as a cached root, because its root combines with the full
+// chunks before it the same way that its leaves would, and the returned
+// number of leaves only counts the leaves that it holds. When proving a leaf
+// of an earlier chunk, the CachedTree can't know that the final chunk is
+// partial, so the returned number of leaves counts it as full, as Prove does,
+// and the caller must substitute the true number of leaves.
+func (ct *CachedTree) ProveFromReader(chunkData io.Reader, segmentSize int) (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64, err error) {
+	if !ct.proofTree {
+		panic("wrong usage: can't call prove on a tree if SetIndex wasn't called")
+	}
+	if !ct.ProofReached() {
+		return nil, nil, 0, 0, errors.New("the cached node containing the proof index has not been pushed")
+	}
+
+	leavesPerCachedNode := uint64(1) << ct.cachedNodeHeight
+	inner := New(ct.hash)
+	if err := inner.SetIndex(ct.trueProofIndex % leavesPerCachedNode); err != nil {
+		return nil, nil, 0, 0, err
+	}
+	if err := inner.ReadAll(chunkData, segmentSize); err != nil {
+		return nil, nil, 0, 0, err
+	}
+	chunkLeaves := inner.CurrentIndex()
+	finalChunk := ct.proofIndex == ct.currentIndex-1
+	if chunkLeaves > leavesPerCachedNode || (chunkLeaves < leavesPerCachedNode && !finalChunk) {
+		return nil, nil, 0, 0, errors.New("chunk data has the wrong number of leaves for the cached node height")
+	}
+	innerRoot, innerProof, _, _ := inner.Prove()
+	if len(innerProof) == 0 {
+		return nil, nil, 0, 0, errors.New("chunk data ends before the proof index")
+	}
+	if !bytes.Equal(innerRoot, ct.proofSet[0]) {
+		return nil, nil, 0, 0, errors.New("chunk data does not match the cached root")
+	}
+
+	merkleRoot, proofSet, proofIndex, numLeaves = ct.Prove(innerProof)
+	numLeaves -= leavesPerCachedNode - chunkLeaves
+	return merkleRoot, proofSet, proofIndex, numLeaves, nil
+}
+
+// CurrentIndex returns the index that the next leaf will have, which is the
+// number of leaves represented by the cached nodes pushed so far. Every cached
+// node counts as 2^height leaves.
+func (ct *CachedTree) CurrentIndex() uint64 {
+	return ct.currentIndex << ct.cachedNodeHeight
+}
+
 // SetIndex will inform the CachedTree of the index of the leaf for which a
 // storage proof is being created. The index should be the index of the actual
 // leaf, and not the index of the cached element containing the leaf. SetIndex
 // must be called on empty CachedTree.
 func (ct *CachedTree) SetIndex(i uint64) error {
-	if ct.head != nil {
+	if !ct.IsEmpty() {
 		return errors.New("cannot call SetIndex on Tree if Tree has not been reset")
 	}
 	ct.trueProofIndex = i
 	return ct.Tree.SetIndex(i / (1 << ct.cachedNodeHeight))
 }
+
+// Reset returns the CachedTree to its empty state, the same as Tree.Reset,
+// and also clears the true proof index, so that a reset CachedTree behaves
+// the same as a new one. The cached node height is kept.
+func (ct *CachedTree) Reset() {
+	ct.Tree.Reset()
+	ct.trueProofIndex = 0
+}
+
+// VerifyCachedProof verifies a proof that was produced in two pieces, without
+// the caller having to splice them together as CachedTree.Prove does.
+// 'subProof' is the proof of the leaf within its cached node, as returned by
+// Tree.Prove on a tree of 2^cachedNodeHeight leaves. 'cachedProof' is the
+// proof of the cached node within the tree of cached nodes, as returned by
+// the Tree embedded in a CachedTree, so its first element is the cached node
+// root. 'proofIndex' and 'numLeaves' refer to the full tree. False is
+// returned if the pieces do not fit together, including when the root
+// produced by 'subProof' is not the root at the start of 'cachedProof'.
+func VerifyCachedProof(h hash.Hash, root []byte, subProof [][]byte, cachedProof [][]byte, cachedNodeHeight, proofIndex, numLeaves uint64) bool {
+	if cachedNodeHeight > MaxHeight || uint64(len(subProof)) != cachedNodeHeight+1 || len(cachedProof) < 1 {
+		return false
+	}
+	leavesPerCachedNode := uint64(1) << cachedNodeHeight
+	if numLeaves%leavesPerCachedNode != 0 {
+		return false
+	}
+
+	// The sub proof must produce the cached node root that the cached proof
+	// starts from.
+	cachedRoot, err := proofRoot(h, nil, subProof, proofIndex%leavesPerCachedNode, leavesPerCachedNode)
+	if err != nil || !bytes.Equal(cachedRoot, cachedProof[0]) {
+		return false
+	}
+
+	// Splice the pieces the same way as CachedTree.Prove, leaving out the
+	// cached node root.
+	proofSet := make([][]byte, 0, len(subProof)+len(cachedProof)-1)
+	proofSet = append(proofSet, subProof...)
+	proofSet = append(proofSet, cachedProof[1:]...)
+	return VerifyProof(h, root, proofSet, proofIndex, numLeaves)
+}
+
+// ExtendProof rebases a proof of a leaf within a chunk of the tree into a
+// proof of the same leaf within the whole tree, performing the same splicing
+// as CachedTree.Prove without needing a CachedTree. The chunk is the
+// 'chunkIndex'th run of 'innerNumLeaves' leaves, and 'innerNumLeaves' must be
+// a power of two. 'siblingRoots' are the siblings of the chunk root among the
+// chunk roots of the whole tree, which is the cached level proof returned by
+// the Tree embedded in a CachedTree without its first element. The root of
+// the returned proof is computed from the spliced proof set, so the caller
+// should compare it against the root it expects.
+func ExtendProof(h hash.Hash, innerProof [][]byte, innerIndex, innerNumLeaves uint64, chunkIndex uint64, siblingRoots [][]byte, totalLeaves uint64) (Proof, error) {
+	if innerNumLeaves == 0 || innerNumLeaves&(innerNumLeaves-1) != 0 {
+		return Proof{}, errors.New("number of leaves in a chunk must be a power of two")
+	}
+	if innerIndex >= innerNumLeaves {
+		return Proof{}, ErrProofIndexOutOfRange
+	}
+	if len(innerProof) != proofLen(innerIndex, innerNumLeaves) {
+		return Proof{}, errors.New("inner proof has the wrong length for the inner index")
+	}
+	chunkStart := chunkIndex * innerNumLeaves
+	if chunkStart/innerNumLeaves != chunkIndex || chunkStart+innerNumLeaves < chunkStart || chunkStart+innerNumLeaves > totalLeaves {
+		return Proof{}, errors.New("chunk is not inside the tree")
+	}
+	numChunks := (totalLeaves-1)/innerNumLeaves + 1
+	if len(siblingRoots) != proofLen(chunkIndex, numChunks)-1 {
+		return Proof{}, errors.New("wrong number of sibling roots for the chunk index")
+	}
+
+	proofSet := make([][]byte, 0, len(innerProof)+len(siblingRoots))
+	proofSet = append(proofSet, innerProof...)
+	proofSet = append(proofSet, siblingRoots...)
+	index := chunkStart + innerIndex
+	root, err := proofRoot(h, nil, proofSet, index, totalLeaves)
+	if err != nil {
+		return Proof{}, err
+	}
+	return Proof{
+		Root:      root,
+		Set:       proofSet,
+		Index:     index,
+		NumLeaves: totalLeaves,
+	}, nil
+}
+
+// NewTreeFromCache creates a Tree holding the cached nodes 'cachedRoots', each
+// the root of 2^cacheHeight leaves, positioned at the leaf after them. Raw
+// leaves pushed with Push or ReadAll follow the cached prefix, producing the
+// same root as pushing every leaf of the data into a new Tree. To prove a
+// leaf after the cached prefix, use NewProofTreeFromCache.
+func NewTreeFromCache(h hash.Hash, cacheHeight uint64, cachedRoots [][]byte) (*Tree, error) {
+	return newTreeFromCache(h, cacheHeight, cachedRoots, nil)
+}
+
+// NewProofTreeFromCache is the same as NewTreeFromCache, but the Tree proves
+// the leaf at 'proofIndex', which must come after the cached prefix.
+func NewProofTreeFromCache(h hash.Hash, cacheHeight uint64, cachedRoots [][]byte, proofIndex uint64) (*Tree, error) {
+	return newTreeFromCache(h, cacheHeight, cachedRoots, &proofIndex)
+}
+
+// newTreeFromCache pushes 'cachedRoots' into a new Tree, after setting the
+// proof index if 'proofIndex' is not nil.
+func newTreeFromCache(h hash.Hash, cacheHeight uint64, cachedRoots [][]byte, proofIndex *uint64) (*Tree, error) {
+	if h == nil {
+		return nil, errors.New("hash is nil")
+	}
+	if cacheHeight > MaxHeight {
+		return nil, ErrHeightTooLarge
+	}
+	t := New(h)
+	if proofIndex != nil {
+		if err := t.SetIndex(*proofIndex); err != nil {
+			return nil, err
+		}
+	}
+	for _, root := range cachedRoots {
+		if err := t.PushSubTree(int(cacheHeight), root); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
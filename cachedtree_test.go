@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"testing"
+
+	"github.com/NebulousLabs/fastrand"
 )
 
 // addSubTree will create a subtree of the desired height using the dataSeed to
@@ -358,3 +360,531 @@ func TestCachedTreeConstructionAuto(t *testing.T) {
 		}
 	}
 }
+
+// TestVerifyCachedProof mirrors TestCachedTreeConstructionAuto, but keeps the
+// proof within the cached node and the proof of the cached node separate, and
+// verifies them with VerifyCachedProof.
+func TestVerifyCachedProof(t *testing.T) {
+	maxNodes := uint64(20)
+	if testing.Short() {
+		maxNodes = 6
+	}
+	for h := uint64(0); h < 4; h++ {
+		n := uint64(1) << h
+		for i := uint64(1); i < maxNodes; i++ {
+			for j := uint64(0); j < i*n; j++ {
+				tree := New(sha256.New())
+				cachedTree := NewCachedTree(sha256.New(), h)
+				if err := cachedTree.SetIndex(j); err != nil {
+					t.Fatal(err)
+				}
+				var subProof [][]byte
+				for k := uint64(0); k < i; k++ {
+					subtree := addSubTree(h, []byte{byte(k)}, j%n, tree)
+					cachedTree.Push(subtree.Root())
+					if k == j/n {
+						_, subProof, _, _ = subtree.Prove()
+					}
+				}
+				root := tree.Root()
+				_, cachedProof, _, _ := cachedTree.Tree.Prove()
+				numLeaves := i * n
+
+				if !VerifyCachedProof(sha256.New(), root, subProof, cachedProof, h, j, numLeaves) {
+					t.Error("cached proof pieces did not verify", h, i, j)
+				}
+				if numLeaves > 1 && VerifyCachedProof(sha256.New(), root, subProof, cachedProof, h, (j+1)%numLeaves, numLeaves) {
+					t.Error("cached proof pieces verified for the wrong index", h, i, j)
+				}
+
+				// Pieces of the wrong length must fail cleanly.
+				if VerifyCachedProof(sha256.New(), root, subProof[:h], cachedProof, h, j, numLeaves) {
+					t.Error("short sub proof verified", h, i, j)
+				}
+				if VerifyCachedProof(sha256.New(), root, append(subProof[:len(subProof):len(subProof)], root), cachedProof, h, j, numLeaves) {
+					t.Error("long sub proof verified", h, i, j)
+				}
+				if VerifyCachedProof(sha256.New(), root, subProof, nil, h, j, numLeaves) {
+					t.Error("empty cached proof verified", h, i, j)
+				}
+				if len(cachedProof) > 1 && VerifyCachedProof(sha256.New(), root, subProof, cachedProof[:len(cachedProof)-1], h, j, numLeaves) {
+					t.Error("short cached proof verified", h, i, j)
+				}
+			}
+		}
+	}
+
+	// A cached node root that the sub proof doesn't produce must be rejected.
+	tree := New(sha256.New())
+	cachedTree := NewCachedTree(sha256.New(), 1)
+	if err := cachedTree.SetIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	subtree := addSubTree(1, []byte{0}, 1, tree)
+	cachedTree.Push(subtree.Root())
+	_, subProof, _, _ := subtree.Prove()
+	cachedProof := [][]byte{make([]byte, sha256.Size)}
+	if VerifyCachedProof(sha256.New(), tree.Root(), subProof, cachedProof, 1, 1, 2) {
+		t.Error("pieces with a mismatched cached node root verified")
+	}
+}
+
+// TestExtendProof builds proofs with ExtendProof and compares them byte for
+// byte against the proofs built by a CachedTree and by a Tree holding every
+// leaf, including trees whose final chunk is not full.
+func TestExtendProof(t *testing.T) {
+	leaf := func(i uint64) []byte { return []byte{byte(i), byte(i >> 8)} }
+	for height := uint64(0); height < 4; height++ {
+		n := uint64(1) << height
+		for numChunks := uint64(1); numChunks < 10; numChunks++ {
+			for _, extra := range []uint64{0, 1, n - 1} {
+				if extra >= n || (extra > 0 && n == 1) {
+					continue
+				}
+				totalLeaves := numChunks*n + extra
+				for index := uint64(0); index < numChunks*n; index++ {
+					chunkIndex, innerIndex := index/n, index%n
+
+					tree := New(sha256.New())
+					if err := tree.SetIndex(index); err != nil {
+						t.Fatal(err)
+					}
+					for i := uint64(0); i < totalLeaves; i++ {
+						tree.Push(leaf(i))
+					}
+					root, proofSet, _, _ := tree.Prove()
+
+					inner := New(sha256.New())
+					if err := inner.SetIndex(innerIndex); err != nil {
+						t.Fatal(err)
+					}
+					for i := chunkIndex * n; i < (chunkIndex+1)*n; i++ {
+						inner.Push(leaf(i))
+					}
+					_, innerProof, _, _ := inner.Prove()
+
+					// The outer siblings come from a CachedTree when the
+					// tree is made of full chunks, and from the full proof
+					// otherwise.
+					siblingRoots := proofSet[len(innerProof):]
+					var cachedProof [][]byte
+					if extra == 0 {
+						cachedTree := NewCachedTree(sha256.New(), height)
+						if err := cachedTree.SetIndex(index); err != nil {
+							t.Fatal(err)
+						}
+						for c := uint64(0); c < numChunks; c++ {
+							chunk := New(sha256.New())
+							for i := c * n; i < (c+1)*n; i++ {
+								chunk.Push(leaf(i))
+							}
+							cachedTree.Push(chunk.Root())
+						}
+						_, tail, _, _ := cachedTree.Tree.Prove()
+						siblingRoots = tail[1:]
+						_, cachedProof, _, _ = cachedTree.Prove(innerProof)
+					}
+
+					p, err := ExtendProof(sha256.New(), innerProof, innerIndex, n, chunkIndex, siblingRoots, totalLeaves)
+					if err != nil {
+						t.Fatal(err, height, numChunks, extra, index)
+					}
+					if !bytes.Equal(p.Root, root) || p.Index != index || p.NumLeaves != totalLeaves {
+						t.Error("extended proof has the wrong root or metadata", height, numChunks, extra, index)
+					}
+					if len(p.Set) != len(proofSet) {
+						t.Fatal("extended proof has the wrong length", height, numChunks, extra, index)
+					}
+					for i := range proofSet {
+						if !bytes.Equal(p.Set[i], proofSet[i]) {
+							t.Error("extended proof does not match the Tree proof", height, numChunks, extra, index, i)
+						}
+						if cachedProof != nil && !bytes.Equal(p.Set[i], cachedProof[i]) {
+							t.Error("extended proof does not match the CachedTree proof", height, numChunks, extra, index, i)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestExtendProofBadInputs checks that inconsistent inputs are rejected.
+func TestExtendProofBadInputs(t *testing.T) {
+	inner := New(sha256.New())
+	if err := inner.SetIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		inner.Push([]byte{byte(i)})
+	}
+	_, innerProof, _, _ := inner.Prove()
+	siblings := [][]byte{make([]byte, sha256.Size), make([]byte, sha256.Size)}
+
+	if _, err := ExtendProof(sha256.New(), innerProof, 1, 4, 1, siblings, 16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtendProof(sha256.New(), innerProof, 1, 3, 1, siblings, 16); err == nil {
+		t.Error("able to extend a proof from a chunk that is not a power of two")
+	}
+	if _, err := ExtendProof(sha256.New(), innerProof, 4, 4, 1, siblings, 16); err == nil {
+		t.Error("able to extend a proof with an inner index outside of the chunk")
+	}
+	if _, err := ExtendProof(sha256.New(), innerProof[:2], 1, 4, 1, siblings, 16); err == nil {
+		t.Error("able to extend an inner proof of the wrong length")
+	}
+	if _, err := ExtendProof(sha256.New(), innerProof, 1, 4, 4, siblings, 16); err == nil {
+		t.Error("able to extend a proof into a chunk outside of the tree")
+	}
+	if _, err := ExtendProof(sha256.New(), innerProof, 1, 4, 1, siblings[:1], 16); err == nil {
+		t.Error("able to extend a proof with too few sibling roots")
+	}
+	if _, err := ExtendProof(sha256.New(), innerProof, 1, 4, 1<<62, siblings, 16); err == nil {
+		t.Error("able to extend a proof with an overflowing chunk index")
+	}
+}
+
+// TestCachedTreeCurrentIndex checks that CurrentIndex of a CachedTree counts
+// leaves rather than cached nodes, and that ProofReached uses the true proof
+// index.
+func TestCachedTreeCurrentIndex(t *testing.T) {
+	ct := NewCachedTree(sha256.New(), 2)
+	if err := ct.SetIndex(5); err != nil {
+		t.Fatal(err)
+	}
+	ct.Push(make([]byte, 32))
+	if ct.CurrentIndex() != 4 || ct.ProofReached() {
+		t.Error("wrong state after one cached node")
+	}
+	ct.Push(make([]byte, 32))
+	if ct.CurrentIndex() != 8 || !ct.ProofReached() {
+		t.Error("wrong state after the cached node containing the proof index")
+	}
+}
+
+// TestCachedTreeProveFromReader checks that ProveFromReader produces the same
+// proofs as BuildReaderProof over the full data, including when the last
+// chunk, and the last segment, are partial.
+func TestCachedTreeProveFromReader(t *testing.T) {
+	segmentSize := 8
+	for h := uint64(0); h < 4; h++ {
+		chunkSize := segmentSize << h
+		for _, dataSize := range []int{chunkSize, 5 * chunkSize, 5*chunkSize + segmentSize, 6*chunkSize - 3} {
+			data := fastrand.Bytes(dataSize)
+			var chunks [][]byte
+			for i := 0; i < len(data); i += chunkSize {
+				end := i + chunkSize
+				if end > len(data) {
+					end = len(data)
+				}
+				chunks = append(chunks, data[i:end])
+			}
+			numLeaves := uint64((dataSize + segmentSize - 1) / segmentSize)
+
+			for j := uint64(0); j < numLeaves; j++ {
+				ct := NewCachedTree(sha256.New(), h)
+				if err := ct.SetIndex(j); err != nil {
+					t.Fatal(err)
+				}
+				for _, chunk := range chunks {
+					root, err := ReaderRoot(bytes.NewReader(chunk), sha256.New(), segmentSize)
+					if err != nil {
+						t.Fatal(err)
+					}
+					ct.Push(root)
+				}
+				chunk := chunks[j>>h]
+				root, proofSet, proofIndex, leaves, err := ct.ProveFromReader(bytes.NewReader(chunk), segmentSize)
+				if err != nil {
+					t.Fatal(h, dataSize, j, err)
+				}
+
+				expectedRoot, expectedProof, expectedLeaves, err := BuildReaderProof(bytes.NewReader(data), sha256.New(), segmentSize, j)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The number of leaves is only known to be short of the full
+				// chunks when the proof is within the final chunk.
+				if j>>h != uint64(len(chunks)-1) && leaves == uint64(len(chunks))<<h {
+					leaves = expectedLeaves
+				}
+				if !bytes.Equal(root, expectedRoot) || proofIndex != j || leaves != expectedLeaves || len(proofSet) != len(expectedProof) {
+					t.Fatal("proof does not match BuildReaderProof", h, dataSize, j)
+				}
+				for k := range proofSet {
+					if !bytes.Equal(proofSet[k], expectedProof[k]) {
+						t.Error("proof set does not match BuildReaderProof", h, dataSize, j, k)
+					}
+				}
+				if !VerifyProof(sha256.New(), root, proofSet, proofIndex, leaves) {
+					t.Error("proof does not verify", h, dataSize, j)
+				}
+			}
+		}
+	}
+}
+
+// TestCachedTreeProveFromReaderBadInputs checks that ProveFromReader rejects
+// chunk data that doesn't belong to the cached node containing the proof
+// index.
+func TestCachedTreeProveFromReaderBadInputs(t *testing.T) {
+	segmentSize := 8
+	chunkSize := segmentSize << 2
+	data := fastrand.Bytes(4 * chunkSize)
+	ct := NewCachedTree(sha256.New(), 2)
+	if err := ct.SetIndex(5); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := ct.ProveFromReader(bytes.NewReader(data[chunkSize:2*chunkSize]), segmentSize); err == nil {
+		t.Error("able to prove before the cached node was pushed")
+	}
+	for i := 0; i < len(data); i += chunkSize {
+		root, err := ReaderRoot(bytes.NewReader(data[i:i+chunkSize]), sha256.New(), segmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct.Push(root)
+	}
+
+	bad := [][]byte{
+		data[:chunkSize],
+		data[chunkSize : 2*chunkSize-1],
+		data[chunkSize : 2*chunkSize+segmentSize],
+		data[chunkSize : chunkSize+segmentSize],
+		nil,
+	}
+	for i, chunk := range bad {
+		if _, _, _, _, err := ct.ProveFromReader(bytes.NewReader(chunk), segmentSize); err == nil {
+			t.Error("able to prove with bad chunk data", i)
+		}
+	}
+	if _, _, _, _, err := ct.ProveFromReader(bytes.NewReader(data[chunkSize:2*chunkSize]), segmentSize); err != nil {
+		t.Error("unable to prove with the right chunk data:", err)
+	}
+}
+
+// TestCachedTreeReset checks that a CachedTree that is reset after building a
+// proof behaves the same as a new CachedTree.
+func TestCachedTreeReset(t *testing.T) {
+	roots := make([][]byte, 7)
+	for i := range roots {
+		roots[i] = sum(sha256.New(), []byte{byte(i)})
+	}
+	cachedProof := [][]byte{{1}, make([]byte, sha256.Size)}
+
+	used := NewCachedTree(sha256.New(), 2)
+	if err := used.SetIndex(21); err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range roots {
+		used.Push(root)
+	}
+	used.Prove(cachedProof)
+	used.Reset()
+	if !used.Equals(&NewCachedTree(sha256.New(), 2).Tree) || used.trueProofIndex != 0 {
+		t.Fatal("reset CachedTree is not empty")
+	}
+
+	// Without a new SetIndex, both trees refuse to prove.
+	fresh := NewCachedTree(sha256.New(), 2)
+	for _, ct := range []*CachedTree{used, fresh} {
+		ct.Push(roots[0])
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Prove without SetIndex did not panic")
+				}
+			}()
+			ct.Prove(cachedProof)
+		}()
+	}
+
+	// With a new SetIndex, both trees build the same proof.
+	used.Reset()
+	fresh = NewCachedTree(sha256.New(), 2)
+	for _, ct := range []*CachedTree{used, fresh} {
+		if err := ct.SetIndex(9); err != nil {
+			t.Fatal(err)
+		}
+		for _, root := range roots[:5] {
+			ct.Push(root)
+		}
+	}
+	root, proofSet, proofIndex, numLeaves := used.Prove(cachedProof)
+	freshRoot, freshSet, freshIndex, freshLeaves := fresh.Prove(cachedProof)
+	if !bytes.Equal(root, freshRoot) || proofIndex != freshIndex || numLeaves != freshLeaves || len(proofSet) != len(freshSet) {
+		t.Fatal("reset CachedTree built a different proof", proofIndex, freshIndex)
+	}
+	for i := range proofSet {
+		if !bytes.Equal(proofSet[i], freshSet[i]) {
+			t.Error("reset CachedTree built a different proof set", i)
+		}
+	}
+}
+
+// TestNewTreeFromCache checks that a Tree built from a cached prefix and then
+// given raw leaves matches a Tree given every leaf, and that it can prove the
+// raw leaves.
+func TestNewTreeFromCache(t *testing.T) {
+	for cacheHeight := uint64(0); cacheHeight < 4; cacheHeight++ {
+		leavesPerNode := 1 << cacheHeight
+		for numCached := 0; numCached < 7; numCached++ {
+			leaves := make([][]byte, numCached*leavesPerNode+11)
+			for i := range leaves {
+				leaves[i] = fastrand.Bytes(16)
+			}
+			var cachedRoots [][]byte
+			for c := 0; c < numCached; c++ {
+				chunk := New(sha256.New())
+				for _, leaf := range leaves[c*leavesPerNode : (c+1)*leavesPerNode] {
+					chunk.Push(leaf)
+				}
+				cachedRoots = append(cachedRoots, chunk.Root())
+			}
+			raw := leaves[numCached*leavesPerNode:]
+
+			tree, err := NewTreeFromCache(sha256.New(), cacheHeight, cachedRoots)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tree.CurrentIndex() != uint64(numCached*leavesPerNode) {
+				t.Error("tree is at the wrong index", cacheHeight, numCached, tree.CurrentIndex())
+			}
+			flat := New(sha256.New())
+			for i, leaf := range leaves {
+				flat.Push(leaf)
+				if i >= numCached*leavesPerNode {
+					tree.Push(leaf)
+				}
+			}
+			if !bytes.Equal(tree.Root(), flat.Root()) {
+				t.Error("root does not match the flat tree", cacheHeight, numCached)
+			}
+
+			proofIndex := uint64(numCached*leavesPerNode + fastrand.Intn(len(raw)))
+			proofTree, err := NewProofTreeFromCache(sha256.New(), cacheHeight, cachedRoots, proofIndex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, leaf := range raw {
+				proofTree.Push(leaf)
+			}
+			root, proofSet, _, numLeaves := proofTree.Prove()
+			if !VerifyProof(sha256.New(), flat.Root(), proofSet, proofIndex, numLeaves) || !bytes.Equal(root, flat.Root()) {
+				t.Error("proof of a raw leaf does not verify", cacheHeight, numCached, proofIndex)
+			}
+			if numCached > 0 {
+				if _, err := NewProofTreeFromCache(sha256.New(), cacheHeight, cachedRoots, 0); err == nil {
+					t.Error("proof index inside the cached prefix was accepted", cacheHeight, numCached)
+				}
+			}
+		}
+	}
+
+	if _, err := NewTreeFromCache(nil, 0, nil); err == nil {
+		t.Error("nil hash was accepted")
+	}
+	if _, err := NewTreeFromCache(sha256.New(), 64, nil); err == nil {
+		t.Error("cache height of 64 was accepted")
+	}
+	if _, err := NewTreeFromCache(sha256.New(), 2, [][]byte{make([]byte, 31)}); err == nil {
+		t.Error("short cached root was accepted")
+	}
+}
+
+// TestCachedTreeMaxHeight checks that a CachedTree can be created with a
+// cached node height of MaxHeight, but not greater, that NewCachedTreeChecked
+// returns ErrHeightTooLarge instead of panicking, and that the helpers
+// taking a cached node height reject heights past MaxHeight.
+func TestCachedTreeMaxHeight(t *testing.T) {
+	ct := NewCachedTree(sha256.New(), MaxHeight)
+	if err := ct.SetIndex(1<<MaxHeight + 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.Validate(); err != nil {
+		t.Error(err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a cached node height past MaxHeight")
+			}
+		}()
+		NewCachedTree(sha256.New(), MaxHeight+1)
+	}()
+	if _, err := NewCachedTreeChecked(sha256.New(), MaxHeight+1); err != ErrHeightTooLarge {
+		t.Error("wrong error for a cached node height past MaxHeight:", err)
+	}
+	if _, err := NewCachedTreeChecked(sha256.New(), MaxHeight); err != nil {
+		t.Error("cached node height of MaxHeight was rejected:", err)
+	}
+	if _, _, _, err := BuildCache(bytes.NewReader(nil), sha256.New(), 64, MaxHeight+1); err != ErrHeightTooLarge {
+		t.Error("wrong error for a cache height past MaxHeight:", err)
+	}
+
+	if _, err := NewTreeFromCache(sha256.New(), MaxHeight+1, nil); err != ErrHeightTooLarge {
+		t.Error("wrong error for a cache height past MaxHeight:", err)
+	}
+	if _, err := NewTreeFromCache(sha256.New(), MaxHeight, [][]byte{make([]byte, sha256.Size)}); err != nil {
+		t.Error("cache height of MaxHeight was rejected:", err)
+	}
+	if _, err := ReadAllSubtreeRoots(bytes.NewReader(nil), sha256.New(), 64, MaxHeight+1, nil); err != ErrHeightTooLarge {
+		t.Error("wrong error for a cache height past MaxHeight:", err)
+	}
+}
+
+// TestCachedTreeLeafOverflow checks that a CachedTree rejects the cached node
+// that would give it more leaves than a uint64 can count, and that Validate
+// rejects a CachedTree that holds one.
+func TestCachedTreeLeafOverflow(t *testing.T) {
+	root := make([]byte, sha256.Size)
+
+	// A tree of height 63 holds a single cached node.
+	ct := NewCachedTree(sha256.New(), MaxHeight)
+	if err := ct.SetIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.Push(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.Push(root); err != ErrHeightTooLarge {
+		t.Error("wrong error for a second cached node of height 63:", err)
+	}
+	if ct.CurrentIndex() != 1<<MaxHeight {
+		t.Error("wrong current index after a rejected push:", ct.CurrentIndex())
+	}
+	if err := ct.Validate(); err != nil {
+		t.Error(err)
+	}
+	ct.Tree.Push(root)
+	if err := ct.Validate(); err == nil {
+		t.Error("cached tree with too many leaves was accepted")
+	}
+
+	// A tree of height 62 holds three cached nodes.
+	ct = NewCachedTree(sha256.New(), MaxHeight-1)
+	if err := ct.SetIndex(3 << (MaxHeight - 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.PushSubTree(1, root); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.PushSubTree(0, root); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.Push(root); err != ErrHeightTooLarge {
+		t.Error("wrong error for a fourth cached node of height 62:", err)
+	}
+	if err := ct.PushSubTree(0, root); err != ErrHeightTooLarge {
+		t.Error("wrong error for a fourth cached node of height 62:", err)
+	}
+	if ct.CurrentIndex() != 3<<(MaxHeight-1) {
+		t.Error("wrong current index after a rejected push:", ct.CurrentIndex())
+	}
+	if err := ct.Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := NewCachedTree(sha256.New(), MaxHeight).PushSubTree(1, root); err != ErrHeightTooLarge {
+		t.Error("wrong error for a subtree of two cached nodes of height 63:", err)
+	}
+}
@@ -0,0 +1,254 @@
+// Command merkletree computes Merkle roots and proofs of files, and verifies
+// proofs, using the merkletree package, so that mismatched roots can be
+// debugged with exactly the code that produced them.
+//
+// Usage:
+//
+//	merkletree root [-seg 64] [-hash sha256] file
+//	merkletree prove [-seg 64] [-hash sha256] -index 12345 file > proof.bin
+//	merkletree verify [-root HEX] proof.bin
+//
+// Proofs are written in the protobuf encoding of the proofpb package, which
+// records the hash that built the tree. The exit code is 0 on success, 1 if a
+// proof does not verify, 2 if the command line is invalid, and 3 for any
+// other error.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/NebulousLabs/merkletree"
+	"github.com/NebulousLabs/merkletree/proofpb"
+)
+
+// The exit codes of the command.
+const (
+	exitOK      = 0
+	exitInvalid = 1
+	exitUsage   = 2
+	exitError   = 3
+)
+
+// hashes contains the hash functions that can be selected with -hash. The
+// names are the names used by proofpb.
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// usageError is returned by a command when its command line is invalid. The
+// flag package has already reported the problem if msg is empty.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// invalidProofError holds the reason that a proof did not verify, so that run
+// can tell a failed check apart from other errors.
+type invalidProofError struct {
+	check string
+}
+
+func (e invalidProofError) Error() string {
+	return "proof is invalid: " + e.check
+}
+
+// treeFlags are the flags shared by the commands that build a tree from a
+// file.
+type treeFlags struct {
+	segmentSize int
+	hashName    string
+}
+
+// register adds the tree flags to 'fs'.
+func (tf *treeFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&tf.segmentSize, "seg", 64, "size of each leaf in bytes")
+	fs.StringVar(&tf.hashName, "hash", "sha256", "hash function, sha256 or sha512")
+}
+
+// hash returns the hash selected by the flags.
+func (tf *treeFlags) hash() (hash.Hash, error) {
+	if tf.segmentSize <= 0 {
+		return nil, usageError{"-seg must be positive"}
+	}
+	newHash, ok := hashes[tf.hashName]
+	if !ok {
+		return nil, usageError{"unknown hash " + tf.hashName + ", use sha256 or sha512"}
+	}
+	return newHash(), nil
+}
+
+// parse parses 'args' with 'fs', and returns its single positional argument.
+func parse(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", usageError{}
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", usageError{}
+	}
+	return fs.Arg(0), nil
+}
+
+// rootCmd writes the hex encoded Merkle root of a file to 'stdout'.
+func rootCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("root", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var tf treeFlags
+	tf.register(fs)
+	filename, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	h, err := tf.hash()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	root, err := merkletree.ReaderRoot(f, h, tf.segmentSize)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, merkletree.RootHex(root))
+	return err
+}
+
+// proveCmd writes the encoded proof of a leaf of a file to 'stdout'.
+func proveCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("prove", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var tf treeFlags
+	tf.register(fs)
+	index := fs.Int64("index", -1, "index of the leaf to prove")
+	filename, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *index < 0 {
+		return usageError{"-index must be set to the index of a leaf"}
+	}
+	h, err := tf.hash()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	root, proofSet, numLeaves, err := merkletree.BuildReaderProof(f, h, tf.segmentSize, uint64(*index))
+	if err != nil {
+		return err
+	}
+	if len(proofSet) == 0 {
+		return errors.New("index is not within the file")
+	}
+	m, err := proofpb.ToProto(merkletree.Proof{
+		Root:      root,
+		Set:       proofSet,
+		Index:     uint64(*index),
+		NumLeaves: numLeaves,
+	}, tf.hashName)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(m.Marshal())
+	return err
+}
+
+// verifyCmd verifies an encoded proof, optionally against an expected root.
+func verifyCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	rootHex := fs.String("root", "", "hex encoded root that the proof must be for")
+	filename, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	var expectedRoot []byte
+	if *rootHex != "" {
+		expectedRoot, err = hex.DecodeString(*rootHex)
+		if err != nil {
+			return usageError{"-root is not valid hex"}
+		}
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var m proofpb.Proof
+	if err := m.Unmarshal(b); err != nil {
+		return invalidProofError{"decoding failed: " + err.Error()}
+	}
+	p, err := proofpb.FromProto(&m)
+	if err != nil {
+		return invalidProofError{"decoding failed: " + err.Error()}
+	}
+	newHash, ok := hashes[m.HashName]
+	if !ok {
+		return errors.New("proof uses unsupported hash " + m.HashName)
+	}
+	if expectedRoot != nil && !bytes.Equal(p.Root, expectedRoot) {
+		return invalidProofError{"proof root " + merkletree.RootHex(p.Root) + " does not match -root"}
+	}
+	if !p.Verify(newHash()) {
+		return invalidProofError{"proof set does not produce the proof root"}
+	}
+	_, err = fmt.Fprintln(stdout, "ok", merkletree.RootHex(p.Root), p.Index, p.NumLeaves)
+	return err
+}
+
+// commands contains the subcommands of the command, by name.
+var commands = map[string]func(args []string, stdout, stderr io.Writer) error{
+	"root":   rootCmd,
+	"prove":  proveCmd,
+	"verify": verifyCmd,
+}
+
+// run runs the command with the arguments 'args', not including the program
+// name, and returns its exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprintln(stderr, "usage: merkletree root|prove|verify [flags] file")
+		return exitUsage
+	}
+	err := commands[args[0]](args[1:], stdout, stderr)
+	switch err := err.(type) {
+	case nil:
+		return exitOK
+	case invalidProofError:
+		fmt.Fprintln(stderr, err)
+		return exitInvalid
+	case usageError:
+		if err.msg != "" {
+			fmt.Fprintln(stderr, "merkletree "+args[0]+":", err)
+		}
+		return exitUsage
+	}
+	fmt.Fprintln(stderr, "merkletree "+args[0]+":", err)
+	return exitError
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
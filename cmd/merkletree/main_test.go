@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+	"github.com/NebulousLabs/merkletree"
+)
+
+// runTest runs the command with 'args', returning its exit code and output.
+func runTest(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// writeTemp writes 'data' to a new file in 'dir' and returns its name.
+func writeTemp(t *testing.T, dir, name string, data []byte) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// TestRootProveVerify checks that the commands compute the same root as the
+// library, and that the proofs they write verify.
+func TestRootProveVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := fastrand.Bytes(64*37 + 13)
+	dataFile := writeTemp(t, dir, "data", data)
+
+	code, stdout, stderr := runTest("root", "-seg", "64", dataFile)
+	if code != exitOK {
+		t.Fatal(code, stderr)
+	}
+	root, _ := merkletree.ReaderRoot(bytes.NewReader(data), sha512.New(), 64)
+	if strings.TrimSpace(stdout) == merkletree.RootHex(root) {
+		t.Error("sha512 root returned by default")
+	}
+	code, stdout, _ = runTest("root", "-seg", "64", "-hash", "sha512", dataFile)
+	if code != exitOK || strings.TrimSpace(stdout) != merkletree.RootHex(root) {
+		t.Error("wrong root", code, stdout)
+	}
+
+	code, stdout, stderr = runTest("prove", "-seg", "64", "-hash", "sha512", "-index", "37", dataFile)
+	if code != exitOK {
+		t.Fatal(code, stderr)
+	}
+	proofFile := writeTemp(t, dir, "proof", []byte(stdout))
+	code, stdout, stderr = runTest("verify", "-root", merkletree.RootHex(root), proofFile)
+	if code != exitOK || !strings.HasPrefix(stdout, "ok ") {
+		t.Error("proof does not verify", code, stderr)
+	}
+	code, _, _ = runTest("verify", proofFile)
+	if code != exitOK {
+		t.Error("proof does not verify without -root", code)
+	}
+
+	// A different root or a corrupted proof must fail the check, and say which
+	// check failed.
+	code, _, stderr = runTest("verify", "-root", strings.Repeat("00", 64), proofFile)
+	if code != exitInvalid || !strings.Contains(stderr, "does not match -root") {
+		t.Error("wrong root accepted", code, stderr)
+	}
+	proof, _ := ioutil.ReadFile(proofFile)
+	proof[len(proof)/2]++
+	corruptFile := writeTemp(t, dir, "corrupt", proof)
+	code, _, stderr = runTest("verify", corruptFile)
+	if code != exitInvalid {
+		t.Error("corrupted proof accepted", code, stderr)
+	}
+	garbageFile := writeTemp(t, dir, "garbage", []byte{0xff})
+	code, _, stderr = runTest("verify", garbageFile)
+	if code != exitInvalid || !strings.Contains(stderr, "decoding failed") {
+		t.Error("garbage accepted", code, stderr)
+	}
+}
+
+// TestUsage checks the exit codes of invalid command lines and missing files.
+func TestUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dataFile := writeTemp(t, dir, "data", fastrand.Bytes(100))
+	missingFile := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, exitUsage},
+		{[]string{"unknown"}, exitUsage},
+		{[]string{"root"}, exitUsage},
+		{[]string{"root", "-seg", "0", dataFile}, exitUsage},
+		{[]string{"root", "-hash", "md5", dataFile}, exitUsage},
+		{[]string{"root", "-bogus", dataFile}, exitUsage},
+		{[]string{"prove", dataFile}, exitUsage},
+		{[]string{"verify", "-root", "zz", dataFile}, exitUsage},
+		{[]string{"root", missingFile}, exitError},
+		{[]string{"prove", "-index", "0", missingFile}, exitError},
+		{[]string{"prove", "-index", "2", dataFile}, exitError},
+		{[]string{"verify", missingFile}, exitError},
+	}
+	for i, test := range tests {
+		if code, _, _ := runTest(test.args...); code != test.code {
+			t.Error("wrong exit code", i, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// A ProofLink tells how a leaf of an outer tree commits to the root of an
+// inner tree, for proofs through a tree of trees.
+type ProofLink int
+
+// The ways that an outer leaf can commit to an inner root.
+const (
+	// LinkLeafData means that the data of the outer leaf is the inner root,
+	// so the outer leaf sum is H(0x00 || inner root), and the outer proof is
+	// a proof of that data as returned by Tree.Prove.
+	LinkLeafData ProofLink = iota
+
+	// LinkLeafHash means that the outer leaf sum is the inner root, so that
+	// the inner tree is a subtree of the outer tree, and the outer proof
+	// starts with the leaf sum, as returned by a Tree with
+	// ProveLeafHashOnly, or by the Tree embedded in a CachedTree.
+	LinkLeafHash
+)
+
+// verifyComposed checks that 'inner' is a valid proof of its root, that
+// 'outer' is a valid proof against 'root', and that the outer leaf commits to
+// the inner root as 'link' says.
+func verifyComposed(h hash.Hash, root []byte, inner, outer Proof, link ProofLink) error {
+	if !inner.Verify(h) {
+		return errors.New("inner proof does not verify")
+	}
+	if len(outer.Set) == 0 || !bytes.Equal(outer.Set[0], inner.Root) {
+		return errors.New("inner root is not the leaf of the outer proof")
+	}
+	switch link {
+	case LinkLeafData:
+		if !VerifyProof(h, root, outer.Set, outer.Index, outer.NumLeaves) {
+			return errors.New("outer proof does not verify")
+		}
+	case LinkLeafHash:
+		if !VerifyProofWithLeafHash(h, root, outer.Set[0], outer.Set[1:], outer.Index, outer.NumLeaves) {
+			return errors.New("outer proof does not verify")
+		}
+	default:
+		return errors.New("unknown proof link")
+	}
+	return nil
+}
+
+// VerifyComposed returns true if 'inner' proves a leaf of an inner tree whose
+// root is committed to by the leaf that 'outer' proves in the outer tree with
+// root 'root', the outer leaf committing to the inner root as 'link' says.
+// Each proof is checked against its own tree, so the trees may have any
+// number of leaves.
+func VerifyComposed(h hash.Hash, root []byte, inner, outer Proof, link ProofLink) bool {
+	return verifyComposed(h, root, inner, outer, link) == nil
+}
+
+// ComposeProofs flattens a proof through a tree of trees into a single proof
+// against the root of the outer tree. That is only possible with LinkLeafHash,
+// where the inner tree is a subtree of the outer tree, and only if every leaf
+// of the outer tree is the root of an inner tree with as many leaves as the
+// one that 'inner' belongs to, which must be a power of two, as with the
+// cached nodes of a CachedTree. The proofs can't show that the other inner
+// trees have that size, so the caller must know it. The index of the
+// composed proof counts the leaves of the inner trees, which are
+// outer.Index*inner.NumLeaves + inner.Index.
+//
+// An error is returned if either proof does not verify, if the inner root is
+// not the outer leaf, or if the proofs can't be flattened; proofs linked with
+// LinkLeafData must be checked with VerifyComposed instead.
+func ComposeProofs(h hash.Hash, inner, outer Proof, link ProofLink) (Proof, error) {
+	if err := verifyComposed(h, outer.Root, inner, outer, link); err != nil {
+		return Proof{}, err
+	}
+	if link != LinkLeafHash {
+		return Proof{}, errors.New("proofs linked by leaf data can't be flattened")
+	}
+	totalLeaves := outer.NumLeaves * inner.NumLeaves
+	if totalLeaves/inner.NumLeaves != outer.NumLeaves {
+		return Proof{}, errors.New("composed tree has too many leaves")
+	}
+	return ExtendProof(h, inner.Set, inner.Index, inner.NumLeaves, outer.Index, outer.Set[1:], totalLeaves)
+}
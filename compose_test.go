@@ -0,0 +1,132 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// composedTrees builds 'numInner' inner trees of 'innerLeaves' leaves and an
+// outer tree over their roots, linked as 'link' says, and returns the proof
+// of leaf 'innerIndex' of inner tree 'outerIndex', and of that tree's root in
+// the outer tree. It also returns a Tree over every inner leaf.
+func composedTrees(t *testing.T, numInner, innerLeaves, outerIndex, innerIndex int, link ProofLink) (inner, outer Proof, whole *Tree) {
+	// The Tree embedded in a CachedTree takes the inner roots as leaf sums.
+	whole = New(sha256.New())
+	outerTree := New(sha256.New())
+	if link == LinkLeafHash {
+		outerTree = &NewCachedTree(sha256.New(), 0).Tree
+	}
+	if err := outerTree.SetIndex(uint64(outerIndex)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < numInner; i++ {
+		innerTree := New(sha256.New())
+		if err := innerTree.SetIndex(uint64(innerIndex)); err != nil {
+			t.Fatal(err)
+		}
+		for _, leaf := range randomLeaves(innerLeaves, 16) {
+			innerTree.Push(leaf)
+			whole.Push(leaf)
+		}
+		root, proofSet, _, numLeaves := innerTree.Prove()
+		if i == outerIndex {
+			inner = Proof{Root: root, Set: proofSet, Index: uint64(innerIndex), NumLeaves: numLeaves}
+		}
+		outerTree.Push(root)
+	}
+	root, proofSet, _, numLeaves := outerTree.Prove()
+	outer = Proof{Root: root, Set: proofSet, Index: uint64(outerIndex), NumLeaves: numLeaves}
+	return inner, outer, whole
+}
+
+// TestComposeProofs checks composed proofs against a proof built from a Tree
+// over every inner leaf.
+func TestComposeProofs(t *testing.T) {
+	for _, test := range []struct {
+		numInner, innerLeaves, outerIndex, innerIndex int
+	}{
+		{1, 1, 0, 0},
+		{3, 4, 2, 1},
+		{5, 8, 0, 7},
+		{7, 2, 6, 0},
+		{16, 16, 9, 13},
+	} {
+		inner, outer, whole := composedTrees(t, test.numInner, test.innerLeaves, test.outerIndex, test.innerIndex, LinkLeafHash)
+		if !VerifyComposed(sha256.New(), outer.Root, inner, outer, LinkLeafHash) {
+			t.Fatal("composed proofs do not verify", test)
+		}
+		p, err := ComposeProofs(sha256.New(), inner, outer, LinkLeafHash)
+		if err != nil {
+			t.Fatal(err, test)
+		}
+		index := uint64(test.outerIndex*test.innerLeaves + test.innerIndex)
+		if !bytes.Equal(p.Root, whole.Root()) || p.Index != index || !p.Verify(sha256.New()) {
+			t.Error("composed proof is wrong", test)
+		}
+	}
+}
+
+// TestComposeProofsLeafData checks proofs linked by leaf data, which can be
+// verified but not flattened.
+func TestComposeProofsLeafData(t *testing.T) {
+	inner, outer, _ := composedTrees(t, 5, 3, 4, 2, LinkLeafData)
+	if !VerifyComposed(sha256.New(), outer.Root, inner, outer, LinkLeafData) {
+		t.Fatal("composed proofs do not verify")
+	}
+	if VerifyComposed(sha256.New(), outer.Root, inner, outer, LinkLeafHash) {
+		t.Error("proofs verify with the wrong link")
+	}
+	if _, err := ComposeProofs(sha256.New(), inner, outer, LinkLeafData); err == nil {
+		t.Error("proofs linked by leaf data flattened")
+	}
+	if VerifyComposed(sha256.New(), outer.Root, inner, outer, ProofLink(2)) {
+		t.Error("unknown link accepted")
+	}
+}
+
+// TestComposeProofsCorrupt checks that corrupting either proof, or the link
+// between them, is rejected.
+func TestComposeProofsCorrupt(t *testing.T) {
+	for _, link := range []ProofLink{LinkLeafData, LinkLeafHash} {
+		inner, outer, _ := composedTrees(t, 6, 4, 3, 1, link)
+		corrupt := func(set [][]byte, i int) [][]byte {
+			set = append([][]byte(nil), set...)
+			set[i] = append([]byte{}, set[i]...)
+			set[i][0]++
+			return set
+		}
+
+		// A corrupted inner sibling changes the inner root, so either the
+		// inner proof or the link fails.
+		badInner := inner
+		badInner.Set = corrupt(inner.Set, 1)
+		// The inner root no longer matches the outer leaf.
+		otherInner := inner
+		otherInner.Root = corrupt([][]byte{inner.Root}, 0)[0]
+		// The outer proof no longer proves the outer leaf.
+		badOuter := outer
+		badOuter.Set = corrupt(outer.Set, 1)
+		// The outer leaf is replaced along with the inner root, so the link
+		// holds but the outer proof fails.
+		relinked := outer
+		relinked.Set = append([][]byte{otherInner.Root}, outer.Set[1:]...)
+
+		for i, pair := range [][2]Proof{
+			{badInner, outer},
+			{otherInner, outer},
+			{inner, badOuter},
+			{otherInner, relinked},
+		} {
+			if VerifyComposed(sha256.New(), outer.Root, pair[0], pair[1], link) {
+				t.Error("corrupted proofs verify", link, i)
+			}
+			if _, err := ComposeProofs(sha256.New(), pair[0], pair[1], link); err == nil {
+				t.Error("corrupted proofs composed", link, i)
+			}
+		}
+		if VerifyComposed(sha256.New(), corrupt([][]byte{outer.Root}, 0)[0], inner, outer, link) {
+			t.Error("composed proofs verify against the wrong root", link)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// A nodeRange identifies a node of a Merkle tree by the range of leaves
+// [start, end) that it covers. Within a tree of a given size, every node
+// covers a different range.
+type nodeRange struct {
+	start, end uint64
+}
+
+// A proofNodeKind tells how a node of a proof set relates to the node built
+// from the elements of the proof set before it.
+type proofNodeKind int
+
+const (
+	// subtreeSibling is the other half of the complete subtree that holds
+	// the node built so far, on either side, and of the same size.
+	subtreeSibling proofNodeKind = iota
+
+	// orphanSibling is the node made of every leaf to the right of the
+	// largest complete subtree that holds the proof index. It is a right
+	// sibling, smaller than the node built so far.
+	orphanSibling
+
+	// outerSibling is one of the larger complete subtrees to the left of
+	// every leaf covered by the previous kinds. VerifyProof does not require
+	// them.
+	outerSibling
+)
+
+// walkProofNodes calls 'fn' with the range and kind of every node whose sum
+// is an element of the proof set of the leaf at 'proofIndex' in a tree with
+// 'numLeaves' leaves, in proof set order, so the i'th call is for
+// proofSet[i+1]. A node is the left sibling of the node built from the
+// previous elements if its range ends at or before 'proofIndex'. 'fn' is not
+// called if 'proofIndex' is not in the tree. This is the only derivation of
+// the order of a proof set outside of VerifyProof: proofNodeRanges,
+// proofLenRange, proofSides, ProofPositions and DescribeProof are built on it.
+func walkProofNodes(proofIndex, numLeaves uint64, fn func(r nodeRange, kind proofNodeKind)) {
+	if proofIndex >= numLeaves {
+		return
+	}
+
+	// While the subtree containing the proof index is complete, the sibling
+	// is the other half of that subtree.
+	start, end := proofIndex, proofIndex+1
+	for size := uint64(2); size != 0 && size <= numLeaves; size *= 2 {
+		// The subtree ends past the last leaf. 'subTreeStart'+'size' would
+		// overflow for the second half of a tree of more than 2^63 leaves.
+		subTreeStart := proofIndex / size * size
+		if size > numLeaves-subTreeStart {
+			break
+		}
+		half := size / 2
+		if proofIndex-subTreeStart < half {
+			fn(nodeRange{subTreeStart + half, subTreeStart + size}, subtreeSibling)
+		} else {
+			fn(nodeRange{subTreeStart, subTreeStart + half}, subtreeSibling)
+		}
+		start, end = subTreeStart, subTreeStart+size
+	}
+
+	// Every leaf to the right of the largest complete subtree has been
+	// combined into a single orphan, which is the right sibling.
+	if end != numLeaves {
+		fn(nodeRange{end, numLeaves}, orphanSibling)
+	}
+
+	// The remaining siblings are the larger subtrees to the left. Because
+	// 'start' is the sum of the sizes of those subtrees, the size of the
+	// closest one is the lowest set bit of 'start'.
+	for start > 0 {
+		size := start & -start
+		fn(nodeRange{start - size, start}, outerSibling)
+		start -= size
+	}
+}
+
+// proofNodeRanges returns the ranges of the nodes whose sums make up the
+// proof set of the leaf at 'proofIndex' in a tree with 'numLeaves' leaves, in
+// proof set order. ranges[i] is the node of proofSet[i+1]. A node is the left
+// sibling of the node built from the previous elements if its range ends at
+// or before 'proofIndex'. nil is returned if 'proofIndex' is not in the tree.
+func proofNodeRanges(proofIndex, numLeaves uint64) []nodeRange {
+	var ranges []nodeRange
+	walkProofNodes(proofIndex, numLeaves, func(r nodeRange, _ proofNodeKind) {
+		ranges = append(ranges, r)
+	})
+	return ranges
+}
+
+// CompressedProofs holds a set of proofs for leaves of the same Merkle tree,
+// storing every node that appears in more than one proof only once.
+type CompressedProofs struct {
+	Root      []byte
+	NumLeaves uint64
+
+	// Hashes is the table of node sums shared by the proofs.
+	Hashes [][]byte
+	Proofs []CompressedProof
+}
+
+// A CompressedProof is a proof within a CompressedProofs. Data is the leaf
+// data, and Refs holds, for every element of the proof set after the data, the
+// position of its sum in the Hashes table.
+type CompressedProof struct {
+	Index uint64
+	Data  []byte
+	Refs  []int
+}
+
+// CompressProofs compresses proofs for leaves of the same Merkle tree. Proof
+// set elements are deduplicated by the node of the tree they belong to, which
+// is computed from the index and the number of leaves, rather than by their
+// contents. All of the proofs must have the same root and number of leaves,
+// and each proof set must have the length expected for its index.
+func CompressProofs(proofs []Proof) (CompressedProofs, error) {
+	var c CompressedProofs
+	if len(proofs) == 0 {
+		return c, nil
+	}
+	c.Root = proofs[0].Root
+	c.NumLeaves = proofs[0].NumLeaves
+
+	table := make(map[nodeRange]int)
+	for _, p := range proofs {
+		if !bytes.Equal(p.Root, c.Root) || p.NumLeaves != c.NumLeaves {
+			return CompressedProofs{}, errors.New("proofs must all belong to the same tree")
+		}
+		if p.Index >= p.NumLeaves {
+			return CompressedProofs{}, errors.New("proof index is outside of the tree")
+		}
+		ranges := proofNodeRanges(p.Index, p.NumLeaves)
+		if len(p.Set) != len(ranges)+1 {
+			return CompressedProofs{}, errors.New("proof set has the wrong length for its index")
+		}
+
+		cp := CompressedProof{
+			Index: p.Index,
+			Data:  p.Set[0],
+			Refs:  make([]int, len(ranges)),
+		}
+		for i, r := range ranges {
+			ref, ok := table[r]
+			if !ok {
+				ref = len(c.Hashes)
+				table[r] = ref
+				c.Hashes = append(c.Hashes, p.Set[i+1])
+			} else if !bytes.Equal(c.Hashes[ref], p.Set[i+1]) {
+				return CompressedProofs{}, errors.New("proofs disagree on the sum of a node")
+			}
+			cp.Refs[i] = ref
+		}
+		c.Proofs = append(c.Proofs, cp)
+	}
+	return c, nil
+}
+
+// DecompressProofs expands compressed proofs back into the proofs that were
+// passed to CompressProofs.
+func DecompressProofs(c CompressedProofs) ([]Proof, error) {
+	var proofs []Proof
+	for _, cp := range c.Proofs {
+		if _, err := c.nodeRanges(cp); err != nil {
+			return nil, err
+		}
+		set := make([][]byte, 1, len(cp.Refs)+1)
+		set[0] = cp.Data
+		for _, ref := range cp.Refs {
+			set = append(set, c.Hashes[ref])
+		}
+		proofs = append(proofs, Proof{
+			Root:      c.Root,
+			Set:       set,
+			Index:     cp.Index,
+			NumLeaves: c.NumLeaves,
+		})
+	}
+	return proofs, nil
+}
+
+// nodeRanges returns the ranges of the nodes referenced by a compressed
+// proof, after checking that the proof has the number of references expected
+// for its index and that each of them is in the Hashes table.
+func (c CompressedProofs) nodeRanges(cp CompressedProof) ([]nodeRange, error) {
+	if cp.Index >= c.NumLeaves {
+		return nil, errors.New("proof index is outside of the tree")
+	}
+	ranges := proofNodeRanges(cp.Index, c.NumLeaves)
+	if len(cp.Refs) != len(ranges) {
+		return nil, errors.New("compressed proof has the wrong number of references for its index")
+	}
+	for _, ref := range cp.Refs {
+		if ref < 0 || ref >= len(c.Hashes) {
+			return nil, errors.New("compressed proof references a hash outside of the table")
+		}
+	}
+	return ranges, nil
+}
+
+// VerifyCompressed returns true if every proof in 'c' is valid, reading the
+// sibling sums directly from the Hashes table instead of expanding the proofs.
+// False is returned if 'c' holds no proofs, or if any sum in the table is not
+// the size of the hash's output, which VerifyProof would reject in an
+// expanded proof.
+func VerifyCompressed(h hash.Hash, c CompressedProofs) bool {
+	if c.Root == nil || len(c.Proofs) == 0 {
+		return false
+	}
+	for _, sibling := range c.Hashes {
+		if len(sibling) != h.Size() {
+			return false
+		}
+	}
+	var sum []byte
+	for _, cp := range c.Proofs {
+		ranges, err := c.nodeRanges(cp)
+		if err != nil {
+			return false
+		}
+		start := cp.Index
+		sum = appendLeafSum(sum[:0], h, cp.Data)
+		for i, r := range ranges {
+			sibling := c.Hashes[cp.Refs[i]]
+			if r.end <= start {
+				sum = appendNodeSum(sum[:0], h, sibling, sum)
+				start = r.start
+			} else {
+				sum = appendNodeSum(sum[:0], h, sum, sibling)
+			}
+		}
+		if !bytes.Equal(sum, c.Root) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// buildProofs builds a proof for each of 'indices' in a tree with 'numLeaves'
+// leaves.
+func buildProofs(t *testing.T, indices []uint64, numLeaves uint64) []Proof {
+	var proofs []Proof
+	for _, index := range indices {
+		tree := New(sha256.New())
+		if err := tree.SetIndex(index); err != nil {
+			t.Fatal(err)
+		}
+		for i := uint64(0); i < numLeaves; i++ {
+			tree.Push([]byte{byte(i), byte(i >> 8)})
+		}
+		root, proofSet, _, _ := tree.Prove()
+		proofs = append(proofs, Proof{Root: root, Set: proofSet, Index: index, NumLeaves: numLeaves})
+	}
+	return proofs
+}
+
+// TestProofNodeRanges checks that proofNodeRanges agrees with proofSides and
+// with the length of real proofs for every index of small trees.
+func TestProofNodeRanges(t *testing.T) {
+	for numLeaves := uint64(1); numLeaves < 70; numLeaves++ {
+		for index := uint64(0); index < numLeaves; index++ {
+			ranges := proofNodeRanges(index, numLeaves)
+			sides, ok := proofSides(len(ranges)+1, index, numLeaves)
+			if !ok || len(sides) != len(ranges) {
+				t.Fatal("proofNodeRanges has the wrong number of ranges", numLeaves, index)
+			}
+			start, end := index, index+1
+			for i, r := range ranges {
+				if (r.end == start) != sides[i] || (!sides[i] && r.start != end) {
+					t.Error("range is not adjacent on the expected side", numLeaves, index, i)
+				}
+				if r.start < start {
+					start = r.start
+				}
+				if r.end > end {
+					end = r.end
+				}
+			}
+			if start != 0 || end != numLeaves {
+				t.Error("ranges do not cover the tree", numLeaves, index)
+			}
+		}
+	}
+	if proofNodeRanges(5, 5) != nil {
+		t.Error("expected no ranges for an index outside of the tree")
+	}
+}
+
+// TestProofNodeRangesLarge checks the ranges of proofs in the second half of
+// trees of more than 2^63 leaves. Such a proof is the proof of the same leaf
+// within the second half, followed by the first half as a left sibling.
+func TestProofNodeRangesLarge(t *testing.T) {
+	const half = uint64(1) << 63
+	for _, m := range []uint64{1, 2, 5, 1000, half - 2, half - 1} {
+		for _, k := range []uint64{0, m / 2, m - 1} {
+			ranges := proofNodeRanges(half+k, half+m)
+			inner := proofNodeRanges(k, m)
+			if len(ranges) != len(inner)+1 {
+				t.Fatal("wrong number of ranges", m, k, len(ranges), len(inner))
+			}
+			for i, r := range inner {
+				if ranges[i] != (nodeRange{half + r.start, half + r.end}) {
+					t.Error("wrong range", m, k, i, ranges[i])
+				}
+			}
+			if ranges[len(inner)] != (nodeRange{0, half}) {
+				t.Error("first half is not the last sibling", m, k, ranges[len(inner)])
+			}
+			min, max := proofLenRange(half+k, half+m)
+			innerMin, innerMax := proofLenRange(k, m)
+			if min != innerMin || max != innerMax+1 {
+				t.Error("wrong proof length range", m, k, min, max)
+			}
+		}
+	}
+}
+
+// TestCompressProofs round trips clustered and scattered sets of proofs
+// through CompressProofs and DecompressProofs, and checks that the compressed
+// form verifies and is smaller.
+func TestCompressProofs(t *testing.T) {
+	numLeaves := uint64(1000)
+	clustered := make([]uint64, 100)
+	for i := range clustered {
+		clustered[i] = 400 + uint64(i)
+	}
+	scattered := make([]uint64, 100)
+	for i := range scattered {
+		scattered[i] = uint64(i) * 997 % numLeaves
+	}
+
+	for _, indices := range [][]uint64{clustered, scattered, {3}} {
+		proofs := buildProofs(t, indices, numLeaves)
+		c, err := CompressProofs(proofs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyCompressed(sha256.New(), c) {
+			t.Error("compressed proofs do not verify", len(indices))
+		}
+
+		totalHashes := 0
+		for _, p := range proofs {
+			totalHashes += len(p.Set) - 1
+		}
+		if len(indices) > 1 && len(c.Hashes) >= totalHashes {
+			t.Error("compression did not remove any hashes", len(c.Hashes), totalHashes)
+		}
+
+		decompressed, err := DecompressProofs(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decompressed) != len(proofs) {
+			t.Fatal("wrong number of decompressed proofs")
+		}
+		for i, p := range proofs {
+			d := decompressed[i]
+			if d.Index != p.Index || d.NumLeaves != p.NumLeaves || !bytes.Equal(d.Root, p.Root) || len(d.Set) != len(p.Set) {
+				t.Fatal("decompressed proof does not match", i)
+			}
+			for j := range p.Set {
+				if !bytes.Equal(d.Set[j], p.Set[j]) {
+					t.Error("decompressed proof set does not match", i, j)
+				}
+			}
+		}
+	}
+
+	// 100 adjacent leaves share almost all of their upper nodes, so the table
+	// should be far smaller than the combined proof sets.
+	proofs := buildProofs(t, clustered, numLeaves)
+	c, err := CompressProofs(proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Hashes)*4 > len(proofs)*(len(proofs[0].Set)-1) {
+		t.Error("clustered proofs compressed poorly:", len(c.Hashes))
+	}
+}
+
+// TestCompressProofsBadInputs checks that inconsistent proofs are rejected by
+// CompressProofs, and that corrupted compressed proofs are rejected by
+// DecompressProofs and VerifyCompressed.
+func TestCompressProofsBadInputs(t *testing.T) {
+	proofs := buildProofs(t, []uint64{2, 3, 9}, 13)
+
+	bad := append([]Proof(nil), proofs...)
+	bad[1].NumLeaves = 14
+	if _, err := CompressProofs(bad); err == nil {
+		t.Error("able to compress proofs of different trees")
+	}
+	bad = append([]Proof(nil), proofs...)
+	bad[1].Set = bad[1].Set[:len(bad[1].Set)-1]
+	if _, err := CompressProofs(bad); err == nil {
+		t.Error("able to compress a proof set with the wrong length")
+	}
+	bad = append([]Proof(nil), proofs...)
+	bad[1].Set = append([][]byte(nil), bad[1].Set...)
+	bad[1].Set[len(bad[1].Set)-1] = make([]byte, 32)
+	if _, err := CompressProofs(bad); err == nil {
+		t.Error("able to compress proofs that disagree on a node")
+	}
+
+	c, err := CompressProofs(proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyCompressed(sha256.New(), c) {
+		t.Fatal("compressed proofs do not verify")
+	}
+	c.Proofs[1].Refs[0] = len(c.Hashes)
+	if _, err := DecompressProofs(c); err == nil {
+		t.Error("able to decompress a reference outside of the table")
+	}
+	if VerifyCompressed(sha256.New(), c) {
+		t.Error("compressed proof with a bad reference verified")
+	}
+	c.Proofs[1].Refs = c.Proofs[1].Refs[1:]
+	if _, err := DecompressProofs(c); err == nil {
+		t.Error("able to decompress a proof with too few references")
+	}
+
+	c, _ = CompressProofs(proofs)
+	c.Proofs[0].Data = []byte{0}
+	if VerifyCompressed(sha256.New(), c) {
+		t.Error("compressed proof with corrupted data verified")
+	}
+
+	// Sums of the wrong size are rejected, as VerifyProof rejects them.
+	c, _ = CompressProofs(proofs)
+	c.Hashes[0] = append(c.Hashes[0], 0)
+	if VerifyCompressed(sha256.New(), c) {
+		t.Error("compressed proof with a long sum verified")
+	}
+	c, _ = CompressProofs(proofs)
+	c.Hashes = append(c.Hashes, make([]byte, 31))
+	if VerifyCompressed(sha256.New(), c) {
+		t.Error("compressed proofs with a short sum in the table verified")
+	}
+	if VerifyCompressed(sha256.New(), CompressedProofs{}) {
+		t.Error("empty compressed proofs verified")
+	}
+}
@@ -0,0 +1,118 @@
+package merkletree
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// A ProofElementRole tells what an element of a proof set is.
+type ProofElementRole int
+
+// The roles of the elements of a proof set.
+const (
+	// RoleLeafData is the data of the proven leaf, the first element.
+	RoleLeafData ProofElementRole = iota
+
+	// RoleLeftSibling is a complete subtree to the left of the node built
+	// from the elements before it.
+	RoleLeftSibling
+
+	// RoleRightSibling is a complete subtree to the right of the node built
+	// from the elements before it, of the same size as that node.
+	RoleRightSibling
+
+	// RoleOrphan is the node made of every leaf to the right of the largest
+	// complete subtree that holds the proven leaf. It is smaller than the
+	// node built from the elements before it, and is on its right.
+	RoleOrphan
+)
+
+// String returns the name of the role, as used in descriptions of proofs.
+func (r ProofElementRole) String() string {
+	switch r {
+	case RoleLeafData:
+		return "leaf data"
+	case RoleLeftSibling:
+		return "left sibling"
+	case RoleRightSibling:
+		return "right sibling"
+	case RoleOrphan:
+		return "orphan aggregate"
+	}
+	return "unknown role " + strconv.Itoa(int(r))
+}
+
+// A ProofElementInfo describes an element of a proof set: its position in the
+// set, its role, the leaves [Start, End) of the node it is the sum of, and
+// the height of that node. The height is that of a NodePosition, the lowest
+// height whose nodes cover the range.
+type ProofElementInfo struct {
+	Element    int
+	Role       ProofElementRole
+	Start, End uint64
+	Height     int
+}
+
+// String describes the element in a line, such as "element 3 = left sibling
+// covering leaves [8,16) at height 3".
+func (e ProofElementInfo) String() string {
+	what := "covering leaves"
+	if e.Role == RoleLeafData {
+		what = "of leaf"
+	}
+	return "element " + strconv.Itoa(e.Element) + " = " + e.Role.String() + " " + what +
+		" [" + strconv.FormatUint(e.Start, 10) + "," + strconv.FormatUint(e.End, 10) + ")" +
+		" at height " + strconv.Itoa(e.Height)
+}
+
+// A ProofDescription describes every element of a proof set, in order.
+type ProofDescription []ProofElementInfo
+
+// String describes the elements of the proof set, one per line.
+func (d ProofDescription) String() string {
+	lines := make([]string, len(d))
+	for i, e := range d {
+		lines[i] = e.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DescribeProof describes what each element of the proof set of 'p' is
+// supposed to be, given its index and number of leaves, in the order that
+// Tree.Prove returns them. The sums in the proof set are not read, so the
+// description of a proof that fails to verify says what its elements should
+// have been. An error is returned if the index is not in the tree, or if the
+// proof set does not have the length that Tree.Prove produces.
+func DescribeProof(p Proof) (ProofDescription, error) {
+	if p.Index >= p.NumLeaves {
+		return nil, ErrProofIndexOutOfRange
+	}
+	if len(p.Set) != proofLen(p.Index, p.NumLeaves) {
+		return nil, errors.New("proof set has the wrong length for its index")
+	}
+	d := make(ProofDescription, 0, len(p.Set))
+	d = append(d, ProofElementInfo{
+		Element: 0,
+		Role:    RoleLeafData,
+		Start:   p.Index,
+		End:     p.Index + 1,
+	})
+	walkProofNodes(p.Index, p.NumLeaves, func(r nodeRange, kind proofNodeKind) {
+		e := ProofElementInfo{
+			Element: len(d),
+			Role:    RoleRightSibling,
+			Start:   r.start,
+			End:     r.end,
+			Height:  bits.Len64(r.end - r.start - 1),
+		}
+		if r.end <= p.Index {
+			e.Role = RoleLeftSibling
+		} else if kind == orphanSibling {
+			e.Role = RoleOrphan
+		}
+		d = append(d, e)
+	})
+	return d, nil
+}
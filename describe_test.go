@@ -0,0 +1,84 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// TestDescribeProof checks the descriptions of every proof of small trees
+// against the nodes of a FullTree, and against ProofPositions.
+func TestDescribeProof(t *testing.T) {
+	ft := NewFull(sha256.New())
+	leaves := randomLeaves(40, 16)
+	for n := 1; n <= len(leaves); n++ {
+		if err := ft.Push(leaves[n-1]); err != nil {
+			t.Fatal(err)
+		}
+		for index := uint64(0); index < uint64(n); index++ {
+			root, proofSet, err := ft.ProveIndex(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := DescribeProof(Proof{Root: root, Set: proofSet, Index: index, NumLeaves: uint64(n)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			positions, err := ProofPositions(index, index+1, uint64(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(d) != len(proofSet) || d[0].Role != RoleLeafData || d[0].Start != index {
+				t.Fatal("wrong description", n, index)
+			}
+
+			// Every sibling must be the sum of the node it describes, and
+			// folding the siblings on the sides they describe must give the
+			// root.
+			sum := LeafSum(sha256.New(), proofSet[0])
+			for i, e := range d[1:] {
+				node, err := ft.rangeSum(sha256.New(), nil, nodeRange{e.Start, e.End})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e.Element != i+1 || !bytes.Equal(node, proofSet[i+1]) {
+					t.Fatal("element is not the node it describes", n, index, i+1)
+				}
+				if e.Height != positions[i].Height || (e.Role == RoleLeftSibling) != (positions[i].Side == SiblingLeft) {
+					t.Fatal("description does not match the position", n, index, i+1)
+				}
+				if e.Role == RoleLeftSibling {
+					sum = nodeSum(sha256.New(), proofSet[i+1], sum)
+				} else {
+					sum = nodeSum(sha256.New(), sum, proofSet[i+1])
+				}
+			}
+			if !bytes.Equal(sum, root) {
+				t.Fatal("described sides do not give the root", n, index)
+			}
+		}
+	}
+}
+
+// TestDescribeProofString checks the rendering of a description, and the
+// errors of DescribeProof.
+func TestDescribeProofString(t *testing.T) {
+	p := Proof{Set: make([][]byte, 4), Index: 4, NumLeaves: 7}
+	d, err := DescribeProof(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "element 0 = leaf data of leaf [4,5) at height 0\n" +
+		"element 1 = right sibling covering leaves [5,6) at height 0\n" +
+		"element 2 = orphan aggregate covering leaves [6,7) at height 0\n" +
+		"element 3 = left sibling covering leaves [0,4) at height 2"
+	if d.String() != expected {
+		t.Error("wrong description:\n" + d.String())
+	}
+	if _, err := DescribeProof(Proof{Set: make([][]byte, 3), Index: 4, NumLeaves: 7}); err == nil {
+		t.Error("short proof described")
+	}
+	if _, err := DescribeProof(Proof{Set: make([][]byte, 1), Index: 7, NumLeaves: 7}); err != ErrProofIndexOutOfRange {
+		t.Error("wrong error for an index out of range", err)
+	}
+}
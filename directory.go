@@ -0,0 +1,139 @@
+//go:build go1.16
+// +build go1.16
+
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// FileLeaf returns the leaf of the file at 'path' in the tree built by
+// DirectoryRoot: the length of the path as an 8 byte little-endian integer,
+// the path, the root of the file's data, and the size of the file as an 8
+// byte little-endian integer. The root of an empty file is empty.
+func FileLeaf(path string, fileRoot []byte, fileSize int64) []byte {
+	leaf := make([]byte, 8, 8+len(path)+len(fileRoot)+8)
+	binary.LittleEndian.PutUint64(leaf, uint64(len(path)))
+	leaf = append(leaf, path...)
+	leaf = append(leaf, fileRoot...)
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(fileSize))
+	return append(leaf, size[:]...)
+}
+
+// directoryFiles returns the paths of the regular files in 'fsys', sorted as
+// strings. An error is returned for any file that is not a regular file or a
+// directory, including symlinks.
+func directoryFiles(fsys fs.FS) ([]string, error) {
+	var paths []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+		case d.Type().IsRegular():
+			paths = append(paths, path)
+		default:
+			return errors.New("directory holds " + path + ", which is not a regular file or a directory")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// countingReader counts the bytes read from an io.Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// fileLeaf reads the file at 'path' and returns its leaf.
+func fileLeaf(fsys fs.FS, path string, h hash.Hash, segmentSize int) ([]byte, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cr := &countingReader{r: f}
+	fileRoot, err := ReaderRoot(cr, h, segmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return FileLeaf(path, fileRoot, cr.n), nil
+}
+
+// DirectoryRoot returns the Merkle root of the regular files in 'fsys'. Every
+// file is one leaf, as returned by FileLeaf with the root of its data from
+// ReaderRoot, and the leaves are ordered by path, sorted byte-wise as
+// strings, so "a.txt" comes before "a/b". Paths are the slash-separated paths
+// of io/fs, relative to the root of 'fsys'. Directories only contribute the
+// files in them, so an empty directory has no effect on the root, and a
+// directory without any files has a nil root. Symlinks are not followed: a
+// symlink, or any other file that is not a regular file or a directory,
+// causes an error, so that the root never depends on what a link points to.
+func DirectoryRoot(fsys fs.FS, h func() hash.Hash, segmentSize int) ([]byte, error) {
+	paths, err := directoryFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+	tree := New(h())
+	for _, path := range paths {
+		leaf, err := fileLeaf(fsys, path, h(), segmentSize)
+		if err != nil {
+			return nil, err
+		}
+		tree.Push(leaf)
+	}
+	return tree.Root(), nil
+}
+
+// ProveFile returns a proof that the file at 'path' is part of the root
+// returned by DirectoryRoot. The first element of the proof set is the leaf
+// of the file, as returned by FileLeaf, so a verifier that knows the path,
+// root and size of the file can check it with ProofContains and Verify. An
+// error wrapping fs.ErrNotExist is returned if 'path' is not a regular file in
+// 'fsys'.
+func ProveFile(fsys fs.FS, h func() hash.Hash, segmentSize int, path string) (Proof, error) {
+	paths, err := directoryFiles(fsys)
+	if err != nil {
+		return Proof{}, err
+	}
+	index := sort.SearchStrings(paths, path)
+	if index == len(paths) || paths[index] != path {
+		return Proof{}, &fs.PathError{Op: "prove", Path: path, Err: fs.ErrNotExist}
+	}
+	tree := New(h())
+	if err := tree.SetIndex(uint64(index)); err != nil {
+		return Proof{}, err
+	}
+	for _, path := range paths {
+		leaf, err := fileLeaf(fsys, path, h(), segmentSize)
+		if err != nil {
+			return Proof{}, err
+		}
+		tree.Push(leaf)
+	}
+	root, proofSet, proofIndex, numLeaves := tree.Prove()
+	return Proof{
+		Root:      root,
+		Set:       proofSet,
+		Index:     proofIndex,
+		NumLeaves: numLeaves,
+	}, nil
+}
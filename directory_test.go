@@ -0,0 +1,109 @@
+//go:build go1.16
+// +build go1.16
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// testDirectory returns a small directory with nested files, an empty file
+// and an empty directory.
+func testDirectory() fstest.MapFS {
+	return fstest.MapFS{
+		"a.txt":      {Data: []byte("hello, world")},
+		"a/b":        {Data: bytes.Repeat([]byte{7}, 200)},
+		"a/c/d":      {Data: []byte{1, 2, 3}},
+		"empty":      {Data: nil},
+		"emptydir":   {Mode: fs.ModeDir},
+		"z/last.bin": {Data: bytes.Repeat([]byte{9}, 64)},
+	}
+}
+
+// TestDirectoryRoot checks DirectoryRoot against a root built by hand, and
+// pins it.
+func TestDirectoryRoot(t *testing.T) {
+	fsys := testDirectory()
+	root, err := DirectoryRoot(fsys, sha256.New, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are sorted byte-wise, so "a.txt" comes before "a/b".
+	tree := New(sha256.New())
+	for _, path := range []string{"a.txt", "a/b", "a/c/d", "empty", "z/last.bin"} {
+		fileRoot, err := ReaderRoot(bytes.NewReader(fsys[path].Data), sha256.New(), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.Push(FileLeaf(path, fileRoot, int64(len(fsys[path].Data))))
+	}
+	if !bytes.Equal(root, tree.Root()) {
+		t.Error("directory root does not match the root built by hand")
+	}
+	golden := "d0a8f791bb777d2d0ad145778765c5578ae5b07574daf627ad3e952eac19e1dd"
+	if hex.EncodeToString(root) != golden {
+		t.Error("directory root changed", hex.EncodeToString(root))
+	}
+
+	// An empty directory has no effect, and a directory without files has a
+	// nil root.
+	delete(fsys, "emptydir")
+	if root2, err := DirectoryRoot(fsys, sha256.New, 64); err != nil || !bytes.Equal(root2, root) {
+		t.Error("empty directory changed the root", err)
+	}
+	if root, err := DirectoryRoot(fstest.MapFS{"d": {Mode: fs.ModeDir}}, sha256.New, 64); err != nil || root != nil {
+		t.Error("directory without files has a root", root, err)
+	}
+
+	// Renaming or changing a file changes the root.
+	fsys["a/c/e"] = fsys["a/c/d"]
+	delete(fsys, "a/c/d")
+	if root2, err := DirectoryRoot(fsys, sha256.New, 64); err != nil || bytes.Equal(root2, root) {
+		t.Error("renaming a file did not change the root", err)
+	}
+
+	// Symlinks are rejected.
+	fsys = testDirectory()
+	fsys["link"] = &fstest.MapFile{Data: []byte("a.txt"), Mode: fs.ModeSymlink}
+	if _, err := DirectoryRoot(fsys, sha256.New, 64); err == nil {
+		t.Error("symlink was accepted")
+	}
+}
+
+// TestProveFile checks that the proof of every file verifies against the
+// directory root and holds the leaf of the file.
+func TestProveFile(t *testing.T) {
+	fsys := testDirectory()
+	root, err := DirectoryRoot(fsys, sha256.New, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, file := range fsys {
+		if file.Mode.IsDir() {
+			continue
+		}
+		proof, err := ProveFile(fsys, sha256.New, 64, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileRoot, _ := ReaderRoot(bytes.NewReader(file.Data), sha256.New(), 64)
+		if !bytes.Equal(proof.Root, root) || !proof.Verify(sha256.New()) {
+			t.Error("proof does not verify", path)
+		}
+		if !ProofContains(sha256.New(), proof, FileLeaf(path, fileRoot, int64(len(file.Data)))) {
+			t.Error("proof does not hold the leaf of the file", path)
+		}
+	}
+	for _, path := range []string{"missing", "a", "emptydir"} {
+		if _, err := ProveFile(fsys, sha256.New, 64, path); !errors.Is(err, fs.ErrNotExist) {
+			t.Error("proved a path that is not a file", path, err)
+		}
+	}
+}
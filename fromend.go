@@ -0,0 +1,114 @@
+package merkletree
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// fromEndState is the state kept by a Tree that proves the k-th leaf from the
+// end, see SetIndexFromEnd.
+type fromEndState struct {
+	k uint64
+
+	// window holds the data and leaf sums of the last k+1 leaves, oldest
+	// first, and lag holds every leaf before them.
+	window []fromEndLeaf
+	lag    *Stack
+}
+
+// fromEndLeaf is a leaf in the window of a Tree that proves the k-th leaf
+// from the end.
+type fromEndLeaf struct {
+	data []byte
+	sum  []byte
+}
+
+// SetIndexFromEnd is the same as SetIndex, but proves the leaf that is 'k'
+// leaves before the last leaf, for when the number of leaves is not known
+// before the data is pushed. k=0 proves the last leaf. Prove resolves the
+// proof index as CurrentIndex()-1-k, and returns a nil proof set if the Tree
+// holds k leaves or fewer, for which ProveErr returns
+// ErrProofIndexOutOfRange.
+//
+// The Tree keeps the data and leaf sums of the last k+1 leaves, and a second
+// stack of subtree sums that lags k+1 leaves behind, so its memory grows in
+// O(k + log(n)). Leaves are pushed one at a time: ReadAll does not hash in
+// batches, PadTo pushes every copy of the pad leaf, and PushSubTree and
+// PushReader return an error, since they hide the data of their leaves.
+// SetIndexFromEnd must be called on an empty Tree, and is cleared by SetIndex
+// and Reset. A CachedTree can't prove a leaf from the end.
+func (t *Tree) SetIndexFromEnd(k uint64) error {
+	if !t.IsEmpty() {
+		return errors.New("cannot call SetIndexFromEnd on Tree if Tree has not been reset")
+	}
+	if t.cachedTree {
+		return errors.New("cannot prove a leaf from the end of a cached tree")
+	}
+	t.proofTree = false
+	t.proofIndex = 0
+	t.fromEnd = &fromEndState{
+		k:   k,
+		lag: NewStack(t.hash),
+	}
+	return nil
+}
+
+// pushFromEnd adds the leaf with 'data' and leaf sum 'sum' to the window,
+// moving the oldest leaf of the window into the lagging stack if the window
+// is full. The sum is copied, because it may live in the scratch buffer.
+func (t *Tree) pushFromEnd(data, sum []byte) {
+	s := t.fromEnd
+	s.window = append(s.window, fromEndLeaf{
+		data: data,
+		sum:  append([]byte(nil), sum...),
+	})
+	if uint64(len(s.window)) <= s.k+1 {
+		return
+	}
+	// Appending a leaf to the lagging stack joins one subtree per trailing
+	// one bit of its number of leaves.
+	for i := bits.TrailingZeros64(^s.lag.NumLeaves()); i > 0; i-- {
+		t.stats.NodeHashes++
+		t.stats.BytesHashed += uint64(len(nodeHashPrefix) + 2*t.HashSize())
+	}
+	s.lag.AppendLeafHash(s.window[0].sum)
+	s.window[0] = fromEndLeaf{}
+	s.window = s.window[1:]
+}
+
+// proveFromEnd builds the proof of the k-th leaf from the end. The left
+// siblings of the leaf are the subtrees of the lagging stack, and the leaf
+// and everything to its right are in the window, so the proof is built by a
+// new Tree holding the subtrees and then the window.
+func (t *Tree) proveFromEnd() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) {
+	s := t.fromEnd
+	if t.currentIndex <= s.k {
+		return t.Root(), nil, 0, t.currentIndex
+	}
+	proofIndex = t.currentIndex - 1 - s.k
+
+	pt := New(t.hash)
+	pt.indexedLeaves, pt.proofLeafHash = t.indexedLeaves, t.proofLeafHash
+	if err := pt.SetIndex(proofIndex); err != nil {
+		panic(err)
+	}
+	for _, st := range s.lag.stack {
+		if err := pt.PushSubTree(st.height, st.sum); err != nil {
+			// The subtrees are in order, and all come before the proof index.
+			panic(err)
+		}
+	}
+	pt.Push(s.window[0].data)
+	for _, leaf := range s.window[1:] {
+		if err := pt.PushSubTree(0, leaf.sum); err != nil {
+			// A single leaf after the proof index can always be pushed as a
+			// subtree.
+			panic(err)
+		}
+	}
+	merkleRoot, proofSet, proofIndex, numLeaves = pt.Prove()
+	t.stats.LeafHashes += pt.stats.LeafHashes
+	t.stats.NodeHashes += pt.stats.NodeHashes
+	t.stats.BytesHashed += pt.stats.BytesHashed
+	return merkleRoot, proofSet, proofIndex, numLeaves
+}
@@ -0,0 +1,115 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestSetIndexFromEnd checks that a Tree proving the k-th leaf from the end
+// returns the same proof as a Tree with the index set ahead of time.
+func TestSetIndexFromEnd(t *testing.T) {
+	for _, k := range []uint64{0, 1, 2, 5, 16} {
+		for n := k + 1; n < 40; n++ {
+			leaves := randomLeaves(int(n), 24)
+			tree := New(sha256.New())
+			if err := tree.SetIndexFromEnd(k); err != nil {
+				t.Fatal(err)
+			}
+			for _, leaf := range leaves {
+				tree.Push(leaf)
+			}
+			if !tree.ProofReached() {
+				t.Error("proof not reached", k, n)
+			}
+			root, proofSet, proofIndex, numLeaves, err := tree.ProveErr()
+			if err != nil {
+				t.Fatal(err, k, n)
+			}
+
+			expected := New(sha256.New())
+			if err := expected.SetIndex(n - 1 - k); err != nil {
+				t.Fatal(err)
+			}
+			for _, leaf := range leaves {
+				expected.Push(leaf)
+			}
+			eRoot, eSet, eIndex, eNumLeaves := expected.Prove()
+			if !bytes.Equal(root, eRoot) || proofIndex != eIndex || numLeaves != eNumLeaves || len(proofSet) != len(eSet) {
+				t.Fatal("proof does not match", k, n)
+			}
+			for i := range eSet {
+				if !bytes.Equal(proofSet[i], eSet[i]) {
+					t.Fatal("proof element does not match", k, n, i)
+				}
+			}
+			if !VerifyProof(sha256.New(), root, proofSet, proofIndex, numLeaves) {
+				t.Error("proof does not verify", k, n)
+			}
+		}
+	}
+}
+
+// TestSetIndexFromEndTooFew checks that a Tree with k leaves or fewer returns
+// no proof set.
+func TestSetIndexFromEndTooFew(t *testing.T) {
+	tree := New(sha256.New())
+	if err := tree.SetIndexFromEnd(3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if tree.ProofReached() {
+			t.Error("proof reached early", i)
+		}
+		if _, _, _, _, err := tree.ProveErr(); err != ErrProofIndexOutOfRange {
+			t.Error("wrong error", i, err)
+		}
+		if _, proofSet, _, _ := tree.Prove(); proofSet != nil {
+			t.Error("proof set returned", i)
+		}
+		tree.Push(fastrand.Bytes(8))
+	}
+	if !tree.ProofReached() {
+		t.Error("proof not reached")
+	}
+}
+
+// TestSetIndexFromEndUsage checks the calls that a Tree proving a leaf from
+// the end rejects, and that SetIndex and Reset clear it.
+func TestSetIndexFromEndUsage(t *testing.T) {
+	tree := New(sha256.New())
+	tree.Push([]byte{1})
+	if tree.SetIndexFromEnd(0) == nil {
+		t.Error("SetIndexFromEnd accepted on a non-empty tree")
+	}
+	tree.Reset()
+	if err := tree.SetIndexFromEnd(1); err != nil {
+		t.Fatal(err)
+	}
+	if tree.PushSubTree(0, make([]byte, sha256.Size)) == nil {
+		t.Error("PushSubTree accepted")
+	}
+	if tree.PushReader(bytes.NewReader([]byte{1})) != ErrProofLeafStreamed {
+		t.Error("PushReader accepted")
+	}
+	tree.Reset()
+	if tree.fromEnd != nil {
+		t.Error("Reset did not clear SetIndexFromEnd")
+	}
+	if err := tree.SetIndexFromEnd(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	if tree.fromEnd != nil {
+		t.Error("SetIndex did not clear SetIndexFromEnd")
+	}
+
+	ct := NewCachedTree(sha256.New(), 2)
+	if ct.SetIndexFromEnd(0) == nil {
+		t.Error("SetIndexFromEnd accepted on a cached tree")
+	}
+}
@@ -0,0 +1,463 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"sync"
+)
+
+// A FullTree is a Merkle tree that keeps the sum of every complete subtree in
+// a NodeStore, so that the root and the proof of any leaf can be built at any
+// time by reading O(log(n)) nodes, without pushing the leaves again. It has
+// the same shape as a Tree, and its proofs are the proofs that a Tree with the
+// proof index set to the same leaf produces. The only node sums that are not
+// retained are those of the orphans on the right edge of the tree, which are
+// built from the O(log(n)) complete subtrees that cover them when they are
+// needed.
+//
+// A FullTree created with NewFull keeps its nodes and the data of every leaf
+// in memory, which grows in O(n). A FullTree created with NewFullWithStore
+// keeps no leaf data, and the first element of its proof sets is the leaf
+// sum, as with Tree.ProveLeafHashOnly.
+//
+// A FullTree is safe for concurrent use. Methods that change the tree hold an
+// exclusive lock, and methods that read it, such as Root and ProveIndex, share
+// a lock, so every root and proof that a read returns belongs to the same
+// state of the tree, before or after any change. Readers build the orphans on
+// the right edge of the tree, and a hash can't be shared between goroutines.
+// A FullTree created with NewFullFunc or NewFullWithStoreFunc gives each
+// reader its own hash from a pool, so readers hash in parallel. A FullTree
+// created with a single hash.Hash has only that hash, so its readers take
+// turns hashing unless SetHashFunc is called.
+type FullTree struct {
+	mu sync.RWMutex
+
+	// hashMu guards the use of 'hash' by readers if hashPool is nil.
+	// Writers hold mu exclusively, so they use 'hash' freely.
+	hashMu   sync.Mutex
+	hashPool *sync.Pool
+
+	hash      hash.Hash
+	store     NodeStore
+	numLeaves uint64
+
+	// leaves holds the data of every leaf if keepData is set.
+	leaves   [][]byte
+	keepData bool
+
+	// buf holds the sums built by Push and UpdateLeaf before they are put
+	// in the store, so that they don't allocate.
+	buf []byte
+}
+
+// NewFull creates an empty FullTree that keeps its nodes and leaf data in
+// memory. The provided hash will be used for all hashing operations within
+// the FullTree.
+func NewFull(h hash.Hash) *FullTree {
+	return &FullTree{
+		hash:     h,
+		store:    NewMemoryNodeStore(),
+		keepData: true,
+	}
+}
+
+// NewFullFunc is like NewFull, but creates its hashes with 'newHash', so that
+// the methods that read the tree can hash in parallel.
+func NewFullFunc(newHash func() hash.Hash) *FullTree {
+	ft := NewFull(newHash())
+	ft.hashPool = newHashPool(newHash)
+	return ft
+}
+
+// NewFullWithStore creates a FullTree that keeps its nodes in 'store',
+// continuing the tree that the store already holds, whose leaves are the leaf
+// sums of level 0. The levels above are brought in line with level 0: nodes
+// that are past the end of their level are removed, and nodes that are
+// missing, such as those of a push that was interrupted, are built again.
+// Updates and truncations that were interrupted before the store was flushed
+// can't be detected.
+func NewFullWithStore(h hash.Hash, store NodeStore) (*FullTree, error) {
+	ft := &FullTree{
+		hash:  h,
+		store: store,
+	}
+	numLeaves, err := store.NumNodes(0)
+	if err != nil {
+		return nil, err
+	}
+	ft.numLeaves = numLeaves
+	for k := 0; k+1 < maxNodeLevel; k++ {
+		want := numLeaves >> uint(k+1)
+		have, err := store.NumNodes(k + 1)
+		if err != nil {
+			return nil, err
+		}
+		if have > want {
+			if err := store.Truncate(k+1, want); err != nil {
+				return nil, err
+			}
+		}
+		for i := have; i < want; i++ {
+			left, err := ft.node(k, 2*i)
+			if err != nil {
+				return nil, err
+			}
+			right, err := ft.node(k, 2*i+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := store.Put(k+1, i, nodeSum(h, left, right)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ft, nil
+}
+
+// NewFullWithStoreFunc is like NewFullWithStore, but creates its hashes with
+// 'newHash', so that the methods that read the tree can hash in parallel.
+func NewFullWithStoreFunc(newHash func() hash.Hash, store NodeStore) (*FullTree, error) {
+	ft, err := NewFullWithStore(newHash(), store)
+	if err != nil {
+		return nil, err
+	}
+	ft.hashPool = newHashPool(newHash)
+	return ft, nil
+}
+
+// newHashPool returns a pool of hashes created by 'newHash'.
+func newHashPool(newHash func() hash.Hash) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} { return newHash() },
+	}
+}
+
+// node reads the sum of the node at 'index' of 'level' from the store,
+// checking its size.
+func (ft *FullTree) node(level int, index uint64) ([]byte, error) {
+	sum, err := ft.store.Get(level, index)
+	if err != nil {
+		return nil, err
+	}
+	if len(sum) != ft.hash.Size() {
+		return nil, errors.New("stored node has the wrong size for the hash")
+	}
+	return sum, nil
+}
+
+// SetHashFunc lets the methods that read a tree created with a single hash
+// hash in parallel, each with its own hash created by 'newHash', which must
+// create the same hash as the one the tree was created with.
+func (ft *FullTree) SetHashFunc(newHash func() hash.Hash) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.hashPool = newHashPool(newHash)
+}
+
+// readHash returns a hash for a reader to use, and a function to call when
+// the reader is done with it. Without a pool, the single hash of the tree is
+// shared, and readers hold hashMu while they use it.
+func (ft *FullTree) readHash() (hash.Hash, func()) {
+	if ft.hashPool == nil {
+		ft.hashMu.Lock()
+		return ft.hash, ft.hashMu.Unlock
+	}
+	h := ft.hashPool.Get().(hash.Hash)
+	return h, func() { ft.hashPool.Put(h) }
+}
+
+// NumLeaves returns the number of leaves in the tree.
+func (ft *FullTree) NumLeaves() uint64 {
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+	return ft.numLeaves
+}
+
+// Flush makes every change to the tree durable in its store.
+func (ft *FullTree) Flush() error {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.store.Flush()
+}
+
+// Push adds a leaf with 'data' to the tree. The data is copied. As with
+// Tree.Push, a nil leaf is the same as an empty leaf. Once the store has
+// grown, Push allocates nothing but the copy of the data, and nothing at all
+// if the tree does not keep its leaf data.
+func (ft *FullTree) Push(data []byte) error {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.push(data)
+}
+
+// push adds a leaf to the tree while the tree is locked for writing.
+func (ft *FullTree) push(data []byte) error {
+	index := ft.numLeaves
+	sum := appendLeafSum(ft.buf[:0], ft.hash, data)
+	defer func() { ft.buf = sum }()
+	for k := 0; ; k++ {
+		if err := ft.store.Put(k, index, sum); err != nil {
+			return err
+		}
+		if index%2 == 0 {
+			break
+		}
+		left, err := ft.node(k, index-1)
+		if err != nil {
+			return err
+		}
+		sum = appendNodeSum(sum[:0], ft.hash, left, sum)
+		index /= 2
+	}
+	if ft.keepData {
+		ft.leaves = append(ft.leaves, append([]byte{}, data...))
+	}
+	ft.numLeaves++
+	return nil
+}
+
+// Append adds a leaf with 'data' to the tree, the same as Push, and returns
+// the new Merkle root. Only the complete subtrees that the leaf completes are
+// hashed, along with the orphans on the right edge of the tree.
+func (ft *FullTree) Append(data []byte) ([]byte, error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if err := ft.push(data); err != nil {
+		return nil, err
+	}
+	return ft.root(ft.hash)
+}
+
+// TruncateTo removes every leaf after the first 'n', returning the new Merkle
+// root. Because only complete subtrees are retained, and the complete
+// subtrees of the first 'n' leaves don't depend on the leaves after them,
+// truncating drops the subtrees that held a removed leaf and hashes nothing
+// but the orphans on the new right edge. An error is returned if the tree has
+// fewer than 'n' leaves.
+func (ft *FullTree) TruncateTo(n uint64) ([]byte, error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if n > ft.numLeaves {
+		return nil, errors.New("cannot truncate a tree to more leaves than it has")
+	}
+	for k := 0; ft.numLeaves>>uint(k) > 0; k++ {
+		if err := ft.store.Truncate(k, n>>uint(k)); err != nil {
+			return nil, err
+		}
+	}
+	if ft.keepData {
+		for i := range ft.leaves[n:] {
+			ft.leaves[n+uint64(i)] = nil
+		}
+		ft.leaves = ft.leaves[:n]
+	}
+	ft.numLeaves = n
+	return ft.root(ft.hash)
+}
+
+// Root returns the Merkle root of the tree, or nil if the tree is empty.
+func (ft *FullTree) Root() ([]byte, error) {
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+	h, done := ft.readHash()
+	defer done()
+	return ft.root(h)
+}
+
+// root returns the Merkle root of the tree while the tree is locked, using
+// 'h' for hashing.
+func (ft *FullTree) root(h hash.Hash) ([]byte, error) {
+	if ft.numLeaves == 0 {
+		return nil, nil
+	}
+	return ft.rangeSum(h, nil, nodeRange{0, ft.numLeaves})
+}
+
+// rangeSum appends the sum of the node covering 'r' to 'dst', using 'h' for
+// hashing. The sum is copied out of the store, so it does not change when
+// the store does.
+func (ft *FullTree) rangeSum(h hash.Hash, dst []byte, r nodeRange) ([]byte, error) {
+	sum, err := nodeRangeSum(h, r, ft.node)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, sum...), nil
+}
+
+// ProveIndex returns the Merkle root and the proof set of the leaf at index
+// 'i', the same as Tree.Prove returns for a Tree with the proof index set to
+// 'i' and the same leaves. ErrProofIndexOutOfRange is returned if the leaf is
+// not in the tree. The proof set may share memory with the tree, so it must
+// not be modified.
+func (ft *FullTree) ProveIndex(i uint64) (merkleRoot []byte, proofSet [][]byte, err error) {
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+	h, done := ft.readHash()
+	defer done()
+	return ft.proveIndex(h, i)
+}
+
+// proveIndex returns the root and the proof set of the leaf at index 'i'
+// while the tree is locked, using 'h' for hashing.
+func (ft *FullTree) proveIndex(h hash.Hash, i uint64) (merkleRoot []byte, proofSet [][]byte, err error) {
+	if i >= ft.numLeaves {
+		return nil, nil, ErrProofIndexOutOfRange
+	}
+	// The leaf sum, if it is needed, and the siblings are copied into a
+	// single buffer.
+	ranges := proofNodeRanges(i, ft.numLeaves)
+	proofSet = make([][]byte, 0, len(ranges)+1)
+	buf := make([]byte, 0, (len(ranges)+1)*ft.hash.Size())
+	if ft.keepData {
+		proofSet = append(proofSet, ft.leaves[i])
+	} else {
+		if buf, err = ft.rangeSum(h, buf, nodeRange{i, i + 1}); err != nil {
+			return nil, nil, err
+		}
+		proofSet = append(proofSet, buf[:len(buf):len(buf)])
+	}
+	for _, r := range ranges {
+		start := len(buf)
+		if buf, err = ft.rangeSum(h, buf, r); err != nil {
+			return nil, nil, err
+		}
+		proofSet = append(proofSet, buf[start:len(buf):len(buf)])
+	}
+	merkleRoot, err = ft.root(h)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merkleRoot, proofSet, nil
+}
+
+// ProveIndices returns the proofs of the leaves at 'indices', compressed the
+// same as CompressProofs compresses the proofs that a Tree produces for them,
+// in the same order. Every node is read or built once, however many proofs it
+// appears in. ErrProofIndexOutOfRange is returned if a leaf is not in the
+// tree, and an error is returned if the tree does not keep its leaf data,
+// which a CompressedProofs must hold.
+func (ft *FullTree) ProveIndices(indices []uint64) (CompressedProofs, error) {
+	if !ft.keepData {
+		return CompressedProofs{}, errors.New("tree does not keep the leaf data of its proofs")
+	}
+	var c CompressedProofs
+	if len(indices) == 0 {
+		return c, nil
+	}
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+	h, done := ft.readHash()
+	defer done()
+	root, err := ft.root(h)
+	if err != nil {
+		return CompressedProofs{}, err
+	}
+	c.Root = root
+	c.NumLeaves = ft.numLeaves
+
+	table := make(map[nodeRange]int)
+	for _, i := range indices {
+		if i >= ft.numLeaves {
+			return CompressedProofs{}, ErrProofIndexOutOfRange
+		}
+		ranges := proofNodeRanges(i, ft.numLeaves)
+		cp := CompressedProof{
+			Index: i,
+			Data:  ft.leaves[i],
+			Refs:  make([]int, len(ranges)),
+		}
+		for j, r := range ranges {
+			ref, ok := table[r]
+			if !ok {
+				sum, err := ft.rangeSum(h, nil, r)
+				if err != nil {
+					return CompressedProofs{}, err
+				}
+				ref = len(c.Hashes)
+				table[r] = ref
+				c.Hashes = append(c.Hashes, sum)
+			}
+			cp.Refs[j] = ref
+		}
+		c.Proofs = append(c.Proofs, cp)
+	}
+	return c, nil
+}
+
+// UpdateLeaf replaces the data of the leaf at index 'i' with 'data', and
+// rehashes the complete subtrees on the path from the leaf to the root,
+// returning the new Merkle root. The data is copied. Proofs returned before
+// the update are not modified. ErrProofIndexOutOfRange is returned if the
+// leaf is not in the tree.
+//
+// The siblings of the leaf are not changed by the update, so a proof of the
+// leaf taken before the update, as returned by UpdateProof, lets a party that
+// only holds the old root compute the new one with ApplyLeafUpdate.
+func (ft *FullTree) UpdateLeaf(i uint64, data []byte) (newRoot []byte, err error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if i >= ft.numLeaves {
+		return nil, ErrProofIndexOutOfRange
+	}
+	if ft.keepData {
+		ft.leaves[i] = append([]byte{}, data...)
+	}
+	index := i
+	sum := appendLeafSum(ft.buf[:0], ft.hash, data)
+	defer func() { ft.buf = sum }()
+	for k := 0; ; k++ {
+		if err := ft.store.Put(k, index, sum); err != nil {
+			return nil, err
+		}
+		// The node has a parent if it and its sibling are complete.
+		sibling := index ^ 1
+		if ft.numLeaves>>uint(k) <= sibling|1 {
+			break
+		}
+		other, err := ft.node(k, sibling)
+		if err != nil {
+			return nil, err
+		}
+		if index%2 == 0 {
+			sum = appendNodeSum(sum[:0], ft.hash, sum, other)
+		} else {
+			sum = appendNodeSum(sum[:0], ft.hash, other, sum)
+		}
+		index /= 2
+	}
+	return ft.root(ft.hash)
+}
+
+// UpdateProof returns the proof of the leaf at index 'i' in the current state
+// of the tree, to be taken before the leaf is changed with UpdateLeaf. Along
+// with the new data, it is the update that ApplyLeafUpdate applies to the old
+// root, if the tree keeps its leaf data. ErrProofIndexOutOfRange is returned
+// if the leaf is not in the tree.
+func (ft *FullTree) UpdateProof(i uint64) (Proof, error) {
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+	h, done := ft.readHash()
+	defer done()
+	root, proofSet, err := ft.proveIndex(h, i)
+	if err != nil {
+		return Proof{}, err
+	}
+	return Proof{
+		Root:      root,
+		Set:       proofSet,
+		Index:     i,
+		NumLeaves: ft.numLeaves,
+	}, nil
+}
+
+// ApplyLeafUpdate checks that 'old' is a canonical proof of a leaf, as
+// returned by FullTree.UpdateProof, and returns the root of the tree in which
+// the data of that leaf is replaced with 'data'. The root is computed from the
+// siblings of the old proof, which the update does not change.
+func ApplyLeafUpdate(h hash.Hash, old Proof, data []byte) (newRoot []byte, err error) {
+	if !VerifyProofStrict(h, old.Root, old.Set, old.Index, old.NumLeaves) {
+		return nil, errors.New("old proof is not a canonical proof of its root")
+	}
+	proofSet := append([][]byte{data}, old.Set[1:]...)
+	return proofRoot(h, nil, proofSet, old.Index, old.NumLeaves)
+}
@@ -0,0 +1,655 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// fullTreeStores returns a function for each kind of FullTree that creates an
+// empty tree, and a function that removes what the trees left behind.
+func fullTreeStores(t *testing.T) (map[string]func() *FullTree, func()) {
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stores []*FileNodeStore
+	n := 0
+	newTrees := map[string]func() *FullTree{
+		"memory": func() *FullTree {
+			return NewFull(sha256.New())
+		},
+		"memory-func": func() *FullTree {
+			return NewFullFunc(sha256.New)
+		},
+		"file": func() *FullTree {
+			n++
+			// A small cache makes the tree evict pages all the time.
+			fns, err := OpenFileNodeStore(dir+"/"+strconv.Itoa(n), sha256.Size, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stores = append(stores, fns)
+			ft, err := NewFullWithStore(sha256.New(), fns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ft
+		},
+	}
+	return newTrees, func() {
+		for _, fns := range stores {
+			fns.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// checkFullProof checks the root and the proof of the leaf at 'index' of a
+// FullTree against a Tree built from 'leaves'. The first element of the proof
+// set is the leaf sum if the FullTree does not keep its leaf data.
+func checkFullProof(t *testing.T, ft *FullTree, leaves [][]byte, index uint64) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		tree.Push(leaf)
+	}
+	eRoot, eSet, _, _ := tree.Prove()
+	if !ft.keepData {
+		eSet[0] = LeafSum(sha256.New(), eSet[0])
+	}
+	root, proofSet, err := ft.ProveIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, eRoot) || len(proofSet) != len(eSet) {
+		t.Fatal("proof does not match tree", len(leaves), index)
+	}
+	for i := range eSet {
+		if !bytes.Equal(proofSet[i], eSet[i]) {
+			t.Fatal("proof element does not match tree", len(leaves), index, i)
+		}
+	}
+}
+
+// TestFullTree checks the roots and proofs of a FullTree against a Tree for
+// every index of every small tree.
+func TestFullTree(t *testing.T) {
+	newTrees, cleanup := fullTreeStores(t)
+	defer cleanup()
+	for name, newTree := range newTrees {
+		ft := newTree()
+		if root, err := ft.Root(); root != nil || err != nil {
+			t.Error("empty tree has a root", name, err)
+		}
+		if _, _, err := ft.ProveIndex(0); err != ErrProofIndexOutOfRange {
+			t.Error("wrong error for an empty tree", name, err)
+		}
+
+		leaves := randomLeaves(70, 16)
+		leaves[3] = nil
+		for n := 1; n <= len(leaves); n++ {
+			if err := ft.Push(leaves[n-1]); err != nil {
+				t.Fatal(err)
+			}
+			if ft.NumLeaves() != uint64(n) {
+				t.Fatal("wrong number of leaves", name, n)
+			}
+			for index := 0; index < n; index++ {
+				checkFullProof(t, ft, leaves[:n], uint64(index))
+			}
+			if _, _, err := ft.ProveIndex(uint64(n)); err != ErrProofIndexOutOfRange {
+				t.Error("wrong error for an index out of range", name, n, err)
+			}
+		}
+	}
+}
+
+// TestFullTreeCopiesData checks that a FullTree is not affected by changes to
+// the data that was pushed.
+func TestFullTreeCopiesData(t *testing.T) {
+	ft := NewFull(sha256.New())
+	data := []byte{1, 2, 3}
+	if err := ft.Push(data); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 9
+	_, proofSet, err := ft.ProveIndex(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(proofSet[0], []byte{1, 2, 3}) {
+		t.Error("pushed data was not copied")
+	}
+}
+
+// BenchmarkFullTreeProve builds proofs from trees of increasing size, to show
+// that the cost of a proof grows with the height of the tree rather than the
+// amount of data.
+func BenchmarkFullTreeProve(b *testing.B) {
+	for _, n := range []int{1<<10 - 1, 1<<15 - 1, 1<<20 - 1} {
+		ft := NewFull(sha256.New())
+		for i := 0; i < n; i++ {
+			if err := ft.Push([]byte{byte(i)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := ft.ProveIndex(uint64(i % n)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestFullTreeUpdateLeaf applies random updates to a FullTree, checking the
+// root against a tree rebuilt from scratch and against the root that
+// ApplyLeafUpdate computes from the proof taken before the update.
+func TestFullTreeUpdateLeaf(t *testing.T) {
+	newTrees, cleanup := fullTreeStores(t)
+	defer cleanup()
+	for name, newTree := range newTrees {
+		for _, n := range []int{1, 2, 3, 7, 8, 13, 64, 65} {
+			leaves := randomLeaves(n, 16)
+			ft := newTree()
+			for _, leaf := range leaves {
+				if err := ft.Push(leaf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for step := 0; step < 3*n; step++ {
+				i := uint64(fastrand.Intn(n))
+				data := fastrand.Bytes(fastrand.Intn(16))
+				old, err := ft.UpdateProof(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				newRoot, err := ft.UpdateLeaf(i, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				leaves[i] = data
+
+				tree := New(sha256.New())
+				for _, leaf := range leaves {
+					tree.Push(leaf)
+				}
+				if root, err := ft.Root(); err != nil || !bytes.Equal(newRoot, tree.Root()) || !bytes.Equal(root, tree.Root()) {
+					t.Fatal("root does not match a rebuilt tree", name, n, step, err)
+				}
+				if !ft.keepData {
+					if !VerifyProofWithLeafHash(sha256.New(), old.Root, old.Set[0], old.Set[1:], old.Index, old.NumLeaves) {
+						t.Fatal("old proof was modified by the update", name, n, step)
+					}
+					continue
+				}
+				applied, err := ApplyLeafUpdate(sha256.New(), old, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(applied, newRoot) {
+					t.Fatal("applied update does not match", name, n, step)
+				}
+				if !old.Verify(sha256.New()) {
+					t.Fatal("old proof was modified by the update", name, n, step)
+				}
+			}
+		}
+	}
+}
+
+// TestFullTreeUpdateLeafInvalid checks updates of leaves out of range, and
+// applying an update with a proof that does not verify.
+func TestFullTreeUpdateLeafInvalid(t *testing.T) {
+	ft := NewFull(sha256.New())
+	if _, err := ft.UpdateLeaf(0, nil); err != ErrProofIndexOutOfRange {
+		t.Error("wrong error for an empty tree", err)
+	}
+	ft.Push([]byte{1})
+	ft.Push([]byte{2})
+	if _, err := ft.UpdateLeaf(2, nil); err != ErrProofIndexOutOfRange {
+		t.Error("wrong error for an index out of range", err)
+	}
+	if _, err := ft.UpdateProof(2); err != ErrProofIndexOutOfRange {
+		t.Error("wrong error for an index out of range", err)
+	}
+	p, err := ft.UpdateProof(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Set = append([][]byte{{3}}, p.Set[1:]...)
+	if _, err := ApplyLeafUpdate(sha256.New(), p, []byte{4}); err == nil {
+		t.Error("update applied with an invalid proof")
+	}
+}
+
+// TestFullTreeAppendTruncate drives a FullTree through a random sequence of
+// appends, updates and truncations, checking the root and a proof against a
+// tree rebuilt from scratch after every step.
+func TestFullTreeAppendTruncate(t *testing.T) {
+	steps := 2000
+	if testing.Short() {
+		steps = 300
+	}
+	newTrees, cleanup := fullTreeStores(t)
+	defer cleanup()
+	for name, newTree := range newTrees {
+		ft := newTree()
+		var leaves [][]byte
+		for step := 0; step < steps; step++ {
+			var root []byte
+			var err error
+			switch op := fastrand.Intn(10); {
+			case op < 6 || len(leaves) == 0:
+				data := fastrand.Bytes(fastrand.Intn(16))
+				leaves = append(leaves, data)
+				root, err = ft.Append(data)
+			case op < 8:
+				i := fastrand.Intn(len(leaves))
+				leaves[i] = fastrand.Bytes(fastrand.Intn(16))
+				root, err = ft.UpdateLeaf(uint64(i), leaves[i])
+			default:
+				n := fastrand.Intn(len(leaves) + 1)
+				leaves = leaves[:n]
+				root, err = ft.TruncateTo(uint64(n))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ft.NumLeaves() != uint64(len(leaves)) {
+				t.Fatal("wrong number of leaves", name, step)
+			}
+			if len(leaves) == 0 {
+				if root != nil {
+					t.Fatal("empty tree has a root", name, step)
+				}
+				continue
+			}
+			index := uint64(fastrand.Intn(len(leaves)))
+			checkFullProof(t, ft, leaves, index)
+			if eRoot, _ := ft.Root(); !bytes.Equal(root, eRoot) {
+				t.Fatal("returned root is not the root of the tree", name, step)
+			}
+		}
+		if _, err := ft.TruncateTo(ft.NumLeaves() + 1); err == nil {
+			t.Error("truncated to more leaves than the tree has", name)
+		}
+	}
+}
+
+// TestFullTreeProveIndices checks the compressed proofs of a FullTree against
+// the compressed proofs of a Tree, for random sets of indices that include
+// adjacent leaves and the leaves on the right edge.
+func TestFullTreeProveIndices(t *testing.T) {
+	for n := 1; n <= 40; n++ {
+		leaves := randomLeaves(n, 16)
+		ft := NewFull(sha256.New())
+		for _, leaf := range leaves {
+			if err := ft.Push(leaf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for trial := 0; trial < 5; trial++ {
+			indices := []uint64{uint64(n - 1)}
+			for i := fastrand.Intn(5); i > 0; i-- {
+				index := uint64(fastrand.Intn(n))
+				indices = append(indices, index)
+				if index+1 < uint64(n) {
+					indices = append(indices, index+1)
+				}
+			}
+			var proofs []Proof
+			for _, index := range indices {
+				tree := New(sha256.New())
+				if err := tree.SetIndex(index); err != nil {
+					t.Fatal(err)
+				}
+				for _, leaf := range leaves {
+					tree.Push(leaf)
+				}
+				root, proofSet, _, numLeaves := tree.Prove()
+				proofs = append(proofs, Proof{Root: root, Set: proofSet, Index: index, NumLeaves: numLeaves})
+			}
+			expected, err := CompressProofs(proofs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := ft.ProveIndices(indices)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c, expected) {
+				t.Fatal("compressed proofs do not match", n, indices)
+			}
+			if !VerifyCompressed(sha256.New(), c) {
+				t.Fatal("compressed proofs do not verify", n, indices)
+			}
+		}
+	}
+}
+
+// TestFullTreeProveIndicesLarge spot checks compressed proofs of a large tree,
+// and the errors of ProveIndices.
+func TestFullTreeProveIndicesLarge(t *testing.T) {
+	ft := NewFull(sha256.New())
+	for i := 0; i < 5000; i++ {
+		if err := ft.Push([]byte{byte(i), byte(i >> 8)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indices := []uint64{0, 1, 2, 1000, 4095, 4096, 4999}
+	c, err := ft.ProveIndices(indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyCompressed(sha256.New(), c) || len(c.Proofs) != len(indices) {
+		t.Fatal("compressed proofs do not verify")
+	}
+	if c, err := ft.ProveIndices(nil); err != nil || len(c.Proofs) != 0 {
+		t.Error("proofs of no indices", err)
+	}
+	if _, err := ft.ProveIndices([]uint64{3, 5000}); err != ErrProofIndexOutOfRange {
+		t.Error("wrong error for an index out of range", err)
+	}
+
+	newTrees, cleanup := fullTreeStores(t)
+	defer cleanup()
+	st := newTrees["file"]()
+	if err := st.Push([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.ProveIndices([]uint64{0}); err == nil {
+		t.Error("compressed proofs built without leaf data")
+	}
+}
+
+// pointerNodeStore is a NodeStore that holds every node in its own slice, as
+// the first MemoryNodeStore did, for comparison with the flat layout.
+type pointerNodeStore struct {
+	levels [maxNodeLevel][][]byte
+}
+
+func (ps *pointerNodeStore) Get(level int, index uint64) ([]byte, error) {
+	if index >= uint64(len(ps.levels[level])) {
+		return nil, ErrNodeNotStored
+	}
+	return ps.levels[level][index], nil
+}
+
+func (ps *pointerNodeStore) Put(level int, index uint64, sum []byte) error {
+	sum = append([]byte(nil), sum...)
+	if index < uint64(len(ps.levels[level])) {
+		ps.levels[level][index] = sum
+	} else {
+		ps.levels[level] = append(ps.levels[level], sum)
+	}
+	return nil
+}
+
+func (ps *pointerNodeStore) NumNodes(level int) (uint64, error) {
+	return uint64(len(ps.levels[level])), nil
+}
+
+func (ps *pointerNodeStore) Truncate(level int, n uint64) error {
+	if n < uint64(len(ps.levels[level])) {
+		ps.levels[level] = ps.levels[level][:n]
+	}
+	return nil
+}
+
+func (ps *pointerNodeStore) Flush() error {
+	return nil
+}
+
+// benchmarkLayouts runs 'fn' with a tree of 'n' leaves over the flat and the
+// pointer layouts.
+func benchmarkLayouts(b *testing.B, n int, fn func(b *testing.B, ft *FullTree)) {
+	layouts := []struct {
+		name  string
+		store NodeStore
+	}{
+		{"flat", NewMemoryNodeStore()},
+		{"pointer", new(pointerNodeStore)},
+	}
+	for _, l := range layouts {
+		ft, err := NewFullWithStore(sha256.New(), l.store)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if err := ft.Push([]byte{byte(i)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.Run(l.name, func(b *testing.B) {
+			b.ReportAllocs()
+			fn(b, ft)
+		})
+	}
+}
+
+// BenchmarkFullTreeLayoutProve compares building proofs over the flat and
+// the pointer layouts.
+func BenchmarkFullTreeLayoutProve(b *testing.B) {
+	const n = 1<<20 - 1
+	benchmarkLayouts(b, n, func(b *testing.B, ft *FullTree) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := ft.ProveIndex(uint64(i*7919) % n); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkFullTreeLayoutUpdate compares updating leaves over the flat and
+// the pointer layouts.
+func BenchmarkFullTreeLayoutUpdate(b *testing.B) {
+	const n = 1<<20 - 1
+	data := []byte{1, 2, 3}
+	benchmarkLayouts(b, n, func(b *testing.B, ft *FullTree) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ft.UpdateLeaf(uint64(i*7919)%n, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkFullTreeLayoutMemory reports the heap used by the nodes of a tree
+// of 10 million leaves in the flat and the pointer layouts.
+func BenchmarkFullTreeLayoutMemory(b *testing.B) {
+	const n = 10000000
+	for _, newStore := range []func() NodeStore{
+		func() NodeStore { return NewMemoryNodeStore() },
+		func() NodeStore { return new(pointerNodeStore) },
+	} {
+		name := "flat"
+		if _, ok := newStore().(*pointerNodeStore); ok {
+			name = "pointer"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				ft, err := NewFullWithStore(sha256.New(), newStore())
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < n; j++ {
+					if err := ft.Push([]byte{byte(j)}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/n, "heapbytes/leaf")
+				runtime.KeepAlive(ft)
+			}
+		})
+	}
+}
+
+// TestFullTreeNoAllocs checks that pushing leaves to a tree in memory that
+// keeps no leaf data allocates nothing once the store has grown.
+func TestFullTreeNoAllocs(t *testing.T) {
+	ft, err := NewFullWithStore(sha256.New(), NewMemoryNodeStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{1, 2, 3}
+	fill := func() {
+		if _, err := ft.TruncateTo(0); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := ft.Push(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fill()
+	if allocs := testing.AllocsPerRun(10, fill); allocs != 0 {
+		t.Error("tree allocated", allocs)
+	}
+}
+
+// TestFullTreeHashPool checks that the readers of a FullTree created with a
+// hash function don't use the shared hash, and so don't wait for each other.
+func TestFullTreeHashPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fns, err := OpenFileNodeStore(dir+"/pool", sha256.Size, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fns.Close()
+	fileTree, err := NewFullWithStoreFunc(sha256.New, fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ft := range []*FullTree{NewFullFunc(sha256.New), fileTree} {
+		for j := 0; j < 7; j++ {
+			if err := ft.Push([]byte{byte(j)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Holding hashMu blocks any reader that shares the tree's hash.
+		ft.hashMu.Lock()
+		done := make(chan error, 1)
+		go func() {
+			if _, _, err := ft.ProveIndex(6); err != nil {
+				done <- err
+				return
+			}
+			_, err := ft.Root()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(i, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("reader waited for the shared hash", i)
+		}
+		ft.hashMu.Unlock()
+	}
+}
+
+// TestFullTreeConcurrent reads proofs from many goroutines while a writer
+// appends, updates and truncates, checking that every proof verifies against
+// the root returned with it. It is meant to be run with the race detector.
+func TestFullTreeConcurrent(t *testing.T) {
+	writes := 2000
+	if testing.Short() {
+		writes = 300
+	}
+	newTrees, cleanup := fullTreeStores(t)
+	defer cleanup()
+	for name, newTree := range newTrees {
+		ft := newTree()
+		if name == "memory" {
+			ft.SetHashFunc(sha256.New)
+		}
+		for i := 0; i < 10; i++ {
+			if err := ft.Push([]byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		done := make(chan struct{})
+		errs := make(chan error, 4)
+		for r := 0; r < 4; r++ {
+			go func() {
+				for {
+					select {
+					case <-done:
+						errs <- nil
+						return
+					default:
+					}
+					n := ft.NumLeaves()
+					p, err := ft.UpdateProof(uint64(fastrand.Intn(int(n))))
+					if err == ErrProofIndexOutOfRange {
+						continue
+					} else if err != nil {
+						errs <- err
+						return
+					}
+					var ok bool
+					if ft.keepData {
+						ok = p.Verify(sha256.New())
+					} else {
+						ok = VerifyProofWithLeafHash(sha256.New(), p.Root, p.Set[0], p.Set[1:], p.Index, p.NumLeaves)
+					}
+					if !ok {
+						errs <- errors.New("proof does not verify against its root")
+						return
+					}
+				}
+			}()
+		}
+
+		for i := 0; i < writes; i++ {
+			var err error
+			switch op := fastrand.Intn(10); {
+			case op < 6:
+				_, err = ft.Append(fastrand.Bytes(8))
+			case op < 9:
+				_, err = ft.UpdateLeaf(uint64(fastrand.Intn(int(ft.NumLeaves()))), fastrand.Bytes(8))
+			default:
+				// Keep at least one leaf, so that readers always have a
+				// leaf to prove.
+				_, err = ft.TruncateTo(ft.NumLeaves()/2 + 1)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(done)
+		for r := 0; r < 4; r++ {
+			if err := <-errs; err != nil {
+				t.Fatal(name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"testing"
+)
+
+// fuzzSeeds are the (numLeaves, proofIndex) pairs of the manual proof vectors
+// in CreateMerkleTester.
+var fuzzSeeds = [][2]uint16{
+	{1, 0}, {2, 0}, {2, 1}, {5, 4}, {6, 0}, {6, 2}, {6, 4}, {6, 5},
+	{7, 5}, {15, 3}, {15, 10}, {15, 13},
+}
+
+// fuzzTree builds a Tree of 'numLeaves' leaves, where leaf i is the byte i,
+// and returns its root and the proof of the leaf at 'proofIndex'.
+func fuzzTree(numLeaves, proofIndex uint64) (root []byte, proofSet [][]byte) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(proofIndex); err != nil {
+		panic(err)
+	}
+	for i := uint64(0); i < numLeaves; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	root, proofSet, _, _ = tree.Prove()
+	return root, proofSet
+}
+
+// FuzzProve builds a proof for every (numLeaves, proofIndex) pair, checks
+// that it verifies, and checks that it does not verify for a neighbouring
+// index. A neighbouring number of leaves is not checked, because trees whose
+// proofs for an index take the same path, such as 15 and 16 leaves for index
+// 3, accept the same proof.
+func FuzzProve(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, numLeaves, proofIndex uint16) {
+		n, i := uint64(numLeaves%1024), uint64(proofIndex%1024)
+		if i >= n {
+			return
+		}
+		root, proofSet := fuzzTree(n, i)
+		if !VerifyProof(sha256.New(), root, proofSet, i, n) {
+			t.Fatal("valid proof was rejected", n, i)
+		}
+		if !VerifyProofStrict(sha256.New(), root, proofSet, i, n) {
+			t.Fatal("valid proof was rejected by strict verification", n, i)
+		}
+		for _, p := range [][2]uint64{{i + 1, n}, {i - 1, n}} {
+			if p[0] < p[1] && VerifyProof(sha256.New(), root, proofSet, p[0], p[1]) {
+				t.Fatal("proof verified for the wrong parameters", n, i, p)
+			}
+		}
+	})
+}
+
+// FuzzVerifyProof passes arbitrary proof sets to VerifyProof against the
+// root of a real tree. VerifyProof must not panic, and must only accept the
+// proof set that the tree produces.
+func FuzzVerifyProof(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		root, proofSet := fuzzTree(uint64(seed[0]), uint64(seed[1]))
+		var enc []byte
+		for _, elem := range proofSet[1:] {
+			enc = append(enc, elem...)
+		}
+		f.Add(seed[0], seed[1], proofSet[0], enc, uint16(0))
+		f.Add(seed[0], seed[1], proofSet[0], append(enc, root...), uint16(1))
+	}
+	f.Fuzz(func(t *testing.T, numLeaves, proofIndex uint16, leaf, siblings []byte, flip uint16) {
+		n, i := uint64(numLeaves%64), uint64(proofIndex)
+		root, honest := fuzzTree(n, i%(n+1))
+
+		// Split the siblings into hash sized elements, leaving a short final
+		// element if there is one.
+		proofSet := [][]byte{leaf}
+		for len(siblings) > 0 {
+			size := sha256.Size
+			if len(siblings) < size {
+				size = len(siblings)
+			}
+			proofSet = append(proofSet, siblings[:size])
+			siblings = siblings[size:]
+		}
+		if VerifyProof(sha256.New(), root, proofSet, i, n) {
+			if i >= n || len(proofSet) != len(honest) {
+				t.Fatal("proof accepted for mismatched parameters", n, i)
+			}
+			for j := range proofSet {
+				if !bytes.Equal(proofSet[j], honest[j]) {
+					t.Fatal("proof accepted that was not produced by the tree", n, i, j)
+				}
+			}
+		}
+
+		// Flipping a bit of an honest proof must invalidate it.
+		if i < n && len(honest) > 0 {
+			j := int(flip) % len(honest)
+			mutated := append([][]byte(nil), honest...)
+			mutated[j] = append([]byte(nil), honest[j]...)
+			if len(mutated[j]) > 0 {
+				mutated[j][int(flip>>8)%len(mutated[j])] ^= 1
+				if VerifyProof(sha256.New(), root, mutated, i, n) {
+					t.Fatal("mutated proof was accepted", n, i, j)
+				}
+			}
+		}
+	})
+}
+
+// FuzzVerifyProofLarge passes proof sets of zeroed elements to VerifyProof for
+// trees of any size, including sizes whose proofs reach past MaxHeight.
+// VerifyProof must not panic, and can't accept a proof that no tree produced.
+func FuzzVerifyProofLarge(f *testing.F) {
+	// Every complete subtree of the largest tree fits below MaxHeight, which
+	// used to overflow the subtree shifts of VerifyProof.
+	f.Add(uint64(math.MaxUint64), uint64(0), uint8(65))
+	f.Add(uint64(1)<<MaxHeight, uint64(1)<<MaxHeight-1, uint8(64))
+	f.Fuzz(func(t *testing.T, numLeaves, proofIndex uint64, setLen uint8) {
+		proofSet := make([][]byte, setLen%80)
+		for i := range proofSet {
+			proofSet[i] = make([]byte, sha256.Size)
+		}
+		if VerifyProof(sha256.New(), make([]byte, sha256.Size), proofSet, proofIndex, numLeaves) {
+			t.Fatal("proof of zeroes was accepted", numLeaves, proofIndex, len(proofSet))
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package merkletree
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// RootHex returns the lowercase hex encoding of a Merkle root, without any
+// truncation.
+func RootHex(root []byte) string {
+	return hex.EncodeToString(root)
+}
+
+// EncodeProofHex encodes a proof in a compact textual form:
+//
+//	<version>:<index>/<numLeaves>:<root>:<set[0]>:<set[1]>:...
+//
+// The version is the proof version header as two lowercase hex digits. The
+// index and number of leaves are decimal, and the root and proof set elements
+// are lowercase hex. The leaf data may be empty, in which case the element is an empty
+// string. For example, the proof of leaf 1 in a tree of 2 leaves with root
+// 'r', leaf data 'd' and sibling 's' is "01:1/2:r:d:s".
+func EncodeProofHex(p Proof) string {
+	var b strings.Builder
+	b.WriteString(hex.EncodeToString([]byte{proofVersionSingle}))
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatUint(p.Index, 10))
+	b.WriteByte('/')
+	b.WriteString(strconv.FormatUint(p.NumLeaves, 10))
+	b.WriteByte(':')
+	b.WriteString(hex.EncodeToString(p.Root))
+	for _, elem := range p.Set {
+		b.WriteByte(':')
+		b.WriteString(hex.EncodeToString(elem))
+	}
+	return b.String()
+}
+
+// DecodeProofHex decodes a proof encoded by EncodeProofHex. The string must be
+// exactly what EncodeProofHex would produce: the proof set must have the
+// number of elements expected for the index and the number of leaves, every
+// element after the leaf data must be the size of the root, and the hex must
+// be lowercase. ErrUnknownProofVersion is returned if the version is not the
+// one written by EncodeProofHex. The proof itself is not verified.
+func DecodeProofHex(s string) (Proof, error) {
+	fields := strings.Split(s, ":")
+	if fields[0] != hex.EncodeToString([]byte{proofVersionSingle}) {
+		return Proof{}, ErrUnknownProofVersion
+	}
+	fields = fields[1:]
+	if len(fields) < 3 {
+		return Proof{}, errors.New("encoded proof is missing the root or the leaf data")
+	}
+	params := strings.Split(fields[0], "/")
+	if len(params) != 2 {
+		return Proof{}, errors.New("encoded proof must have <index>/<numLeaves> after the version")
+	}
+	var p Proof
+	var err error
+	if p.Index, err = strconv.ParseUint(params[0], 10, 64); err != nil {
+		return Proof{}, errors.New("encoded proof has an invalid index")
+	}
+	if p.NumLeaves, err = strconv.ParseUint(params[1], 10, 64); err != nil {
+		return Proof{}, errors.New("encoded proof has an invalid number of leaves")
+	}
+	if p.Index >= p.NumLeaves {
+		return Proof{}, ErrProofIndexOutOfRange
+	}
+	if len(fields)-2 != proofLen(p.Index, p.NumLeaves) {
+		return Proof{}, errors.New("encoded proof has the wrong number of elements for its index")
+	}
+
+	if p.Root, err = hex.DecodeString(fields[1]); err != nil || len(p.Root) == 0 {
+		return Proof{}, errors.New("encoded proof has an invalid root")
+	}
+	p.Set = make([][]byte, len(fields)-2)
+	for i, field := range fields[2:] {
+		if p.Set[i], err = hex.DecodeString(field); err != nil {
+			return Proof{}, errors.New("encoded proof has an invalid element")
+		}
+		if i > 0 && len(p.Set[i]) != len(p.Root) {
+			return Proof{}, errors.New("encoded proof has an element that is not the size of the root")
+		}
+	}
+
+	// Reject anything that decodes to the same proof but is spelled
+	// differently, such as uppercase hex or numbers with leading zeros, so
+	// that every proof has a single encoding.
+	if EncodeProofHex(p) != s {
+		return Proof{}, errors.New("encoded proof is not in canonical form")
+	}
+	return p, nil
+}
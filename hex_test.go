@@ -0,0 +1,126 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestEncodeProofHexGolden checks the encoding of fixed proofs against strings
+// that must not change.
+func TestEncodeProofHexGolden(t *testing.T) {
+	tests := []struct {
+		p       Proof
+		encoded string
+	}{
+		{
+			Proof{Root: []byte{0xab, 0x01}, Set: [][]byte{{}, {0x0f, 0xff}}, Index: 1, NumLeaves: 2},
+			"01:1/2:ab01::0fff",
+		},
+		{
+			Proof{Root: []byte{0x00, 0xff}, Set: [][]byte{[]byte("data"), {0x11, 0x11}, {0x22, 0x22}}, Index: 0, NumLeaves: 3},
+			"01:0/3:00ff:64617461:1111:2222",
+		},
+		{
+			Proof{Root: []byte{0xc0}, Set: [][]byte{{0x01}}, Index: 0, NumLeaves: 1},
+			"01:0/1:c0:01",
+		},
+	}
+	for i, test := range tests {
+		if s := EncodeProofHex(test.p); s != test.encoded {
+			t.Error("wrong encoding", i, s)
+		}
+		p, err := DecodeProofHex(test.encoded)
+		if err != nil {
+			t.Error("unable to decode golden string", i, err)
+			continue
+		}
+		if !proofsEqual(p, test.p) {
+			t.Error("golden string decoded to the wrong proof", i)
+		}
+	}
+	if RootHex([]byte{0xde, 0xad, 0xBE, 0xef}) != "deadbeef" {
+		t.Error("wrong root encoding")
+	}
+}
+
+// TestDecodeProofHexBadInputs checks that malformed and non-canonical
+// encodings are rejected.
+func TestDecodeProofHexBadInputs(t *testing.T) {
+	bad := []string{
+		"",
+		"01:1/2:ab01",
+		"01:1/2:ab01::0fff:0fff",
+		"01:1/2:ab01:",
+		"01:12:ab01::0fff",
+		"01:1/2/3:ab01::0fff",
+		"01:2/2:ab01::0fff",
+		"01:x/2:ab01::0fff",
+		"01:1/-2:ab01::0fff",
+		"01:1/2::00:0fff",
+		"01:1/2:ab0::0fff",
+		"01:1/2:ab01::0fffff",
+		"01:1/2:ab01:zz:0fff",
+		"01:1/2:AB01::0fff",
+		"01:01/2:ab01::0fff",
+		"01:+1/2:ab01::0fff",
+		"01",
+		"1/2:ab01::0fff",
+		"02:1/2:ab01::0fff",
+		"1:1/2:ab01::0fff",
+	}
+	for _, s := range bad {
+		if _, err := DecodeProofHex(s); err == nil {
+			t.Errorf("decoded bad input %q", s)
+		}
+	}
+}
+
+// TestProofHexRoundTrip checks that decoding an encoded proof returns the
+// original proof, for real proofs and for random ones.
+func TestProofHexRoundTrip(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	p := Proof{Root: mt.roots[15], Set: mt.proofSets[15][10], Index: 10, NumLeaves: 15}
+	decoded, err := DecodeProofHex(EncodeProofHex(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proofsEqual(decoded, p) || !decoded.Verify(sha256.New()) {
+		t.Error("real proof did not survive a round trip")
+	}
+
+	for i := 0; i < 500; i++ {
+		p := Proof{
+			Root:      fastrand.Bytes(32),
+			NumLeaves: fastrand.Uint64n(1<<40) + 1,
+		}
+		p.Index = fastrand.Uint64n(p.NumLeaves)
+		p.Set = [][]byte{fastrand.Bytes(fastrand.Intn(40))}
+		for j := 1; j < proofLen(p.Index, p.NumLeaves); j++ {
+			p.Set = append(p.Set, fastrand.Bytes(32))
+		}
+		decoded, err := DecodeProofHex(EncodeProofHex(p))
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !proofsEqual(decoded, p) {
+			t.Fatal("random proof did not survive a round trip", i)
+		}
+	}
+}
+
+// proofsEqual returns true if two proofs have the same fields, treating nil
+// and empty elements as equal.
+func proofsEqual(a, b Proof) bool {
+	if a.Index != b.Index || a.NumLeaves != b.NumLeaves || !bytes.Equal(a.Root, b.Root) || len(a.Set) != len(b.Set) {
+		return false
+	}
+	for i := range a.Set {
+		if !bytes.Equal(a.Set[i], b.Set[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+)
+
+// An ICS23HashOp identifies a hash function using the values of the HashOp
+// enum from the ICS-23 specification.
+type ICS23HashOp int32
+
+// The ICS-23 hash operations supported by VerifyICS23.
+const (
+	ICS23NoHash ICS23HashOp = 0
+	ICS23SHA256 ICS23HashOp = 1
+	ICS23SHA512 ICS23HashOp = 2
+)
+
+// ICS23LeafOp mirrors the LeafOp message of ICS-23. Proofs produced by this
+// package never prehash the key or value and never length-prefix them, so
+// those fields are always zero (NO_HASH and NO_PREFIX).
+type ICS23LeafOp struct {
+	Hash         ICS23HashOp
+	PrehashKey   ICS23HashOp
+	PrehashValue ICS23HashOp
+	Length       int32
+	Prefix       []byte
+}
+
+// ICS23InnerOp mirrors the InnerOp message of ICS-23. The parent of a node is
+// Hash(Prefix || node || Suffix).
+type ICS23InnerOp struct {
+	Hash   ICS23HashOp
+	Prefix []byte
+	Suffix []byte
+}
+
+// ICS23ExistenceProof mirrors the ExistenceProof message of ICS-23.
+type ICS23ExistenceProof struct {
+	Key   []byte
+	Value []byte
+	Leaf  *ICS23LeafOp
+	Path  []*ICS23InnerOp
+}
+
+// proofSides determines, for every hash in a proof set, whether that hash is
+// the left sibling (true) or the right sibling (false) of the node built from
+// all of the previous elements of the proof set. sides[i] refers to
+// proofSet[i+1], since the first element of the proof set is the leaf data.
+// As with VerifyProof, the larger subtrees to the left may be left out, and
+// any elements past the full proof set are left siblings. false is returned
+// if the proof set is too short for 'proofIndex' and 'numLeaves'.
+func proofSides(proofLen int, proofIndex, numLeaves uint64) (sides []bool, ok bool) {
+	if proofIndex >= numLeaves || proofLen < 1 {
+		return nil, false
+	}
+	if min, _ := proofLenRange(proofIndex, numLeaves); proofLen < min {
+		return nil, false
+	}
+	sides = make([]bool, 0, proofLen-1)
+	walkProofNodes(proofIndex, numLeaves, func(r nodeRange, _ proofNodeKind) {
+		if len(sides) < proofLen-1 {
+			sides = append(sides, r.end <= proofIndex)
+		}
+	})
+	for len(sides) < proofLen-1 {
+		sides = append(sides, true)
+	}
+	return sides, true
+}
+
+// ProofToICS23 converts a proof created by Tree.Prove into an ICS-23
+// ExistenceProof. The 0x00 leaf prefix is carried by the leaf op and the 0x01
+// node prefix is embedded into the prefix of every inner op. ICS-23 requires
+// both a key and a value, so the first byte of the leaf data becomes the key
+// and the rest becomes the value; leaves with fewer than two bytes of data
+// cannot be converted.
+//
+// Every tree shape translates, including trees whose number of leaves is not
+// a power of two: an elevated orphan simply becomes an inner op higher up the
+// path. Because of this, the number of inner ops depends on both the proof
+// index and the number of leaves, so an ICS-23 ProofSpec used to check these
+// proofs must not pin MinDepth or MaxDepth. Non-existence proofs have no
+// equivalent in this package.
+func ProofToICS23(proofSet [][]byte, proofIndex, numLeaves uint64, hashOp ICS23HashOp) (*ICS23ExistenceProof, error) {
+	sides, ok := proofSides(len(proofSet), proofIndex, numLeaves)
+	if !ok {
+		return nil, errors.New("proof set is invalid for the proof index and number of leaves")
+	}
+	data := proofSet[0]
+	if len(data) < 2 {
+		return nil, errors.New("ICS-23 requires at least two bytes of leaf data")
+	}
+
+	p := &ICS23ExistenceProof{
+		Key:   data[:1],
+		Value: data[1:],
+		Leaf: &ICS23LeafOp{
+			Hash:   hashOp,
+			Prefix: []byte{0},
+		},
+	}
+	for i, left := range sides {
+		op := &ICS23InnerOp{
+			Hash:   hashOp,
+			Prefix: []byte{1},
+		}
+		if left {
+			op.Prefix = append(op.Prefix, proofSet[i+1]...)
+		} else {
+			op.Suffix = proofSet[i+1]
+		}
+		p.Path = append(p.Path, op)
+	}
+	return p, nil
+}
+
+// ProofFromICS23 converts an ICS-23 ExistenceProof created by ProofToICS23
+// back into a proof set. The proof index and number of leaves are not part of
+// an ICS-23 proof, and must be supplied separately to VerifyProof.
+func ProofFromICS23(p *ICS23ExistenceProof) (proofSet [][]byte, err error) {
+	if p == nil || p.Leaf == nil {
+		return nil, errors.New("ICS-23 proof has no leaf op")
+	}
+	if !bytes.Equal(p.Leaf.Prefix, []byte{0}) || p.Leaf.PrehashKey != ICS23NoHash || p.Leaf.PrehashValue != ICS23NoHash || p.Leaf.Length != 0 {
+		return nil, errors.New("ICS-23 leaf op was not created by this package")
+	}
+	proofSet = append(proofSet, append(append([]byte(nil), p.Key...), p.Value...))
+	for _, op := range p.Path {
+		if op == nil || len(op.Prefix) < 1 || op.Prefix[0] != 1 {
+			return nil, errors.New("ICS-23 inner op is missing the node prefix")
+		}
+		switch {
+		case len(op.Prefix) > 1 && len(op.Suffix) == 0:
+			proofSet = append(proofSet, op.Prefix[1:])
+		case len(op.Prefix) == 1 && len(op.Suffix) > 0:
+			proofSet = append(proofSet, op.Suffix)
+		default:
+			return nil, errors.New("ICS-23 inner op must have exactly one sibling")
+		}
+	}
+	return proofSet, nil
+}
+
+// ics23Hash returns the hash function for an ICS-23 hash operation.
+func ics23Hash(op ICS23HashOp) hash.Hash {
+	switch op {
+	case ICS23SHA256:
+		return sha256.New()
+	case ICS23SHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// VerifyICS23 executes the operations of an ICS-23 ExistenceProof and returns
+// true if the result is the Merkle root. It implements the same computation as
+// an ICS-23 verifier, without the ProofSpec checks.
+func VerifyICS23(merkleRoot []byte, p *ICS23ExistenceProof) bool {
+	if merkleRoot == nil || p == nil || p.Leaf == nil {
+		return false
+	}
+	if len(p.Key) == 0 || len(p.Value) == 0 {
+		return false
+	}
+	if p.Leaf.PrehashKey != ICS23NoHash || p.Leaf.PrehashValue != ICS23NoHash || p.Leaf.Length != 0 {
+		return false
+	}
+	h := ics23Hash(p.Leaf.Hash)
+	if h == nil {
+		return false
+	}
+	node := sum(h, p.Leaf.Prefix, p.Key, p.Value)
+	for _, op := range p.Path {
+		if op == nil {
+			return false
+		}
+		h := ics23Hash(op.Hash)
+		if h == nil {
+			return false
+		}
+		node = sum(h, op.Prefix, node, op.Suffix)
+	}
+	return bytes.Equal(node, merkleRoot)
+}
@@ -0,0 +1,111 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"testing"
+)
+
+// TestICS23RoundTrip converts every proof of trees up to 40 leaves into ICS-23
+// form and back, checking that the ICS-23 form verifies against the root and
+// that the round trip reproduces the original proof set.
+func TestICS23RoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		newHash func() hash.Hash
+		op      ICS23HashOp
+	}{
+		{sha256.New, ICS23SHA256},
+		{sha512.New, ICS23SHA512},
+	} {
+		for numLeaves := uint64(1); numLeaves <= 40; numLeaves++ {
+			for proofIndex := uint64(0); proofIndex < numLeaves; proofIndex++ {
+				tree := New(test.newHash())
+				if err := tree.SetIndex(proofIndex); err != nil {
+					t.Fatal(err)
+				}
+				for i := uint64(0); i < numLeaves; i++ {
+					tree.Push([]byte{byte(i), byte(i + 1)})
+				}
+				root, proofSet, _, _ := tree.Prove()
+
+				p, err := ProofToICS23(proofSet, proofIndex, numLeaves, test.op)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(p.Path) != len(proofSet)-1 {
+					t.Error("wrong number of inner ops", numLeaves, proofIndex)
+				}
+				if !VerifyICS23(root, p) {
+					t.Error("ICS-23 proof did not verify", numLeaves, proofIndex)
+				}
+
+				proofSet2, err := ProofFromICS23(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(proofSet2) != len(proofSet) {
+					t.Fatal("round trip changed the length of the proof", numLeaves, proofIndex)
+				}
+				for i := range proofSet {
+					if !bytes.Equal(proofSet[i], proofSet2[i]) {
+						t.Error("round trip changed the proof", numLeaves, proofIndex, i)
+					}
+				}
+				if !VerifyProof(test.newHash(), root, proofSet2, proofIndex, numLeaves) {
+					t.Error("round tripped proof did not verify", numLeaves, proofIndex)
+				}
+			}
+		}
+	}
+}
+
+// TestICS23BadInputs checks that invalid proofs are rejected by the converters
+// and the verifier.
+func TestICS23BadInputs(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	if _, err := ProofToICS23(mt.proofSets[15][10], 10, 15, ICS23SHA256); err == nil {
+		t.Error("able to convert a proof with single byte leaf data")
+	}
+
+	tree := New(sha256.New())
+	if err := tree.SetIndex(3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		tree.Push([]byte{byte(i), 0})
+	}
+	root, proofSet, _, _ := tree.Prove()
+	if _, err := ProofToICS23(proofSet[:2], 3, 7, ICS23SHA256); err == nil {
+		t.Error("able to convert a proof that is too short")
+	}
+	if _, err := ProofToICS23(proofSet, 7, 7, ICS23SHA256); err == nil {
+		t.Error("able to convert a proof with an out of bounds index")
+	}
+
+	p, err := ProofToICS23(proofSet, 3, 7, ICS23SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyICS23(nil, p) {
+		t.Error("ICS-23 proof verified against a nil root")
+	}
+	p.Path[1].Hash = ICS23NoHash
+	if VerifyICS23(root, p) {
+		t.Error("ICS-23 proof verified with an unsupported hash")
+	}
+	p.Path[1].Hash = ICS23SHA256
+	p.Path[0].Prefix, p.Path[0].Suffix = []byte{1}, p.Path[0].Prefix[1:]
+	if VerifyICS23(root, p) {
+		t.Error("ICS-23 proof verified with a sibling on the wrong side")
+	}
+	p.Path[0].Prefix = []byte{1, 2}
+	if _, err := ProofFromICS23(p); err == nil {
+		t.Error("able to convert an inner op with two siblings")
+	}
+	p.Path[0].Prefix = []byte{0}
+	if _, err := ProofFromICS23(p); err == nil {
+		t.Error("able to convert an inner op without the node prefix")
+	}
+}
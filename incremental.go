@@ -0,0 +1,246 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// An IncrementalTree is a Merkle tree of a fixed depth that is filled from left
+// to right, in the style of the Ethereum deposit contract. A tree of depth D
+// always has 2^D leaves. Leaves that have not been appended yet take the value
+// 'zeroLeaf', and the roots of entirely empty subtrees are precomputed so that
+// the Merkle root can be produced in O(D) hashes regardless of how many leaves
+// have been appended.
+//
+// Unlike Tree, an IncrementalTree never promotes orphans: the shape of the tree
+// is always the full binary tree of depth D. Proofs produced by an
+// IncrementalTree must therefore be checked with VerifyIncrementalProof rather
+// than VerifyProof.
+//
+// A tree created with NewIncremental hashes leaves and nodes with the same
+// domain separation as Tree. A tree created with NewPlainIncremental does not,
+// so that its root is the same as the root of systems such as the deposit
+// contract, whose nodes are H(left || right).
+type IncrementalTree struct {
+	depth int
+	hash  hash.Hash
+	plain bool
+
+	// branch[i] holds the root of the most recently completed left subtree of
+	// height i. zeroes[i] holds the root of an empty subtree of height i, with
+	// zeroes[0] being the zero leaf. Once the tree is full, 'full' holds the
+	// root.
+	branch [][]byte
+	zeroes [][]byte
+	full   []byte
+
+	// levels[i] holds the roots of every complete subtree of height i, from
+	// left to right, so levels[0] holds the leaf hashes. They are kept so that
+	// a proof reads its siblings instead of rebuilding the tree: only the
+	// subtree that holds the last leaf is incomplete, and building its root
+	// costs O(D) hashes, so a proof costs O(D^2) hashes at most. The data of
+	// every leaf is kept for the first element of a proof, unless the tree is
+	// plain, in which case the data is the leaf hash.
+	levels [][][]byte
+	data   [][]byte
+}
+
+// NewIncremental creates an IncrementalTree of the given depth. Leaves that
+// have not been appended are given the value 'zeroLeaf', which is used as-is
+// and is not passed through leafSum. The depth must be in the range [0, 63].
+func NewIncremental(h hash.Hash, depth int, zeroLeaf []byte) *IncrementalTree {
+	return newIncremental(h, depth, zeroLeaf, false)
+}
+
+// NewPlainIncremental is the same as NewIncremental, but the tree does not
+// prefix the data it hashes: appended leaves are used as-is, and each node is
+// H(left || right). With sha256, a depth of 32 and a zero leaf of 32 zero
+// bytes, the root is the root of the Ethereum deposit contract's tree, before
+// the deposit count is mixed in. Every appended leaf must be the size of the
+// hash's output. Proofs must be checked with VerifyPlainIncrementalProof.
+func NewPlainIncremental(h hash.Hash, depth int, zeroLeaf []byte) *IncrementalTree {
+	return newIncremental(h, depth, zeroLeaf, true)
+}
+
+// newIncremental creates an IncrementalTree and precomputes its zero
+// subtrees.
+func newIncremental(h hash.Hash, depth int, zeroLeaf []byte, plain bool) *IncrementalTree {
+	if depth < 0 || depth > MaxHeight {
+		panic("wrong usage: depth of an IncrementalTree must be between 0 and 63")
+	}
+
+	it := &IncrementalTree{
+		depth:  depth,
+		hash:   h,
+		plain:  plain,
+		branch: make([][]byte, depth),
+		zeroes: make([][]byte, depth+1),
+		levels: make([][][]byte, depth+1),
+	}
+	it.zeroes[0] = zeroLeaf
+	for i := 1; i <= depth; i++ {
+		it.zeroes[i] = it.node(it.zeroes[i-1], it.zeroes[i-1])
+	}
+	return it
+}
+
+// node returns the sum of the nodes 'a' and 'b', with or without the node
+// prefix depending on the tree.
+func (it *IncrementalTree) node(a, b []byte) []byte {
+	if it.plain {
+		return sum(it.hash, a, b)
+	}
+	return nodeSum(it.hash, a, b)
+}
+
+// Append adds a leaf to the tree. The leaf is hashed using leafSum, the same
+// as for Tree, unless the tree is plain, in which case it is used as-is and
+// must be the size of the hash's output. An error is returned if the tree
+// already has 2^depth leaves.
+func (it *IncrementalTree) Append(data []byte) error {
+	size := uint64(len(it.levels[0]))
+	if size == 1<<uint(it.depth) {
+		return errors.New("cannot append to an IncrementalTree that is full")
+	}
+
+	var leaf []byte
+	if it.plain {
+		if len(data) != it.hash.Size() {
+			return errors.New("leaf of a plain IncrementalTree must be the size of the hash")
+		}
+		leaf = data
+	} else {
+		leaf = leafSum(it.hash, data)
+		it.data = append(it.data, data)
+	}
+	it.levels[0] = append(it.levels[0], leaf)
+
+	// Walk up the tree, combining the new node with the completed left
+	// subtrees until the node becomes a left child itself. If the walk reaches
+	// the top, the tree has become full and the final node is the root.
+	node := leaf
+	for height := 0; height < it.depth; height++ {
+		if size&1 == 0 {
+			it.branch[height] = node
+			return nil
+		}
+		node = it.node(it.branch[height], node)
+		it.levels[height+1] = append(it.levels[height+1], node)
+		size >>= 1
+	}
+	it.full = node
+	return nil
+}
+
+// Root returns the Merkle root of the tree, using the zero subtrees for any
+// leaves that have not been appended yet.
+func (it *IncrementalTree) Root() []byte {
+	if it.full != nil {
+		return it.full
+	}
+
+	// Combine the stored left subtrees with the zero subtrees from the bottom
+	// of the tree up. A set bit in 'size' means that a complete left subtree
+	// of that height exists.
+	size := uint64(len(it.levels[0]))
+	node := it.zeroes[0]
+	for height := 0; height < it.depth; height++ {
+		if size&1 == 1 {
+			node = it.node(it.branch[height], node)
+		} else {
+			node = it.node(node, it.zeroes[height])
+		}
+		size >>= 1
+	}
+	return node
+}
+
+// NumLeaves returns the number of leaves that have been appended to the tree.
+func (it *IncrementalTree) NumLeaves() uint64 {
+	return uint64(len(it.levels[0]))
+}
+
+// subtreeRoot returns the root of the 'index'th subtree of the given height,
+// which is stored if it is complete, a zero subtree if it has no leaves, and
+// built from its two halves otherwise.
+func (it *IncrementalTree) subtreeRoot(height int, index uint64) []byte {
+	if index < uint64(len(it.levels[height])) {
+		return it.levels[height][index]
+	}
+	if index<<uint(height) >= uint64(len(it.levels[0])) {
+		return it.zeroes[height]
+	}
+	return it.node(it.subtreeRoot(height-1, 2*index), it.subtreeRoot(height-1, 2*index+1))
+}
+
+// Prove creates a proof that the leaf at index 'i' is an element of the tree.
+// The first element of the proof set is the data of the leaf, followed by
+// exactly 'depth' sibling hashes ordered from the bottom of the tree to the
+// top. The siblings are read from the stored subtrees, so a proof costs
+// O(depth^2) hashes at most, however many leaves have been appended.
+func (it *IncrementalTree) Prove(i uint64) (merkleRoot []byte, proofSet [][]byte, err error) {
+	if i >= uint64(len(it.levels[0])) {
+		return nil, nil, errors.New("cannot prove an index that has not been appended")
+	}
+
+	proofSet = make([][]byte, 0, it.depth+1)
+	if it.plain {
+		proofSet = append(proofSet, it.levels[0][i])
+	} else {
+		proofSet = append(proofSet, it.data[i])
+	}
+	for height := 0; height < it.depth; height++ {
+		proofSet = append(proofSet, it.subtreeRoot(height, (i>>uint(height))^1))
+	}
+	return it.Root(), proofSet, nil
+}
+
+// VerifyIncrementalProof takes a Merkle root, a proof set created by an
+// IncrementalTree of the given depth, and a proof index, and returns true if
+// the first element of the proof set is the leaf at 'proofIndex'.
+func VerifyIncrementalProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, depth int) bool {
+	return verifyIncrementalProof(h, merkleRoot, proofSet, proofIndex, depth, false)
+}
+
+// VerifyPlainIncrementalProof is the same as VerifyIncrementalProof, for the
+// proofs of an IncrementalTree created with NewPlainIncremental. The first
+// element of the proof set is the leaf hash itself.
+func VerifyPlainIncrementalProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, depth int) bool {
+	return verifyIncrementalProof(h, merkleRoot, proofSet, proofIndex, depth, true)
+}
+
+// verifyIncrementalProof verifies the proof of a prefixed or a plain
+// IncrementalTree.
+func verifyIncrementalProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, depth int, plain bool) bool {
+	if merkleRoot == nil {
+		return false
+	}
+	if depth < 0 || depth > MaxHeight || proofIndex >= 1<<uint(depth) {
+		return false
+	}
+	if len(proofSet) != depth+1 {
+		return false
+	}
+
+	// Every level of the tree is complete, so the position of each sibling is
+	// determined entirely by the corresponding bit of the proof index.
+	join, root := nodeSum, []byte(nil)
+	if plain {
+		if len(proofSet[0]) != h.Size() {
+			return false
+		}
+		join = func(h hash.Hash, a, b []byte) []byte { return sum(h, a, b) }
+		root = proofSet[0]
+	} else {
+		root = leafSum(h, proofSet[0])
+	}
+	for height := 0; height < depth; height++ {
+		if proofIndex&(1<<uint(height)) == 0 {
+			root = join(h, root, proofSet[height+1])
+		} else {
+			root = join(h, proofSet[height+1], root)
+		}
+	}
+	return bytes.Equal(root, merkleRoot)
+}
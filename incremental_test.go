@@ -0,0 +1,194 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// naiveIncrementalRoot builds the full tree of the given depth by padding the
+// leaf hashes out with 'zeroLeaf' and hashing every level.
+func naiveIncrementalRoot(data [][]byte, depth int, zeroLeaf []byte) []byte {
+	level := make([][]byte, 1<<uint(depth))
+	for i := range level {
+		if i < len(data) {
+			level[i] = leafSum(sha256.New(), data[i])
+		} else {
+			level[i] = zeroLeaf
+		}
+	}
+	for len(level) > 1 {
+		next := make([][]byte, len(level)/2)
+		for i := range next {
+			next[i] = nodeSum(sha256.New(), level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// TestIncrementalTreeRoot compares the root of an IncrementalTree against a
+// naively constructed full tree after every append, for several depths.
+func TestIncrementalTreeRoot(t *testing.T) {
+	zeroLeaf := make([]byte, 32)
+	for depth := 0; depth < 6; depth++ {
+		it := NewIncremental(sha256.New(), depth, zeroLeaf)
+		var data [][]byte
+		if !bytes.Equal(it.Root(), naiveIncrementalRoot(data, depth, zeroLeaf)) {
+			t.Error("empty root does not match naive root at depth", depth)
+		}
+		for i := 0; i < 1<<uint(depth); i++ {
+			data = append(data, []byte{byte(i)})
+			if err := it.Append(data[i]); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(it.Root(), naiveIncrementalRoot(data, depth, zeroLeaf)) {
+				t.Error("root does not match naive root at depth", depth, "after", i+1, "leaves")
+			}
+		}
+		if it.NumLeaves() != uint64(len(data)) {
+			t.Error("wrong leaf count at depth", depth)
+		}
+
+		// The tree is full, further appends should fail.
+		if err := it.Append([]byte{1}); err == nil {
+			t.Error("able to append to a full tree at depth", depth)
+		}
+	}
+}
+
+// TestIncrementalTreeProof builds and verifies proofs for every index of
+// partially and completely filled IncrementalTrees.
+func TestIncrementalTreeProof(t *testing.T) {
+	zeroLeaf := []byte("zero")
+	for depth := 0; depth < 5; depth++ {
+		for size := 1; size <= 1<<uint(depth); size++ {
+			it := NewIncremental(sha256.New(), depth, zeroLeaf)
+			for i := 0; i < size; i++ {
+				if err := it.Append([]byte{byte(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := uint64(0); i < uint64(size); i++ {
+				root, proofSet, err := it.Prove(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(proofSet) != depth+1 {
+					t.Error("proof has the wrong length for", depth, size, i)
+				}
+				if !VerifyIncrementalProof(sha256.New(), root, proofSet, i, depth) {
+					t.Error("proof did not verify for", depth, size, i)
+				}
+				// The proof should not verify for any other index.
+				for j := uint64(0); j < 1<<uint(depth); j++ {
+					if j != i && VerifyIncrementalProof(sha256.New(), root, proofSet, j, depth) {
+						t.Error("proof verified for wrong index", depth, size, i, j)
+					}
+				}
+			}
+
+			if _, _, err := it.Prove(uint64(size)); err == nil {
+				t.Error("able to prove an index that was never appended")
+			}
+		}
+	}
+}
+
+// TestIncrementalTreeBadInputs checks that VerifyIncrementalProof rejects
+// malformed inputs.
+func TestIncrementalTreeBadInputs(t *testing.T) {
+	it := NewIncremental(sha256.New(), 3, make([]byte, 32))
+	for i := 0; i < 5; i++ {
+		if err := it.Append([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, proofSet, err := it.Prove(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyIncrementalProof(sha256.New(), nil, proofSet, 2, 3) {
+		t.Error("proof verified with a nil root")
+	}
+	if VerifyIncrementalProof(sha256.New(), root, proofSet[:3], 2, 3) {
+		t.Error("truncated proof verified")
+	}
+	if VerifyIncrementalProof(sha256.New(), root, append(proofSet, root), 2, 3) {
+		t.Error("extended proof verified")
+	}
+	if VerifyIncrementalProof(sha256.New(), root, proofSet, 8, 3) {
+		t.Error("proof verified for an index outside of the tree")
+	}
+	if VerifyIncrementalProof(sha256.New(), root, proofSet, 2, 4) {
+		t.Error("proof verified for the wrong depth")
+	}
+}
+
+// TestPlainIncrementalTree checks the roots of a plain IncrementalTree
+// against the zero hashes of the Ethereum deposit contract and against a
+// naively constructed full tree, and checks its proofs.
+func TestPlainIncrementalTree(t *testing.T) {
+	// The roots of empty trees of depth 1 to 4 are the zero hashes of the
+	// deposit contract.
+	zeroHashes := []string{
+		"f5a5fd42d16a20302798ef6ed309979b43003d2320d9f0e8ea9831a92759fb4b",
+		"db56114e00fdd4c1f85c892bf35ac9a89289aaecb1ebd0a96cde606a748b5d71",
+		"c78009fdf07fc56a11f122370658a353aaa542ed63e44c4bc15ff4cd105ab33c",
+		"536d98837f2dd165a55d5eeae91485954472d56f246df256bf3cae19352a123c",
+	}
+	zeroLeaf := make([]byte, 32)
+	for i, zh := range zeroHashes {
+		if root := NewPlainIncremental(sha256.New(), i+1, zeroLeaf).Root(); hex.EncodeToString(root) != zh {
+			t.Error("empty root does not match the zero hash at depth", i+1)
+		}
+	}
+
+	for depth := 0; depth < 5; depth++ {
+		it := NewPlainIncremental(sha256.New(), depth, zeroLeaf)
+		var leaves [][]byte
+		for i := 0; i < 1<<uint(depth); i++ {
+			leaves = append(leaves, sum(sha256.New(), []byte{byte(i)}))
+			if err := it.Append(leaves[i]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Build the naive root with plain nodes.
+			level := make([][]byte, 1<<uint(depth))
+			for j := range level {
+				level[j] = zeroLeaf
+			}
+			copy(level, leaves)
+			for len(level) > 1 {
+				next := make([][]byte, len(level)/2)
+				for j := range next {
+					next[j] = sum(sha256.New(), level[2*j], level[2*j+1])
+				}
+				level = next
+			}
+			if !bytes.Equal(it.Root(), level[0]) {
+				t.Fatal("root does not match naive root at depth", depth, "after", i+1, "leaves")
+			}
+
+			for j := uint64(0); j <= uint64(i); j++ {
+				root, proofSet, err := it.Prove(j)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(proofSet[0], leaves[j]) || !VerifyPlainIncrementalProof(sha256.New(), root, proofSet, j, depth) {
+					t.Error("proof did not verify for", depth, i+1, j)
+				}
+				if depth > 0 && VerifyIncrementalProof(sha256.New(), root, proofSet, j, depth) {
+					t.Error("plain proof verified as a prefixed proof", depth, i+1, j)
+				}
+			}
+		}
+	}
+
+	it := NewPlainIncremental(sha256.New(), 3, zeroLeaf)
+	if err := it.Append([]byte("short")); err == nil {
+		t.Error("leaf of the wrong size was appended")
+	}
+}
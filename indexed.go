@@ -0,0 +1,94 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// leafIndexSize is the size of the leaf index that IndexLeaves adds to every
+// leaf sum.
+const leafIndexSize = 8
+
+// leafIndexBytes returns the encoding of 'index' that IndexLeaves adds to
+// the leaf sum of the leaf at 'index'.
+func leafIndexBytes(index uint64) []byte {
+	b := make([]byte, leafIndexSize)
+	binary.LittleEndian.PutUint64(b, index)
+	return b
+}
+
+// appendIndexedLeafSum appends the indexed leaf sum of 'data' at 'index' to
+// 'dst'.
+func appendIndexedLeafSum(dst []byte, h hash.Hash, index uint64, data []byte) []byte {
+	h.Reset()
+	_, _ = h.Write(leafHashPrefix)
+	_, _ = h.Write(leafIndexBytes(index))
+	_, _ = h.Write(data)
+	return h.Sum(dst)
+}
+
+// IndexedLeafSum returns the leaf sum of 'data' at 'index' in a Tree whose
+// leaf sums include the index, see IndexLeaves: H(0x00 || index || data),
+// with the index as an 8 byte little-endian integer. To build the root of
+// such a tree with BuildFromLeaves, pass it the indexed leaf sums.
+func IndexedLeafSum(h hash.Hash, index uint64, data []byte) []byte {
+	return appendIndexedLeafSum(nil, h, index, data)
+}
+
+// IndexLeaves makes the Tree include the index of every leaf in its leaf sum,
+// as IndexedLeafSum does, so that a leaf can't be moved to another position,
+// in this tree or a related one, without changing its sum. The roots and
+// proofs of the Tree differ from those of a Tree without the option, and its
+// proofs must be checked with VerifyIndexedProof. ReadAll pushes one leaf at a
+// time instead of in batches, and PadTo pushes every copy of the pad leaf
+// separately, since each copy has a different sum. It must be called while
+// the Tree is empty, and a CachedTree, whose leaves are cached node roots,
+// can't index them; an error is returned in either case, and the Tree is left
+// unchanged. The setting is kept by Reset.
+func (t *Tree) IndexLeaves() error {
+	if !t.IsEmpty() {
+		return errors.New("cannot call IndexLeaves on Tree if Tree has not been reset")
+	}
+	if t.cachedTree {
+		return errors.New("cannot index the leaves of a cached tree")
+	}
+	t.indexedLeaves = true
+	return nil
+}
+
+// appendLeafSum appends the leaf sum of 'data' to 'dst', as the leaf at the
+// current index.
+func (t *Tree) appendLeafSum(dst []byte, data []byte) []byte {
+	if t.indexedLeaves {
+		return appendIndexedLeafSum(dst, t.hash, t.currentIndex, data)
+	}
+	return appendLeafSum(dst, t.hash, data)
+}
+
+// VerifyIndexedProof is the same as VerifyProof, for a proof built by a Tree
+// whose leaf sums include the index, see IndexLeaves. A proof built without
+// the option never verifies, and neither does a proof built with the option
+// when checked by VerifyProof.
+func VerifyIndexedProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) bool {
+	if len(proofSet) == 0 {
+		return false
+	}
+	return VerifyProofWithLeafHash(h, merkleRoot, IndexedLeafSum(h, proofIndex, proofSet[0]), proofSet[1:], proofIndex, numLeaves)
+}
+
+// IndexedReaderRoot is the same as ReaderRoot, but for a Tree whose leaf sums
+// include the index, see IndexLeaves.
+func IndexedReaderRoot(r io.Reader, h hash.Hash, segmentSize int) (root []byte, err error) {
+	tree := New(h)
+	if err = tree.IndexLeaves(); err != nil {
+		return
+	}
+	err = tree.ReadAll(r, segmentSize)
+	if err != nil {
+		return
+	}
+	root = tree.Root()
+	return
+}
@@ -0,0 +1,192 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestIndexedLeavesVectors pins the roots of small trees whose leaf sums
+// include the index, computed by hand from IndexedLeafSum.
+func TestIndexedLeavesVectors(t *testing.T) {
+	h := sha256.New()
+	leaves := [][]byte{{0}, {1}, {2}}
+	l0, l1, l2 := IndexedLeafSum(h, 0, leaves[0]), IndexedLeafSum(h, 1, leaves[1]), IndexedLeafSum(h, 2, leaves[2])
+	if !bytes.Equal(l0, sum(h, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0})) {
+		t.Error("wrong indexed leaf sum for leaf 0")
+	}
+	if !bytes.Equal(l1, sum(h, []byte{0, 1, 0, 0, 0, 0, 0, 0, 0, 1})) {
+		t.Error("wrong indexed leaf sum for leaf 1")
+	}
+	expected := nodeSum(h, nodeSum(h, l0, l1), l2)
+
+	tree := New(sha256.New())
+	if err := tree.IndexLeaves(); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		tree.Push(leaf)
+	}
+	if !bytes.Equal(tree.Root(), expected) {
+		t.Error("wrong indexed root")
+	}
+	golden := "ef0d861db7f361de7bd0f1b4b9f6eb373d6c1ef2eaad35e52c6d851f17d6eb84"
+	if hex.EncodeToString(tree.Root()) != golden {
+		t.Error("indexed root changed", hex.EncodeToString(tree.Root()))
+	}
+
+	plain := New(sha256.New())
+	for _, leaf := range leaves {
+		plain.Push(leaf)
+	}
+	if bytes.Equal(plain.Root(), tree.Root()) {
+		t.Error("indexed root is the same as the plain root")
+	}
+}
+
+// TestIndexedLeavesProofs checks that indexed proofs verify only in indexed
+// mode, and that plain proofs don't verify in indexed mode.
+func TestIndexedLeavesProofs(t *testing.T) {
+	for numLeaves := uint64(1); numLeaves < 20; numLeaves++ {
+		data := fastrand.Bytes(int(numLeaves) * 16)
+		for index := uint64(0); index < numLeaves; index++ {
+			indexed, plain := New(sha256.New()), New(sha256.New())
+			if err := indexed.IndexLeaves(); err != nil {
+				t.Fatal(err)
+			}
+			if err := indexed.SetIndex(index); err != nil {
+				t.Fatal(err)
+			}
+			if err := plain.SetIndex(index); err != nil {
+				t.Fatal(err)
+			}
+			if err := indexed.ReadAll(bytes.NewReader(data), 16); err != nil {
+				t.Fatal(err)
+			}
+			if err := plain.ReadAll(bytes.NewReader(data), 16); err != nil {
+				t.Fatal(err)
+			}
+			root, proofSet, _, _ := indexed.Prove()
+			plainRoot, plainProof, _, _ := plain.Prove()
+			if !VerifyIndexedProof(sha256.New(), root, proofSet, index, numLeaves) {
+				t.Error("indexed proof does not verify", numLeaves, index)
+			}
+			if VerifyProof(sha256.New(), root, proofSet, index, numLeaves) {
+				t.Error("indexed proof verifies in plain mode", numLeaves, index)
+			}
+			if VerifyIndexedProof(sha256.New(), plainRoot, plainProof, index, numLeaves) {
+				t.Error("plain proof verifies in indexed mode", numLeaves, index)
+			}
+
+			// A leaf moved to another position doesn't verify there, even
+			// with the siblings of that position.
+			if numLeaves > 1 {
+				other := (index + 1) % numLeaves
+				moved := New(sha256.New())
+				if err := moved.IndexLeaves(); err != nil {
+					t.Fatal(err)
+				}
+				if err := moved.SetIndex(other); err != nil {
+					t.Fatal(err)
+				}
+				if err := moved.ReadAll(bytes.NewReader(data), 16); err != nil {
+					t.Fatal(err)
+				}
+				_, movedProof, _, _ := moved.Prove()
+				movedProof[0] = proofSet[0]
+				if VerifyIndexedProof(sha256.New(), root, movedProof, other, numLeaves) {
+					t.Error("leaf verifies at another position", numLeaves, index)
+				}
+			}
+
+			readerRoot, err := IndexedReaderRoot(bytes.NewReader(data), sha256.New(), 16)
+			if err != nil || !bytes.Equal(readerRoot, root) {
+				t.Error("IndexedReaderRoot does not match the indexed tree", numLeaves, err)
+			}
+		}
+	}
+}
+
+// TestIndexedLeavesEntryPoints checks that the other ways of adding leaves
+// agree with Push when the leaf sums include the index.
+func TestIndexedLeavesEntryPoints(t *testing.T) {
+	leaves := make([][]byte, 11)
+	leafSums := make([][]byte, len(leaves))
+	for i := range leaves {
+		leaves[i] = fastrand.Bytes(24)
+		leafSums[i] = IndexedLeafSum(sha256.New(), uint64(i), leaves[i])
+	}
+	tree := New(sha256.New())
+	if err := tree.IndexLeaves(); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		tree.Push(leaf)
+	}
+
+	// BuildFromLeaves takes the indexed leaf sums.
+	root, err := BuildFromLeaves(sha256.New, leafSums, 2)
+	if err != nil || !bytes.Equal(root, tree.Root()) {
+		t.Error("BuildFromLeaves does not match the indexed tree", err)
+	}
+
+	// PushReader includes the index.
+	streamed := New(sha256.New())
+	if err := streamed.IndexLeaves(); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		if err := streamed.PushReader(bytes.NewReader(leaf)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(streamed.Root(), tree.Root()) {
+		t.Error("PushReader does not match Push")
+	}
+
+	// PadTo pushes every copy of the pad leaf at its own index.
+	padded := New(sha256.New())
+	if err := padded.IndexLeaves(); err != nil {
+		t.Fatal(err)
+	}
+	expected := New(sha256.New())
+	if err := expected.IndexLeaves(); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		padded.Push(leaf)
+		expected.Push(leaf)
+	}
+	padded.PadTo([]byte{9})
+	for expected.CurrentIndex() < 16 {
+		expected.Push([]byte{9})
+	}
+	if padded.CurrentIndex() != 16 || !bytes.Equal(padded.Root(), expected.Root()) {
+		t.Error("PadTo does not match pushing the copies")
+	}
+
+	// The setting is kept by Reset, and rejected on a tree that isn't empty.
+	tree.Reset()
+	tree.Push(leaves[0])
+	if !bytes.Equal(tree.Root(), leafSums[0]) {
+		t.Error("Reset did not keep the setting")
+	}
+	late := New(sha256.New())
+	late.Push(leaves[0])
+	if late.IndexLeaves() == nil {
+		t.Error("IndexLeaves succeeded on a tree that was not empty")
+	}
+	late.Push(leaves[1])
+	plain := New(sha256.New())
+	plain.Push(leaves[0])
+	plain.Push(leaves[1])
+	if !bytes.Equal(late.Root(), plain.Root()) {
+		t.Error("IndexLeaves changed a tree that was not empty")
+	}
+	if NewCachedTree(sha256.New(), 2).IndexLeaves() == nil {
+		t.Error("IndexLeaves succeeded on a cached tree")
+	}
+}
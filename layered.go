@@ -0,0 +1,206 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"strconv"
+)
+
+// A ProofLayer is one layer of a LayeredProof: a proof within the tree built
+// from the leaves [Begin, End) of the full tree, whose base elements each
+// cover 2^Height of those leaves. The last base element may cover fewer if
+// the range ends first. Set is a proof set within that tree. In the first
+// layer, whose Height is 0, Set[0] is the leaf data. In every other layer,
+// Set[0] is the root of the previous layer, which is the base element
+// containing the proven leaf.
+type ProofLayer struct {
+	Begin  uint64
+	End    uint64
+	Height uint64
+	Set    [][]byte
+
+	// Source names the party that produced the layer. It is only used to
+	// attribute errors.
+	Source string
+}
+
+// A LayeredProof is a proof that the leaf at Index is an element of a Merkle
+// tree, kept as the separate layers that it was assembled from, ordered from
+// the leaf to the root. For example, a proof built with a CachedTree has a
+// layer for the proof within the cached node and a layer for the proof of the
+// cached node within the tree. The last layer covers the whole tree, so its
+// Begin is 0 and its End is the number of leaves.
+type LayeredProof struct {
+	Index  uint64
+	Layers []ProofLayer
+}
+
+// A LayerError is returned when a layer of a LayeredProof is invalid or does
+// not fit with the layer before it. Layer is the position of the layer.
+type LayerError struct {
+	Layer  int
+	Source string
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *LayerError) Error() string {
+	s := "proof layer " + strconv.Itoa(e.Layer)
+	if e.Source != "" {
+		s += " (" + e.Source + ")"
+	}
+	return s + ": " + e.Err.Error()
+}
+
+// layerError returns a LayerError for the k'th layer of 'lp'.
+func (lp LayeredProof) layerError(k int, err error) error {
+	return &LayerError{Layer: k, Source: lp.Layers[k].Source, Err: err}
+}
+
+// root computes the root of the tree of the layer from its proof set. 'index'
+// is the index of the proven leaf in the full tree. If 'leaf' is true, Set[0]
+// is leaf data, otherwise it is the sum of a node.
+func (l ProofLayer) root(h hash.Hash, index uint64, leaf bool) ([]byte, error) {
+	numElements := (l.End-l.Begin-1)>>l.Height + 1
+	elemIndex := (index - l.Begin) >> l.Height
+	ranges := proofNodeRanges(elemIndex, numElements)
+	if len(l.Set) < len(ranges)+1 {
+		return nil, ErrProofTooShort
+	} else if len(l.Set) > len(ranges)+1 {
+		return nil, ErrProofTooLong
+	}
+	for i := 1; i < len(l.Set); i++ {
+		if len(l.Set[i]) != h.Size() {
+			return nil, &ElementSizeError{Index: i, Size: len(l.Set[i]), Expected: h.Size()}
+		}
+	}
+
+	var sum []byte
+	if leaf {
+		sum = leafSum(h, l.Set[0])
+	} else if len(l.Set[0]) != h.Size() {
+		return nil, &ElementSizeError{Index: 0, Size: len(l.Set[0]), Expected: h.Size()}
+	} else {
+		sum = append([]byte(nil), l.Set[0]...)
+	}
+	start := elemIndex
+	for i, r := range ranges {
+		if r.end <= start {
+			sum = appendNodeSum(sum[:0], h, l.Set[i+1], sum)
+			start = r.start
+		} else {
+			sum = appendNodeSum(sum[:0], h, sum, l.Set[i+1])
+		}
+	}
+	return sum, nil
+}
+
+// layerRoots checks the ranges of the layers and computes the root of every
+// layer from its own proof set, without checking that the layers fit
+// together.
+func (lp LayeredProof) layerRoots(h hash.Hash) ([][]byte, error) {
+	if len(lp.Layers) == 0 {
+		return nil, errors.New("layered proof has no layers")
+	}
+
+	// The ranges only depend on the index and the layers above, so they are
+	// checked from the top down.
+	for k := len(lp.Layers) - 1; k >= 0; k-- {
+		l := lp.Layers[k]
+		if l.Height > MaxHeight || l.Begin > lp.Index || lp.Index >= l.End {
+			return nil, lp.layerError(k, errors.New("layer does not contain the proof index"))
+		}
+		if k == len(lp.Layers)-1 && l.Begin != 0 {
+			return nil, lp.layerError(k, errors.New("last layer does not cover the whole tree"))
+		}
+		if k == 0 && l.Height != 0 {
+			return nil, lp.layerError(k, errors.New("first layer must start from a leaf"))
+		}
+		if k < len(lp.Layers)-1 {
+			parent := lp.Layers[k+1]
+			begin := parent.Begin + (lp.Index-parent.Begin)>>parent.Height<<parent.Height
+			end := begin + 1<<parent.Height
+			if end > parent.End || end < begin {
+				end = parent.End
+			}
+			if l.Begin != begin || l.End != end || l.Height >= parent.Height {
+				return nil, lp.layerError(k, errors.New("layer does not cover the base element of the next layer"))
+			}
+		}
+	}
+
+	roots := make([][]byte, len(lp.Layers))
+	for k := len(lp.Layers) - 1; k >= 0; k-- {
+		var err error
+		if roots[k], err = lp.Layers[k].root(h, lp.Index, k == 0); err != nil {
+			return nil, lp.layerError(k, err)
+		}
+	}
+	return roots, nil
+}
+
+// link checks, from the top down, that the root of every layer is the base
+// element of the layer above it. A layer whose root does not match is the
+// one at fault, since the layer above it vouches for its base element.
+func (lp LayeredProof) link(roots [][]byte) error {
+	for k := len(lp.Layers) - 2; k >= 0; k-- {
+		if !bytes.Equal(roots[k], lp.Layers[k+1].Set[0]) {
+			return lp.layerError(k, errors.New("layer root is not the base element of the next layer"))
+		}
+	}
+	return nil
+}
+
+// Flatten checks that the layers fit together and splices them into a single
+// proof, the same way that CachedTree.Prove does. The root of the returned
+// proof is the root computed from the layers.
+func (lp LayeredProof) Flatten(h hash.Hash) (Proof, error) {
+	roots, err := lp.layerRoots(h)
+	if err != nil {
+		return Proof{}, err
+	}
+	if err := lp.link(roots); err != nil {
+		return Proof{}, err
+	}
+	set := append([][]byte(nil), lp.Layers[0].Set...)
+	for _, l := range lp.Layers[1:] {
+		set = append(set, l.Set[1:]...)
+	}
+	return Proof{
+		Root:      roots[len(roots)-1],
+		Set:       set,
+		Index:     lp.Index,
+		NumLeaves: lp.Layers[len(lp.Layers)-1].End,
+	}, nil
+}
+
+// VerifyLayered verifies the layers from the top down: the last layer must
+// produce 'root', and every other layer must produce the base element of the
+// layer above it. The flattened proof is then verified against 'root'. A
+// *LayerError identifies the highest layer that is wrong, which is the layer
+// whose producer can be blamed for the failure.
+func (lp LayeredProof) VerifyLayered(h hash.Hash, root []byte) error {
+	if root == nil {
+		return ErrNilRoot
+	}
+	roots, err := lp.layerRoots(h)
+	if err != nil {
+		return err
+	}
+	top := len(lp.Layers) - 1
+	if !bytes.Equal(roots[top], root) {
+		return lp.layerError(top, &RootMismatchError{Computed: roots[top], Expected: root})
+	}
+	if err := lp.link(roots); err != nil {
+		return err
+	}
+	p, err := lp.Flatten(h)
+	if err != nil {
+		return err
+	}
+	if err := VerifyProofErr(h, root, p.Set, p.Index, p.NumLeaves); err != nil {
+		return lp.layerError(top, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// buildLayeredProof builds a tree of 'numChunks' cached nodes of height 2
+// with a CachedTree, and returns its root, the layered proof of leaf
+// 'index', and the proof returned by CachedTree.Prove for comparison.
+func buildLayeredProof(t *testing.T, numChunks, index uint64) ([]byte, LayeredProof, Proof) {
+	const height = 2
+	ct := NewCachedTree(sha256.New(), height)
+	if err := ct.SetIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	var subProof [][]byte
+	for i := uint64(0); i < numChunks; i++ {
+		sub := New(sha256.New())
+		if err := sub.SetIndex(index % (1 << height)); err != nil {
+			t.Fatal(err)
+		}
+		for j := uint64(0); j < 1<<height; j++ {
+			sub.Push([]byte{byte(i), byte(j)})
+		}
+		ct.Push(sub.Root())
+		if i == index>>height {
+			_, subProof, _, _ = sub.Prove()
+		}
+	}
+	_, cachedProof, _, _ := ct.Tree.Prove()
+	root, proofSet, proofIndex, numLeaves := ct.Prove(subProof)
+
+	chunkStart := index >> height << height
+	lp := LayeredProof{
+		Index: index,
+		Layers: []ProofLayer{
+			{Begin: chunkStart, End: chunkStart + 1<<height, Height: 0, Set: subProof, Source: "host"},
+			{Begin: 0, End: numChunks << height, Height: height, Set: cachedProof, Source: "renter"},
+		},
+	}
+	return root, lp, Proof{Root: root, Set: proofSet, Index: proofIndex, NumLeaves: numLeaves}
+}
+
+// copyLayers returns a copy of the layers of 'lp' that can be corrupted
+// without affecting 'lp'.
+func copyLayers(lp LayeredProof) LayeredProof {
+	c := LayeredProof{Index: lp.Index}
+	for _, l := range lp.Layers {
+		l.Set = append([][]byte(nil), l.Set...)
+		for i := range l.Set {
+			l.Set[i] = append([]byte(nil), l.Set[i]...)
+		}
+		c.Layers = append(c.Layers, l)
+	}
+	return c
+}
+
+// TestLayeredProof checks that layered proofs built with a CachedTree verify
+// and flatten to the proof returned by CachedTree.Prove.
+func TestLayeredProof(t *testing.T) {
+	for numChunks := uint64(1); numChunks < 12; numChunks++ {
+		for index := uint64(0); index < numChunks<<2; index++ {
+			root, lp, expected := buildLayeredProof(t, numChunks, index)
+			if err := lp.VerifyLayered(sha256.New(), root); err != nil {
+				t.Fatal("layered proof did not verify", numChunks, index, err)
+			}
+			p, err := lp.Flatten(sha256.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proofsEqual(p, expected) {
+				t.Error("flattened proof does not match CachedTree.Prove", numChunks, index)
+			}
+		}
+	}
+}
+
+// TestLayeredProofCorruption corrupts every element of every layer in turn,
+// and checks that the failure is attributed to that layer.
+func TestLayeredProofCorruption(t *testing.T) {
+	root, lp, _ := buildLayeredProof(t, 11, 22)
+	for k, l := range lp.Layers {
+		for i := range l.Set {
+			bad := copyLayers(lp)
+			bad.Layers[k].Set[i][0] ^= 1
+
+			// The layers are checked from the top down, so a corrupted base
+			// element is blamed on its own layer rather than on the layer
+			// below, which is still consistent with the tree.
+			expected := k
+			err := bad.VerifyLayered(sha256.New(), root)
+			le, ok := err.(*LayerError)
+			if !ok {
+				t.Fatal("expected a LayerError, got", err)
+			}
+			if le.Layer != expected || le.Source != lp.Layers[expected].Source {
+				t.Error("corruption attributed to the wrong layer", k, i, le)
+			}
+		}
+	}
+
+	// Inconsistent ranges are attributed to the layer with the bad range.
+	bad := copyLayers(lp)
+	bad.Layers[0].Begin++
+	if err, ok := bad.VerifyLayered(sha256.New(), root).(*LayerError); !ok || err.Layer != 0 {
+		t.Error("bad range was not attributed to the first layer", err)
+	}
+	bad = copyLayers(lp)
+	bad.Layers[1].End = 24
+	if err, ok := bad.VerifyLayered(sha256.New(), root).(*LayerError); !ok || err.Layer != 1 {
+		t.Error("bad range was not attributed to the last layer", err)
+	}
+	bad = copyLayers(lp)
+	bad.Layers[1].Set = bad.Layers[1].Set[:len(bad.Layers[1].Set)-1]
+	if err, ok := bad.VerifyLayered(sha256.New(), root).(*LayerError); !ok || err.Layer != 1 || err.Err != ErrProofTooShort {
+		t.Error("short layer was not attributed to the last layer", err)
+	}
+	if _, err := (LayeredProof{}).Flatten(sha256.New()); err == nil {
+		t.Error("able to flatten a proof with no layers")
+	}
+	if lp.VerifyLayered(sha256.New(), nil) != ErrNilRoot {
+		t.Error("expected ErrNilRoot")
+	}
+	if !bytes.Contains([]byte(bad.VerifyLayered(sha256.New(), root).Error()), []byte("(renter)")) {
+		t.Error("LayerError does not name the source of the layer")
+	}
+}
@@ -0,0 +1,92 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"io"
+)
+
+// ReadAllLeafHashes reads segments of size 'segmentSize' from 'r' until EOF
+// is reached, and calls 'fn' with the index and the leaf sum of every
+// segment, in order. The last segment may be smaller than 'segmentSize', the
+// same as in ReadAll. If 'fn' returns an error, reading stops and the error
+// is returned. The number of leaves read is returned, including the leaf for
+// which 'fn' returned an error. The leaf sums passed to 'fn' may be retained.
+func ReadAllLeafHashes(r io.Reader, h hash.Hash, segmentSize int, fn func(i uint64, leafHash []byte) error) (numLeaves uint64, err error) {
+	if segmentSize <= 0 {
+		return 0, errors.New("segment size must be positive")
+	}
+	segment := make([]byte, segmentSize)
+	for {
+		n, readErr := io.ReadFull(r, segment)
+		if readErr == io.EOF {
+			// All data has been read.
+			return numLeaves, nil
+		} else if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return numLeaves, readErr
+		}
+		numLeaves++
+		if err := fn(numLeaves-1, leafSum(h, segment[:n])); err != nil {
+			return numLeaves, err
+		}
+		if readErr == io.ErrUnexpectedEOF {
+			// This was the last segment, and there weren't enough bytes to
+			// fill it.
+			return numLeaves, nil
+		}
+	}
+}
+
+// ReadAllSubtreeRoots is the same as ReadAllLeafHashes, but calls 'fn' with
+// the root of every run of 2^cacheHeight leaves instead of every leaf, which
+// is the root of a cached node of that height. If the data ends in the middle
+// of a run, 'fn' is called with the root of the partial run and 'partial' set
+// to true. Pushing the roots into a CachedTree of the same height produces
+// the same root as ReaderRoot. The roots passed to 'fn' may be retained.
+func ReadAllSubtreeRoots(r io.Reader, h hash.Hash, segmentSize int, cacheHeight uint64, fn func(i uint64, root []byte, partial bool) error) (numLeaves uint64, err error) {
+	if cacheHeight > MaxHeight {
+		return 0, ErrHeightTooLarge
+	}
+	run := NewStack(h)
+	var runs uint64
+	numLeaves, err = ReadAllLeafHashes(r, h, segmentSize, func(_ uint64, leafHash []byte) error {
+		run.AppendLeafHash(leafHash)
+		if run.NumLeaves() < 1<<cacheHeight {
+			return nil
+		}
+		root := run.Root()
+		run.Reset()
+		runs++
+		return fn(runs-1, root, false)
+	})
+	if err != nil || run.NumLeaves() == 0 {
+		return numLeaves, err
+	}
+	return numLeaves, fn(runs, run.Root(), true)
+}
+
+// BuildCache reads 'r' once and returns both the Merkle root of the data and
+// the roots of its cached nodes of height 'cacheHeight', as emitted by
+// ReadAllSubtreeRoots. If the data ends in the middle of a cached node, the
+// root of the partial node is the last cached root, and numLeaves counts only
+// the leaves that were read. Pushing the cached roots into a CachedTree of the
+// same height produces the returned root.
+func BuildCache(r io.Reader, h hash.Hash, segmentSize int, cacheHeight uint64) (root []byte, cachedRoots [][]byte, numLeaves uint64, err error) {
+	numLeaves, err = ReadAllSubtreeRoots(r, h, segmentSize, cacheHeight, func(_ uint64, root []byte, _ bool) error {
+		cachedRoots = append(cachedRoots, root)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	ct, err := NewCachedTreeChecked(h, cacheHeight)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for _, cachedRoot := range cachedRoots {
+		if err := ct.Push(cachedRoot); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	return ct.Root(), cachedRoots, numLeaves, nil
+}
@@ -0,0 +1,152 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"testing/iotest"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestReadAllLeafHashes checks that ReadAllLeafHashes passes the leaf sum of
+// every segment to the callback, and that an error from the callback stops
+// the read.
+func TestReadAllLeafHashes(t *testing.T) {
+	for _, size := range []int{0, 1, 8, 63, 64, 65, 1000} {
+		data := fastrand.Bytes(size)
+		tree := New(sha256.New())
+		numLeaves, err := ReadAllLeafHashes(iotest.HalfReader(bytes.NewReader(data)), sha256.New(), 8, func(i uint64, leafHash []byte) error {
+			if i != tree.CurrentIndex() {
+				t.Fatal("callback called out of order", size, i)
+			}
+			end := int(i+1) * 8
+			if end > size {
+				end = size
+			}
+			if !bytes.Equal(leafHash, leafSum(sha256.New(), data[i*8:end])) {
+				t.Error("wrong leaf sum", size, i)
+			}
+			return tree.PushSubTree(0, leafHash)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if numLeaves != numSegments(int64(size), 8) || tree.CurrentIndex() != numLeaves {
+			t.Error("wrong number of leaves", size, numLeaves)
+		}
+		root, err := ReaderRoot(bytes.NewReader(data), sha256.New(), 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tree.Root(), root) {
+			t.Error("leaf sums produced the wrong root", size)
+		}
+	}
+
+	errStop := errors.New("stop")
+	r := bytes.NewReader(make([]byte, 100))
+	numLeaves, err := ReadAllLeafHashes(r, sha256.New(), 8, func(i uint64, _ []byte) error {
+		if i == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || numLeaves != 3 || r.Len() != 100-24 {
+		t.Error("callback error did not stop the read", err, numLeaves, r.Len())
+	}
+	if _, err := ReadAllLeafHashes(bytes.NewReader(nil), sha256.New(), 0, nil); err == nil {
+		t.Error("segment size of 0 was accepted")
+	}
+}
+
+// TestReadAllSubtreeRoots checks that pushing the roots emitted by
+// ReadAllSubtreeRoots into a CachedTree reproduces the root from ReaderRoot.
+func TestReadAllSubtreeRoots(t *testing.T) {
+	for cacheHeight := uint64(0); cacheHeight < 4; cacheHeight++ {
+		for _, size := range []int{0, 1, 8, 64, 100, 8 << 5, 1000} {
+			data := fastrand.Bytes(size)
+			ct := NewCachedTree(sha256.New(), cacheHeight)
+			var sawPartial bool
+			numLeaves, err := ReadAllSubtreeRoots(bytes.NewReader(data), sha256.New(), 8, cacheHeight, func(i uint64, root []byte, partial bool) error {
+				if i != ct.Tree.CurrentIndex() || sawPartial {
+					t.Fatal("callback called out of order", cacheHeight, size, i)
+				}
+				sawPartial = partial
+				ct.Push(root)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaves := numSegments(int64(size), 8)
+			if numLeaves != leaves {
+				t.Error("wrong number of leaves", cacheHeight, size, numLeaves)
+			}
+			if sawPartial != (leaves%(1<<cacheHeight) != 0) {
+				t.Error("partial run was not flagged correctly", cacheHeight, size)
+			}
+			root, err := ReaderRoot(bytes.NewReader(data), sha256.New(), 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(ct.Root(), root) {
+				t.Error("CachedTree built from the roots has the wrong root", cacheHeight, size)
+			}
+		}
+	}
+
+	errStop := errors.New("stop")
+	_, err := ReadAllSubtreeRoots(bytes.NewReader(make([]byte, 100)), sha256.New(), 8, 1, func(i uint64, _ []byte, _ bool) error {
+		if i == 1 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Error("callback error was not returned", err)
+	}
+	if _, err := ReadAllSubtreeRoots(bytes.NewReader(nil), sha256.New(), 8, 64, nil); err == nil {
+		t.Error("cache height of 64 was accepted")
+	}
+}
+
+// TestBuildCache checks that BuildCache returns the root from ReaderRoot, and
+// cached roots that reproduce it in a CachedTree.
+func TestBuildCache(t *testing.T) {
+	for cacheHeight := uint64(0); cacheHeight < 4; cacheHeight++ {
+		for _, size := range []int{0, 1, 8, 64, 100, 8 << 5, 1000} {
+			data := fastrand.Bytes(size)
+			root, cachedRoots, numLeaves, err := BuildCache(iotest.HalfReader(bytes.NewReader(data)), sha256.New(), 8, cacheHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := ReaderRoot(bytes.NewReader(data), sha256.New(), 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(root, expected) {
+				t.Error("wrong root", cacheHeight, size)
+			}
+			if numLeaves != numSegments(int64(size), 8) {
+				t.Error("wrong number of leaves", cacheHeight, size, numLeaves)
+			}
+			leavesPerNode := uint64(1) << cacheHeight
+			if uint64(len(cachedRoots)) != (numLeaves+leavesPerNode-1)/leavesPerNode {
+				t.Error("wrong number of cached roots", cacheHeight, size, len(cachedRoots))
+			}
+			ct := NewCachedTree(sha256.New(), cacheHeight)
+			for _, cachedRoot := range cachedRoots {
+				ct.Push(cachedRoot)
+			}
+			if !bytes.Equal(ct.Root(), expected) {
+				t.Error("cached roots do not reproduce the root", cacheHeight, size)
+			}
+		}
+	}
+
+	if _, _, _, err := BuildCache(bytes.NewReader(nil), sha256.New(), 8, 64); err == nil {
+		t.Error("cache height of 64 was accepted")
+	}
+}
@@ -0,0 +1,144 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+)
+
+// An MMR is a Merkle Mountain Range: an append-only list of perfect binary
+// trees (the peaks) that are never rebalanced. The peaks are exactly the
+// subtrees that a Tree keeps on its stack, so an MMR of n leaves has the same
+// peaks, root and proofs as a Tree of n leaves.
+//
+// Leaves are hashed as Hash(0x00 || data) and nodes as Hash(0x01 || left ||
+// right). The root is formed by bagging the peaks from right to left: the two
+// smallest peaks are hashed together, then the result is hashed with the next
+// peak to the left as the left sibling, and so on. For peaks P1, P2, P3 in
+// order from left (tallest) to right (shortest), the root is:
+//
+//	Hash(0x01 || P1 || Hash(0x01 || P2 || P3))
+//
+// Unlike a Tree, an MMR retains every node of every peak and the data of every
+// leaf, so that a proof can be built for any leaf at any time.
+type MMR struct {
+	hash hash.Hash
+	data [][]byte
+
+	// nodes[i] holds the roots of every complete subtree of height i, in order
+	// from left to right.
+	nodes [][][]byte
+}
+
+// NewMMR creates an empty MMR. The provided hash will be used for all hashing
+// operations within the MMR.
+func NewMMR(h hash.Hash) *MMR {
+	return &MMR{
+		hash: h,
+	}
+}
+
+// Append adds a leaf to the MMR. Every peak that the new leaf completes is
+// merged, costing one hash per merge.
+func (m *MMR) Append(data []byte) {
+	m.data = append(m.data, data)
+	node := leafSum(m.hash, data)
+	for height := 0; ; height++ {
+		if height == len(m.nodes) {
+			m.nodes = append(m.nodes, nil)
+		}
+		m.nodes[height] = append(m.nodes[height], node)
+		n := len(m.nodes[height])
+		if n%2 == 1 {
+			return
+		}
+		node = nodeSum(m.hash, m.nodes[height][n-2], m.nodes[height][n-1])
+	}
+}
+
+// Size returns the number of leaves in the MMR.
+func (m *MMR) Size() uint64 {
+	return uint64(len(m.data))
+}
+
+// Peaks returns the roots of the peaks of the MMR, in order from left
+// (tallest) to right (shortest). The peak of height i exists if and only if
+// bit i of the size of the MMR is set.
+func (m *MMR) Peaks() [][]byte {
+	var peaks [][]byte
+	for height := len(m.nodes) - 1; height >= 0; height-- {
+		if len(m.nodes[height])%2 == 1 {
+			peaks = append(peaks, m.nodes[height][len(m.nodes[height])-1])
+		}
+	}
+	return peaks
+}
+
+// bagPeaks combines a list of peaks into a single root, from right to left.
+func bagPeaks(h hash.Hash, peaks [][]byte) []byte {
+	if len(peaks) == 0 {
+		return nil
+	}
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		root = nodeSum(h, peaks[i], root)
+	}
+	return root
+}
+
+// Root returns the root of the MMR, which is the bagging of its peaks. The
+// root of an empty MMR is nil.
+func (m *MMR) Root() []byte {
+	return bagPeaks(m.hash, m.Peaks())
+}
+
+// ProveLeaf creates a proof that the leaf at index 'i' is an element of the
+// MMR. The proof consists of the data of the leaf, the siblings within the peak
+// that contains the leaf, the bagging of all peaks to the right of that peak
+// (if there are any), and then the peaks to the left, from nearest to
+// farthest. This is the same proof that a Tree would produce, and the proof
+// remains valid for the current root and size of the MMR.
+func (m *MMR) ProveLeaf(i uint64) ([][]byte, error) {
+	size := m.Size()
+	if i >= size {
+		return nil, errors.New("cannot prove a leaf that is not in the MMR")
+	}
+
+	// Find the peak containing the leaf. The peaks are visited from left to
+	// right, with 'start' being the index of the first leaf of the peak.
+	peaks := m.Peaks()
+	var peak, height int
+	var start uint64
+	for height = len(m.nodes) - 1; height >= 0; height-- {
+		if size&(1<<uint(height)) == 0 {
+			continue
+		}
+		if i < start+1<<uint(height) {
+			break
+		}
+		start += 1 << uint(height)
+		peak++
+	}
+
+	// Add the siblings within the peak.
+	proofSet := [][]byte{m.data[i]}
+	for h := 0; h < height; h++ {
+		proofSet = append(proofSet, m.nodes[h][(i>>uint(h))^1])
+	}
+
+	// Add the bagging of the peaks to the right, then the peaks to the left.
+	if peak < len(peaks)-1 {
+		proofSet = append(proofSet, bagPeaks(m.hash, peaks[peak+1:]))
+	}
+	for j := peak - 1; j >= 0; j-- {
+		proofSet = append(proofSet, peaks[j])
+	}
+	return proofSet, nil
+}
+
+// VerifyMMRProof takes the root of an MMR with 'size' leaves and a proof set
+// created by ProveLeaf, and returns true if the first element of the proof set
+// is the data of the leaf at index 'i'. Because an MMR has the same shape as a
+// Tree, this is equivalent to VerifyProof.
+func VerifyMMRProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, i uint64, size uint64) bool {
+	return VerifyProof(h, merkleRoot, proofSet, i, size)
+}
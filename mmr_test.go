@@ -0,0 +1,153 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// TestMMRVectors pins the peak hashing and bagging order of the MMR so that
+// other implementations can interoperate. Leaf i is the single byte i.
+func TestMMRVectors(t *testing.T) {
+	roots := []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"a20bf9a7cc2dc8a08f5f415a71b19f6ac427bab54d24eec868b5d3103449953a",
+		"3b6cccd7e3e023ff393006f030315ee7ad9eb111b022b41fba7e5b7a3973f688",
+		"9bcd51240af4005168f033121ba85be5a6ed4f0e6a5fac262066729b8fbfdecb",
+		"b855b42d6c30f5b087e05266783fbd6e394f7b926013ccaa67700a8b0c5a596f",
+		"bb36e7d3d4cee5720cbd323d02fab15962e2ba1dadf5f8fc6eeef4fd6ad056a8",
+		"3560191803028444b232018ac047fdb561c09c23a7a6876c85e08b5e4d48e9f3",
+	}
+	m := NewMMR(sha256.New())
+	if m.Root() != nil {
+		t.Error("root of an empty MMR should be nil")
+	}
+	for i, root := range roots {
+		m.Append([]byte{byte(i)})
+		if hex.EncodeToString(m.Root()) != root {
+			t.Error("MMR root does not match test vector for size", i+1)
+		}
+	}
+
+	// With 7 leaves there are peaks of height 2, 1 and 0, and the root bags
+	// them from right to left.
+	mt := CreateMerkleTester(t)
+	peaks := m.Peaks()
+	expected := [][]byte{
+		mt.roots[4],
+		mt.join(mt.leaves[4], mt.leaves[5]),
+		mt.leaves[6],
+	}
+	if len(peaks) != len(expected) {
+		t.Fatal("wrong number of peaks:", len(peaks))
+	}
+	for i := range peaks {
+		if !bytes.Equal(peaks[i], expected[i]) {
+			t.Error("wrong peak at position", i)
+		}
+	}
+	if !bytes.Equal(m.Root(), mt.join(peaks[0], mt.join(peaks[1], peaks[2]))) {
+		t.Error("root is not the right-to-left bagging of the peaks")
+	}
+}
+
+// TestMMRMatchesTree checks that the roots and proofs of an MMR match those of
+// a Tree of the same size.
+func TestMMRMatchesTree(t *testing.T) {
+	m := NewMMR(sha256.New())
+	for size := uint64(1); size <= 70; size++ {
+		m.Append([]byte{byte(size)})
+		for i := uint64(0); i < size; i++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(i); err != nil {
+				t.Fatal(err)
+			}
+			for j := uint64(1); j <= size; j++ {
+				tree.Push([]byte{byte(j)})
+			}
+			root, treeProof, _, _ := tree.Prove()
+			if !bytes.Equal(root, m.Root()) {
+				t.Fatal("MMR root does not match Tree root for size", size)
+			}
+
+			proof, err := m.ProveLeaf(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(proof) != len(treeProof) {
+				t.Fatal("MMR proof has the wrong length", size, i)
+			}
+			for k := range proof {
+				if !bytes.Equal(proof[k], treeProof[k]) {
+					t.Error("MMR proof does not match Tree proof", size, i, k)
+				}
+			}
+			if !VerifyMMRProof(sha256.New(), m.Root(), proof, i, size) {
+				t.Error("MMR proof did not verify", size, i)
+			}
+		}
+		if _, err := m.ProveLeaf(size); err == nil {
+			t.Error("able to prove a leaf outside of the MMR")
+		}
+	}
+}
+
+// TestMMRExtendability checks that a proof created at size n relates to the
+// roots of larger MMRs through the peak decomposition: the peak that contains
+// the leaf at size n is a node of every larger MMR, so the part of the proof
+// within that peak is a prefix of the proof at any larger size.
+func TestMMRExtendability(t *testing.T) {
+	var proofs [][][]byte
+	var peakHeights []int
+	m := NewMMR(sha256.New())
+	const index = 5
+	for size := uint64(1); size <= 64; size++ {
+		m.Append([]byte{byte(size)})
+		if size <= index {
+			continue
+		}
+
+		proof, err := m.ProveLeaf(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Determine the height of the peak containing the leaf.
+		var start uint64
+		height := 63
+		for ; height >= 0; height-- {
+			if size&(1<<uint(height)) == 0 {
+				continue
+			}
+			if index < start+1<<uint(height) {
+				break
+			}
+			start += 1 << uint(height)
+		}
+
+		// Every earlier proof must be a prefix of this proof up to the height
+		// of the peak that contained the leaf at the time.
+		for j := range proofs {
+			for k := 0; k <= peakHeights[j]; k++ {
+				if !bytes.Equal(proofs[j][k], proof[k]) {
+					t.Error("proof within peak changed as the MMR grew", j, size, k)
+				}
+			}
+			// The old peak must be a node of the current MMR.
+			node := leafSum(sha256.New(), proofs[j][0])
+			for k := 1; k <= peakHeights[j]; k++ {
+				if (index>>uint(k-1))&1 == 0 {
+					node = nodeSum(sha256.New(), node, proofs[j][k])
+				} else {
+					node = nodeSum(sha256.New(), proofs[j][k], node)
+				}
+			}
+			if !bytes.Equal(node, m.nodes[peakHeights[j]][index>>uint(peakHeights[j])]) {
+				t.Error("old peak is not a node of the larger MMR", j, size)
+			}
+		}
+		proofs = append(proofs, proof)
+		peakHeights = append(peakHeights, height)
+	}
+}
@@ -0,0 +1,453 @@
+package merkletree
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// maxNodeLevel is the number of levels a NodeStore must hold, one for each
+// possible height of a complete subtree.
+const maxNodeLevel = 64
+
+// ErrNodeNotStored is returned by a NodeStore when a node beyond the last
+// node of its level is read.
+var ErrNodeNotStored = errors.New("node is not in the store")
+
+// A NodeStore holds the sums of the complete subtrees of a FullTree, by level
+// and index within the level. Level 0 holds the leaf sums. Every level is a
+// list of sums that grows by one at a time and can be truncated, so nodes are
+// addressed by their position and need no other key.
+//
+// A FullTree calls Get from many goroutines at once when it is read
+// concurrently, so Get must be safe to call concurrently with other calls to
+// Get. The other methods are never called concurrently with any method.
+type NodeStore interface {
+	// Get returns the sum of the node at 'index' of 'level'.
+	// ErrNodeNotStored is returned if the level holds 'index' nodes or
+	// fewer. The returned slice must not be modified, and may change when
+	// the node is put or its level is truncated.
+	Get(level int, index uint64) ([]byte, error)
+
+	// Put sets the sum of the node at 'index' of 'level', which may be the
+	// index of an existing node or the number of nodes in the level, to add
+	// a node to its end. The store does not retain 'sum'.
+	Put(level int, index uint64, sum []byte) error
+
+	// NumNodes returns the number of nodes in 'level'.
+	NumNodes(level int) (uint64, error)
+
+	// Truncate removes every node after the first 'n' of 'level'.
+	Truncate(level int, n uint64) error
+
+	// Flush makes every change to the store durable.
+	Flush() error
+}
+
+// checkNodeLevel returns an error if 'level' is not a level of a NodeStore.
+func checkNodeLevel(level int) error {
+	if level < 0 || level >= maxNodeLevel {
+		return errors.New("node level must be between 0 and 63")
+	}
+	return nil
+}
+
+// MemoryNodeStore is a NodeStore that holds every node in memory. It is the
+// store of a FullTree created with NewFull. Each level is a single slice of
+// fixed-size records, so a node costs no more than its sum, and once a level
+// has grown, putting and truncating nodes allocate nothing. The record size
+// is the size of the first node that is put.
+type MemoryNodeStore struct {
+	size   int
+	levels [maxNodeLevel][]byte
+}
+
+// NewMemoryNodeStore creates an empty MemoryNodeStore.
+func NewMemoryNodeStore() *MemoryNodeStore {
+	return new(MemoryNodeStore)
+}
+
+// numNodes returns the number of nodes in 'level', which must be valid.
+func (ms *MemoryNodeStore) numNodes(level int) uint64 {
+	if ms.size == 0 {
+		return 0
+	}
+	return uint64(len(ms.levels[level]) / ms.size)
+}
+
+// Get implements NodeStore. The returned slice is part of the level, so it
+// changes when the node is put.
+func (ms *MemoryNodeStore) Get(level int, index uint64) ([]byte, error) {
+	if err := checkNodeLevel(level); err != nil {
+		return nil, err
+	}
+	if index >= ms.numNodes(level) {
+		return nil, ErrNodeNotStored
+	}
+	off := int(index) * ms.size
+	return ms.levels[level][off : off+ms.size : off+ms.size], nil
+}
+
+// Put implements NodeStore.
+func (ms *MemoryNodeStore) Put(level int, index uint64, sum []byte) error {
+	if err := checkNodeLevel(level); err != nil {
+		return err
+	}
+	if ms.size == 0 {
+		if len(sum) == 0 {
+			return errors.New("node sum is empty")
+		}
+		ms.size = len(sum)
+	} else if len(sum) != ms.size {
+		return errors.New("node sum does not have the size of the other nodes")
+	}
+	switch n := ms.numNodes(level); {
+	case index < n:
+		copy(ms.levels[level][int(index)*ms.size:], sum)
+	case index == n:
+		ms.levels[level] = append(ms.levels[level], sum...)
+	default:
+		return errors.New("cannot put a node after the end of its level")
+	}
+	return nil
+}
+
+// NumNodes implements NodeStore.
+func (ms *MemoryNodeStore) NumNodes(level int) (uint64, error) {
+	if err := checkNodeLevel(level); err != nil {
+		return 0, err
+	}
+	return ms.numNodes(level), nil
+}
+
+// Truncate implements NodeStore. The storage of the level is kept, to be
+// reused by later puts.
+func (ms *MemoryNodeStore) Truncate(level int, n uint64) error {
+	if err := checkNodeLevel(level); err != nil {
+		return err
+	}
+	if n < ms.numNodes(level) {
+		ms.levels[level] = ms.levels[level][:int(n)*ms.size]
+	}
+	return nil
+}
+
+// Flush implements NodeStore. The nodes of a MemoryNodeStore are never
+// durable, so it does nothing.
+func (ms *MemoryNodeStore) Flush() error {
+	return nil
+}
+
+// filePageSize is the number of bytes of a level file that a FileNodeStore
+// reads and writes at a time.
+const filePageSize = 4096
+
+// fileLevel is a level of a FileNodeStore, stored in its own file.
+type fileLevel struct {
+	f        *os.File
+	numNodes uint64
+}
+
+// filePageKey identifies a page of a FileNodeStore.
+type filePageKey struct {
+	level int
+	page  uint64
+}
+
+// filePage is a cached page of a level file. Only the records of the page
+// that are within the level are valid, and 'dirty' is set if they have been
+// changed since they were read or written.
+type filePage struct {
+	key   filePageKey
+	data  []byte
+	dirty bool
+}
+
+// FileNodeStore is a NodeStore that keeps each level in a file of fixed-size
+// records in a directory, so that the node of a level and index is found by
+// its offset. Reads and writes go through a least recently used cache of
+// pages, and changed pages are written when they are evicted and when the
+// store is flushed, which also syncs the files. A change that has not been
+// flushed may or may not be in the files after a crash.
+//
+// A level file whose size is not a multiple of the record size, or that is
+// shorter than the store expects, is reported as an error. A FileNodeStore is
+// safe for concurrent use, but reads share its cache, so they take turns.
+type FileNodeStore struct {
+	mu sync.Mutex
+
+	dir         string
+	recordSize  int
+	pageRecords uint64
+	maxPages    int
+
+	levels [maxNodeLevel]*fileLevel
+	pages  map[filePageKey]*list.Element
+	lru    *list.List
+}
+
+// OpenFileNodeStore opens the FileNodeStore in the directory 'dir', creating
+// it if it does not exist. 'recordSize' is the size of a node sum, which is
+// the output size of the hash of the tree, and 'cachePages' is the number of
+// pages of 4 KiB that the store may hold in memory.
+func OpenFileNodeStore(dir string, recordSize int, cachePages int) (*FileNodeStore, error) {
+	if recordSize <= 0 {
+		return nil, errors.New("record size must be positive")
+	}
+	if cachePages <= 0 {
+		return nil, errors.New("cache must hold at least one page")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	pageRecords := uint64(filePageSize / recordSize)
+	if pageRecords == 0 {
+		pageRecords = 1
+	}
+	fns := &FileNodeStore{
+		dir:         dir,
+		recordSize:  recordSize,
+		pageRecords: pageRecords,
+		maxPages:    cachePages,
+		pages:       make(map[filePageKey]*list.Element),
+		lru:         list.New(),
+	}
+	// Open every level that has a file, so that short records are reported
+	// right away.
+	for level := 0; level < maxNodeLevel; level++ {
+		if _, err := os.Stat(fns.levelPath(level)); os.IsNotExist(err) {
+			continue
+		}
+		if _, err := fns.level(level); err != nil {
+			fns.Close()
+			return nil, err
+		}
+	}
+	return fns, nil
+}
+
+// levelPath returns the path of the file of 'level'.
+func (fns *FileNodeStore) levelPath(level int) string {
+	return filepath.Join(fns.dir, "level-"+strconv.Itoa(level))
+}
+
+// level returns 'level', opening or creating its file if it is not open.
+func (fns *FileNodeStore) level(level int) (*fileLevel, error) {
+	if err := checkNodeLevel(level); err != nil {
+		return nil, err
+	}
+	if fns.levels[level] != nil {
+		return fns.levels[level], nil
+	}
+	f, err := os.OpenFile(fns.levelPath(level), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size()%int64(fns.recordSize) != 0 {
+		f.Close()
+		return nil, errors.New("level file " + fns.levelPath(level) + " ends with a short record")
+	}
+	fns.levels[level] = &fileLevel{
+		f:        f,
+		numNodes: uint64(info.Size()) / uint64(fns.recordSize),
+	}
+	return fns.levels[level], nil
+}
+
+// validRecords returns the number of records of page 'page' of 'fl' that are
+// within the level.
+func (fns *FileNodeStore) validRecords(fl *fileLevel, page uint64) uint64 {
+	first := page * fns.pageRecords
+	if first >= fl.numNodes {
+		return 0
+	}
+	if n := fl.numNodes - first; n < fns.pageRecords {
+		return n
+	}
+	return fns.pageRecords
+}
+
+// writePage writes the valid records of 'p' to its level file.
+func (fns *FileNodeStore) writePage(p *filePage) error {
+	fl := fns.levels[p.key.level]
+	n := fns.validRecords(fl, p.key.page) * uint64(fns.recordSize)
+	off := int64(p.key.page * fns.pageRecords * uint64(fns.recordSize))
+	if _, err := fl.f.WriteAt(p.data[:n], off); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
+
+// page returns the page of 'fl' that holds 'index', reading it from the
+// level file if it is not cached, and evicting the least recently used page
+// if the cache is full.
+func (fns *FileNodeStore) page(level int, fl *fileLevel, index uint64) (*filePage, error) {
+	key := filePageKey{level, index / fns.pageRecords}
+	if e, ok := fns.pages[key]; ok {
+		fns.lru.MoveToFront(e)
+		return e.Value.(*filePage), nil
+	}
+
+	p := &filePage{
+		key:  key,
+		data: make([]byte, fns.pageRecords*uint64(fns.recordSize)),
+	}
+	n := fns.validRecords(fl, key.page) * uint64(fns.recordSize)
+	off := int64(key.page * fns.pageRecords * uint64(fns.recordSize))
+	if _, err := fl.f.ReadAt(p.data[:n], off); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errors.New("level file " + fns.levelPath(level) + " is shorter than its records")
+	} else if err != nil {
+		return nil, err
+	}
+
+	for fns.lru.Len() >= fns.maxPages {
+		back := fns.lru.Back()
+		evicted := back.Value.(*filePage)
+		if evicted.dirty {
+			if err := fns.writePage(evicted); err != nil {
+				return nil, err
+			}
+		}
+		fns.lru.Remove(back)
+		delete(fns.pages, evicted.key)
+	}
+	fns.pages[key] = fns.lru.PushFront(p)
+	return p, nil
+}
+
+// Get implements NodeStore. The returned sum is a copy.
+func (fns *FileNodeStore) Get(level int, index uint64) ([]byte, error) {
+	fns.mu.Lock()
+	defer fns.mu.Unlock()
+	fl, err := fns.level(level)
+	if err != nil {
+		return nil, err
+	}
+	if index >= fl.numNodes {
+		return nil, ErrNodeNotStored
+	}
+	p, err := fns.page(level, fl, index)
+	if err != nil {
+		return nil, err
+	}
+	off := int(index%fns.pageRecords) * fns.recordSize
+	return append([]byte(nil), p.data[off:off+fns.recordSize]...), nil
+}
+
+// Put implements NodeStore.
+func (fns *FileNodeStore) Put(level int, index uint64, sum []byte) error {
+	fns.mu.Lock()
+	defer fns.mu.Unlock()
+	if len(sum) != fns.recordSize {
+		return errors.New("node sum does not have the record size of the store")
+	}
+	fl, err := fns.level(level)
+	if err != nil {
+		return err
+	}
+	if index > fl.numNodes {
+		return errors.New("cannot put a node after the end of its level")
+	}
+	p, err := fns.page(level, fl, index)
+	if err != nil {
+		return err
+	}
+	off := int(index%fns.pageRecords) * fns.recordSize
+	copy(p.data[off:], sum)
+	p.dirty = true
+	if index == fl.numNodes {
+		fl.numNodes++
+	}
+	return nil
+}
+
+// NumNodes implements NodeStore.
+func (fns *FileNodeStore) NumNodes(level int) (uint64, error) {
+	fns.mu.Lock()
+	defer fns.mu.Unlock()
+	fl, err := fns.level(level)
+	if err != nil {
+		return 0, err
+	}
+	return fl.numNodes, nil
+}
+
+// Truncate implements NodeStore. The level file is truncated right away, but
+// the truncation is only durable once the store is flushed.
+func (fns *FileNodeStore) Truncate(level int, n uint64) error {
+	fns.mu.Lock()
+	defer fns.mu.Unlock()
+	fl, err := fns.level(level)
+	if err != nil {
+		return err
+	}
+	if n >= fl.numNodes {
+		return nil
+	}
+	fl.numNodes = n
+	// Pages that are now past the end of the level are dropped, and the
+	// valid part of the page holding the new end is shrunk by numNodes.
+	for key, e := range fns.pages {
+		if key.level == level && key.page*fns.pageRecords >= n {
+			fns.lru.Remove(e)
+			delete(fns.pages, key)
+		}
+	}
+	return fl.f.Truncate(int64(n) * int64(fns.recordSize))
+}
+
+// Flush implements NodeStore, writing every changed page and syncing the
+// level files.
+func (fns *FileNodeStore) Flush() error {
+	fns.mu.Lock()
+	defer fns.mu.Unlock()
+	return fns.flush()
+}
+
+// flush writes every changed page and syncs the level files while the store
+// is locked.
+func (fns *FileNodeStore) flush() error {
+	for e := fns.lru.Front(); e != nil; e = e.Next() {
+		if p := e.Value.(*filePage); p.dirty {
+			if err := fns.writePage(p); err != nil {
+				return err
+			}
+		}
+	}
+	for _, fl := range fns.levels {
+		if fl == nil {
+			continue
+		}
+		if err := fl.f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the store and closes its files.
+func (fns *FileNodeStore) Close() error {
+	fns.mu.Lock()
+	defer fns.mu.Unlock()
+	err := fns.flush()
+	for i, fl := range fns.levels {
+		if fl == nil {
+			continue
+		}
+		if cerr := fl.f.Close(); err == nil {
+			err = cerr
+		}
+		fns.levels[i] = nil
+	}
+	return err
+}
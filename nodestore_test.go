@@ -0,0 +1,195 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestNodeStores checks that the stores agree on a random sequence of puts
+// and truncations.
+func TestNodeStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fns, err := OpenFileNodeStore(dir, 16, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fns.Close()
+	ms := NewMemoryNodeStore()
+
+	for i := 0; i < 3000; i++ {
+		level := fastrand.Intn(3)
+		n, err := ms.NumNodes(level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fn, err := fns.NumNodes(level); err != nil || fn != n {
+			t.Fatal("number of nodes does not match", i, fn, n, err)
+		}
+		switch op := fastrand.Intn(10); {
+		case op < 7:
+			index := uint64(fastrand.Intn(int(n) + 1))
+			sum := fastrand.Bytes(16)
+			if ms.Put(level, index, sum) != nil || fns.Put(level, index, sum) != nil {
+				t.Fatal("put failed", i)
+			}
+		case op < 8:
+			keep := uint64(fastrand.Intn(int(n) + 1))
+			if ms.Truncate(level, keep) != nil || fns.Truncate(level, keep) != nil {
+				t.Fatal("truncate failed", i)
+			}
+		case n > 0:
+			index := uint64(fastrand.Intn(int(n)))
+			a, err := ms.Get(level, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := fns.Get(level, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(a, b) {
+				t.Fatal("nodes do not match", i)
+			}
+		}
+	}
+
+	for _, s := range []NodeStore{ms, fns} {
+		n, _ := s.NumNodes(0)
+		if _, err := s.Get(0, n); err != ErrNodeNotStored {
+			t.Error("wrong error for a node past the end", err)
+		}
+		if s.Put(0, n+1, make([]byte, 16)) == nil {
+			t.Error("put after the end of a level accepted")
+		}
+		if _, err := s.Get(64, 0); err == nil {
+			t.Error("level 64 accepted")
+		}
+	}
+	if fns.Put(0, 0, make([]byte, 15)) == nil {
+		t.Error("short node accepted")
+	}
+}
+
+// TestFullTreeReopen checks that a FullTree over a FileNodeStore can be
+// reopened after it is flushed, and after pushes that were interrupted before
+// their parents were stored.
+func TestFullTreeReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	open := func() (*FileNodeStore, *FullTree) {
+		fns, err := OpenFileNodeStore(dir, sha256.Size, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ft, err := NewFullWithStore(sha256.New(), fns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fns, ft
+	}
+
+	leaves := randomLeaves(100, 16)
+	fns, ft := open()
+	for _, leaf := range leaves[:77] {
+		if err := ft.Push(leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ft.TruncateTo(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := fns.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fns, ft = open()
+	if ft.NumLeaves() != 60 {
+		t.Fatal("wrong number of leaves after reopening", ft.NumLeaves())
+	}
+	checkFullProof(t, ft, leaves[:60], 41)
+
+	// Store the leaf sums of the next leaves without their parents, as a
+	// push that was interrupted would.
+	for i, leaf := range leaves[60:] {
+		if err := fns.Put(0, uint64(60+i), LeafSum(sha256.New(), leaf)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fns.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fns, ft = open()
+	defer fns.Close()
+	if ft.NumLeaves() != 100 {
+		t.Fatal("wrong number of leaves after recovering", ft.NumLeaves())
+	}
+	for _, index := range []uint64{0, 59, 60, 99} {
+		checkFullProof(t, ft, leaves, index)
+	}
+}
+
+// TestFileNodeStoreCorruption checks that level files with short records
+// are reported as errors rather than read as nodes.
+func TestFileNodeStoreCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merkletree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fns, err := OpenFileNodeStore(dir, sha256.Size, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft, err := NewFullWithStore(sha256.New(), fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range randomLeaves(10, 16) {
+		if err := ft.Push(leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fns.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the last record of level 1 short.
+	path := filepath.Join(dir, "level-1")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b[:len(b)-1], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileNodeStore(dir, sha256.Size, 4); err == nil {
+		t.Error("short record accepted")
+	}
+
+	// A store whose records are not the size of the hash is rejected when
+	// the tree reads a node.
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fns, err = OpenFileNodeStore(dir, 16, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fns.Close()
+	if _, err := NewFullWithStore(sha256.New(), fns); err == nil {
+		t.Error("nodes of the wrong size accepted")
+	}
+}
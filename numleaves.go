@@ -0,0 +1,196 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+)
+
+// numLeavesState is the state kept by a Tree that tracks its number of
+// leaves, see TrackNumLeaves.
+type numLeavesState struct {
+	// peaks holds the first leaf of every subtree in the stack, in the same
+	// order as the stack, along with the siblings of that leaf within the
+	// subtree, from the bottom up.
+	peaks []numLeavesPeak
+
+	// prev holds the peaks from before the last leaf was pushed, whose sums
+	// are the siblings of the last leaf, and last is the data of the last
+	// leaf.
+	prev []numLeavesPeak
+	last []byte
+
+	// broken is set when a subtree whose leaves are unknown is pushed, after
+	// which no proof can be built until the Tree is reset.
+	broken bool
+}
+
+// numLeavesPeak is the first leaf of a subtree in the stack of a Tree that
+// tracks its number of leaves.
+type numLeavesPeak struct {
+	first    []byte
+	siblings [][]byte
+	sum      []byte
+}
+
+// TrackNumLeaves makes the Tree keep what ProveNumLeaves needs to prove the
+// number of leaves: the data of the last leaf, and the data of the first leaf
+// of every subtree in the stack along with its siblings, which is O(log^2(n))
+// sums. It must be called while the Tree is empty. ReadAll pushes one leaf at
+// a time instead of in batches when the Tree tracks its number of leaves.
+// Subtrees pushed with PushSubTree, PushReader or PadTo hide their leaves, so
+// pushing one stops the tracking until the Tree is reset. A CachedTree can't
+// track its number of leaves. The setting is kept by Reset.
+func (t *Tree) TrackNumLeaves() {
+	t.leafCount = &numLeavesState{broken: !t.IsEmpty() || t.cachedTree}
+}
+
+// pushLeaf records that 'data' is being pushed as the next leaf. It must be
+// called before the leaf is added to the stack.
+func (s *numLeavesState) pushLeaf(stack []subTree, data []byte) {
+	if s.broken {
+		return
+	}
+	s.prev = s.prev[:0]
+	for i, peak := range s.peaks {
+		peak.sum = stack[i].sum
+		s.prev = append(s.prev, peak)
+	}
+	s.last = data
+	s.peaks = append(s.peaks, numLeavesPeak{first: data})
+}
+
+// join records that the last two subtrees of the stack are being joined,
+// where 'right' is the sum of the last subtree.
+func (s *numLeavesState) join(right []byte) {
+	if s.broken {
+		return
+	}
+	// The sum of a leaf that is about to be joined may live in the scratch
+	// buffer of the Tree, so it is copied.
+	n := len(s.peaks)
+	s.peaks[n-2].siblings = append(s.peaks[n-2].siblings, append([]byte(nil), right...))
+	s.peaks = s.peaks[:n-1]
+}
+
+// stop stops the tracking, because a subtree whose leaves are unknown was
+// pushed.
+func (s *numLeavesState) stop() {
+	*s = numLeavesState{broken: true}
+}
+
+// numLeavesProofIndices returns the indices of the leaves whose proofs make up
+// a proof that a tree has 'numLeaves' leaves: the first leaf of every left
+// sibling of the last leaf, from the top down, then the last leaf itself. The
+// left siblings of the last leaf are the subtrees of a tree of numLeaves-1
+// leaves, one per set bit.
+func numLeavesProofIndices(numLeaves uint64) []uint64 {
+	var indices []uint64
+	var start uint64
+	for height := 63; height >= 0; height-- {
+		if (numLeaves-1)&(1<<uint(height)) != 0 {
+			indices = append(indices, start)
+			start += 1 << uint(height)
+		}
+	}
+	return append(indices, numLeaves-1)
+}
+
+// ProveNumLeaves returns the Merkle root of the Tree, a proof of its number
+// of leaves, and the number of leaves. The proof is a list of proof sets, as
+// returned by Prove, for the leaves chosen by the number of leaves: the first
+// leaf of every left sibling of the last leaf, then the last leaf. The proof
+// of the last leaf alone does not pin the number of leaves, because a
+// verifier can't tell a leaf sum from a node sum; a proof of the last leaf of
+// 6 leaves also proves the last leaf of 7. Opening the first leaf of every
+// left sibling pins the height of each one, and with them the number of
+// leaves. The proof is nil if the Tree does not track its number of leaves,
+// see TrackNumLeaves, or is empty.
+func (t *Tree) ProveNumLeaves() (merkleRoot []byte, proof [][][]byte, numLeaves uint64) {
+	merkleRoot, numLeaves = t.Root(), t.currentIndex
+	s := t.leafCount
+	if s == nil || s.broken || numLeaves == 0 {
+		return merkleRoot, nil, numLeaves
+	}
+
+	// suffix[i] is the root of everything to the right of peak i-1: the
+	// peaks from i on, and the last leaf.
+	suffix := make([][]byte, len(s.prev)+1)
+	t.countLeaf(s.last)
+	suffix[len(s.prev)] = leafSum(t.hash, s.last)
+	for i := len(s.prev) - 1; i >= 1; i-- {
+		t.countNode(s.prev[i].sum, suffix[i+1])
+		suffix[i] = nodeSum(t.hash, s.prev[i].sum, suffix[i+1])
+	}
+
+	for i, peak := range s.prev {
+		proofSet := make([][]byte, 0, 1+len(peak.siblings)+i+1)
+		proofSet = append(proofSet, peak.first)
+		proofSet = append(proofSet, peak.siblings...)
+		proofSet = append(proofSet, suffix[i+1])
+		for j := i - 1; j >= 0; j-- {
+			proofSet = append(proofSet, s.prev[j].sum)
+		}
+		proof = append(proof, proofSet)
+	}
+	lastSet := make([][]byte, 0, 1+len(s.prev))
+	lastSet = append(lastSet, s.last)
+	for j := len(s.prev) - 1; j >= 0; j-- {
+		lastSet = append(lastSet, s.prev[j].sum)
+	}
+	return merkleRoot, append(proof, lastSet), numLeaves
+}
+
+// VerifyNumLeaves returns true if 'proof', as returned by Tree.ProveNumLeaves,
+// proves that the tree with root 'merkleRoot' has exactly 'numLeaves' leaves.
+// Every proof set must have exactly the length expected for its leaf, so a
+// proof made for a different number of leaves is never accepted.
+func VerifyNumLeaves(h hash.Hash, merkleRoot []byte, proof [][][]byte, numLeaves uint64) bool {
+	if numLeaves == 0 {
+		return false
+	}
+	indices := numLeavesProofIndices(numLeaves)
+	if len(proof) != len(indices) {
+		return false
+	}
+	for i, index := range indices {
+		if len(proof[i]) != proofLen(index, numLeaves) || !VerifyProof(h, merkleRoot, proof[i], index, numLeaves) {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrNotLastLeaf is returned by ProveLast when the leaf at the proof index is
+// not the last leaf of the Tree.
+var ErrNotLastLeaf = errors.New("the proof index is not the last leaf of the tree")
+
+// ProveLast is the same as Prove, but returns ErrNotLastLeaf unless the leaf
+// at the proof index set with SetIndex is the last leaf that was pushed. The
+// proof can then be checked with VerifyLast, which also proves that no leaf
+// follows it.
+func (t *Tree) ProveLast() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, err error) {
+	if !t.proofTree || t.currentIndex == 0 || t.proofIndex != t.currentIndex-1 {
+		return nil, nil, 0, ErrNotLastLeaf
+	}
+	merkleRoot, proofSet, proofIndex, _ = t.Prove()
+	return merkleRoot, proofSet, proofIndex, nil
+}
+
+// VerifyLast returns true if 'proofSet' proves that the leaf at 'proofIndex'
+// is the last leaf of the tree with root 'merkleRoot', meaning the tree has
+// proofIndex+1 leaves. The proof set of a leaf grows by at least one element
+// when any leaf is added after it, so a proof set whose length is shared by
+// any larger tree, as reported by NumLeavesRange, is rejected rather than
+// trusted to pin the number of leaves.
+//
+// The proof shows that the leaf is the last leaf of the tree, but only pins
+// its index up to the number of set bits: every sibling of the last leaf is
+// on its left, so the proof of the last of 3 leaves also verifies as the last
+// of 2. To prove the index of the last leaf as well, use ProveNumLeaves.
+func VerifyLast(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64) bool {
+	min, max, err := NumLeavesRange(len(proofSet), proofIndex)
+	if err != nil || min != proofIndex+1 || max != proofIndex+1 {
+		return false
+	}
+	return VerifyProof(h, merkleRoot, proofSet, proofIndex, proofIndex+1)
+}
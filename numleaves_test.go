@@ -0,0 +1,156 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/bits"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestProveNumLeaves checks that the proof of the number of leaves of every
+// tree up to 200 leaves verifies for the true number of leaves, and for no
+// other.
+func TestProveNumLeaves(t *testing.T) {
+	maxLeaves := uint64(200)
+	if testing.Short() {
+		maxLeaves = 70
+	}
+	tree := New(sha256.New())
+	tree.TrackNumLeaves()
+	for n := uint64(1); n <= maxLeaves; n++ {
+		tree.Push(fastrand.Bytes(int(n % 5)))
+		root, proof, numLeaves := tree.ProveNumLeaves()
+		if numLeaves != n || !bytes.Equal(root, tree.Root()) {
+			t.Fatal("wrong root or number of leaves", n, numLeaves)
+		}
+		if !VerifyNumLeaves(sha256.New(), root, proof, n) {
+			t.Fatal("proof does not verify", n)
+		}
+		for m := uint64(0); m <= 2*n+2; m++ {
+			if m != n && VerifyNumLeaves(sha256.New(), root, proof, m) {
+				t.Error("proof of", n, "leaves verifies for", m)
+			}
+		}
+
+		// Corrupting any element must fail.
+		i := fastrand.Intn(len(proof))
+		j := fastrand.Intn(len(proof[i]))
+		elem := proof[i][j]
+		proof[i][j] = append([]byte{1}, elem...)
+		if VerifyNumLeaves(sha256.New(), root, proof, n) {
+			t.Error("corrupted proof verifies", n, i, j)
+		}
+		proof[i][j] = elem
+	}
+}
+
+// TestProveNumLeavesState checks when a Tree can prove its number of leaves.
+func TestProveNumLeavesState(t *testing.T) {
+	tree := New(sha256.New())
+	tree.Push([]byte{1})
+	if _, proof, _ := tree.ProveNumLeaves(); proof != nil {
+		t.Error("proof built without tracking")
+	}
+	tree.TrackNumLeaves()
+	if _, proof, _ := tree.ProveNumLeaves(); proof != nil {
+		t.Error("proof built after tracking started on a non-empty tree")
+	}
+
+	// Reset starts the tracking again, and ReadAll must push one leaf at a
+	// time even with a BatchHasher.
+	tree = NewSHA256()
+	tree.TrackNumLeaves()
+	if _, proof, _ := tree.ProveNumLeaves(); proof != nil {
+		t.Error("proof built for an empty tree")
+	}
+	tree.Push([]byte{1})
+	tree.Reset()
+	if err := tree.ReadAll(bytes.NewReader(fastrand.Bytes(1000)), 64); err != nil {
+		t.Fatal(err)
+	}
+	root, proof, numLeaves := tree.ProveNumLeaves()
+	if numLeaves != 16 || !VerifyNumLeaves(sha256.New(), root, proof, 16) {
+		t.Error("proof after Reset and ReadAll does not verify")
+	}
+
+	// A subtree hides its leaves.
+	if err := tree.PushSubTree(0, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if _, proof, _ := tree.ProveNumLeaves(); proof != nil {
+		t.Error("proof built after PushSubTree")
+	}
+	tree.Reset()
+	tree.Push([]byte{2})
+	if _, proof, _ := tree.ProveNumLeaves(); proof == nil {
+		t.Error("Reset did not restart the tracking")
+	}
+
+	ct := NewCachedTree(sha256.New(), 2)
+	ct.TrackNumLeaves()
+	ct.Push(make([]byte, 32))
+	if _, proof, _ := ct.ProveNumLeaves(); proof != nil {
+		t.Error("cached tree built a proof")
+	}
+}
+
+// TestProveLast checks that the last leaf of trees of many sizes can be
+// proven to be last, and that other leaves can't.
+func TestProveLast(t *testing.T) {
+	for n := uint64(1); n < 150; n++ {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			leaves[i] = fastrand.Bytes(8)
+		}
+		for _, index := range []uint64{n - 1, fastrand.Uint64n(n)} {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(index); err != nil {
+				t.Fatal(err)
+			}
+			for _, leaf := range leaves {
+				tree.Push(leaf)
+			}
+			root, proofSet, proofIndex, err := tree.ProveLast()
+			if index != n-1 {
+				if err != ErrNotLastLeaf {
+					t.Error("proved a leaf that is not last", n, index, err)
+				}
+				// The ordinary proof of a leaf that is not last must not
+				// pass as a proof that it is last.
+				root, proofSet, _, _ = tree.Prove()
+				if VerifyLast(sha256.New(), root, proofSet, index) {
+					t.Error("leaf that is not last verifies as last", n, index)
+				}
+				continue
+			}
+			if err != nil || proofIndex != index {
+				t.Fatal(err, n, proofIndex)
+			}
+			if !VerifyLast(sha256.New(), root, proofSet, index) {
+				t.Error("last leaf does not verify", n)
+			}
+			// The index is only pinned up to its number of set bits.
+			for other := uint64(0); other < 2*n; other++ {
+				if VerifyLast(sha256.New(), root, proofSet, other) != (bits.OnesCount64(other) == bits.OnesCount64(index)) {
+					t.Error("last leaf verifies at the wrong indices", n, other)
+				}
+			}
+			if VerifyLast(sha256.New(), root, proofSet[:len(proofSet)-1], index) {
+				t.Error("truncated proof verifies", n)
+			}
+		}
+	}
+
+	tree := New(sha256.New())
+	if _, _, _, err := tree.ProveLast(); err != ErrNotLastLeaf {
+		t.Error("proved the last leaf without a proof index", err)
+	}
+	if err := tree.SetIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := tree.ProveLast(); err != ErrNotLastLeaf {
+		t.Error("proved the last leaf of an empty tree", err)
+	}
+}
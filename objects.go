@@ -0,0 +1,37 @@
+package merkletree
+
+import (
+	"encoding"
+)
+
+// PushObject marshals 'v' and pushes the result into the tree as a single
+// leaf. If marshaling fails, the error is returned and the tree is not
+// modified. The marshaled bytes are owned by the tree, and may be returned in
+// a proof set.
+func (t *Tree) PushObject(v encoding.BinaryMarshaler) error {
+	data, err := v.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	t.Push(data)
+	return nil
+}
+
+// PushObjects marshals every element of 'vs' and pushes the results into the
+// tree in order, one leaf per element. Every element is marshaled before any
+// of them are pushed, so if marshaling fails the error is returned and the
+// tree is not modified.
+func (t *Tree) PushObjects(vs ...encoding.BinaryMarshaler) error {
+	leaves := make([][]byte, len(vs))
+	for i, v := range vs {
+		data, err := v.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		leaves[i] = data
+	}
+	for _, leaf := range leaves {
+		t.Push(leaf)
+	}
+	return nil
+}
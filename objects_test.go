@@ -0,0 +1,84 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testObject is a small type that implements encoding.BinaryMarshaler. If
+// 'fail' is set, MarshalBinary returns an error.
+type testObject struct {
+	id   uint64
+	name string
+	fail bool
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (o testObject) MarshalBinary() ([]byte, error) {
+	if o.fail {
+		return nil, errors.New("marshal failed")
+	}
+	b := make([]byte, 8, 8+len(o.name))
+	binary.LittleEndian.PutUint64(b, o.id)
+	return append(b, o.name...), nil
+}
+
+// TestPushObject checks that PushObject and PushObjects produce the same tree
+// as pushing the marshaled bytes, and that marshal errors leave the tree
+// unmodified.
+func TestPushObject(t *testing.T) {
+	objs := []encoding.BinaryMarshaler{
+		testObject{id: 1, name: "foo"},
+		testObject{id: 2, name: "bar"},
+		testObject{id: 3},
+		testObject{id: 4, name: "quux"},
+		testObject{id: 5, name: "baz"},
+	}
+
+	expected := New(sha256.New())
+	single := New(sha256.New())
+	batch := New(sha256.New())
+	for _, tree := range []*Tree{expected, single, batch} {
+		if err := tree.SetIndex(3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, obj := range objs {
+		data, _ := obj.MarshalBinary()
+		expected.Push(data)
+		if err := single.PushObject(obj); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if err := batch.PushObjects(objs...); err != nil {
+		t.Fatal(err)
+	}
+	if !single.EqualsWithProofState(expected) || !batch.EqualsWithProofState(expected) {
+		t.Fatal("pushing objects produced a different tree than pushing their bytes")
+	}
+	root, proofSet, proofIndex, numLeaves := batch.Prove()
+	if !VerifyProof(sha256.New(), root, proofSet, proofIndex, numLeaves) {
+		t.Error("proof of an object is invalid")
+	}
+	data, _ := objs[3].MarshalBinary()
+	if !bytes.Equal(proofSet[0], data) {
+		t.Error("proof set does not start with the marshaled object")
+	}
+
+	// A failing marshaler leaves the tree unmodified, even if it is in the
+	// middle of a batch.
+	bad := testObject{id: 6, fail: true}
+	if err := single.PushObject(bad); err == nil {
+		t.Error("PushObject did not return the marshal error")
+	}
+	if err := batch.PushObjects(testObject{id: 6}, bad, testObject{id: 7}); err == nil {
+		t.Error("PushObjects did not return the marshal error")
+	}
+	if !single.EqualsWithProofState(expected) || !batch.EqualsWithProofState(expected) {
+		t.Error("a failed marshal modified the tree")
+	}
+}
@@ -0,0 +1,105 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// An OTSOpTag identifies an OpenTimestamps operation using the tag byte of
+// its binary encoding.
+type OTSOpTag byte
+
+// The OpenTimestamps operations that a proof translates into.
+const (
+	OTSOpSHA256  OTSOpTag = 0x08
+	OTSOpAppend  OTSOpTag = 0xf0
+	OTSOpPrepend OTSOpTag = 0xf1
+)
+
+// An OTSOp is a single OpenTimestamps operation. Append and prepend take Arg
+// as their argument; SHA256 takes no argument.
+type OTSOp struct {
+	Tag OTSOpTag
+	Arg []byte
+}
+
+// ProofToOTS converts a proof created with SHA-256 into the sequence of
+// OpenTimestamps operations that turns the leaf data, p.Set[0], into the
+// Merkle root. The 0x00 leaf prefix and the 0x01 node prefix become prepend
+// operations, a left sibling is prepended before the node prefix, and a
+// right sibling is appended. p.Root is not used.
+func ProofToOTS(p Proof) ([]OTSOp, error) {
+	if p.Index >= p.NumLeaves || len(p.Set) > proofLen(p.Index, p.NumLeaves) {
+		return nil, errors.New("proof set is invalid for the proof index and number of leaves")
+	}
+	sides, ok := proofSides(len(p.Set), p.Index, p.NumLeaves)
+	if !ok {
+		return nil, errors.New("proof set is invalid for the proof index and number of leaves")
+	}
+
+	ops := []OTSOp{
+		{Tag: OTSOpPrepend, Arg: leafHashPrefix},
+		{Tag: OTSOpSHA256},
+	}
+	for i, left := range sides {
+		if left {
+			ops = append(ops, OTSOp{Tag: OTSOpPrepend, Arg: p.Set[i+1]})
+		} else {
+			ops = append(ops, OTSOp{Tag: OTSOpAppend, Arg: p.Set[i+1]})
+		}
+		ops = append(ops,
+			OTSOp{Tag: OTSOpPrepend, Arg: nodeHashPrefix},
+			OTSOp{Tag: OTSOpSHA256},
+		)
+	}
+	return ops, nil
+}
+
+// EncodeOTS returns the OpenTimestamps binary encoding of 'ops': each
+// operation is its tag byte, and the argument of an append or prepend follows
+// as a varuint length and the argument bytes.
+func EncodeOTS(ops []OTSOp) []byte {
+	var b []byte
+	length := make([]byte, binary.MaxVarintLen64)
+	for _, op := range ops {
+		b = append(b, byte(op.Tag))
+		if op.Tag == OTSOpAppend || op.Tag == OTSOpPrepend {
+			n := binary.PutUvarint(length, uint64(len(op.Arg)))
+			b = append(b, length[:n]...)
+			b = append(b, op.Arg...)
+		}
+	}
+	return b
+}
+
+// EvaluateOTS executes 'ops' on 'msg' and returns the result. An error is
+// returned if an operation is not supported.
+func EvaluateOTS(msg []byte, ops []OTSOp) ([]byte, error) {
+	msg = append([]byte(nil), msg...)
+	for _, op := range ops {
+		switch op.Tag {
+		case OTSOpSHA256:
+			sum := sha256.Sum256(msg)
+			msg = sum[:]
+		case OTSOpAppend:
+			msg = append(msg, op.Arg...)
+		case OTSOpPrepend:
+			msg = append(append([]byte(nil), op.Arg...), msg...)
+		default:
+			return nil, errors.New("unsupported OpenTimestamps operation")
+		}
+	}
+	return msg, nil
+}
+
+// VerifyOTS returns true if executing 'ops' on the leaf data 'data' produces
+// the Merkle root.
+func VerifyOTS(merkleRoot []byte, data []byte, ops []OTSOp) bool {
+	if merkleRoot == nil {
+		return false
+	}
+	result, err := EvaluateOTS(data, ops)
+	return err == nil && bytes.Equal(result, merkleRoot)
+}
@@ -0,0 +1,82 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// TestOTS converts every proof of trees up to 40 leaves into OpenTimestamps
+// operations and checks that executing them produces the root.
+func TestOTS(t *testing.T) {
+	for numLeaves := uint64(1); numLeaves <= 40; numLeaves++ {
+		for proofIndex := uint64(0); proofIndex < numLeaves; proofIndex++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(proofIndex); err != nil {
+				t.Fatal(err)
+			}
+			for i := uint64(0); i < numLeaves; i++ {
+				tree.Push([]byte{byte(i)})
+			}
+			root, proofSet, _, _ := tree.Prove()
+			ops, err := ProofToOTS(Proof{Set: proofSet, Index: proofIndex, NumLeaves: numLeaves})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ops) != 3*len(proofSet)-1 {
+				t.Error("wrong number of operations", numLeaves, proofIndex)
+			}
+			if !VerifyOTS(root, proofSet[0], ops) {
+				t.Error("operations did not produce the root", numLeaves, proofIndex)
+			}
+			if VerifyOTS(root, []byte{byte(proofIndex + 1)}, ops) {
+				t.Error("operations produced the root for the wrong data", numLeaves, proofIndex)
+			}
+		}
+	}
+
+	mt := CreateMerkleTester(t)
+	if _, err := ProofToOTS(Proof{Set: mt.proofSets[15][10][:2], Index: 10, NumLeaves: 15}); err == nil {
+		t.Error("proof set that is too short was converted")
+	}
+	if _, err := ProofToOTS(Proof{Set: append(mt.proofSets[15][10], mt.roots[15]), Index: 10, NumLeaves: 15}); err == nil {
+		t.Error("proof set that is too long was converted")
+	}
+	if _, err := ProofToOTS(Proof{Set: mt.proofSets[15][10], Index: 15, NumLeaves: 15}); err == nil {
+		t.Error("proof index outside of the tree was converted")
+	}
+	if _, err := EvaluateOTS(nil, []OTSOp{{Tag: 0x02}}); err == nil {
+		t.Error("unsupported operation was executed")
+	}
+}
+
+// TestOTSGolden checks the encoding of the operations of a few proofs against
+// fixed vectors, so that the encoding stays stable.
+func TestOTSGolden(t *testing.T) {
+	tests := []struct {
+		numLeaves, proofIndex uint64
+		encoded               string
+	}{
+		{1, 0, "f1010008"},
+		{2, 1, "f1010008f12096a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7f1010108"},
+		{3, 2, "f1010008f120a20bf9a7cc2dc8a08f5f415a71b19f6ac427bab54d24eec868b5d3103449953af1010108"},
+		{5, 1, "f1010008f12096a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7f1010108f02052c56b473e5246933e7852989cd9feba3b38f078742b93afff1e65ed46797825f1010108f0204f35212d12f9ad2036492c95f1fe79baf4ec7bd9bef3dffa7579f2293ff546a4f1010108"},
+	}
+	for _, test := range tests {
+		tree := New(sha256.New())
+		if err := tree.SetIndex(test.proofIndex); err != nil {
+			t.Fatal(err)
+		}
+		for i := uint64(0); i < test.numLeaves; i++ {
+			tree.Push([]byte{byte(i)})
+		}
+		_, proofSet, _, _ := tree.Prove()
+		ops, err := ProofToOTS(Proof{Set: proofSet, Index: test.proofIndex, NumLeaves: test.numLeaves})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc := hex.EncodeToString(EncodeOTS(ops)); enc != test.encoded {
+			t.Error("wrong encoding", test.numLeaves, test.proofIndex, enc)
+		}
+	}
+}
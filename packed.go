@@ -0,0 +1,122 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"strconv"
+)
+
+// packedLeafPrefixSize is the size of the length prefix of the leaf data in a
+// packed proof. A packed proof is the proofVersionSingle header, then the
+// length of the leaf data as an 8 byte little-endian integer, then the leaf
+// data, then every sibling of the proof set back to back. Every sibling has
+// the same size, so they are not framed.
+const packedLeafPrefixSize = 8
+
+// PackProof packs the proof set of 'p' into a single byte slice, for
+// verifiers that work on contiguous buffers. Every element of the proof set
+// after the leaf data must be 'hashSize' bytes. The root, index and number of
+// leaves of the proof are not packed.
+func PackProof(p Proof, hashSize int) ([]byte, error) {
+	if hashSize <= 0 {
+		return nil, errors.New("hash size must be positive")
+	}
+	if len(p.Set) == 0 {
+		return nil, errors.New("proof set is empty")
+	}
+	for i, elem := range p.Set[1:] {
+		if len(elem) != hashSize {
+			return nil, errors.New("proof set element " + strconv.Itoa(i+1) + " is not " + strconv.Itoa(hashSize) + " bytes")
+		}
+	}
+	b := make([]byte, 1+packedLeafPrefixSize, 1+packedLeafPrefixSize+len(p.Set[0])+(len(p.Set)-1)*hashSize)
+	b[0] = proofVersionSingle
+	binary.LittleEndian.PutUint64(b[1:], uint64(len(p.Set[0])))
+	for _, elem := range p.Set {
+		b = append(b, elem...)
+	}
+	return b, nil
+}
+
+// splitPacked returns the leaf data and the siblings of a packed proof. An
+// error is returned if the siblings are not a whole number of 'hashSize'
+// elements, and ErrUnknownProofVersion if the header is not
+// proofVersionSingle.
+func splitPacked(b []byte, hashSize int) (leaf, siblings []byte, err error) {
+	if hashSize <= 0 {
+		return nil, nil, errors.New("hash size must be positive")
+	}
+	if len(b) < 1 || b[0] != proofVersionSingle {
+		return nil, nil, ErrUnknownProofVersion
+	}
+	b = b[1:]
+	if len(b) < packedLeafPrefixSize {
+		return nil, nil, errors.New("packed proof is too short for the leaf length")
+	}
+	leafLen := binary.LittleEndian.Uint64(b)
+	b = b[packedLeafPrefixSize:]
+	if leafLen > uint64(len(b)) {
+		return nil, nil, errors.New("packed proof is too short for the leaf data")
+	}
+	leaf, siblings = b[:leafLen:leafLen], b[leafLen:]
+	if len(siblings)%hashSize != 0 {
+		return nil, nil, errors.New("packed proof siblings are not a multiple of the hash size")
+	}
+	return leaf, siblings, nil
+}
+
+// UnpackProof unpacks a proof set packed by PackProof. Only the Set of the
+// returned Proof is filled in, and its elements point into 'b'. An error is
+// returned if 'b' is not a packed proof of elements of 'hashSize' bytes, or
+// ErrUnknownProofVersion if it does not start with the header of a packed
+// proof.
+func UnpackProof(b []byte, hashSize int) (Proof, error) {
+	leaf, siblings, err := splitPacked(b, hashSize)
+	if err != nil {
+		return Proof{}, err
+	}
+	set := make([][]byte, 1, 1+len(siblings)/hashSize)
+	set[0] = leaf
+	for len(siblings) > 0 {
+		set = append(set, siblings[:hashSize:hashSize])
+		siblings = siblings[hashSize:]
+	}
+	return Proof{Set: set}, nil
+}
+
+// VerifyPacked returns true if the packed proof set 'packed' proves the leaf
+// at 'index' of a tree of 'numLeaves' leaves with root 'root'. The siblings
+// are read directly out of 'packed', which must hold exactly the siblings of
+// the leaf, each the size of the hash's output. Unlike VerifyProof, a packed
+// proof with trailing siblings left out is never accepted.
+func VerifyPacked(h hash.Hash, root []byte, packed []byte, index, numLeaves uint64) bool {
+	if root == nil || index >= numLeaves {
+		return false
+	}
+	leaf, siblings, err := splitPacked(packed, h.Size())
+	if err != nil {
+		return false
+	}
+
+	// Walk up the tree one level at a time. A node at the end of a level with
+	// an odd number of nodes has no sibling, and is promoted unchanged.
+	sum := appendLeafSum(nil, h, leaf)
+	for width := numLeaves; width > 1; width = (width + 1) / 2 {
+		if index^1 < width {
+			if len(siblings) < h.Size() {
+				return false
+			}
+			sibling := siblings[:h.Size()]
+			siblings = siblings[h.Size():]
+			if index&1 == 0 {
+				sum = appendNodeSum(sum[:0], h, sum, sibling)
+			} else {
+				sum = appendNodeSum(sum[:0], h, sibling, sum)
+			}
+		}
+		index /= 2
+	}
+	return len(siblings) == 0 && bytes.Equal(sum, root)
+}
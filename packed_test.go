@@ -0,0 +1,117 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestPackProof checks that packed proofs round trip, and that VerifyPacked
+// agrees with VerifyProof.
+func TestPackProof(t *testing.T) {
+	for numLeaves := uint64(1); numLeaves < 40; numLeaves++ {
+		for index := uint64(0); index < numLeaves; index++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(index); err != nil {
+				t.Fatal(err)
+			}
+			for i := uint64(0); i < numLeaves; i++ {
+				tree.Push(fastrand.Bytes(int(i % 70)))
+			}
+			root, proofSet, _, _ := tree.Prove()
+			packed, err := PackProof(Proof{Set: proofSet}, sha256.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := UnpackProof(packed, sha256.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Set) != len(proofSet) {
+				t.Fatal("unpacked proof has the wrong length", numLeaves, index)
+			}
+			for i := range proofSet {
+				if !bytes.Equal(p.Set[i], proofSet[i]) {
+					t.Fatal("unpacked proof differs", numLeaves, index, i)
+				}
+			}
+			if !VerifyPacked(sha256.New(), root, packed, index, numLeaves) {
+				t.Error("packed proof does not verify", numLeaves, index)
+			}
+
+			// Corrupting any byte must fail.
+			corrupt := append([]byte(nil), packed...)
+			corrupt[fastrand.Intn(len(corrupt))]++
+			if VerifyPacked(sha256.New(), root, corrupt, index, numLeaves) {
+				t.Error("corrupted packed proof verifies", numLeaves, index)
+			}
+		}
+	}
+}
+
+// TestPackProofSizes checks that mis-sized proofs and buffers are rejected.
+func TestPackProofSizes(t *testing.T) {
+	tree := New(sha512.New())
+	if err := tree.SetIndex(2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		tree.Push([]byte{byte(i), 1, 2})
+	}
+	root, proofSet, _, _ := tree.Prove()
+	if _, err := PackProof(Proof{Set: proofSet}, sha256.Size); err == nil {
+		t.Error("packed sha512 proof with the sha256 size")
+	}
+	if _, err := PackProof(Proof{}, sha512.Size); err == nil {
+		t.Error("packed an empty proof")
+	}
+	packed, err := PackProof(Proof{Set: proofSet}, sha512.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyPacked(sha512.New(), root, packed, 2, 7) {
+		t.Fatal("packed proof does not verify")
+	}
+
+	// Truncated, padded and mis-sized buffers must be rejected.
+	bad := [][]byte{
+		nil,
+		packed[:4],
+		packed[:packedLeafPrefixSize+2],
+		packed[:len(packed)-1],
+		packed[:len(packed)-sha512.Size],
+		append(append([]byte(nil), packed...), make([]byte, sha512.Size)...),
+	}
+	for i, b := range bad {
+		if VerifyPacked(sha512.New(), root, b, 2, 7) {
+			t.Error("bad packed proof verifies", i)
+		}
+	}
+	for i, b := range bad[:4] {
+		if _, err := UnpackProof(b, sha512.Size); err == nil {
+			t.Error("bad packed proof unpacks", i)
+		}
+	}
+	for _, version := range []byte{0, proofVersionSingle + 1} {
+		b := append([]byte(nil), packed...)
+		b[0] = version
+		if _, err := UnpackProof(b, sha512.Size); err != ErrUnknownProofVersion {
+			t.Error("wrong error for an unknown version", version, err)
+		}
+		if VerifyPacked(sha512.New(), root, b, 2, 7) {
+			t.Error("packed proof with an unknown version verifies", version)
+		}
+	}
+	if _, err := UnpackProof(packed[1:], sha512.Size); err != ErrUnknownProofVersion {
+		t.Error("wrong error for a packed proof without a header", err)
+	}
+	if _, err := UnpackProof(packed, 0); err == nil {
+		t.Error("unpacked with a zero hash size")
+	}
+	if VerifyPacked(sha512.New(), root, packed, 7, 7) {
+		t.Error("packed proof verifies an index outside the tree")
+	}
+}
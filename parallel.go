@@ -0,0 +1,200 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"runtime"
+)
+
+// parallelBatchHeight is the height of the subtrees that ParallelTree hands
+// to its workers. Each batch holds 1 << parallelBatchHeight leaves, which is
+// enough hashing to outweigh the cost of starting a goroutine even for small
+// segments.
+const parallelBatchHeight = 8
+
+// A ParallelTree computes the same Merkle root and proofs as a Tree, but
+// spreads the hashing of leaves across several goroutines. Leaves are grouped
+// into aligned batches of 1 << parallelBatchHeight leaves, the root of each
+// batch is computed by a worker, and the batch roots are merged in order into
+// a Tree using PushSubTree. The batch containing the proof index is pushed
+// leaf by leaf instead, so that the proof can be built.
+//
+// Push retains 'data' until the batch containing it has been hashed, so the
+// data must not be modified after it has been pushed. A ParallelTree must not
+// be used concurrently.
+type ParallelTree struct {
+	tree *Tree
+
+	// batch holds the leaves of the batch that is currently being filled.
+	// pending holds the batches that have been handed to workers but have not
+	// yet been merged into 'tree', in order.
+	batch   [][]byte
+	pending []*parallelBatch
+
+	// workers is a pool of idle trees, one per worker. Taking a tree from the
+	// pool is what limits the number of batches being hashed at once.
+	workers chan *Tree
+
+	numLeaves  uint64
+	proofIndex uint64
+	proofTree  bool
+}
+
+// A parallelBatch is a batch of leaves that is being hashed by a worker. done
+// is closed once 'root' has been set. If the batch contains the proof index
+// it is never hashed, 'root' stays nil, and the leaves are pushed
+// individually when the batch is merged.
+type parallelBatch struct {
+	leaves [][]byte
+	root   []byte
+	done   chan struct{}
+}
+
+// NewParallel creates a new ParallelTree that hashes with 'workers'
+// goroutines. If 'workers' is less than 1, GOMAXPROCS workers are used. Each
+// worker gets its own hash from 'newHash'.
+func NewParallel(newHash func() hash.Hash, workers int) *ParallelTree {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	pt := &ParallelTree{
+		tree:    New(newHash()),
+		workers: make(chan *Tree, workers),
+	}
+	for i := 0; i < workers; i++ {
+		pt.workers <- New(newHash())
+	}
+	return pt
+}
+
+// SetIndex will tell the ParallelTree to create a storage proof for the leaf
+// at the input index. SetIndex must be called on an empty tree.
+func (pt *ParallelTree) SetIndex(i uint64) error {
+	if pt.numLeaves != 0 {
+		return errors.New("cannot call SetIndex on ParallelTree if it is not empty")
+	}
+	if err := pt.tree.SetIndex(i); err != nil {
+		return err
+	}
+	pt.proofTree = true
+	pt.proofIndex = i
+	return nil
+}
+
+// Push adds a leaf to the tree. Once a batch of leaves is full it is handed
+// to a worker, blocking if every worker is busy.
+func (pt *ParallelTree) Push(data []byte) {
+	pt.batch = append(pt.batch, data)
+	pt.numLeaves++
+	if len(pt.batch) == 1<<parallelBatchHeight {
+		pt.dispatch()
+	}
+}
+
+// ReadAll will read segments of size 'segmentSize' and push them into the
+// tree until EOF is reached, in the same way as Tree.ReadAll.
+func (pt *ParallelTree) ReadAll(r io.Reader, segmentSize int) error {
+	return readSegments(r, segmentSize, pt.Push)
+}
+
+// Root returns the Merkle root of the data that has been pushed. Root waits
+// for every batch to be hashed. More leaves can be pushed after calling Root.
+func (pt *ParallelTree) Root() []byte {
+	return pt.finish().Root()
+}
+
+// Prove creates a proof that the leaf at the index established by SetIndex is
+// an element of the Merkle tree, with the same results as Tree.Prove. Prove
+// waits for every batch to be hashed. More leaves can be pushed after calling
+// Prove.
+func (pt *ParallelTree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) {
+	if !pt.proofTree {
+		panic("wrong usage: can't call prove on a tree if SetIndex wasn't called")
+	}
+	return pt.finish().Prove()
+}
+
+// dispatch hands the current batch to a worker. Batches that have already
+// been hashed are merged first, so that hashing and merging overlap.
+func (pt *ParallelTree) dispatch() {
+	b := &parallelBatch{
+		leaves: pt.batch,
+		done:   make(chan struct{}),
+	}
+	pt.batch = nil
+
+	// Merge the completed batches at the front of the queue. If the queue is
+	// long, the first batch is holding up the rest, so wait for it rather
+	// than letting the queue grow without bound.
+	pt.merge(len(pt.pending) >= 2*cap(pt.workers))
+	pt.pending = append(pt.pending, b)
+
+	start := pt.numLeaves - uint64(len(b.leaves))
+	if pt.proofTree && start <= pt.proofIndex && pt.proofIndex < pt.numLeaves {
+		close(b.done)
+		return
+	}
+	worker := <-pt.workers
+	go func() {
+		for _, leaf := range b.leaves {
+			worker.Push(leaf)
+		}
+		b.root = worker.Root()
+		worker.Reset()
+		pt.workers <- worker
+		close(b.done)
+	}()
+}
+
+// merge pushes the batches at the front of the queue into the tree, in
+// order, for as long as they have finished hashing. If 'wait' is true, merge
+// waits for the first batch to finish.
+func (pt *ParallelTree) merge(wait bool) {
+	for len(pt.pending) > 0 {
+		b := pt.pending[0]
+		if wait {
+			<-b.done
+			wait = false
+		} else {
+			select {
+			case <-b.done:
+			default:
+				return
+			}
+		}
+
+		if b.root == nil {
+			for _, leaf := range b.leaves {
+				pt.tree.Push(leaf)
+			}
+		} else if err := pt.tree.PushSubTree(parallelBatchHeight, b.root); err != nil {
+			// Batches are aligned and never contain the proof index, so
+			// PushSubTree can't fail.
+			panic(err)
+		}
+		pt.pending[0] = nil
+		pt.pending = pt.pending[1:]
+	}
+}
+
+// finish waits for every batch to be hashed and merged, and returns a Tree
+// holding every leaf pushed so far. The leaves of the unfinished batch can't
+// be pushed into 'pt.tree', because later batches would no longer be aligned,
+// so they are pushed into a copy of it instead.
+func (pt *ParallelTree) finish() *Tree {
+	for len(pt.pending) > 0 {
+		pt.merge(true)
+	}
+	if len(pt.batch) == 0 {
+		return pt.tree
+	}
+	t := *pt.tree
+	t.stack = append([]subTree(nil), pt.tree.stack...)
+	t.proofSet = t.proofSet[:len(t.proofSet):len(t.proofSet)]
+	t.scratch = nil
+	for _, leaf := range pt.batch {
+		t.Push(leaf)
+	}
+	return &t
+}
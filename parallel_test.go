@@ -0,0 +1,146 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// parallelTestSizes are the leaf counts used to compare ParallelTree against
+// Tree: empty trees, trees smaller than a batch, exact multiples of a batch,
+// and trees ending in a partial batch.
+var parallelTestSizes = []uint64{
+	0,
+	1,
+	7,
+	1 << parallelBatchHeight,
+	1<<parallelBatchHeight + 1,
+	3 << parallelBatchHeight,
+	5<<parallelBatchHeight + 37,
+	16 << parallelBatchHeight,
+}
+
+// TestParallelTreeRoot checks that ParallelTree produces the same roots as
+// Tree for a range of leaf counts and worker counts.
+func TestParallelTreeRoot(t *testing.T) {
+	for _, workers := range []int{0, 1, 2, 5} {
+		for _, numLeaves := range parallelTestSizes {
+			tree := New(sha256.New())
+			pt := NewParallel(sha256.New, workers)
+			for i := uint64(0); i < numLeaves; i++ {
+				leaf := fastrand.Bytes(8)
+				tree.Push(leaf)
+				pt.Push(leaf)
+			}
+			if !bytes.Equal(pt.Root(), tree.Root()) {
+				t.Error("ParallelTree root does not match Tree root", workers, numLeaves)
+			}
+		}
+	}
+}
+
+// TestParallelTreeProof checks that ParallelTree produces the same proofs as
+// Tree, for proof indices inside full batches and inside the final partial
+// batch.
+func TestParallelTreeProof(t *testing.T) {
+	numLeaves := uint64(5<<parallelBatchHeight + 37)
+	leaves := make([][]byte, numLeaves)
+	for i := range leaves {
+		leaves[i] = fastrand.Bytes(8)
+	}
+	for _, proofIndex := range []uint64{0, 1<<parallelBatchHeight - 1, 1 << parallelBatchHeight, 3<<parallelBatchHeight + 100, numLeaves - 1} {
+		tree := New(sha256.New())
+		pt := NewParallel(sha256.New, 3)
+		if err := tree.SetIndex(proofIndex); err != nil {
+			t.Fatal(err)
+		}
+		if err := pt.SetIndex(proofIndex); err != nil {
+			t.Fatal(err)
+		}
+		for _, leaf := range leaves {
+			tree.Push(leaf)
+			pt.Push(leaf)
+		}
+
+		root, proofSet, _, _ := tree.Prove()
+		pRoot, pProofSet, pProofIndex, pNumLeaves := pt.Prove()
+		if !bytes.Equal(pRoot, root) || pProofIndex != proofIndex || pNumLeaves != numLeaves {
+			t.Error("ParallelTree proof metadata does not match Tree", proofIndex)
+		}
+		if len(pProofSet) != len(proofSet) {
+			t.Fatal("ParallelTree proof has the wrong length", proofIndex)
+		}
+		for i := range proofSet {
+			if !bytes.Equal(pProofSet[i], proofSet[i]) {
+				t.Error("ParallelTree proof does not match Tree proof", proofIndex, i)
+			}
+		}
+		if !VerifyProof(sha256.New(), pRoot, pProofSet, proofIndex, numLeaves) {
+			t.Error("ParallelTree proof does not verify", proofIndex)
+		}
+	}
+}
+
+// TestParallelTreePushAfterRoot checks that calling Root in the middle of a
+// partial batch doesn't disturb the leaves pushed afterwards.
+func TestParallelTreePushAfterRoot(t *testing.T) {
+	tree := New(sha256.New())
+	pt := NewParallel(sha256.New, 2)
+	for i := 0; i < 4<<parallelBatchHeight; i++ {
+		leaf := fastrand.Bytes(8)
+		tree.Push(leaf)
+		pt.Push(leaf)
+		if i%100 == 0 && !bytes.Equal(pt.Root(), tree.Root()) {
+			t.Error("ParallelTree root does not match Tree root", i)
+		}
+	}
+	if !bytes.Equal(pt.Root(), tree.Root()) {
+		t.Error("ParallelTree root does not match Tree root after calling Root")
+	}
+}
+
+// TestParallelTreeReadAll checks that ReadAll matches ReaderRoot, and that
+// SetIndex is rejected on a non-empty tree.
+func TestParallelTreeReadAll(t *testing.T) {
+	data := fastrand.Bytes(64*(3<<parallelBatchHeight) + 10)
+	root, err := ReaderRoot(bytes.NewReader(data), sha256.New(), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := NewParallel(sha256.New, 4)
+	if err := pt.ReadAll(bytes.NewReader(data), 64); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt.Root(), root) {
+		t.Error("ParallelTree.ReadAll root does not match ReaderRoot")
+	}
+	if pt.SetIndex(0) == nil {
+		t.Error("able to call SetIndex on a non-empty ParallelTree")
+	}
+}
+
+// benchmarkParallelTree pushes 4MB of data in segments of 'segmentSize' into
+// a ParallelTree with GOMAXPROCS workers.
+func benchmarkParallelTree(b *testing.B, segmentSize int) {
+	data := fastrand.Bytes(1 << 22)
+	b.SetBytes(1 << 22)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pt := NewParallel(sha256.New, 0)
+		for j := 0; j < len(data); j += segmentSize {
+			pt.Push(data[j : j+segmentSize])
+		}
+		_ = pt.Root()
+	}
+}
+
+// BenchmarkParallelTree64_4MB creates a ParallelTree from 4MB of data split
+// into 64 byte segments.
+func BenchmarkParallelTree64_4MB(b *testing.B) { benchmarkParallelTree(b, 64) }
+
+// BenchmarkParallelTree4k_4MB creates a ParallelTree from 4MB of data split
+// into 4096 byte segments.
+func BenchmarkParallelTree4k_4MB(b *testing.B) { benchmarkParallelTree(b, 4096) }
@@ -0,0 +1,56 @@
+package merkletree
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// A SiblingSide tells whether a proof element is the left or the right
+// sibling of the node built from the elements before it.
+type SiblingSide int
+
+// The sides that a sibling can be on.
+const (
+	SiblingLeft SiblingSide = iota
+	SiblingRight
+)
+
+// A NodePosition locates a node of a Merkle tree. Index is the position of the
+// node within its level, counting from the left, and Height is the level,
+// where leaves have height 0. A node at height h covers the leaves
+// [Index*2^h, min((Index+1)*2^h, numLeaves)), so a node made of orphans sits
+// at the lowest height that covers all of its leaves, the same as in the
+// levels returned by BuildLevelsFromLeaves.
+type NodePosition struct {
+	Height int
+	Index  uint64
+	Side   SiblingSide
+}
+
+// ProofPositions returns the position of every sibling in the proof of the
+// leaves [proofBegin, proofEnd) in a tree with 'numLeaves' leaves, in the
+// order that Prove returns them. positions[i] is the position of proofSet[i+1],
+// since the first element of a proof set is the leaf data. Only proofs of a
+// single leaf are supported, so 'proofEnd' must be 'proofBegin'+1.
+func ProofPositions(proofBegin, proofEnd, numLeaves uint64) ([]NodePosition, error) {
+	if proofEnd != proofBegin+1 {
+		return nil, errors.New("only proofs of a single leaf are supported")
+	}
+	if proofBegin >= numLeaves {
+		return nil, ErrProofIndexOutOfRange
+	}
+	var positions []NodePosition
+	walkProofNodes(proofBegin, numLeaves, func(r nodeRange, _ proofNodeKind) {
+		height := bits.Len64(r.end - r.start - 1)
+		pos := NodePosition{
+			Height: height,
+			Index:  r.start >> uint(height),
+			Side:   SiblingRight,
+		}
+		if r.end <= proofBegin {
+			pos.Side = SiblingLeft
+		}
+		positions = append(positions, pos)
+	})
+	return positions, nil
+}
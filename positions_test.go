@@ -0,0 +1,68 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// TestProofPositions checks, for every proof of trees up to 'max' leaves,
+// that the nodes at the reported positions of a fully built tree match the
+// proof elements, and that the sides match the ones VerifyProof uses.
+func TestProofPositions(t *testing.T) {
+	max := uint64(130)
+	if testing.Short() {
+		max = 40
+	}
+	for numLeaves := uint64(1); numLeaves < max; numLeaves++ {
+		var leafHashes [][]byte
+		for i := uint64(0); i < numLeaves; i++ {
+			leafHashes = append(leafHashes, leafSum(sha256.New(), []byte{byte(i), byte(i >> 8)}))
+		}
+		levels, err := BuildLevelsFromLeaves(sha256.New, leafHashes, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for index := uint64(0); index < numLeaves; index++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(index); err != nil {
+				t.Fatal(err)
+			}
+			for i := uint64(0); i < numLeaves; i++ {
+				tree.Push([]byte{byte(i), byte(i >> 8)})
+			}
+			_, proofSet, _, _ := tree.Prove()
+
+			positions, err := ProofPositions(index, index+1, numLeaves)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sides, ok := proofSides(len(proofSet), index, numLeaves)
+			if !ok || len(positions) != len(proofSet)-1 || len(sides) != len(positions) {
+				t.Fatal("wrong number of positions", numLeaves, index)
+			}
+			for i, pos := range positions {
+				if pos.Height >= len(levels) || pos.Index >= uint64(len(levels[pos.Height])) {
+					t.Fatal("position is outside of the tree", numLeaves, index, i, pos)
+				}
+				if !bytes.Equal(levels[pos.Height][pos.Index], proofSet[i+1]) {
+					t.Error("node at position does not match the proof element", numLeaves, index, i, pos)
+				}
+				if (pos.Side == SiblingLeft) != sides[i] {
+					t.Error("position has the wrong side", numLeaves, index, i, pos)
+				}
+			}
+		}
+	}
+
+	if _, err := ProofPositions(3, 3, 10); err == nil {
+		t.Error("accepted an empty range")
+	}
+	if _, err := ProofPositions(3, 5, 10); err == nil {
+		t.Error("accepted a range of more than one leaf")
+	}
+	if _, err := ProofPositions(10, 11, 10); err != ErrProofIndexOutOfRange {
+		t.Error("expected ErrProofIndexOutOfRange, got", err)
+	}
+}
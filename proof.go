@@ -0,0 +1,64 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// proofVersionSingle is the one byte header that starts the encodings of a
+// Proof written by WriteProof, EncodeProofHex and PackProof, identifying both
+// the version of the encoding and the kind of proof. Header 0 is never used,
+// so that zeroed bytes are not mistaken for a proof. Encodings without a
+// header are not supported. The protobuf messages of package proofpb carry no
+// header: they are versioned by their schema instead.
+const proofVersionSingle = 1
+
+// ErrUnknownProofVersion is returned when decoding a proof whose header is
+// not the header of the proof type being decoded, either because it was
+// written by a newer version of the package, is a different kind of proof, or
+// has no header at all.
+var ErrUnknownProofVersion = errors.New("unknown proof version")
+
+// A Proof is a self-contained proof that the leaf at Index is an element of the
+// Merkle tree with root Root and NumLeaves leaves. Set is the proof set
+// returned by Tree.Prove, whose first element is the data of the leaf.
+type Proof struct {
+	Root      []byte
+	Set       [][]byte
+	Index     uint64
+	NumLeaves uint64
+}
+
+// Verify returns true if the proof is valid, using 'h' for hashing. It is
+// equivalent to calling VerifyProof with the fields of the proof.
+func (p Proof) Verify(h hash.Hash) bool {
+	return VerifyProof(h, p.Root, p.Set, p.Index, p.NumLeaves)
+}
+
+// ProofLeafSum returns the leaf sum of the leaf data at the start of the
+// proof set, which is the sum of the proven leaf in the tree. The proof is not
+// verified. An error is returned if the proof set is empty.
+func ProofLeafSum(h hash.Hash, p Proof) ([]byte, error) {
+	if len(p.Set) == 0 {
+		return nil, errors.New("proof set is empty")
+	}
+	return leafSum(h, p.Set[0]), nil
+}
+
+// ProofContains returns true if the proof set starts with 'data', or with its
+// leaf sum, as computed by LeafSum with 'h', which is how a proof made after
+// ProveLeafHashOnly starts. The proof is not verified, so the result only
+// says what the proof claims; use Verify or VerifyProofWithLeafHash to check
+// the claim against the root. A Proof does not record which of the two forms
+// it holds, so if leaves may themselves be leaf sums, the caller should
+// compare the first element of the proof set directly.
+func ProofContains(h hash.Hash, p Proof, data []byte) bool {
+	if len(p.Set) == 0 {
+		return false
+	}
+	if bytes.Equal(p.Set[0], data) {
+		return true
+	}
+	return len(p.Set[0]) == h.Size() && bytes.Equal(p.Set[0], leafSum(h, data))
+}
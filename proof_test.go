@@ -0,0 +1,119 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// TestProofVerify checks that Proof.Verify agrees with VerifyProof.
+func TestProofVerify(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	p := Proof{
+		Root:      mt.roots[15],
+		Set:       mt.proofSets[15][10],
+		Index:     10,
+		NumLeaves: 15,
+	}
+	if !p.Verify(sha256.New()) {
+		t.Error("valid proof did not verify")
+	}
+	p.Index = 11
+	if p.Verify(sha256.New()) {
+		t.Error("proof verified for the wrong index")
+	}
+}
+
+// TestProofVersion checks that every proof encoding starts with the version
+// header, and that data with any other header, or with no header, is rejected
+// with ErrUnknownProofVersion.
+func TestProofVersion(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	p := Proof{Root: mt.roots[15], Set: mt.proofSets[15][10], Index: 10, NumLeaves: 15}
+
+	var buf bytes.Buffer
+	if err := WriteProof(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+	text := EncodeProofHex(p)
+	if stream[0] != proofVersionSingle || !strings.HasPrefix(text, "01:") {
+		t.Fatal("encodings do not start with the version header")
+	}
+
+	for _, version := range []byte{0, proofVersionSingle + 1, 255} {
+		bad := append([]byte{version}, stream[1:]...)
+		if _, err := ReadProof(bytes.NewReader(bad), 64, 64); err != ErrUnknownProofVersion {
+			t.Error("expected ErrUnknownProofVersion for header", version, err)
+		}
+		badText := hex.EncodeToString([]byte{version}) + text[2:]
+		if _, err := DecodeProofHex(badText); err != ErrUnknownProofVersion {
+			t.Error("expected ErrUnknownProofVersion for header", version, err)
+		}
+	}
+
+	// Encodings without a header are unsupported, and one encoding can't be
+	// decoded as the other.
+	if _, err := ReadProof(bytes.NewReader(stream[1:]), 64, 64); err != ErrUnknownProofVersion {
+		t.Error("expected ErrUnknownProofVersion for a stream without a header, got", err)
+	}
+	if _, err := DecodeProofHex(text[3:]); err != ErrUnknownProofVersion {
+		t.Error("expected ErrUnknownProofVersion for a string without a header, got", err)
+	}
+	if _, err := ReadProof(strings.NewReader(text), 64, 64); err != ErrUnknownProofVersion {
+		t.Error("expected ErrUnknownProofVersion when reading a hex proof as a stream, got", err)
+	}
+	if _, err := DecodeProofHex(string(stream)); err != ErrUnknownProofVersion {
+		t.Error("expected ErrUnknownProofVersion when decoding a stream as hex, got", err)
+	}
+}
+
+// TestProofLeafSum checks ProofLeafSum and ProofContains with matching and
+// mismatched data, and ProofContains with a proof that starts with a leaf sum.
+func TestProofLeafSum(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	for numLeaves, proofSets := range mt.proofSets {
+		for proofIndex, proofSet := range proofSets {
+			p := Proof{Root: mt.roots[numLeaves], Set: proofSet, Index: uint64(proofIndex), NumLeaves: uint64(numLeaves)}
+			sum, err := ProofLeafSum(sha256.New(), p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sum, mt.leaves[proofIndex]) {
+				t.Error("wrong leaf sum", numLeaves, proofIndex)
+			}
+			if !ProofContains(sha256.New(), p, mt.data[proofIndex]) {
+				t.Error("proof does not contain its leaf data", numLeaves, proofIndex)
+			}
+			if ProofContains(sha256.New(), p, mt.data[proofIndex+1]) || ProofContains(sha256.New(), p, mt.data[proofIndex][1:]) || ProofContains(sha256.New(), p, nil) {
+				t.Error("proof contains the wrong data", numLeaves, proofIndex)
+			}
+		}
+	}
+	if _, err := ProofLeafSum(sha256.New(), Proof{}); err == nil {
+		t.Error("empty proof has a leaf sum")
+	}
+	if ProofContains(sha256.New(), Proof{}, nil) {
+		t.Error("empty proof contains data")
+	}
+
+	// A proof that starts with the leaf sum contains the data of the leaf.
+	tree := New(sha256.New())
+	if err := tree.SetIndex(2); err != nil {
+		t.Fatal(err)
+	}
+	tree.ProveLeafHashOnly()
+	for i := 0; i < 5; i++ {
+		tree.Push(mt.data[i])
+	}
+	root, set, index, numLeaves := tree.Prove()
+	p := Proof{Root: root, Set: set, Index: index, NumLeaves: numLeaves}
+	if !ProofContains(sha256.New(), p, mt.data[2]) {
+		t.Error("leaf hash proof does not contain its leaf data")
+	}
+	if ProofContains(sha256.New(), p, mt.data[3]) {
+		t.Error("leaf hash proof contains the wrong data")
+	}
+}
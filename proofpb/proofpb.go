@@ -0,0 +1,214 @@
+// Package proofpb implements the protocol buffers encoding of merkletree
+// proofs defined in proof.proto. The encoding is implemented by hand so that
+// neither this package nor the merkletree package depend on a protobuf
+// runtime, but it is wire-compatible with code generated from proof.proto.
+package proofpb
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/NebulousLabs/merkletree"
+)
+
+// The field numbers of the Proof message. These must match proof.proto and
+// must never change.
+const (
+	fieldRoot      = 1
+	fieldSet       = 2
+	fieldBegin     = 3
+	fieldEnd       = 4
+	fieldNumLeaves = 5
+	fieldHashName  = 6
+)
+
+// The protobuf wire types used by the Proof message.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+// HashSizes contains the names of the hash functions that may appear in the
+// HashName field of a Proof, along with the size of their output.
+var HashSizes = map[string]int{
+	"sha256":      32,
+	"sha512":      64,
+	"blake2b-256": 32,
+}
+
+// Proof is the Go representation of the Proof message in proof.proto.
+type Proof struct {
+	Root      []byte
+	Set       [][]byte
+	Begin     uint64
+	End       uint64
+	NumLeaves uint64
+	HashName  string
+}
+
+// appendTag appends the key of a field to 'b'.
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+// appendBytes appends a length-delimited field to 'b'.
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendVarint appends a varint field to 'b'.
+func appendVarint(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+// Marshal encodes the proof using the protobuf wire format. As in proto3,
+// singular fields holding their zero value are omitted.
+func (m *Proof) Marshal() []byte {
+	var b []byte
+	if len(m.Root) > 0 {
+		b = appendBytes(b, fieldRoot, m.Root)
+	}
+	for _, elem := range m.Set {
+		b = appendBytes(b, fieldSet, elem)
+	}
+	if m.Begin != 0 {
+		b = appendVarint(b, fieldBegin, m.Begin)
+	}
+	if m.End != 0 {
+		b = appendVarint(b, fieldEnd, m.End)
+	}
+	if m.NumLeaves != 0 {
+		b = appendVarint(b, fieldNumLeaves, m.NumLeaves)
+	}
+	if m.HashName != "" {
+		b = appendBytes(b, fieldHashName, []byte(m.HashName))
+	}
+	return b
+}
+
+// Unmarshal decodes a proof encoded using the protobuf wire format,
+// overwriting the contents of 'm'. Unknown fields are skipped.
+func (m *Proof) Unmarshal(b []byte) error {
+	*m = Proof{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		b = b[n:]
+		field, wireType := int(key>>3), int(key&7)
+
+		switch wireType {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("invalid varint")
+			}
+			b = b[n:]
+			switch field {
+			case fieldBegin:
+				m.Begin = v
+			case fieldEnd:
+				m.End = v
+			case fieldNumLeaves:
+				m.NumLeaves = v
+			}
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errors.New("invalid length-delimited field")
+			}
+			v := append([]byte{}, b[n:n+int(l)]...)
+			b = b[n+int(l):]
+			switch field {
+			case fieldRoot:
+				m.Root = v
+			case fieldSet:
+				m.Set = append(m.Set, v)
+			case fieldHashName:
+				m.HashName = string(v)
+			}
+		case wireI64:
+			if len(b) < 8 {
+				return errors.New("invalid fixed64 field")
+			}
+			b = b[8:]
+		case wireI32:
+			if len(b) < 4 {
+				return errors.New("invalid fixed32 field")
+			}
+			b = b[4:]
+		default:
+			return errors.New("unsupported wire type")
+		}
+	}
+	return nil
+}
+
+// validate checks that the hash name is known and that the root and every
+// hash in the proof set have the size of the hash's output. The first element
+// of the proof set is leaf data, and may have any size.
+func validate(root []byte, set [][]byte, hashName string) error {
+	size, known := HashSizes[hashName]
+	if !known {
+		return errors.New("unknown hash name")
+	}
+	if len(root) != size {
+		return errors.New("root has the wrong size for the hash")
+	}
+	if len(set) == 0 {
+		return errors.New("proof set is empty")
+	}
+	for _, elem := range set[1:] {
+		if len(elem) != size {
+			return errors.New("proof set element has the wrong size for the hash")
+		}
+	}
+	return nil
+}
+
+// ToProto converts a proof into its protobuf representation. 'hashName' names
+// the hash function that was used to build the tree, and must be a key of
+// HashSizes.
+func ToProto(p merkletree.Proof, hashName string) (*Proof, error) {
+	if err := validate(p.Root, p.Set, hashName); err != nil {
+		return nil, err
+	}
+	if p.Index >= p.NumLeaves {
+		return nil, errors.New("proof index is outside of the tree")
+	}
+	return &Proof{
+		Root:      p.Root,
+		Set:       p.Set,
+		Begin:     p.Index,
+		End:       p.Index + 1,
+		NumLeaves: p.NumLeaves,
+		HashName:  hashName,
+	}, nil
+}
+
+// FromProto converts the protobuf representation of a proof back into a
+// proof, validating the hash name, the sizes of the elements, and the proof
+// range.
+func FromProto(m *Proof) (merkletree.Proof, error) {
+	if err := validate(m.Root, m.Set, m.HashName); err != nil {
+		return merkletree.Proof{}, err
+	}
+	if m.End != m.Begin+1 {
+		return merkletree.Proof{}, errors.New("only proofs of a single leaf are supported")
+	}
+	if m.Begin >= m.NumLeaves {
+		return merkletree.Proof{}, errors.New("proof index is outside of the tree")
+	}
+	return merkletree.Proof{
+		Root:      m.Root,
+		Set:       m.Set,
+		Index:     m.Begin,
+		NumLeaves: m.NumLeaves,
+	}, nil
+}
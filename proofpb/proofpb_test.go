@@ -0,0 +1,173 @@
+package proofpb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/NebulousLabs/merkletree"
+)
+
+// buildProof builds a proof for leaf 'index' of a tree with 'numLeaves'
+// leaves.
+func buildProof(t *testing.T, index, numLeaves uint64) merkletree.Proof {
+	tree := merkletree.New(sha256.New())
+	if err := tree.SetIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < numLeaves; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	root, set, _, _ := tree.Prove()
+	return merkletree.Proof{
+		Root:      root,
+		Set:       set,
+		Index:     index,
+		NumLeaves: numLeaves,
+	}
+}
+
+// TestProtoRoundTrip converts proofs to their protobuf representation,
+// encodes and decodes them, and converts them back.
+func TestProtoRoundTrip(t *testing.T) {
+	for numLeaves := uint64(1); numLeaves < 20; numLeaves++ {
+		for index := uint64(0); index < numLeaves; index++ {
+			p := buildProof(t, index, numLeaves)
+			m, err := ToProto(p, "sha256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m2 Proof
+			if err := m2.Unmarshal(m.Marshal()); err != nil {
+				t.Fatal(err)
+			}
+			p2, err := FromProto(&m2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p2.Root, p.Root) || p2.Index != p.Index || p2.NumLeaves != p.NumLeaves || len(p2.Set) != len(p.Set) {
+				t.Fatal("round trip changed the proof", numLeaves, index)
+			}
+			for i := range p.Set {
+				if !bytes.Equal(p.Set[i], p2.Set[i]) {
+					t.Error("round trip changed the proof set", numLeaves, index, i)
+				}
+			}
+			if !p2.Verify(sha256.New()) {
+				t.Error("round tripped proof does not verify", numLeaves, index)
+			}
+		}
+	}
+}
+
+// TestProtoFieldNumbers checks that the wire field numbers of the Proof
+// message never change, both in the encoding and in proof.proto.
+func TestProtoFieldNumbers(t *testing.T) {
+	m := &Proof{
+		Root:      []byte{1},
+		Set:       [][]byte{{2}},
+		Begin:     3,
+		End:       4,
+		NumLeaves: 5,
+		HashName:  "x",
+	}
+	expected := []byte{
+		0x0a, 1, 1, // root = 1, bytes
+		0x12, 1, 2, // set = 2, bytes
+		0x18, 3, // begin = 3, varint
+		0x20, 4, // end = 4, varint
+		0x28, 5, // num_leaves = 5, varint
+		0x32, 1, 'x', // hash_name = 6, bytes
+	}
+	if !bytes.Equal(m.Marshal(), expected) {
+		t.Errorf("wire encoding changed: %x", m.Marshal())
+	}
+
+	definition, err := ioutil.ReadFile("proof.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{
+		"bytes root = 1;",
+		"repeated bytes set = 2;",
+		"uint64 begin = 3;",
+		"uint64 end = 4;",
+		"uint64 num_leaves = 5;",
+		"string hash_name = 6;",
+	} {
+		if !strings.Contains(string(definition), field) {
+			t.Error("proof.proto is missing field", field)
+		}
+	}
+}
+
+// TestProtoUnknownFields checks that unknown fields of every wire type are
+// skipped, and that truncated messages are rejected.
+func TestProtoUnknownFields(t *testing.T) {
+	m := &Proof{Root: []byte{1, 2, 3}, NumLeaves: 7}
+	b := m.Marshal()
+	b = append(b, 0x38, 0x01)                   // field 7, varint
+	b = append(b, 0x41, 1, 2, 3, 4, 5, 6, 7, 8) // field 8, fixed64
+	b = append(b, 0x4d, 1, 2, 3, 4)             // field 9, fixed32
+	b = append(b, 0x52, 2, 9, 9)                // field 10, bytes
+	var m2 Proof
+	if err := m2.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m2.Root, m.Root) || m2.NumLeaves != 7 {
+		t.Error("unknown fields corrupted the message")
+	}
+
+	// Cut the message in the middle of a field key, a length-delimited field,
+	// a varint, and each fixed size field.
+	for _, truncated := range [][]byte{
+		{0x8a},
+		{0x0a, 3, 1, 2},
+		{0x28, 0x80},
+		{0x41, 1, 2, 3},
+		{0x4d, 1},
+	} {
+		if err := m2.Unmarshal(truncated); err == nil {
+			t.Errorf("truncated message %x decoded without error", truncated)
+		}
+	}
+}
+
+// TestProtoValidation checks that proofs with unknown hashes, wrong element
+// sizes or invalid ranges are rejected.
+func TestProtoValidation(t *testing.T) {
+	p := buildProof(t, 2, 5)
+	if _, err := ToProto(p, "md5"); err == nil {
+		t.Error("able to convert a proof with an unknown hash")
+	}
+	if _, err := ToProto(p, "sha512"); err == nil {
+		t.Error("able to convert a proof with the wrong hash size")
+	}
+
+	m, err := ToProto(p, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := *m
+	bad.End = bad.Begin + 2
+	if _, err := FromProto(&bad); err == nil {
+		t.Error("able to convert a slice proof")
+	}
+	bad = *m
+	bad.Begin, bad.End = 5, 6
+	if _, err := FromProto(&bad); err == nil {
+		t.Error("able to convert a proof outside of the tree")
+	}
+	bad = *m
+	bad.Set = [][]byte{m.Set[0], m.Set[1][:31]}
+	if _, err := FromProto(&bad); err == nil {
+		t.Error("able to convert a proof with a short element")
+	}
+	bad = *m
+	bad.Set = nil
+	if _, err := FromProto(&bad); err == nil {
+		t.Error("able to convert a proof with an empty set")
+	}
+}
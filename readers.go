@@ -1,16 +1,141 @@
 package merkletree
 
 import (
+	"bytes"
 	"errors"
 	"hash"
 	"io"
+	"math"
+	"strconv"
 )
 
+// readAllBatchSize is the number of segments that ReadAll hashes at once when
+// the Tree's hash is a BatchHasher.
+const readAllBatchSize = 64
+
 // ReadAll will read segments of size 'segmentSize' and push them into the tree
 // until EOF is reached. Success will return 'err == nil', not 'err == EOF'. No
 // padding is added to the data, so the last element may be smaller than
-// 'segmentSize'.
+// 'segmentSize'. A segment is never empty, so data whose size is a multiple
+// of 'segmentSize' does not end with an empty leaf, and empty data pushes no
+// leaves at all. If the Tree's hash is a BatchHasher, the leaf sums of the
+// segments are computed in batches. If the Tree requires a complete tree,
+// ErrIncompleteTree is returned when it isn't one after reading.
 func (t *Tree) ReadAll(r io.Reader, segmentSize int) error {
+	err := t.pushSegments(func(push func([]byte)) error {
+		return readSegments(r, segmentSize, push)
+	})
+	if err != nil {
+		return err
+	}
+	return t.checkComplete()
+}
+
+// pushSegments pushes every segment that 'produce' passes to 'push' into the
+// tree. If the Tree's hash is a BatchHasher, the leaf sums of the segments
+// are computed in batches.
+func (t *Tree) pushSegments(produce func(push func([]byte)) error) error {
+	bh, ok := t.hash.(BatchHasher)
+	if !ok || t.cachedTree || t.leafCount != nil || t.indexedLeaves || t.fromEnd != nil {
+		return produce(t.Push)
+	}
+	batch := make([][]byte, 0, readAllBatchSize)
+	err := produce(func(segment []byte) {
+		batch = append(batch, segment)
+		if len(batch) == readAllBatchSize {
+			t.pushBatch(bh, batch)
+			batch = batch[:0]
+		}
+	})
+	t.pushBatch(bh, batch)
+	return err
+}
+
+// ReadAllFunc is the same as ReadAll, but also calls 'fn' with the root of
+// every run of 2^height leaves read from 'r', in order, as soon as the run is
+// complete. 'index' is the position of the run, counting runs of 2^height
+// leaves from the start of the tree. If the data ends in the middle of a run,
+// 'fn' is called with the root of the partial run after the last segment is
+// read. With a height of 0, 'fn' is called with the leaf sum of every segment.
+// The roots passed to 'fn' may be retained. Pushing the roots into a
+// CachedTree of the same height produces the same root as the Tree.
+//
+// The Tree must hold a multiple of 2^height leaves when ReadAllFunc is called,
+// so that the runs are aligned with the cached nodes of the tree.
+func (t *Tree) ReadAllFunc(r io.Reader, segmentSize int, height int, fn func(index uint64, root []byte)) error {
+	if height < 0 || height > MaxHeight || t.currentIndex%(1<<uint(height)) != 0 {
+		return errors.New("tree does not hold a whole number of runs of 2^height leaves")
+	}
+	t.subtreeHook, t.subtreeHookHeight = fn, height
+	err := t.ReadAll(r, segmentSize)
+	t.subtreeHook = nil
+	if err != nil {
+		return err
+	}
+
+	// The subtrees at the end of the stack that are smaller than a run make
+	// up the partial run, and are combined the same way that Root combines
+	// them.
+	i := len(t.stack) - 1
+	if i < 0 || t.stack[i].height >= height {
+		return nil
+	}
+	root := t.stack[i].sum
+	for i--; i >= 0 && t.stack[i].height < height; i-- {
+		t.countNode(t.stack[i].sum, root)
+		root = nodeSum(t.hash, t.stack[i].sum, root)
+	}
+	fn(t.currentIndex>>uint(height), append([]byte(nil), root...))
+	return nil
+}
+
+// pushBatch pushes a batch of leaves into the tree, computing their leaf sums
+// with a single call to SumBatch. The leaf at the proof index is pushed with
+// Push instead, so that its data is added to the proof set, and is left out of
+// the batch so that it is neither hashed nor counted twice.
+func (t *Tree) pushBatch(bh BatchHasher, leaves [][]byte) {
+	if len(leaves) == 0 {
+		return
+	}
+	proofLeaf := -1
+	if t.proofTree && t.proofIndex >= t.currentIndex && t.proofIndex-t.currentIndex < uint64(len(leaves)) {
+		proofLeaf = int(t.proofIndex - t.currentIndex)
+	}
+	inputs := leaves
+	if proofLeaf >= 0 {
+		inputs = make([][]byte, 0, len(leaves)-1)
+		inputs = append(inputs, leaves[:proofLeaf]...)
+		inputs = append(inputs, leaves[proofLeaf+1:]...)
+	}
+	var sums [][]byte
+	if len(inputs) > 0 {
+		sums = bh.SumBatch(leafHashPrefix[0], inputs)
+	}
+	for _, leaf := range inputs {
+		t.countLeaf(leaf)
+	}
+	for i, leaf := range leaves {
+		if i == proofLeaf {
+			t.Push(leaf)
+			continue
+		}
+		hashed := sums[0]
+		sums = sums[1:]
+		if t.hooks.OnLeaf != nil {
+			t.hooks.OnLeaf(t.currentIndex, hashed)
+		}
+		if err := t.PushSubTree(0, hashed); err != nil {
+			// A single leaf that is not at the proof index can always be
+			// pushed as a subtree.
+			panic(err)
+		}
+	}
+}
+
+// readSegments reads segments of size 'segmentSize' from 'r' and passes each
+// of them to 'push' until EOF is reached. Every segment is freshly allocated,
+// so 'push' may retain it.
+func readSegments(r io.Reader, segmentSize int, push func([]byte)) error {
 	for {
 		segment := make([]byte, segmentSize)
 		n, readErr := io.ReadFull(r, segment)
@@ -24,7 +149,92 @@ func (t *Tree) ReadAll(r io.Reader, segmentSize int) error {
 		} else if readErr != nil {
 			return readErr
 		}
-		t.Push(segment)
+		push(segment)
+	}
+	return nil
+}
+
+// ErrProofLeafStreamed is returned by PushReader when the leaf it would push
+// is the leaf at the proof index, or may become it because the Tree proves a
+// leaf from the end. A proof set starts with the data of the
+// proven leaf, which PushReader does not keep.
+var ErrProofLeafStreamed = errors.New("can't stream the leaf at the proof index")
+
+// PushReader pushes all of the data in 'r' into the tree as a single leaf,
+// streaming it through the hash rather than holding it in memory. The leaf
+// sum is the same as the one Push computes for the same data. If 'r' returns
+// an error other than io.EOF, the error is returned and the Tree is not
+// modified. PushReader returns ErrProofLeafStreamed without reading 'r' if
+// the leaf is the leaf at the proof index; that leaf must be pushed with Push
+// so that its data can be added to the proof set. A CachedTree can't stream
+// its cached node roots, so PushReader returns an error for one.
+func (t *Tree) PushReader(r io.Reader) error {
+	if t.cachedTree {
+		return errors.New("can't stream a leaf into a cached tree")
+	}
+	if (t.proofTree && t.currentIndex == t.proofIndex) || t.fromEnd != nil {
+		return ErrProofLeafStreamed
+	}
+	t.hash.Reset()
+	t.hash.Write(leafHashPrefix)
+	if t.indexedLeaves {
+		t.hash.Write(leafIndexBytes(t.currentIndex))
+	}
+	n, err := io.Copy(t.hash, r)
+	if err != nil {
+		return err
+	}
+	t.countLeaf(nil)
+	t.stats.BytesHashed += uint64(n)
+	leaf := t.hash.Sum(nil)
+	if t.hooks.OnLeaf != nil {
+		t.hooks.OnLeaf(t.currentIndex, leaf)
+	}
+	if err := t.PushSubTree(0, leaf); err != nil {
+		// A single leaf that is not at the proof index can always be pushed
+		// as a subtree.
+		panic(err)
+	}
+	return nil
+}
+
+// A SizeMismatchError is returned when a reader does not hold the number of
+// bytes that it was expected to hold. If the reader held too many bytes, only
+// one byte past the expected size is read, so Actual is Expected+1 and the
+// reader may hold more.
+type SizeMismatchError struct {
+	Expected int64
+	Actual   int64
+}
+
+// Error implements the error interface.
+func (e *SizeMismatchError) Error() string {
+	if e.Actual > e.Expected {
+		return "reader holds more than the expected " + strconv.FormatInt(e.Expected, 10) + " bytes"
+	}
+	return "reader holds " + strconv.FormatInt(e.Actual, 10) + " bytes, expected " + strconv.FormatInt(e.Expected, 10)
+}
+
+// ReadAllExpected is the same as ReadAll, but returns a *SizeMismatchError if
+// 'r' does not hold exactly 'expectedBytes' bytes. Only the expected bytes are
+// pushed into the tree. To detect a reader that holds too many bytes, one
+// byte past the expected size is read from 'r' after the expected bytes.
+func (t *Tree) ReadAllExpected(r io.Reader, segmentSize int, expectedBytes int64) error {
+	if expectedBytes < 0 {
+		return errors.New("expected size can't be negative")
+	}
+	lr := &io.LimitedReader{R: r, N: expectedBytes}
+	if err := t.ReadAll(lr, segmentSize); err != nil {
+		return err
+	}
+	if lr.N > 0 {
+		return &SizeMismatchError{Expected: expectedBytes, Actual: expectedBytes - lr.N}
+	}
+	n, err := io.ReadFull(r, make([]byte, 1))
+	if n > 0 {
+		return &SizeMismatchError{Expected: expectedBytes, Actual: expectedBytes + 1}
+	} else if err != io.EOF {
+		return err
 	}
 	return nil
 }
@@ -32,23 +242,172 @@ func (t *Tree) ReadAll(r io.Reader, segmentSize int) error {
 // ReaderRoot returns the Merkle root of the data read from the reader, where
 // each leaf is 'segmentSize' long and 'h' is used as the hashing function. All
 // leaves will be 'segmentSize' bytes except the last leaf, which will not be
-// padded out if there are not enough bytes remaining in the reader.
+// padded out if there are not enough bytes remaining in the reader. If 'h' is
+// a BatchHasher, the leaf sums of the segments are computed in batches.
 func ReaderRoot(r io.Reader, h hash.Hash, segmentSize int) (root []byte, err error) {
+	s := NewStack(h)
+	bh, ok := h.(BatchHasher)
+	if !ok {
+		err = readSegments(r, segmentSize, func(segment []byte) {
+			s.AppendLeafHash(leafSum(h, segment))
+		})
+		if err != nil {
+			return
+		}
+		return s.Root(), nil
+	}
+
+	batch := make([][]byte, 0, readAllBatchSize)
+	appendBatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for _, sum := range bh.SumBatch(leafHashPrefix[0], batch) {
+			s.AppendLeafHash(sum)
+		}
+		batch = batch[:0]
+	}
+	err = readSegments(r, segmentSize, func(segment []byte) {
+		batch = append(batch, segment)
+		if len(batch) == readAllBatchSize {
+			appendBatch()
+		}
+	})
+	if err != nil {
+		return
+	}
+	appendBatch()
+	return s.Root(), nil
+}
+
+// ReaderRootStats is the same as ReaderRoot, but also returns the hashing
+// that computing the root took.
+func ReaderRootStats(r io.Reader, h hash.Hash, segmentSize int) (root []byte, stats TreeStats, err error) {
 	tree := New(h)
 	err = tree.ReadAll(r, segmentSize)
 	if err != nil {
 		return
 	}
 	root = tree.Root()
+	return root, tree.Stats(), nil
+}
+
+// ReaderRootComplete is the same as ReaderRoot, but returns
+// ErrIncompleteTree if the data does not make a power of two leaves.
+func ReaderRootComplete(r io.Reader, h hash.Hash, segmentSize int) (root []byte, err error) {
+	tree := New(h)
+	tree.RequireCompleteTree()
+	err = tree.ReadAll(r, segmentSize)
+	if err != nil {
+		return
+	}
+	root = tree.Root()
+	return
+}
+
+// ReaderRootFunc is the same as ReaderRoot, but uses ReadAllFunc to call 'fn'
+// with the root of every run of 2^height leaves.
+func ReaderRootFunc(r io.Reader, h hash.Hash, segmentSize int, height int, fn func(index uint64, root []byte)) (root []byte, err error) {
+	tree := New(h)
+	err = tree.ReadAllFunc(r, segmentSize, height, fn)
+	if err != nil {
+		return
+	}
+	root = tree.Root()
+	return
+}
+
+// ReaderRootExpected is the same as ReaderRoot, but uses ReadAllExpected to
+// check that the reader holds exactly 'expectedBytes' bytes.
+func ReaderRootExpected(r io.Reader, h hash.Hash, segmentSize int, expectedBytes int64) (root []byte, err error) {
+	tree := New(h)
+	err = tree.ReadAllExpected(r, segmentSize, expectedBytes)
+	if err != nil {
+		return
+	}
+	root = tree.Root()
 	return
 }
 
+// VerifySubtreeRoot reads the data of a subtree from 'data', split into
+// leaves of 'segmentSize' bytes, and returns true if its root is
+// 'claimedRoot'. An error is returned if 'data' does not hold exactly
+// 'expectedLeaves' leaves, so that data which happens to have the right root
+// for a different number of leaves is never accepted. At most one leaf more
+// than expected is read from 'data'.
+//
+// The root of a subtree of 2^height leaves is the root of a cached node of
+// that height. The final cached node of a file may hold fewer leaves, and its
+// final segment may be short; for that node, 'expectedLeaves' is the number
+// of leaves that it holds, as returned by LeafRangeForBytes.
+func VerifySubtreeRoot(h hash.Hash, claimedRoot []byte, data io.Reader, segmentSize int, expectedLeaves uint64) (bool, error) {
+	if segmentSize <= 0 {
+		return false, errors.New("segment size must be positive")
+	}
+	if expectedLeaves == 0 || expectedLeaves >= uint64(math.MaxInt64/int64(segmentSize)) {
+		return false, errors.New("expected number of leaves is out of range")
+	}
+	tree := New(h)
+	lr := &io.LimitedReader{R: data, N: int64(expectedLeaves+1) * int64(segmentSize)}
+	if err := tree.ReadAll(lr, segmentSize); err != nil {
+		return false, err
+	}
+	if tree.CurrentIndex() != expectedLeaves {
+		if tree.CurrentIndex() > expectedLeaves {
+			return false, errors.New("data holds more than the expected " + strconv.FormatUint(expectedLeaves, 10) + " leaves")
+		}
+		return false, errors.New("data holds " + strconv.FormatUint(tree.CurrentIndex(), 10) + " leaves, expected " + strconv.FormatUint(expectedLeaves, 10))
+	}
+	return bytes.Equal(tree.Root(), claimedRoot), nil
+}
+
 // BuildReaderProof returns a proof that certain data is in the merkle tree
 // created by the data in the reader. The merkle root, set of proofs, and the
 // number of leaves in the Merkle tree are all returned. All leaves will we
 // 'segmentSize' bytes except the last leaf, which will not be padded out if
 // there are not enough bytes remaining in the reader.
 func BuildReaderProof(r io.Reader, h hash.Hash, segmentSize int, index uint64) (root []byte, proofSet [][]byte, numLeaves uint64, err error) {
+	return buildReaderProof(h, index, func(tree *Tree) error {
+		return tree.ReadAll(r, segmentSize)
+	})
+}
+
+// BuildReaderProofStats is the same as BuildReaderProof, but also returns the
+// hashing that building the proof took.
+func BuildReaderProofStats(r io.Reader, h hash.Hash, segmentSize int, index uint64) (root []byte, proofSet [][]byte, numLeaves uint64, stats TreeStats, err error) {
+	var tree *Tree
+	root, proofSet, numLeaves, err = buildReaderProof(h, index, func(t *Tree) error {
+		tree = t
+		return t.ReadAll(r, segmentSize)
+	})
+	return root, proofSet, numLeaves, tree.Stats(), err
+}
+
+// BuildReaderProofExpected is the same as BuildReaderProof, but uses
+// ReadAllExpected to check that the reader holds exactly 'expectedBytes'
+// bytes.
+func BuildReaderProofExpected(r io.Reader, h hash.Hash, segmentSize int, index uint64, expectedBytes int64) (root []byte, proofSet [][]byte, numLeaves uint64, err error) {
+	return buildReaderProof(h, index, func(tree *Tree) error {
+		return tree.ReadAllExpected(r, segmentSize, expectedBytes)
+	})
+}
+
+// BuildReaderProofAt is the same as BuildReaderProof, but reads the data from
+// the section [off, off+length) of 'r', so that the proof covers one object
+// stored inside a larger file. Nothing outside of the section is read. A
+// *SizeMismatchError is returned if 'r' ends before the end of the section.
+func BuildReaderProofAt(r io.ReaderAt, off, length int64, h hash.Hash, segmentSize int, index uint64) (root []byte, proofSet [][]byte, numLeaves uint64, err error) {
+	if off < 0 || length < 0 || off > math.MaxInt64-length {
+		return nil, nil, 0, errors.New("section is out of range")
+	}
+	return buildReaderProof(h, index, func(tree *Tree) error {
+		return tree.ReadAllExpected(io.NewSectionReader(r, off, length), segmentSize, length)
+	})
+}
+
+// buildReaderProof builds a proof of the leaf at 'index' of a tree filled by
+// 'readAll'.
+func buildReaderProof(h hash.Hash, index uint64, readAll func(*Tree) error) (root []byte, proofSet [][]byte, numLeaves uint64, err error) {
 	tree := New(h)
 	err = tree.SetIndex(index)
 	if err != nil {
@@ -57,7 +416,7 @@ func BuildReaderProof(r io.Reader, h hash.Hash, segmentSize int, index uint64) (
 		// point.
 		panic(err)
 	}
-	err = tree.ReadAll(r, segmentSize)
+	err = readAll(tree)
 	if err != nil {
 		return
 	}
@@ -3,7 +3,12 @@ package merkletree
 import (
 	"bytes"
 	"crypto/sha256"
+	"hash"
+	"math"
 	"testing"
+	"testing/iotest"
+
+	"github.com/NebulousLabs/fastrand"
 )
 
 // TestReaderRoot calls ReaderRoot on a manually crafted dataset
@@ -109,3 +114,272 @@ func TestEmptyReader(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// TestReadAllExpected checks ReadAllExpected, ReaderRootExpected and
+// BuildReaderProofExpected with readers that hold fewer, exactly as many, and
+// more bytes than expected.
+func TestReadAllExpected(t *testing.T) {
+	data := fastrand.Bytes(100)
+	root, err := ReaderRoot(bytes.NewReader(data), sha256.New(), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exact sizes, read through a reader that returns short reads.
+	tree := New(sha256.New())
+	if err := tree.ReadAllExpected(iotest.HalfReader(bytes.NewReader(data)), 8, 100); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.Root(), root) {
+		t.Error("ReadAllExpected produced the wrong root")
+	}
+	if r, err := ReaderRootExpected(bytes.NewReader(data), sha256.New(), 8, 100); err != nil || !bytes.Equal(r, root) {
+		t.Error("ReaderRootExpected produced the wrong root", err)
+	}
+	_, proofSet, numLeaves, err := BuildReaderProofExpected(bytes.NewReader(data), sha256.New(), 8, 3, 100)
+	if err != nil || !VerifyProof(sha256.New(), root, proofSet, 3, numLeaves) {
+		t.Error("BuildReaderProofExpected produced an invalid proof", err)
+	}
+	if err := New(sha256.New()).ReadAllExpected(new(bytes.Reader), 8, 0); err != nil {
+		t.Error("empty reader with an expected size of 0 was rejected:", err)
+	}
+
+	// Short and long readers.
+	for _, expected := range []int64{0, 1, 99, 101, 1000} {
+		err := New(sha256.New()).ReadAllExpected(bytes.NewReader(data), 8, expected)
+		sme, ok := err.(*SizeMismatchError)
+		if !ok {
+			t.Fatal("expected a SizeMismatchError, got", err)
+		}
+		actual := int64(len(data))
+		if expected < actual {
+			actual = expected + 1
+		}
+		if sme.Expected != expected || sme.Actual != actual {
+			t.Error("SizeMismatchError has the wrong sizes", expected, sme)
+		}
+		if _, err := ReaderRootExpected(bytes.NewReader(data), sha256.New(), 8, expected); err == nil {
+			t.Error("ReaderRootExpected accepted the wrong size", expected)
+		}
+		if _, _, _, err := BuildReaderProofExpected(bytes.NewReader(data), sha256.New(), 8, 0, expected); err == nil {
+			t.Error("BuildReaderProofExpected accepted the wrong size", expected)
+		}
+	}
+
+	// A long reader only has one byte read past the expected size.
+	r := bytes.NewReader(data)
+	if err := New(sha256.New()).ReadAllExpected(r, 8, 50); err == nil {
+		t.Fatal("long reader was accepted")
+	}
+	if r.Len() != 49 {
+		t.Error("read too far past the expected size:", r.Len())
+	}
+	if err := New(sha256.New()).ReadAllExpected(r, 8, -1); err == nil {
+		t.Error("negative expected size was accepted")
+	}
+}
+
+// TestReaderRootFunc collects the roots passed to the ReaderRootFunc callback
+// and checks that a CachedTree built from them has the same root as the data.
+func TestReaderRootFunc(t *testing.T) {
+	for _, hf := range []func() hash.Hash{sha256.New, newSHA256Hash, func() hash.Hash { return newSHA256Batcher(1) }} {
+		for height := 0; height < 4; height++ {
+			for _, size := range []int{0, 1, 8, 64, 100, 8 << 5, 1000} {
+				data := fastrand.Bytes(size)
+				var roots [][]byte
+				root, err := ReaderRootFunc(bytes.NewReader(data), hf(), 8, height, func(index uint64, root []byte) {
+					if index != uint64(len(roots)) {
+						t.Fatal("callback called out of order", height, size, index)
+					}
+					roots = append(roots, root)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected, err := ReaderRoot(bytes.NewReader(data), hf(), 8)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(root, expected) {
+					t.Error("ReaderRootFunc returned the wrong root", height, size)
+				}
+
+				numLeaves := (size + 7) / 8
+				if len(roots) != (numLeaves+1<<uint(height)-1)>>uint(height) {
+					t.Fatal("wrong number of callbacks", height, size, len(roots))
+				}
+				ct := NewCachedTree(hf(), uint64(height))
+				for _, r := range roots {
+					ct.Push(r)
+				}
+				if !bytes.Equal(ct.Root(), expected) {
+					t.Error("CachedTree built from the callbacks has the wrong root", height, size)
+				}
+			}
+		}
+	}
+
+	// The tree must hold whole runs.
+	tree := New(sha256.New())
+	tree.Push([]byte{1})
+	if tree.ReadAllFunc(bytes.NewReader([]byte{1}), 8, 1, func(uint64, []byte) {}) == nil {
+		t.Error("ReadAllFunc accepted a tree that does not hold whole runs")
+	}
+}
+
+// TestPushReader checks that PushReader produces the same tree as Push, and
+// that it refuses to stream the leaf at the proof index.
+func TestPushReader(t *testing.T) {
+	leaves := [][]byte{nil, fastrand.Bytes(1), fastrand.Bytes(100), fastrand.Bytes(1 << 16), fastrand.Bytes(7)}
+	for proofIndex := uint64(0); proofIndex <= uint64(len(leaves)); proofIndex++ {
+		pushed := New(sha256.New())
+		streamed := New(sha256.New())
+		if err := pushed.SetIndex(proofIndex); err != nil {
+			t.Fatal(err)
+		}
+		if err := streamed.SetIndex(proofIndex); err != nil {
+			t.Fatal(err)
+		}
+		for i, leaf := range leaves {
+			pushed.Push(leaf)
+			if uint64(i) == proofIndex {
+				if err := streamed.PushReader(bytes.NewReader(leaf)); err != ErrProofLeafStreamed {
+					t.Fatal("expected ErrProofLeafStreamed, got", err)
+				}
+				streamed.Push(leaf)
+			} else if err := streamed.PushReader(iotest.OneByteReader(bytes.NewReader(leaf))); err != nil {
+				t.Fatal(err)
+			}
+			if !streamed.EqualsWithProofState(pushed) {
+				t.Fatal("PushReader produced a different tree than Push", proofIndex, i)
+			}
+		}
+		if proofIndex < uint64(len(leaves)) {
+			root, proofSet, _, numLeaves := streamed.Prove()
+			if !VerifyProof(sha256.New(), root, proofSet, proofIndex, numLeaves) {
+				t.Error("proof from a tree with streamed leaves is invalid", proofIndex)
+			}
+		}
+	}
+
+	// A read error leaves the tree unmodified.
+	tree := New(sha256.New())
+	tree.Push([]byte{1})
+	before := New(sha256.New())
+	before.Push([]byte{1})
+	if err := tree.PushReader(iotest.TimeoutReader(iotest.OneByteReader(bytes.NewReader([]byte{1, 2})))); err == nil {
+		t.Error("read error was not returned")
+	}
+	if !tree.Equals(before) {
+		t.Error("a failed read modified the tree")
+	}
+	if NewCachedTree(sha256.New(), 0).PushReader(new(bytes.Reader)) == nil {
+		t.Error("PushReader accepted a cached tree")
+	}
+}
+
+// TestVerifySubtreeRoot checks VerifySubtreeRoot with correct, corrupted,
+// truncated and padded data, and with a short final chunk.
+func TestVerifySubtreeRoot(t *testing.T) {
+	data := fastrand.Bytes(16 * 8)
+	root, err := ReaderRoot(bytes.NewReader(data), sha256.New(), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(data), 8, 16); !ok || err != nil {
+		t.Error("correct data was rejected", err)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[50]++
+	if ok, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(corrupt), 8, 16); ok || err != nil {
+		t.Error("corrupt data was accepted", err)
+	}
+	if ok, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(data[:15*8]), 8, 16); ok || err == nil {
+		t.Error("truncated data was accepted")
+	}
+	if ok, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(append(data, make([]byte, 8)...)), 8, 16); ok || err == nil {
+		t.Error("padded data was accepted")
+	}
+	if ok, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(append(data, 0)), 8, 16); ok || err == nil {
+		t.Error("data with a partial extra leaf was accepted")
+	}
+	if ok, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(data), 8, 8); ok || err == nil {
+		t.Error("data with the wrong number of leaves was accepted")
+	}
+
+	// Padding is not read past one extra leaf.
+	r := bytes.NewReader(append(data, make([]byte, 100)...))
+	VerifySubtreeRoot(sha256.New(), root, r, 8, 16)
+	if r.Len() != 100-8 {
+		t.Error("read too far past the expected leaves:", r.Len())
+	}
+
+	// A short final chunk is checked against the leaves it holds.
+	final := data[:5*8+3]
+	finalRoot, err := ReaderRoot(bytes.NewReader(final), sha256.New(), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifySubtreeRoot(sha256.New(), finalRoot, bytes.NewReader(final), 8, 6); !ok || err != nil {
+		t.Error("short final chunk was rejected", err)
+	}
+
+	for _, expected := range []uint64{0, 1 << 62} {
+		if _, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(data), 8, expected); err == nil {
+			t.Error("out of range number of leaves was accepted", expected)
+		}
+	}
+	if _, err := VerifySubtreeRoot(sha256.New(), root, bytes.NewReader(data), 0, 16); err == nil {
+		t.Error("segment size of 0 was accepted")
+	}
+}
+
+// TestBuildReaderProofAt embeds several objects in one file and proves leaves
+// of each of them.
+func TestBuildReaderProofAt(t *testing.T) {
+	objects := [][]byte{fastrand.Bytes(64), fastrand.Bytes(37), fastrand.Bytes(1), fastrand.Bytes(200)}
+	var file []byte
+	var offsets []int64
+	for _, obj := range objects {
+		file = append(file, fastrand.Bytes(13)...)
+		offsets = append(offsets, int64(len(file)))
+		file = append(file, obj...)
+	}
+	file = append(file, fastrand.Bytes(13)...)
+
+	for i, obj := range objects {
+		numLeaves := numSegments(int64(len(obj)), 8)
+		for index := uint64(0); index < numLeaves; index++ {
+			root, proofSet, n, err := BuildReaderProofAt(bytes.NewReader(file), offsets[i], int64(len(obj)), sha256.New(), 8, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expRoot, expSet, expN, err := BuildReaderProof(bytes.NewReader(obj), sha256.New(), 8, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(root, expRoot) || n != expN || len(proofSet) != len(expSet) {
+				t.Fatal("BuildReaderProofAt does not match BuildReaderProof", i, index)
+			}
+			for j := range proofSet {
+				if !bytes.Equal(proofSet[j], expSet[j]) {
+					t.Error("BuildReaderProofAt returned a different proof", i, index, j)
+				}
+			}
+		}
+		if _, _, _, err := BuildReaderProofAt(bytes.NewReader(file), offsets[i], int64(len(obj)), sha256.New(), 8, numLeaves); err == nil {
+			t.Error("index beyond the object was accepted", i)
+		}
+	}
+
+	// The section must be within the file and well formed.
+	if _, _, _, err := BuildReaderProofAt(bytes.NewReader(file), int64(len(file))-10, 20, sha256.New(), 8, 0); err == nil {
+		t.Error("section past the end of the file was accepted")
+	}
+	for _, section := range [][2]int64{{-1, 10}, {0, -1}, {1, math.MaxInt64}} {
+		if _, _, _, err := BuildReaderProofAt(bytes.NewReader(file), section[0], section[1], sha256.New(), 8, 0); err == nil {
+			t.Error("invalid section was accepted", section)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// referenceTree is a naive Merkle tree that keeps every node of every level,
+// to serve as an oracle for the stack-based implementations. Level 0 holds the
+// leaf hashes, and each following level pairs up the nodes of the level below
+// it, promoting a node without a sibling unchanged. The last level holds only
+// the root.
+type referenceTree struct {
+	leaves [][]byte
+	levels [][][]byte
+}
+
+// newReferenceTree builds the full node matrix of a tree over 'leaves'.
+func newReferenceTree(h hash.Hash, leaves [][]byte) *referenceTree {
+	rt := &referenceTree{leaves: leaves}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = leafSum(h, leaf)
+	}
+	rt.levels = append(rt.levels, level)
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, nodeSum(h, level[i], level[i+1]))
+			}
+		}
+		rt.levels = append(rt.levels, next)
+		level = next
+	}
+	return rt
+}
+
+// root returns the root of the tree, or nil if the tree is empty.
+func (rt *referenceTree) root() []byte {
+	top := rt.levels[len(rt.levels)-1]
+	if len(top) == 0 {
+		return nil
+	}
+	return top[0]
+}
+
+// prove returns the proof set of the leaf at 'index': the leaf data, then the
+// sibling of the node on the path to the root at each level, skipping levels
+// where the node is promoted.
+func (rt *referenceTree) prove(index int) [][]byte {
+	proofSet := [][]byte{rt.leaves[index]}
+	for _, level := range rt.levels[:len(rt.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proofSet = append(proofSet, level[sibling])
+		}
+		index /= 2
+	}
+	return proofSet
+}
+
+// referenceSizes returns the number of random trees to compare and the
+// largest number of leaves they may have, which are smaller in short mode.
+func referenceSizes() (trials, maxLeaves int) {
+	if testing.Short() {
+		return 25, 70
+	}
+	return 250, 600
+}
+
+// randomLeaves returns 'n' leaves of random data of up to 'size' bytes.
+func randomLeaves(n, size int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = fastrand.Bytes(fastrand.Intn(size + 1))
+	}
+	return leaves
+}
+
+// TestReferenceTreeSmall checks the reference tree against the hand-computed
+// roots of small trees.
+func TestReferenceTreeSmall(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	for n, root := range mt.roots {
+		rt := newReferenceTree(sha256.New(), mt.data[:n])
+		if !bytes.Equal(rt.root(), root) {
+			t.Error("reference root does not match the tester", n)
+		}
+	}
+}
+
+// TestReferenceTreeProofs compares the roots and proofs of Tree against the
+// reference tree for random sizes and indices.
+func TestReferenceTreeProofs(t *testing.T) {
+	trials, maxLeaves := referenceSizes()
+	for i := 0; i < trials; i++ {
+		n := fastrand.Intn(maxLeaves) + 1
+		leaves := randomLeaves(n, 40)
+		rt := newReferenceTree(sha256.New(), leaves)
+
+		tree := New(sha256.New())
+		for _, leaf := range leaves {
+			tree.Push(leaf)
+		}
+		if !bytes.Equal(tree.Root(), rt.root()) {
+			t.Fatal("root does not match the reference", n)
+		}
+
+		index := fastrand.Intn(n)
+		tree = New(sha256.New())
+		if err := tree.SetIndex(uint64(index)); err != nil {
+			t.Fatal(err)
+		}
+		for _, leaf := range leaves {
+			tree.Push(leaf)
+		}
+		root, proofSet, _, numLeaves := tree.Prove()
+		expected := rt.prove(index)
+		if !bytes.Equal(root, rt.root()) || numLeaves != uint64(n) || len(proofSet) != len(expected) {
+			t.Fatal("proof does not match the reference", n, index)
+		}
+		for j := range expected {
+			if !bytes.Equal(proofSet[j], expected[j]) {
+				t.Fatal("proof element does not match the reference", n, index, j)
+			}
+		}
+		if !VerifyProof(sha256.New(), rt.root(), expected, uint64(index), uint64(n)) {
+			t.Fatal("reference proof does not verify", n, index)
+		}
+	}
+}
+
+// TestReferenceReaders compares ReaderRoot and BuildReaderProof against the
+// reference tree over random data, including a short final segment.
+func TestReferenceReaders(t *testing.T) {
+	trials, maxLeaves := referenceSizes()
+	for i := 0; i < trials; i++ {
+		segmentSize := fastrand.Intn(64) + 1
+		data := fastrand.Bytes(fastrand.Intn(maxLeaves*segmentSize) + 1)
+		var leaves [][]byte
+		for j := 0; j < len(data); j += segmentSize {
+			end := j + segmentSize
+			if end > len(data) {
+				end = len(data)
+			}
+			leaves = append(leaves, data[j:end])
+		}
+		rt := newReferenceTree(sha256.New(), leaves)
+
+		root, err := ReaderRoot(bytes.NewReader(data), sha256.New(), segmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(root, rt.root()) {
+			t.Fatal("reader root does not match the reference", len(data), segmentSize)
+		}
+
+		index := fastrand.Intn(len(leaves))
+		root, proofSet, numLeaves, err := BuildReaderProof(bytes.NewReader(data), sha256.New(), segmentSize, uint64(index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := rt.prove(index)
+		if !bytes.Equal(root, rt.root()) || numLeaves != uint64(len(leaves)) || len(proofSet) != len(expected) {
+			t.Fatal("reader proof does not match the reference", len(data), segmentSize, index)
+		}
+		for j := range expected {
+			if !bytes.Equal(proofSet[j], expected[j]) {
+				t.Fatal("reader proof element does not match the reference", len(data), segmentSize, index, j)
+			}
+		}
+	}
+}
+
+// TestReferenceCachedTree compares the proofs of a CachedTree, built from the
+// reference roots of each chunk and the reference proof within the chunk,
+// against the reference proof of the whole tree.
+func TestReferenceCachedTree(t *testing.T) {
+	trials, maxLeaves := referenceSizes()
+	for i := 0; i < trials; i++ {
+		height := uint64(fastrand.Intn(5))
+		chunkLeaves := 1 << height
+		numChunks := fastrand.Intn(maxLeaves/chunkLeaves+1) + 1
+		leaves := randomLeaves(numChunks*chunkLeaves, 16)
+		rt := newReferenceTree(sha256.New(), leaves)
+
+		index := fastrand.Intn(len(leaves))
+		ct := NewCachedTree(sha256.New(), height)
+		if err := ct.SetIndex(uint64(index)); err != nil {
+			t.Fatal(err)
+		}
+		var subProof [][]byte
+		for c := 0; c < numChunks; c++ {
+			chunk := newReferenceTree(sha256.New(), leaves[c*chunkLeaves:(c+1)*chunkLeaves])
+			ct.Push(chunk.root())
+			if c == index/chunkLeaves {
+				subProof = chunk.prove(index % chunkLeaves)
+			}
+		}
+		root, proofSet, proofIndex, numLeaves := ct.Prove(subProof)
+		expected := rt.prove(index)
+		if !bytes.Equal(root, rt.root()) || proofIndex != uint64(index) || numLeaves != uint64(len(leaves)) || len(proofSet) != len(expected) {
+			t.Fatal("cached proof does not match the reference", height, numChunks, index)
+		}
+		for j := range expected {
+			if !bytes.Equal(proofSet[j], expected[j]) {
+				t.Fatal("cached proof element does not match the reference", height, numChunks, index, j)
+			}
+		}
+	}
+}
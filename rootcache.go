@@ -0,0 +1,217 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"math/bits"
+)
+
+// rootCacheMagic identifies a root cache file, and rootCacheVersion is the
+// version of the layout that follows it. The header is the magic, the version,
+// and then the hash size, the cached node height and the number of roots as 8
+// byte little-endian integers. The header is followed by the levels of the
+// tree of cached roots, from the bottom up, each packed in order: level 0 is
+// the n cached roots, and level k holds the n>>k roots of the complete
+// subtrees of 2^k cached roots. Keeping the interior levels lets a proof read
+// O(log n) roots instead of the whole file.
+//
+// Version 1 files held level 0 only. They are no longer read.
+var rootCacheMagic = []byte("MTRC")
+
+const (
+	rootCacheVersion    = 2
+	rootCacheHeaderSize = 4 + 1 + 8 + 8 + 8
+)
+
+// CacheMeta describes the roots stored in a root cache file. Every root has
+// HashSize bytes and is the root of a full tree of 2^CachedNodeHeight leaves,
+// the same as the elements pushed into a CachedTree.
+type CacheMeta struct {
+	HashSize         int
+	CachedNodeHeight uint64
+}
+
+// A RootCache reads the roots of a root cache file written by WriteRootCache.
+// Only the header is read when the RootCache is opened; roots are read from
+// the underlying io.ReaderAt as they are needed, O(log n) of them for each
+// root or proof.
+type RootCache struct {
+	r        io.ReaderAt
+	h        hash.Hash
+	meta     CacheMeta
+	numRoots uint64
+}
+
+// WriteRootCache writes a root cache file containing 'roots' to 'w', along
+// with the interior levels of the tree of roots, which are hashed with 'h'.
+// Every root must have meta.HashSize bytes, which must be the size of 'h'.
+func WriteRootCache(w io.Writer, h hash.Hash, meta CacheMeta, roots [][]byte) error {
+	if meta.HashSize <= 0 {
+		return errors.New("hash size must be positive")
+	}
+	if meta.HashSize != h.Size() {
+		return errors.New("hash size does not match the hash")
+	}
+	if meta.CachedNodeHeight > MaxHeight {
+		return errors.New("cached node height is too large")
+	}
+	for _, root := range roots {
+		if len(root) != meta.HashSize {
+			return errors.New("cached root has the wrong size for the hash")
+		}
+	}
+
+	header := make([]byte, rootCacheHeaderSize)
+	copy(header, rootCacheMagic)
+	header[4] = rootCacheVersion
+	binary.LittleEndian.PutUint64(header[5:], uint64(meta.HashSize))
+	binary.LittleEndian.PutUint64(header[13:], meta.CachedNodeHeight)
+	binary.LittleEndian.PutUint64(header[21:], uint64(len(roots)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for level := roots; len(level) > 0; {
+		for _, root := range level {
+			if _, err := w.Write(root); err != nil {
+				return err
+			}
+		}
+		next := make([][]byte, len(level)/2)
+		for i := range next {
+			next[i] = nodeSum(h, level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+	return nil
+}
+
+// OpenRootCache reads the header of the root cache file in 'r', checking that
+// it is well formed, that the hash size matches the size of 'h', and that the
+// file is long enough to hold every root. 'h' is used to hash the roots.
+func OpenRootCache(r io.ReaderAt, h hash.Hash) (*RootCache, error) {
+	header := make([]byte, rootCacheHeaderSize)
+	if err := readFullAt(r, header, 0); err != nil {
+		return nil, errors.New("root cache header is truncated")
+	}
+	if !bytes.Equal(header[:4], rootCacheMagic) {
+		return nil, errors.New("file is not a root cache")
+	}
+	if header[4] != rootCacheVersion {
+		return nil, errors.New("unknown root cache version")
+	}
+	hashSize := binary.LittleEndian.Uint64(header[5:])
+	height := binary.LittleEndian.Uint64(header[13:])
+	numRoots := binary.LittleEndian.Uint64(header[21:])
+	if hashSize != uint64(h.Size()) {
+		return nil, errors.New("root cache hash size does not match the hash")
+	}
+	if height > MaxHeight || (numRoots<<height)>>height != numRoots {
+		return nil, errors.New("root cache has more leaves than a tree can hold")
+	}
+	if numRoots > (1<<63-rootCacheHeaderSize)/hashSize/2 {
+		return nil, errors.New("root cache is too large")
+	}
+
+	rc := &RootCache{
+		r: r,
+		h: h,
+		meta: CacheMeta{
+			HashSize:         int(hashSize),
+			CachedNodeHeight: height,
+		},
+		numRoots: numRoots,
+	}
+	if numRoots > 0 {
+		top := bits.Len64(numRoots) - 1
+		if _, err := rc.readNode(top, numRoots>>uint(top)-1); err != nil {
+			return nil, errors.New("root cache is truncated")
+		}
+	}
+	return rc, nil
+}
+
+// Meta returns the metadata of the root cache.
+func (rc *RootCache) Meta() CacheMeta {
+	return rc.meta
+}
+
+// NumRoots returns the number of roots in the root cache.
+func (rc *RootCache) NumRoots() uint64 {
+	return rc.numRoots
+}
+
+// NumLeaves returns the number of leaves in the tree made of the cached roots.
+func (rc *RootCache) NumLeaves() uint64 {
+	return rc.numRoots << rc.meta.CachedNodeHeight
+}
+
+// readNode reads the root of the 'index'th complete subtree of 2^level cached
+// roots into a new slice.
+func (rc *RootCache) readNode(level int, index uint64) ([]byte, error) {
+	// Level k starts after the n>>j roots of every level j below it.
+	offset := index
+	for j := 0; j < level; j++ {
+		offset += rc.numRoots >> uint(j)
+	}
+	root := make([]byte, rc.meta.HashSize)
+	err := readFullAt(rc.r, root, rootCacheHeaderSize+int64(offset)*int64(rc.meta.HashSize))
+	return root, err
+}
+
+// readFullAt fills 'b' from 'r' at offset 'off'. An io.ReaderAt may return
+// io.EOF along with a full read that ends at the end of its input, which is
+// not an error here.
+func readFullAt(r io.ReaderAt, b []byte, off int64) error {
+	n, err := r.ReadAt(b, off)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Root returns the Merkle root of the tree made of the cached roots, which is
+// the root of the full tree of leaves. nil is returned if the cache is empty.
+func (rc *RootCache) Root() ([]byte, error) {
+	if rc.numRoots == 0 {
+		return nil, nil
+	}
+	return nodeRangeSum(rc.h, nodeRange{0, rc.numRoots}, rc.readNode)
+}
+
+// ProveCachedIndex builds the proof that the cached root at index 'i' is part
+// of the tree, returning the Merkle root and the cached level proof set, whose
+// first element is the cached root. Combined with the proof of a leaf within
+// the cached node, it proves the leaf; see VerifyCachedProof and
+// CachedTree.Prove. Every sibling is read from the interior levels, or built
+// from them if it is the orphan at the end of the tree, so only O(log n)
+// roots are read.
+func (rc *RootCache) ProveCachedIndex(i uint64) (merkleRoot []byte, cachedProof [][]byte, err error) {
+	if i >= rc.numRoots {
+		return nil, nil, ErrProofIndexOutOfRange
+	}
+	ranges := proofNodeRanges(i, rc.numRoots)
+	cachedProof = make([][]byte, 0, len(ranges)+1)
+	root, err := rc.readNode(0, i)
+	if err != nil {
+		return nil, nil, err
+	}
+	cachedProof = append(cachedProof, root)
+	for _, r := range ranges {
+		sibling, err := nodeRangeSum(rc.h, r, rc.readNode)
+		if err != nil {
+			return nil, nil, err
+		}
+		cachedProof = append(cachedProof, sibling)
+	}
+	merkleRoot, err = rc.Root()
+	if err != nil {
+		return nil, nil, err
+	}
+	return merkleRoot, cachedProof, nil
+}
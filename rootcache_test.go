@@ -0,0 +1,173 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+)
+
+// buildRootCache returns the leaves of a tree with 'numLeaves' leaves, and a
+// root cache file holding the roots of each run of 2^height leaves.
+func buildRootCache(t *testing.T, numLeaves, height uint64) ([][]byte, []byte) {
+	var leaves, roots [][]byte
+	for i := uint64(0); i < numLeaves; i++ {
+		leaves = append(leaves, []byte{byte(i), byte(i >> 8)})
+	}
+	for i := uint64(0); i < numLeaves; i += 1 << height {
+		tree := New(sha256.New())
+		for _, leaf := range leaves[i : i+1<<height] {
+			tree.Push(leaf)
+		}
+		roots = append(roots, tree.Root())
+	}
+	var buf bytes.Buffer
+	if err := WriteRootCache(&buf, sha256.New(), CacheMeta{HashSize: sha256.Size, CachedNodeHeight: height}, roots); err != nil {
+		t.Fatal(err)
+	}
+	return leaves, buf.Bytes()
+}
+
+// TestRootCache round trips root caches of several sizes, and checks that the
+// root and the proofs built from them match flat trees of the same leaves.
+func TestRootCache(t *testing.T) {
+	for _, height := range []uint64{0, 1, 3} {
+		for _, numRoots := range []uint64{1, 2, 5, 8, 13} {
+			numLeaves := numRoots << height
+			leaves, file := buildRootCache(t, numLeaves, height)
+			rc, err := OpenRootCache(bytes.NewReader(file), sha256.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rc.NumRoots() != numRoots || rc.NumLeaves() != numLeaves || rc.Meta() != (CacheMeta{sha256.Size, height}) {
+				t.Error("root cache has the wrong metadata", height, numRoots)
+			}
+
+			flat := New(sha256.New())
+			for _, leaf := range leaves {
+				flat.Push(leaf)
+			}
+			root, err := rc.Root()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(root, flat.Root()) {
+				t.Error("root cache root does not match the flat tree", height, numRoots)
+			}
+
+			for index := uint64(0); index < numLeaves; index++ {
+				cachedIndex := index >> height
+				merkleRoot, cachedProof, err := rc.ProveCachedIndex(cachedIndex)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sub := New(sha256.New())
+				if err := sub.SetIndex(index - cachedIndex<<height); err != nil {
+					t.Fatal(err)
+				}
+				for _, leaf := range leaves[cachedIndex<<height : (cachedIndex+1)<<height] {
+					sub.Push(leaf)
+				}
+				_, subProof, _, _ := sub.Prove()
+				if !VerifyCachedProof(sha256.New(), merkleRoot, subProof, cachedProof, height, index, numLeaves) {
+					t.Error("proof from root cache does not verify", height, numRoots, index)
+				}
+				if !bytes.Equal(merkleRoot, flat.Root()) {
+					t.Error("proof from root cache has the wrong root", height, numRoots, index)
+				}
+			}
+			if _, _, err := rc.ProveCachedIndex(numRoots); err != ErrProofIndexOutOfRange {
+				t.Error("expected ErrProofIndexOutOfRange, got", err)
+			}
+		}
+	}
+
+	// An empty cache has a nil root.
+	var buf bytes.Buffer
+	if err := WriteRootCache(&buf, sha256.New(), CacheMeta{HashSize: sha256.Size}, nil); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := OpenRootCache(bytes.NewReader(buf.Bytes()), sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, err := rc.Root(); err != nil || root != nil {
+		t.Error("empty root cache should have a nil root", root, err)
+	}
+}
+
+// TestRootCacheBadFiles checks that corrupt and truncated root cache files,
+// and bad inputs to WriteRootCache, are rejected.
+func TestRootCacheBadFiles(t *testing.T) {
+	_, file := buildRootCache(t, 10, 1)
+	for n := 0; n < len(file); n++ {
+		if _, err := OpenRootCache(bytes.NewReader(file[:n]), sha256.New()); err == nil {
+			t.Error("opened a truncated root cache", n)
+		}
+	}
+	if _, err := OpenRootCache(bytes.NewReader(file), sha512.New()); err == nil {
+		t.Error("opened a root cache with the wrong hash")
+	}
+	for _, offset := range []int{0, 3, 4, 5, 13, 20, 28} {
+		bad := append([]byte(nil), file...)
+		bad[offset] ^= 0xff
+		if _, err := OpenRootCache(bytes.NewReader(bad), sha256.New()); err == nil {
+			t.Error("opened a root cache with a corrupt header byte", offset)
+		}
+	}
+
+	roots := [][]byte{make([]byte, sha256.Size), make([]byte, sha256.Size-1)}
+	if WriteRootCache(new(bytes.Buffer), sha256.New(), CacheMeta{HashSize: sha256.Size}, roots) == nil {
+		t.Error("wrote a root of the wrong size")
+	}
+	if WriteRootCache(new(bytes.Buffer), sha256.New(), CacheMeta{}, nil) == nil {
+		t.Error("wrote a root cache with no hash size")
+	}
+	if WriteRootCache(new(bytes.Buffer), sha256.New(), CacheMeta{HashSize: sha256.Size, CachedNodeHeight: 64}, nil) == nil {
+		t.Error("wrote a root cache with an impossible height")
+	}
+}
+
+// countingReaderAt counts the calls to ReadAt of the underlying reader.
+type countingReaderAt struct {
+	r     *bytes.Reader
+	reads int
+}
+
+// ReadAt implements io.ReaderAt.
+func (cr *countingReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	cr.reads++
+	return cr.r.ReadAt(b, off)
+}
+
+// TestRootCacheReads checks that roots and proofs read O(log n) roots from
+// the file, rather than every root.
+func TestRootCacheReads(t *testing.T) {
+	const numRoots = 1000
+	_, file := buildRootCache(t, numRoots, 0)
+	cr := &countingReaderAt{r: bytes.NewReader(file)}
+	rc, err := OpenRootCache(cr, sha256.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint64{0, 511, 512, 999} {
+		cr.reads = 0
+		if _, _, err := rc.ProveCachedIndex(i); err != nil {
+			t.Fatal(err)
+		}
+		// The proof and the root each read a root for every level, at most.
+		if cr.reads > 4*10 {
+			t.Error("proof read too many roots", i, cr.reads)
+		}
+	}
+
+	// Files of the old layout, without interior levels, are rejected.
+	old := append([]byte(nil), file...)
+	old[4] = 1
+	if _, err := OpenRootCache(bytes.NewReader(old), sha256.New()); err == nil {
+		t.Error("opened a version 1 root cache")
+	}
+	if WriteRootCache(new(bytes.Buffer), sha512.New(), CacheMeta{HashSize: sha256.Size}, nil) == nil {
+		t.Error("wrote a root cache with a hash of the wrong size")
+	}
+}
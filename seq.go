@@ -0,0 +1,34 @@
+//go:build go1.23
+// +build go1.23
+
+package merkletree
+
+import (
+	"hash"
+	"iter"
+)
+
+// PushSeq pushes every leaf produced by 'leaves', stopping at the first error
+// produced by the sequence, which is returned. The leaves produced before the
+// error remain in the tree.
+func (t *Tree) PushSeq(leaves iter.Seq2[[]byte, error]) error {
+	for leaf, err := range leaves {
+		if err != nil {
+			return err
+		}
+		t.Push(leaf)
+	}
+	return nil
+}
+
+// RootFromSeq returns the Merkle root of the leaves produced by 'leaves', and
+// the number of leaves. If the sequence produces an error, the sequence is
+// stopped and the error is returned, along with the number of leaves that
+// were produced before it.
+func RootFromSeq(newHash func() hash.Hash, leaves iter.Seq2[[]byte, error]) ([]byte, uint64, error) {
+	tree := New(newHash())
+	if err := tree.PushSeq(leaves); err != nil {
+		return nil, tree.CurrentIndex(), err
+	}
+	return tree.Root(), tree.CurrentIndex(), nil
+}
@@ -0,0 +1,83 @@
+//go:build go1.23
+// +build go1.23
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"iter"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// sliceSeq returns a sequence of the elements of 'leaves', producing 'err'
+// in place of the leaf at 'errIndex'.
+func sliceSeq(leaves [][]byte, errIndex int, err error) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		for i, leaf := range leaves {
+			if i == errIndex {
+				yield(nil, err)
+				return
+			}
+			if !yield(leaf, nil) {
+				return
+			}
+		}
+	}
+}
+
+// TestRootFromSeq compares RootFromSeq and PushSeq against pushing each leaf.
+func TestRootFromSeq(t *testing.T) {
+	for n := 0; n < 40; n++ {
+		leaves := make([][]byte, n)
+		tree := New(sha256.New())
+		for i := range leaves {
+			leaves[i] = fastrand.Bytes(fastrand.Intn(80))
+			tree.Push(leaves[i])
+		}
+		root, numLeaves, err := RootFromSeq(sha256.New, sliceSeq(leaves, -1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(root, tree.Root()) || numLeaves != uint64(n) {
+			t.Error("RootFromSeq does not match Push", n)
+		}
+
+		seqTree := New(sha256.New())
+		if err := seqTree.PushSeq(sliceSeq(leaves, -1, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if !seqTree.Equals(tree) {
+			t.Error("PushSeq does not match Push", n)
+		}
+	}
+}
+
+// TestRootFromSeqError checks that an error stops the sequence and is
+// returned, and that the leaves before it are pushed.
+func TestRootFromSeqError(t *testing.T) {
+	leaves := make([][]byte, 10)
+	for i := range leaves {
+		leaves[i] = fastrand.Bytes(16)
+	}
+	errSeq := errors.New("cursor failed")
+	root, numLeaves, err := RootFromSeq(sha256.New, sliceSeq(leaves, 6, errSeq))
+	if err != errSeq || root != nil || numLeaves != 6 {
+		t.Error("wrong result for failing sequence", err, root, numLeaves)
+	}
+
+	tree := New(sha256.New())
+	if err := tree.PushSeq(sliceSeq(leaves, 6, errSeq)); err != errSeq {
+		t.Error("PushSeq returned the wrong error", err)
+	}
+	expected := New(sha256.New())
+	for _, leaf := range leaves[:6] {
+		expected.Push(leaf)
+	}
+	if !tree.Equals(expected) {
+		t.Error("PushSeq did not push the leaves before the error")
+	}
+}
@@ -0,0 +1,54 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"hash"
+)
+
+// sha256FastLeafSize is the largest leaf that is hashed by the sha256 fast
+// path. Larger leaves spend most of their time hashing the data, so there is
+// little to gain from avoiding the hash.Hash interface, and copying them into
+// a buffer would cost more than it saves.
+const sha256FastLeafSize = 127
+
+// sha256Hash is the hash used by trees created with NewSHA256. It behaves
+// exactly like the hash returned by sha256.New, but its type lets
+// appendLeafSum and appendNodeSum recognize it and compute small sums with
+// sha256.Sum256 on a stack buffer instead of going through the hash.Hash
+// interface.
+type sha256Hash struct {
+	hash.Hash
+}
+
+// newSHA256Hash returns a new sha256Hash.
+func newSHA256Hash() hash.Hash {
+	return &sha256Hash{sha256.New()}
+}
+
+// NewSHA256 creates a new Tree that uses sha256. The roots and proofs are
+// identical to those of a Tree created with New(sha256.New()), but computing
+// them is faster when the leaves are small.
+func NewSHA256() *Tree {
+	return New(newSHA256Hash())
+}
+
+// sha256LeafSum appends the leaf sum of 'data' to 'dst' using the sha256 fast
+// path. 'data' must be no larger than sha256FastLeafSize.
+func sha256LeafSum(dst []byte, data []byte) []byte {
+	var buf [1 + sha256FastLeafSize]byte
+	buf[0] = leafHashPrefix[0]
+	n := 1 + copy(buf[1:], data)
+	s := sha256.Sum256(buf[:n])
+	return append(dst, s[:]...)
+}
+
+// sha256NodeSum appends the node sum of 'a' and 'b' to 'dst' using the sha256
+// fast path. 'a' and 'b' must both be sha256 sums.
+func sha256NodeSum(dst []byte, a, b []byte) []byte {
+	var buf [1 + 2*sha256.Size]byte
+	buf[0] = nodeHashPrefix[0]
+	copy(buf[1:], a)
+	copy(buf[1+sha256.Size:], b)
+	s := sha256.Sum256(buf[:])
+	return append(dst, s[:]...)
+}
@@ -0,0 +1,100 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestSHA256FastPathVectors checks that trees created with NewSHA256 produce
+// the roots and proofs of the MerkleTester.
+func TestSHA256FastPathVectors(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	for numLeaves, root := range mt.roots {
+		tree := NewSHA256()
+		for i := 0; i < numLeaves; i++ {
+			tree.Push(mt.data[i])
+		}
+		if !bytes.Equal(tree.Root(), root) {
+			t.Error("NewSHA256 tree has the wrong root", numLeaves)
+		}
+	}
+	for numLeaves, proofSets := range mt.proofSets {
+		for proofIndex, expected := range proofSets {
+			tree := NewSHA256()
+			if err := tree.SetIndex(uint64(proofIndex)); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < numLeaves; i++ {
+				tree.Push(mt.data[i])
+			}
+			root, proofSet, _, _ := tree.Prove()
+			if len(proofSet) != len(expected) {
+				t.Fatal("NewSHA256 proof has the wrong length", numLeaves, proofIndex)
+			}
+			for i := range proofSet {
+				if !bytes.Equal(proofSet[i], expected[i]) {
+					t.Error("NewSHA256 proof does not match", numLeaves, proofIndex, i)
+				}
+			}
+			if !VerifyProof(newSHA256Hash(), root, proofSet, uint64(proofIndex), uint64(numLeaves)) {
+				t.Error("NewSHA256 proof does not verify with the fast path", numLeaves, proofIndex)
+			}
+		}
+	}
+}
+
+// TestSHA256FastPathGeneric compares the fast path against the generic path
+// for leaves on either side of sha256FastLeafSize and for subtrees pushed
+// with sums of other sizes, which must fall back to the generic path.
+func TestSHA256FastPathGeneric(t *testing.T) {
+	for _, leafSize := range []int{0, 1, 64, sha256FastLeafSize, sha256FastLeafSize + 1, 4096} {
+		fast := NewSHA256()
+		generic := New(sha256.New())
+		if err := fast.SetIndex(5); err != nil {
+			t.Fatal(err)
+		}
+		if err := generic.SetIndex(5); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 37; i++ {
+			leaf := fastrand.Bytes(leafSize)
+			fast.Push(leaf)
+			generic.Push(leaf)
+		}
+		fastRoot, fastProof, _, _ := fast.Prove()
+		genericRoot, genericProof, _, _ := generic.Prove()
+		if !bytes.Equal(fastRoot, genericRoot) {
+			t.Error("fast path root does not match generic root", leafSize)
+		}
+		for i := range genericProof {
+			if !bytes.Equal(fastProof[i], genericProof[i]) {
+				t.Error("fast path proof does not match generic proof", leafSize, i)
+			}
+		}
+	}
+
+	// Node sums of inputs that are not 32 bytes must take the generic path.
+	a, b := fastrand.Bytes(20), fastrand.Bytes(50)
+	if !bytes.Equal(nodeSum(newSHA256Hash(), a, b), nodeSum(sha256.New(), a, b)) {
+		t.Error("fast path node sum of odd sized inputs does not match the generic path")
+	}
+}
+
+// BenchmarkSHA256Tree64_4MB creates a NewSHA256 tree from 4MB of data split
+// into 64 byte segments, for comparison with BenchmarkTree64_4MB.
+func BenchmarkSHA256Tree64_4MB(b *testing.B) {
+	data := fastrand.Bytes(1 << 22)
+	b.SetBytes(1 << 22)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree := NewSHA256()
+		for j := 0; j < len(data); j += 64 {
+			tree.Push(data[j : j+64])
+		}
+		_ = tree.Root()
+	}
+}
@@ -0,0 +1,136 @@
+// Package sia provides helpers for computing the Merkle roots of Sia sectors
+// and proving segments of them. Sia builds the Merkle tree of a sector from
+// 64 byte segments, and every sector is at most 4 MiB. The helpers in this
+// package are hard-wired to those sizes so that they can't be
+// mis-parameterized, and are built on top of the merkletree package.
+package sia
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+
+	"github.com/NebulousLabs/merkletree"
+)
+
+const (
+	// SegmentSize is the number of bytes in each leaf of a sector's Merkle
+	// tree.
+	SegmentSize = 64
+
+	// SectorSize is the maximum number of bytes in a sector.
+	SectorSize = 1 << 22
+
+	// SegmentsPerSector is the number of leaves in the Merkle tree of a full
+	// sector.
+	SegmentsPerSector = SectorSize / SegmentSize
+)
+
+// numSegments returns the number of leaves in the Merkle tree of a sector of
+// 'sectorLen' bytes. The final segment of a short sector is not padded.
+func numSegments(sectorLen int) int {
+	return (sectorLen + SegmentSize - 1) / SegmentSize
+}
+
+// segment returns segment 'i' of the sector.
+func segment(sector []byte, i int) []byte {
+	end := (i + 1) * SegmentSize
+	if end > len(sector) {
+		end = len(sector)
+	}
+	return sector[i*SegmentSize : end]
+}
+
+// SectorRoot returns the Merkle root of a sector. The sector may be shorter
+// than SectorSize, in which case the final segment is not padded. SectorRoot
+// panics if the sector is larger than SectorSize.
+func SectorRoot(sector []byte, h func() hash.Hash) []byte {
+	if len(sector) > SectorSize {
+		panic("wrong usage: sector is larger than SectorSize")
+	}
+	tree := merkletree.New(h())
+	for i := 0; i < numSegments(len(sector)); i++ {
+		tree.Push(segment(sector, i))
+	}
+	return tree.Root()
+}
+
+// SectorRangeProof returns the Merkle root of a sector along with a proof for
+// each of the segments [start, end). The first element of each proof is the
+// data of the segment. Every level of the tree is built once by
+// merkletree.BuildLevelsFromLeaves, and the siblings of each proof are read
+// from the levels, so proving k segments of a sector of n segments costs O(n)
+// hashes and O(k*log(n)) lookups.
+func SectorRangeProof(sector []byte, h func() hash.Hash, start, end int) (root []byte, proofs [][][]byte, err error) {
+	if len(sector) > SectorSize {
+		return nil, nil, errors.New("sector is larger than SectorSize")
+	}
+	if start < 0 || start >= end || end > numSegments(len(sector)) {
+		return nil, nil, errors.New("invalid segment range for sector")
+	}
+
+	leafHashes := make([][]byte, numSegments(len(sector)))
+	hasher := h()
+	for i := range leafHashes {
+		leafHashes[i] = merkletree.LeafSum(hasher, segment(sector, i))
+	}
+	levels, err := merkletree.BuildLevelsFromLeaves(h, leafHashes, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := start; i < end; i++ {
+		proofs = append(proofs, levelProof(levels, segment(sector, i), i))
+	}
+	return levels[len(levels)-1][0], proofs, nil
+}
+
+// levelProof returns the proof set of the leaf at 'index' in the tree whose
+// levels are 'levels', with 'data' as its first element. The odd node at the
+// end of a level is promoted to the next level, so it has no sibling there.
+func levelProof(levels [][][]byte, data []byte, index int) [][]byte {
+	proof := [][]byte{data}
+	for _, level := range levels[:len(levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// VerifySectorRangeProof takes the Merkle root of a sector of 'sectorLen'
+// bytes and the proofs created by SectorRangeProof, and returns true if 'data'
+// is the content of the segments [start, end) of that sector.
+func VerifySectorRangeProof(h func() hash.Hash, root []byte, data []byte, proofs [][][]byte, start, end int, sectorLen int) bool {
+	if sectorLen > SectorSize {
+		return false
+	}
+	if start < 0 || start >= end || end > numSegments(sectorLen) {
+		return false
+	}
+	if len(proofs) != end-start {
+		return false
+	}
+
+	// The data must be exactly the bytes covered by the range, which is
+	// shorter than a multiple of SegmentSize if the range includes the final
+	// segment of a short sector.
+	rangeEnd := end * SegmentSize
+	if rangeEnd > sectorLen {
+		rangeEnd = sectorLen
+	}
+	if len(data) != rangeEnd-start*SegmentSize {
+		return false
+	}
+
+	numLeaves := uint64(numSegments(sectorLen))
+	for i, proof := range proofs {
+		if len(proof) == 0 || !bytes.Equal(proof[0], segment(data, i)) {
+			return false
+		}
+		if !merkletree.VerifyProof(h(), root, proof, uint64(start+i), numLeaves) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package sia
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+	"github.com/NebulousLabs/merkletree"
+)
+
+// TestSectorRoot compares SectorRoot against ReaderRoot for full and short
+// sectors.
+func TestSectorRoot(t *testing.T) {
+	for _, size := range []int{SectorSize, SectorSize - 1, 4096, 100, 64, 1, 0} {
+		sector := fastrand.Bytes(size)
+		expected, err := merkletree.ReaderRoot(bytes.NewReader(sector), sha256.New(), SegmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(SectorRoot(sector, sha256.New), expected) {
+			t.Error("SectorRoot does not match ReaderRoot for size", size)
+		}
+	}
+}
+
+// TestSectorRangeProof builds and verifies range proofs over full and short
+// sectors, including ranges that end at a short final segment and ranges that
+// cover every segment.
+func TestSectorRangeProof(t *testing.T) {
+	tests := []struct {
+		size       int
+		start, end int
+	}{
+		{SectorSize, 0, 1},
+		{SectorSize, 1000, 1010},
+		{SectorSize, SegmentsPerSector - 3, SegmentsPerSector},
+		{SectorSize, 0, SegmentsPerSector},
+		{1000*SegmentSize + 7, 0, 1001},
+		{4096, 0, 64},
+		{100, 0, 2},
+		{100, 1, 2},
+		{1, 0, 1},
+	}
+	for _, test := range tests {
+		sector := fastrand.Bytes(test.size)
+		root, proofs, err := SectorRangeProof(sector, sha256.New, test.start, test.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(root, SectorRoot(sector, sha256.New)) {
+			t.Error("SectorRangeProof returned the wrong root for", test)
+		}
+
+		rangeEnd := test.end * SegmentSize
+		if rangeEnd > test.size {
+			rangeEnd = test.size
+		}
+		data := sector[test.start*SegmentSize : rangeEnd]
+		if !VerifySectorRangeProof(sha256.New, root, data, proofs, test.start, test.end, test.size) {
+			t.Error("range proof did not verify for", test)
+		}
+
+		// Mutating the data or the parameters should cause verification to
+		// fail.
+		bad := append([]byte(nil), data...)
+		bad[len(bad)-1]++
+		if VerifySectorRangeProof(sha256.New, root, bad, proofs, test.start, test.end, test.size) {
+			t.Error("range proof verified for the wrong data", test)
+		}
+		if VerifySectorRangeProof(sha256.New, root, data[1:], proofs, test.start, test.end, test.size) {
+			t.Error("range proof verified for truncated data", test)
+		}
+		if VerifySectorRangeProof(sha256.New, root, data, proofs[1:], test.start, test.end, test.size) {
+			t.Error("range proof verified with a missing proof", test)
+		}
+		if test.start > 0 && VerifySectorRangeProof(sha256.New, root, data, proofs, test.start-1, test.end-1, test.size) {
+			t.Error("range proof verified for the wrong range", test)
+		}
+	}
+}
+
+// TestSectorRangeProofBadInputs checks that invalid ranges and oversized
+// sectors are rejected.
+func TestSectorRangeProofBadInputs(t *testing.T) {
+	sector := make([]byte, 128)
+	for _, r := range [][2]int{{-1, 1}, {1, 1}, {2, 1}, {0, 3}} {
+		if _, _, err := SectorRangeProof(sector, sha256.New, r[0], r[1]); err == nil {
+			t.Error("expected an error for range", r)
+		}
+	}
+	if _, _, err := SectorRangeProof(make([]byte, SectorSize+1), sha256.New, 0, 1); err == nil {
+		t.Error("expected an error for an oversized sector")
+	}
+}
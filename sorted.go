@@ -0,0 +1,116 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"sort"
+)
+
+// A SortedTree is a Tree whose leaves are pushed in strictly increasing order.
+// Because the leaves are sorted, the tree can prove that a value is absent by
+// proving the inclusion of the two adjacent leaves that straddle it. The
+// leaves are kept in a FullTree, so that an absence proof reads its siblings
+// instead of rehashing the tree.
+type SortedTree struct {
+	tree *FullTree
+}
+
+// An AbsenceProof proves that a target value is not a leaf of a SortedTree. It
+// holds the proofs of the adjacent leaves that straddle the target, in
+// increasing order of index, compressed so that the siblings they share are
+// stored once. If the target is smaller than the first leaf, only the first
+// leaf is proven, and if the target is larger than the last leaf, only the
+// last leaf is proven.
+type AbsenceProof struct {
+	Leaves CompressedProofs
+}
+
+// NewSorted creates a new SortedTree. The provided hash will be used for all
+// hashing operations within the tree.
+func NewSorted(h hash.Hash) *SortedTree {
+	return &SortedTree{
+		tree: NewFull(h),
+	}
+}
+
+// Push adds data to the tree. An error is returned if the data is not strictly
+// greater than the data of the previous leaf. The data is copied.
+func (st *SortedTree) Push(data []byte) error {
+	leaves := st.tree.leaves
+	if len(leaves) > 0 && bytes.Compare(leaves[len(leaves)-1], data) >= 0 {
+		return errors.New("leaves of a SortedTree must be pushed in strictly increasing order")
+	}
+	return st.tree.Push(data)
+}
+
+// Root returns the Merkle root of the data that has been pushed.
+func (st *SortedTree) Root() []byte {
+	// The nodes are kept in memory, so reading them can't fail.
+	root, _ := st.tree.Root()
+	return root
+}
+
+// ProveAbsence creates a proof that 'target' is not a leaf of the tree. An
+// error is returned if the tree is empty or if 'target' is a leaf of the tree.
+func (st *SortedTree) ProveAbsence(target []byte) (AbsenceProof, error) {
+	leaves := st.tree.leaves
+	if len(leaves) == 0 {
+		return AbsenceProof{}, errors.New("cannot prove absence in an empty tree")
+	}
+
+	// Find the first leaf that is not smaller than the target.
+	right := sort.Search(len(leaves), func(i int) bool {
+		return bytes.Compare(leaves[i], target) >= 0
+	})
+	if right < len(leaves) && bytes.Equal(leaves[right], target) {
+		return AbsenceProof{}, errors.New("cannot prove absence of a leaf that is in the tree")
+	}
+
+	var indices []uint64
+	if right > 0 {
+		indices = append(indices, uint64(right-1))
+	}
+	if right < len(leaves) {
+		indices = append(indices, uint64(right))
+	}
+	c, err := st.tree.ProveIndices(indices)
+	if err != nil {
+		return AbsenceProof{}, err
+	}
+	return AbsenceProof{Leaves: c}, nil
+}
+
+// VerifyAbsence takes the Merkle root of a SortedTree and returns true if the
+// proof shows that 'target' is not a leaf of the tree. The proof is accepted
+// if the leaves it contains are adjacent, straddle the target, and are both
+// elements of the tree, or if it contains the first or the last leaf of the
+// tree and the target is beyond it. Absence is only meaningful if the leaves
+// of the tree are known to be sorted.
+func VerifyAbsence(h hash.Hash, merkleRoot []byte, target []byte, proof AbsenceProof) bool {
+	c := proof.Leaves
+	if !bytes.Equal(c.Root, merkleRoot) {
+		return false
+	}
+	switch len(c.Proofs) {
+	case 1:
+		// The target must be smaller than the first leaf, or larger than
+		// the last leaf.
+		p := c.Proofs[0]
+		cmp := bytes.Compare(target, p.Data)
+		if !(p.Index == 0 && cmp < 0) && !(p.Index == c.NumLeaves-1 && cmp > 0) {
+			return false
+		}
+	case 2:
+		left, right := c.Proofs[0], c.Proofs[1]
+		if right.Index != left.Index+1 {
+			return false
+		}
+		if bytes.Compare(left.Data, target) >= 0 || bytes.Compare(right.Data, target) <= 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	return VerifyCompressed(h, c)
+}
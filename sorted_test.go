@@ -0,0 +1,176 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// TestSortedTreePush checks that out of order and duplicate leaves are
+// rejected.
+func TestSortedTreePush(t *testing.T) {
+	st := NewSorted(sha256.New())
+	if err := st.Push([]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Push([]byte{1}); err == nil {
+		t.Error("able to push a smaller leaf")
+	}
+	if err := st.Push([]byte{2}); err == nil {
+		t.Error("able to push a duplicate leaf")
+	}
+	if err := st.Push([]byte{2, 0}); err != nil {
+		t.Error(err)
+	}
+
+	// The root should match a Tree with the same leaves.
+	tree := New(sha256.New())
+	tree.Push([]byte{2})
+	tree.Push([]byte{2, 0})
+	if string(tree.Root()) != string(st.Root()) {
+		t.Error("SortedTree root does not match Tree root")
+	}
+}
+
+// TestSortedTreeAbsence exhaustively checks absence proofs for small sorted
+// trees. The leaves are the even numbers, so every odd target is absent and
+// every even target below 2*size is present.
+func TestSortedTreeAbsence(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		st := NewSorted(sha256.New())
+		for i := 0; i < size; i++ {
+			if err := st.Push([]byte{byte(2 * i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		root := st.Root()
+
+		proofs := make(map[byte]AbsenceProof)
+		for target := byte(0); target <= byte(2*size+1); target++ {
+			proof, err := st.ProveAbsence([]byte{target})
+			if target%2 == 0 && target < byte(2*size) {
+				if err == nil {
+					t.Error("able to prove absence of a present leaf", size, target)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyAbsence(sha256.New(), root, []byte{target}, proof) {
+				t.Error("absence proof did not verify", size, target)
+			}
+			proofs[target] = proof
+		}
+
+		// A proof of absence should not verify for any target other than the
+		// ones between its two leaves. 'gap' is the number of leaves that are
+		// smaller than an absent target.
+		gap := func(target byte) int {
+			if int(target+1)/2 > size {
+				return size
+			}
+			return int(target+1) / 2
+		}
+		for proven, proof := range proofs {
+			for target := byte(0); target <= byte(2*size+1); target++ {
+				present := target%2 == 0 && target < byte(2*size)
+				straddled := !present && gap(target) == gap(proven)
+				if straddled != VerifyAbsence(sha256.New(), root, []byte{target}, proof) {
+					t.Error("absence proof verification is wrong for target", size, proven, target)
+				}
+			}
+		}
+	}
+}
+
+// TestSortedTreeBadAbsenceProofs checks that absence proofs with leaves that
+// are not adjacent, or that don't reach the edges of the tree, are rejected.
+func TestSortedTreeBadAbsenceProofs(t *testing.T) {
+	st := NewSorted(sha256.New())
+	if _, err := st.ProveAbsence([]byte{1}); err == nil {
+		t.Error("able to prove absence in an empty tree")
+	}
+	for i := 0; i < 6; i++ {
+		if err := st.Push([]byte{byte(2 * i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := st.Root()
+
+	// Prove two leaves that are not adjacent, and claim that they straddle
+	// a target between them.
+	c, err := st.tree.ProveIndices([]uint64{0, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyAbsence(sha256.New(), root, []byte{3}, AbsenceProof{Leaves: c}) {
+		t.Error("absence proof with non-adjacent leaves verified")
+	}
+
+	// Drop the right leaf, claiming that the left leaf is the last leaf.
+	proof1, err := st.ProveAbsence([]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := proof1
+	proof.Leaves.Proofs = proof1.Leaves.Proofs[:1]
+	if VerifyAbsence(sha256.New(), root, []byte{1}, proof) {
+		t.Error("absence proof verified without reaching the last leaf")
+	}
+
+	// Drop the left leaf, claiming that the right leaf is the first leaf.
+	proof2, err := st.ProveAbsence([]byte{5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof = proof2
+	proof.Leaves.Proofs = proof2.Leaves.Proofs[1:]
+	if VerifyAbsence(sha256.New(), root, []byte{5}, proof) {
+		t.Error("absence proof verified without reaching the first leaf")
+	}
+
+	// A proof of another tree must not verify against this root.
+	if VerifyAbsence(sha256.New(), make([]byte, sha256.Size), []byte{1}, proof1) {
+		t.Error("absence proof verified against the wrong root")
+	}
+
+	// An empty proof should never verify.
+	if VerifyAbsence(sha256.New(), root, []byte{1}, AbsenceProof{}) {
+		t.Error("empty absence proof verified")
+	}
+}
+
+// TestSortedTreeSharedProof checks that the two leaves of an absence proof
+// share their common siblings, and that the tree does not keep the slices
+// passed to Push.
+func TestSortedTreeSharedProof(t *testing.T) {
+	st := NewSorted(sha256.New())
+	buf := make([]byte, 2)
+	for i := 0; i < 64; i++ {
+		buf[0], buf[1] = byte(i), 1
+		if err := st.Push(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Overwriting the buffer must not change the pushed leaves, or the
+	// order that later leaves are checked against.
+	buf[0], buf[1] = 0xff, 0xff
+	if err := st.Push([]byte{64}); err != nil {
+		t.Fatal("tree kept the pushed buffer:", err)
+	}
+
+	proof, err := st.ProveAbsence([]byte{31, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyAbsence(sha256.New(), st.Root(), []byte{31, 2}, proof) {
+		t.Fatal("absence proof did not verify")
+	}
+	if len(proof.Leaves.Proofs) != 2 {
+		t.Fatal("wrong number of proven leaves", len(proof.Leaves.Proofs))
+	}
+	separate := len(proof.Leaves.Proofs[0].Refs) + len(proof.Leaves.Proofs[1].Refs)
+	if len(proof.Leaves.Hashes) >= separate {
+		t.Error("absence proof does not share siblings", len(proof.Leaves.Hashes), separate)
+	}
+}
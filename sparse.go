@@ -0,0 +1,215 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// A SparseTree commits to a key/value map. Every key is hashed into a path of
+// 8*h.Size() bits, and the value is stored in the leaf at that path of a full
+// binary tree with 2^(8*h.Size()) leaves. Leaves without a value hold an empty
+// leaf of h.Size() zero bytes. Because almost all of the tree is empty, the
+// root of an empty subtree of every height is precomputed, and only the nodes
+// that differ from those defaults are stored. Each update therefore costs one
+// hash per level of the tree.
+//
+// Values are hashed using leafSum and interior nodes are combined using
+// nodeSum, the same as for Tree. Since the position of a leaf is fixed by its
+// key, a SparseTree can prove both that a key is present (ProveInclusion) and
+// that a key is absent (ProveExclusion). Proofs must be checked with
+// VerifySparseInclusion and VerifySparseExclusion.
+type SparseTree struct {
+	depth int
+	hash  hash.Hash
+
+	// defaults[i] is the root of an empty subtree of height i. nodes holds
+	// every node that is not the root of an empty subtree, keyed by its height
+	// and path prefix. values holds the value of every key in the tree, keyed
+	// by the path of the key.
+	defaults [][]byte
+	nodes    map[string][]byte
+	values   map[string][]byte
+}
+
+// sparseDefaults returns the roots of empty subtrees of every height from 0 up
+// to and including 'depth'.
+func sparseDefaults(h hash.Hash, depth int) [][]byte {
+	defaults := make([][]byte, depth+1)
+	defaults[0] = make([]byte, depth/8)
+	for i := 1; i <= depth; i++ {
+		defaults[i] = nodeSum(h, defaults[i-1], defaults[i-1])
+	}
+	return defaults
+}
+
+// sparsePathBit returns the bit of 'path' that determines whether the node at
+// 'height' along the path is a left (false) or a right (true) child. The
+// top-most bit of the path is used for the children of the root.
+func sparsePathBit(path []byte, height int) bool {
+	i := len(path)*8 - 1 - height
+	return path[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+// sparseNodeKey returns the key used to store the node at 'height' along
+// 'path'. Only the bits of the path above 'height' identify the node, so the
+// rest are cleared. If 'sibling' is set, the key of the sibling of that node is
+// returned instead.
+func sparseNodeKey(path []byte, height int, sibling bool) string {
+	key := make([]byte, 2+len(path))
+	key[0] = byte(height >> 8)
+	key[1] = byte(height)
+	prefix := key[2:]
+	copy(prefix, path)
+	for i := len(path)*8 - height; i < len(path)*8; i++ {
+		prefix[i/8] &^= 0x80 >> uint(i%8)
+	}
+	if sibling && height < len(path)*8 {
+		i := len(path)*8 - 1 - height
+		prefix[i/8] ^= 0x80 >> uint(i%8)
+	}
+	return string(key)
+}
+
+// NewSparseTree creates an empty SparseTree. The provided hash is used both to
+// derive the path of each key and for all hashing within the tree.
+func NewSparseTree(h hash.Hash) *SparseTree {
+	depth := 8 * h.Size()
+	return &SparseTree{
+		depth:    depth,
+		hash:     h,
+		defaults: sparseDefaults(h, depth),
+		nodes:    make(map[string][]byte),
+		values:   make(map[string][]byte),
+	}
+}
+
+// node returns the node at 'height' along 'path', or its sibling.
+func (st *SparseTree) node(path []byte, height int, sibling bool) []byte {
+	if n, exists := st.nodes[sparseNodeKey(path, height, sibling)]; exists {
+		return n
+	}
+	return st.defaults[height]
+}
+
+// setLeaf replaces the leaf at 'path' and rehashes every node on the path to
+// the root. Nodes that become the root of an empty subtree are removed.
+func (st *SparseTree) setLeaf(path []byte, leaf []byte) {
+	node := leaf
+	for height := 0; ; height++ {
+		key := sparseNodeKey(path, height, false)
+		if bytes.Equal(node, st.defaults[height]) {
+			delete(st.nodes, key)
+		} else {
+			st.nodes[key] = node
+		}
+		if height == st.depth {
+			return
+		}
+
+		sibling := st.node(path, height, true)
+		if sparsePathBit(path, height) {
+			node = nodeSum(st.hash, sibling, node)
+		} else {
+			node = nodeSum(st.hash, node, sibling)
+		}
+	}
+}
+
+// Update sets the value of 'key', replacing any existing value.
+func (st *SparseTree) Update(key, value []byte) {
+	path := sum(st.hash, key)
+	st.values[string(path)] = append([]byte(nil), value...)
+	st.setLeaf(path, leafSum(st.hash, value))
+}
+
+// Delete removes 'key' from the tree. Deleting a key that is not in the tree
+// has no effect.
+func (st *SparseTree) Delete(key []byte) {
+	path := sum(st.hash, key)
+	if _, exists := st.values[string(path)]; !exists {
+		return
+	}
+	delete(st.values, string(path))
+	st.setLeaf(path, st.defaults[0])
+}
+
+// Root returns the Merkle root of the tree. The root of an empty SparseTree is
+// the root of an empty subtree of the full depth.
+func (st *SparseTree) Root() []byte {
+	return st.node(make([]byte, st.depth/8), st.depth, false)
+}
+
+// siblings returns the siblings of every node on the path from the leaf at
+// 'path' up to, but not including, the root, ordered from the bottom of the
+// tree to the top.
+func (st *SparseTree) siblings(path []byte) [][]byte {
+	proofSet := make([][]byte, 0, st.depth)
+	for height := 0; height < st.depth; height++ {
+		proofSet = append(proofSet, st.node(path, height, true))
+	}
+	return proofSet
+}
+
+// ProveInclusion creates a proof that 'key' is in the tree. The first element
+// of the proof set is the value of the key, followed by the 8*h.Size() sibling
+// hashes on the path from the leaf to the root. An error is returned if the
+// key is not in the tree.
+func (st *SparseTree) ProveInclusion(key []byte) (merkleRoot []byte, proofSet [][]byte, err error) {
+	path := sum(st.hash, key)
+	value, exists := st.values[string(path)]
+	if !exists {
+		return nil, nil, errors.New("cannot prove inclusion of a key that is not in the tree")
+	}
+	proofSet = append([][]byte{value}, st.siblings(path)...)
+	return st.Root(), proofSet, nil
+}
+
+// ProveExclusion creates a proof that 'key' is not in the tree. The proof set
+// contains the 8*h.Size() sibling hashes on the path from the empty leaf of the
+// key to the root. An error is returned if the key is in the tree.
+func (st *SparseTree) ProveExclusion(key []byte) (merkleRoot []byte, proofSet [][]byte, err error) {
+	path := sum(st.hash, key)
+	if _, exists := st.values[string(path)]; exists {
+		return nil, nil, errors.New("cannot prove exclusion of a key that is in the tree")
+	}
+	return st.Root(), st.siblings(path), nil
+}
+
+// verifySparsePath folds 'leaf' together with the sibling hashes along the
+// path of 'key' and compares the result to the Merkle root.
+func verifySparsePath(h hash.Hash, merkleRoot []byte, key []byte, leaf []byte, siblings [][]byte) bool {
+	if merkleRoot == nil {
+		return false
+	}
+	path := sum(h, key)
+	if len(siblings) != 8*len(path) {
+		return false
+	}
+	node := leaf
+	for height, sibling := range siblings {
+		if sparsePathBit(path, height) {
+			node = nodeSum(h, sibling, node)
+		} else {
+			node = nodeSum(h, node, sibling)
+		}
+	}
+	return bytes.Equal(node, merkleRoot)
+}
+
+// VerifySparseInclusion takes a Merkle root, a key, and a proof set created by
+// SparseTree.ProveInclusion, and returns true if the first element of the proof
+// set is the value of 'key' in the SparseTree with that root.
+func VerifySparseInclusion(h hash.Hash, merkleRoot []byte, key []byte, proofSet [][]byte) bool {
+	if len(proofSet) < 1 {
+		return false
+	}
+	return verifySparsePath(h, merkleRoot, key, leafSum(h, proofSet[0]), proofSet[1:])
+}
+
+// VerifySparseExclusion takes a Merkle root, a key, and a proof set created by
+// SparseTree.ProveExclusion, and returns true if 'key' is not in the SparseTree
+// with that root.
+func VerifySparseExclusion(h hash.Hash, merkleRoot []byte, key []byte, proofSet [][]byte) bool {
+	return verifySparsePath(h, merkleRoot, key, make([]byte, h.Size()), proofSet)
+}
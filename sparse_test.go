@@ -0,0 +1,136 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// checkSparseTree verifies an inclusion proof for every key in 'expected' and
+// an exclusion proof for every key in 'absent'.
+func checkSparseTree(t *testing.T, st *SparseTree, expected map[string][]byte, absent []string) {
+	for key, value := range expected {
+		root, proofSet, err := st.ProveInclusion([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(proofSet[0], value) {
+			t.Error("inclusion proof has the wrong value for", key)
+		}
+		if !VerifySparseInclusion(sha256.New(), root, []byte(key), proofSet) {
+			t.Error("inclusion proof did not verify for", key)
+		}
+		if VerifySparseExclusion(sha256.New(), root, []byte(key), proofSet[1:]) {
+			t.Error("exclusion proof verified for a key in the tree", key)
+		}
+		if _, _, err := st.ProveExclusion([]byte(key)); err == nil {
+			t.Error("able to prove exclusion of a key in the tree", key)
+		}
+	}
+	for _, key := range absent {
+		if _, exists := expected[key]; exists {
+			continue
+		}
+		root, proofSet, err := st.ProveExclusion([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifySparseExclusion(sha256.New(), root, []byte(key), proofSet) {
+			t.Error("exclusion proof did not verify for", key)
+		}
+		if VerifySparseInclusion(sha256.New(), root, []byte(key), append([][]byte{{}}, proofSet...)) {
+			t.Error("inclusion proof verified for a key that is not in the tree", key)
+		}
+		if _, _, err := st.ProveInclusion([]byte(key)); err == nil {
+			t.Error("able to prove inclusion of a key that is not in the tree", key)
+		}
+	}
+}
+
+// TestSparseTree runs a sequence of inserts, overwrites and deletes against a
+// SparseTree, checking proofs for every key after every operation.
+func TestSparseTree(t *testing.T) {
+	st := NewSparseTree(sha256.New())
+	emptyRoot := st.Root()
+	expected := make(map[string][]byte)
+	var keys []string
+	for i := 0; i < 12; i++ {
+		keys = append(keys, "key"+strconv.Itoa(i))
+	}
+	checkSparseTree(t, st, expected, keys)
+
+	// Insert every key.
+	for _, key := range keys[:8] {
+		value := fastrand.Bytes(16)
+		st.Update([]byte(key), value)
+		expected[key] = value
+		checkSparseTree(t, st, expected, keys)
+	}
+
+	// Overwrite some of the keys, including with an empty value.
+	for i, key := range keys[:4] {
+		value := fastrand.Bytes(i)
+		st.Update([]byte(key), value)
+		expected[key] = value
+		checkSparseTree(t, st, expected, keys)
+	}
+
+	// Delete keys, including keys that were never inserted.
+	for _, key := range keys[4:10] {
+		st.Delete([]byte(key))
+		delete(expected, key)
+		checkSparseTree(t, st, expected, keys)
+	}
+
+	// The root should not depend on the order of the operations.
+	st2 := NewSparseTree(sha256.New())
+	for i := len(keys) - 1; i >= 0; i-- {
+		if value, exists := expected[keys[i]]; exists {
+			st2.Update([]byte(keys[i]), value)
+		}
+	}
+	if !bytes.Equal(st.Root(), st2.Root()) {
+		t.Error("root depends on the order of updates")
+	}
+
+	// Deleting every key should restore the empty root and remove every
+	// stored node.
+	for _, key := range keys {
+		st.Delete([]byte(key))
+	}
+	if !bytes.Equal(st.Root(), emptyRoot) {
+		t.Error("root of emptied tree does not match the empty root")
+	}
+	if len(st.nodes) != 0 {
+		t.Error("emptied tree still stores nodes:", len(st.nodes))
+	}
+}
+
+// TestSparseTreeBadProofs checks that malformed proofs are rejected.
+func TestSparseTreeBadProofs(t *testing.T) {
+	st := NewSparseTree(sha256.New())
+	st.Update([]byte("foo"), []byte("bar"))
+	root, proofSet, err := st.ProveInclusion([]byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifySparseInclusion(sha256.New(), nil, []byte("foo"), proofSet) {
+		t.Error("proof verified against a nil root")
+	}
+	if VerifySparseInclusion(sha256.New(), root, []byte("foo"), proofSet[:len(proofSet)-1]) {
+		t.Error("truncated proof verified")
+	}
+	if VerifySparseInclusion(sha256.New(), root, []byte("foo"), nil) {
+		t.Error("empty proof verified")
+	}
+	if VerifySparseInclusion(sha256.New(), root, []byte("baz"), proofSet) {
+		t.Error("proof verified for the wrong key")
+	}
+	proofSet[0] = []byte("baz")
+	if VerifySparseInclusion(sha256.New(), root, []byte("foo"), proofSet) {
+		t.Error("proof verified for the wrong value")
+	}
+}
@@ -0,0 +1,154 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// stackEncodingVersion is the version of the encoding produced by
+// Stack.MarshalBinary. The encoding is the version, then the hash size and the
+// number of leaves as 8 byte little-endian integers, then the root of every
+// subtree in the Stack from the tallest to the shortest. The heights of the
+// subtrees are the set bits of the number of leaves, so they are not encoded.
+const (
+	stackEncodingVersion    = 1
+	stackEncodingHeaderSize = 1 + 8 + 8
+)
+
+// A Stack computes a running Merkle root from leaf hashes and subtree roots
+// that have already been computed elsewhere. Like a Tree, it holds one subtree
+// per set bit of the number of leaves, and appending a leaf is binary addition
+// on the subtrees: equal height subtrees are joined until no two heights
+// match. Unlike a Tree, a Stack never hashes leaf data and can't build proofs.
+// The memory footprint of a Stack grows in O(log(n)) in the number of leaves.
+type Stack struct {
+	hash      hash.Hash
+	stack     []subTree
+	numLeaves uint64
+}
+
+// NewStack creates a new Stack. The provided hash will be used to join
+// subtrees.
+func NewStack(h hash.Hash) *Stack {
+	return &Stack{
+		hash: h,
+	}
+}
+
+// AppendLeafHash appends a leaf to the Stack. 'sum' is the leaf sum of the
+// leaf's data, as computed by H(0x00 || data), and not the data itself. The
+// Stack may retain 'sum'.
+func (s *Stack) AppendLeafHash(sum []byte) {
+	s.AppendSubtreeRoot(0, sum)
+}
+
+// AppendSubtreeRoot appends a complete subtree of 2^height leaves to the
+// Stack. The subtree can't be larger than the smallest subtree in the Stack,
+// which is the lowest set bit of NumLeaves, 'sum' must be the size of the
+// hash's output, and NumLeaves must still fit in a uint64 afterwards. The
+// Stack may retain 'sum'.
+func (s *Stack) AppendSubtreeRoot(height int, sum []byte) {
+	if height < 0 || height > MaxHeight {
+		panic("wrong usage: subtree height must be between 0 and 63")
+	} else if len(s.stack) > 0 && height > s.stack[len(s.stack)-1].height {
+		panic("wrong usage: can't append a subtree that is larger than the smallest subtree")
+	} else if len(sum) != s.hash.Size() {
+		panic("wrong usage: subtree root has the wrong size for the hash")
+	} else if s.numLeaves+1<<uint(height) < s.numLeaves {
+		panic("wrong usage: subtree would give the Stack more leaves than a uint64 can count")
+	}
+	s.stack = append(s.stack, subTree{height: height, sum: sum})
+	for len(s.stack) > 1 && s.stack[len(s.stack)-1].height == s.stack[len(s.stack)-2].height {
+		head, next := s.stack[len(s.stack)-1], s.stack[len(s.stack)-2]
+		s.stack = s.stack[:len(s.stack)-1]
+		s.stack[len(s.stack)-1] = joinSubTrees(s.hash, next, head)
+	}
+	s.numLeaves += 1 << uint(height)
+}
+
+// Root returns the Merkle root of the leaves that have been appended. The
+// root of an empty Stack is nil. Root does not modify the Stack.
+func (s *Stack) Root() []byte {
+	if len(s.stack) == 0 {
+		return nil
+	}
+	i := len(s.stack) - 1
+	root := s.stack[i].sum
+	for i--; i >= 0; i-- {
+		root = nodeSum(s.hash, s.stack[i].sum, root)
+	}
+	return root
+}
+
+// NumLeaves returns the number of leaves that have been appended, counting
+// every subtree as the 2^height leaves that it contains.
+func (s *Stack) NumLeaves() uint64 {
+	return s.numLeaves
+}
+
+// Reset returns the Stack to its empty state. The storage of the Stack is
+// reused.
+func (s *Stack) Reset() {
+	for i := range s.stack {
+		s.stack[i] = subTree{}
+	}
+	s.stack = s.stack[:0]
+	s.numLeaves = 0
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding holds the
+// subtrees, the number of leaves and the hash size of the Stack, but not the
+// hash itself.
+func (s *Stack) MarshalBinary() ([]byte, error) {
+	b := make([]byte, stackEncodingHeaderSize, stackEncodingHeaderSize+len(s.stack)*s.hash.Size())
+	b[0] = stackEncodingVersion
+	binary.LittleEndian.PutUint64(b[1:], uint64(s.hash.Size()))
+	binary.LittleEndian.PutUint64(b[9:], s.numLeaves)
+	for _, st := range s.stack {
+		b = append(b, st.sum...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The Stack must have
+// been created with NewStack, and the hash size of the encoded Stack must
+// match the size of its hash. Appending the remaining leaves to the loaded
+// Stack produces the same root as appending them to the Stack that was
+// marshaled. If 'b' is invalid, an error is returned and the Stack is not
+// modified.
+func (s *Stack) UnmarshalBinary(b []byte) error {
+	if s.hash == nil {
+		return errors.New("stack has no hash; create it with NewStack")
+	}
+	if len(b) < stackEncodingHeaderSize {
+		return errors.New("encoded stack is truncated")
+	}
+	if b[0] != stackEncodingVersion {
+		return errors.New("encoded stack has an unknown version")
+	}
+	hashSize := binary.LittleEndian.Uint64(b[1:])
+	if hashSize != uint64(s.hash.Size()) {
+		return errors.New("encoded stack has the wrong hash size")
+	}
+	numLeaves := binary.LittleEndian.Uint64(b[9:])
+	sums := b[stackEncodingHeaderSize:]
+	if uint64(len(sums)) != uint64(bits.OnesCount64(numLeaves))*hashSize {
+		return errors.New("encoded stack has the wrong number of subtrees for its number of leaves")
+	}
+
+	stack := make([]subTree, 0, bits.OnesCount64(numLeaves))
+	for height := 63; height >= 0; height-- {
+		if numLeaves&(1<<uint(height)) == 0 {
+			continue
+		}
+		stack = append(stack, subTree{
+			height: height,
+			sum:    append([]byte(nil), sums[:hashSize]...),
+		})
+		sums = sums[hashSize:]
+	}
+	s.stack, s.numLeaves = stack, numLeaves
+	return nil
+}
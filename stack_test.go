@@ -0,0 +1,172 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"strconv"
+	"testing"
+)
+
+// TestStack checks that a Stack built from leaf hashes produces the same root
+// as a Tree built from the leaf data, for every number of leaves up to 300.
+func TestStack(t *testing.T) {
+	tree := New(sha256.New())
+	s := NewStack(sha256.New())
+	if s.Root() != nil || s.NumLeaves() != 0 {
+		t.Error("empty Stack should have a nil root and no leaves")
+	}
+	for i := 0; i < 300; i++ {
+		data := []byte(strconv.Itoa(i))
+		tree.Push(data)
+		s.AppendLeafHash(leafSum(sha256.New(), data))
+		if !bytes.Equal(s.Root(), tree.Root()) {
+			t.Error("Stack root does not match Tree root", i+1)
+		}
+		if s.NumLeaves() != uint64(i+1) {
+			t.Error("Stack has the wrong number of leaves", i+1, s.NumLeaves())
+		}
+	}
+
+	// Root does not modify the Stack.
+	root := s.Root()
+	if !bytes.Equal(s.Root(), root) {
+		t.Error("calling Root twice produced different roots")
+	}
+
+	s.Reset()
+	if s.Root() != nil || s.NumLeaves() != 0 {
+		t.Error("Reset did not empty the Stack")
+	}
+}
+
+// TestStackSubtreeRoots checks that appending subtree roots produces the same
+// root as appending their leaves one at a time, and that invalid subtrees
+// cause a panic.
+func TestStackSubtreeRoots(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	for numLeaves, root := range mt.roots {
+		// Append one subtree per set bit of the number of leaves, from the
+		// largest to the smallest.
+		s := NewStack(sha256.New())
+		for height := 63; height >= 0; height-- {
+			if numLeaves&(1<<uint(height)) == 0 {
+				continue
+			}
+			sub := NewStack(sha256.New())
+			for j := s.NumLeaves(); j < s.NumLeaves()+1<<uint(height); j++ {
+				sub.AppendLeafHash(mt.leaves[j])
+			}
+			s.AppendSubtreeRoot(height, sub.Root())
+		}
+		if !bytes.Equal(s.Root(), root) {
+			t.Error("Stack of subtree roots has the wrong root", numLeaves)
+		}
+	}
+
+	s := NewStack(sha256.New())
+	s.AppendLeafHash(make([]byte, 32))
+	for _, fn := range []func(){
+		func() { s.AppendSubtreeRoot(1, make([]byte, 32)) },
+		func() { s.AppendSubtreeRoot(-1, make([]byte, 32)) },
+		func() { s.AppendLeafHash(make([]byte, 31)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic for an invalid subtree")
+				}
+			}()
+			fn()
+		}()
+	}
+	if s.NumLeaves() != 1 {
+		t.Error("an invalid subtree modified the Stack")
+	}
+
+	// A Stack can hold up to 2^64-1 leaves, but not more. 'lowest' is the
+	// height of the smallest subtree appended before the one that overflows.
+	for _, lowest := range []int{63, 0} {
+		s := NewStack(sha256.New())
+		for height := 63; height >= lowest; height-- {
+			s.AppendSubtreeRoot(height, make([]byte, 32))
+		}
+		numLeaves := s.NumLeaves()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic for a subtree past 2^64-1 leaves", lowest)
+				}
+			}()
+			s.AppendSubtreeRoot(lowest, make([]byte, 32))
+		}()
+		if s.NumLeaves() != numLeaves {
+			t.Error("an overflowing subtree modified the Stack", lowest)
+		}
+	}
+}
+
+// TestStackMarshal checks that a Stack marshaled at any point and loaded into
+// a new Stack produces the same root as an uninterrupted Stack when fed the
+// remaining leaves, and that invalid encodings are rejected.
+func TestStackMarshal(t *testing.T) {
+	const numLeaves = 100
+	leaves := make([][]byte, numLeaves)
+	full := NewStack(sha256.New())
+	for i := range leaves {
+		leaves[i] = leafSum(sha256.New(), []byte(strconv.Itoa(i)))
+		full.AppendLeafHash(leaves[i])
+	}
+
+	for _, checkpoint := range []int{0, 1, 2, 3, 31, 32, 64, 77, numLeaves - 1, numLeaves} {
+		s := NewStack(sha256.New())
+		for _, leaf := range leaves[:checkpoint] {
+			s.AppendLeafHash(leaf)
+		}
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed := NewStack(sha256.New())
+		if err := resumed.UnmarshalBinary(b); err != nil {
+			t.Fatal(checkpoint, err)
+		}
+		if resumed.NumLeaves() != uint64(checkpoint) || !bytes.Equal(resumed.Root(), s.Root()) {
+			t.Error("loaded Stack does not match the marshaled Stack", checkpoint)
+		}
+		for _, leaf := range leaves[checkpoint:] {
+			resumed.AppendLeafHash(leaf)
+		}
+		if !bytes.Equal(resumed.Root(), full.Root()) {
+			t.Error("resumed Stack has the wrong root", checkpoint)
+		}
+	}
+
+	// Invalid encodings are rejected without modifying the Stack.
+	b, _ := full.MarshalBinary()
+	s := NewStack(sha256.New())
+	s.AppendLeafHash(leaves[0])
+	bad := [][]byte{
+		nil,
+		b[:stackEncodingHeaderSize-1],
+		b[:len(b)-1],
+		append(append([]byte(nil), b...), 0),
+		append([]byte{stackEncodingVersion + 1}, b[1:]...),
+	}
+	for i, enc := range bad {
+		if err := s.UnmarshalBinary(enc); err == nil {
+			t.Error("invalid encoding was accepted", i)
+		}
+	}
+	if s.NumLeaves() != 1 || !bytes.Equal(s.Root(), leaves[0]) {
+		t.Error("a rejected encoding modified the Stack")
+	}
+
+	// A Stack with a different hash size can't load the encoding.
+	if err := NewStack(sha512.New()).UnmarshalBinary(b); err == nil {
+		t.Error("encoding with a different hash size was accepted")
+	}
+	if err := new(Stack).UnmarshalBinary(b); err == nil {
+		t.Error("Stack without a hash accepted an encoding")
+	}
+}
@@ -0,0 +1,130 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrTooManyElements is returned by ReadProof when a proof claims to have
+	// more elements than the caller allows.
+	ErrTooManyElements = errors.New("proof has more elements than allowed")
+
+	// ErrElementTooLarge is returned by ReadProof when the root or an element
+	// of a proof claims to be larger than the caller allows.
+	ErrElementTooLarge = errors.New("proof element is larger than allowed")
+)
+
+// WriteProof writes 'p' to 'w' as a one byte version header followed by a
+// sequence of frames. Every number is an 8 byte little-endian integer:
+//
+//	version, index, numLeaves, len(root), root, len(set), len(set[0]), set[0], ...
+//
+// Each piece is written to 'w' as it is produced, so the proof is never copied
+// into a single buffer.
+func WriteProof(w io.Writer, p Proof) error {
+	var buf [8]byte
+	writeUint64 := func(v uint64) error {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		_, err := w.Write(buf[:])
+		return err
+	}
+	writeBytes := func(b []byte) error {
+		if err := writeUint64(uint64(len(b))); err != nil {
+			return err
+		}
+		_, err := w.Write(b)
+		return err
+	}
+
+	if _, err := w.Write([]byte{proofVersionSingle}); err != nil {
+		return err
+	}
+	if err := writeUint64(p.Index); err != nil {
+		return err
+	}
+	if err := writeUint64(p.NumLeaves); err != nil {
+		return err
+	}
+	if err := writeBytes(p.Root); err != nil {
+		return err
+	}
+	if err := writeUint64(uint64(len(p.Set))); err != nil {
+		return err
+	}
+	for _, elem := range p.Set {
+		if err := writeBytes(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadProof reads a proof written by WriteProof. The number of proof set
+// elements is checked against 'maxElements', and the size of the root and of
+// every element against 'maxElementSize', before anything is allocated for
+// them, so a peer can't make ReadProof allocate more than the limits allow.
+// io.EOF is returned only if 'r' ends before the first byte of the proof; a
+// proof that is cut short returns io.ErrUnexpectedEOF. ErrUnknownProofVersion
+// is returned if the version header is not the one written by WriteProof. The
+// proof itself is not verified.
+func ReadProof(r io.Reader, maxElements, maxElementSize int) (Proof, error) {
+	var buf [8]byte
+	readUint64 := func() (uint64, error) {
+		_, err := io.ReadFull(r, buf[:])
+		return binary.LittleEndian.Uint64(buf[:]), err
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := readUint64()
+		if err != nil {
+			return nil, err
+		}
+		if maxElementSize < 0 || n > uint64(maxElementSize) {
+			return nil, ErrElementTooLarge
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+
+	// Only the very start of the proof may be a clean end of the stream.
+	fail := func(err error) (Proof, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Proof{}, err
+	}
+
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return Proof{}, err
+	}
+	if buf[0] != proofVersionSingle {
+		return Proof{}, ErrUnknownProofVersion
+	}
+	var p Proof
+	var err error
+	if p.Index, err = readUint64(); err != nil {
+		return fail(err)
+	}
+	if p.NumLeaves, err = readUint64(); err != nil {
+		return fail(err)
+	}
+	if p.Root, err = readBytes(); err != nil {
+		return fail(err)
+	}
+	numElements, err := readUint64()
+	if err != nil {
+		return fail(err)
+	}
+	if maxElements < 0 || numElements > uint64(maxElements) {
+		return Proof{}, ErrTooManyElements
+	}
+	p.Set = make([][]byte, numElements)
+	for i := range p.Set {
+		if p.Set[i], err = readBytes(); err != nil {
+			return fail(err)
+		}
+	}
+	return p, nil
+}
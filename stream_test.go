@@ -0,0 +1,168 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+)
+
+// limitedWriter accepts 'n' bytes and then fails every write.
+type limitedWriter struct {
+	n int
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		written := w.n
+		w.n = 0
+		return written, errors.New("write limit reached")
+	}
+	w.n -= len(b)
+	return len(b), nil
+}
+
+// TestProofStreamRoundTrip writes proofs and reads them back through readers
+// that return short reads.
+func TestProofStreamRoundTrip(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	proofs := []Proof{
+		{Root: mt.roots[15], Set: mt.proofSets[15][10], Index: 10, NumLeaves: 15},
+		{Root: mt.roots[1], Set: mt.proofSets[1][0], Index: 0, NumLeaves: 1},
+		{},
+	}
+	for i, p := range proofs {
+		var buf bytes.Buffer
+		if err := WriteProof(&buf, p); err != nil {
+			t.Fatal(err)
+		}
+		encoded := buf.Bytes()
+		readers := []io.Reader{
+			bytes.NewReader(encoded),
+			iotest.OneByteReader(bytes.NewReader(encoded)),
+			iotest.HalfReader(bytes.NewReader(encoded)),
+			iotest.DataErrReader(bytes.NewReader(encoded)),
+		}
+		for j, r := range readers {
+			decoded, err := ReadProof(r, 64, 64)
+			if err != nil {
+				t.Fatal("unable to read proof", i, j, err)
+			}
+			if !proofsEqual(decoded, p) {
+				t.Error("proof did not survive a round trip", i, j)
+			}
+		}
+	}
+
+	// Several proofs written to one stream are read back in order, followed
+	// by io.EOF.
+	var buf bytes.Buffer
+	for _, p := range proofs {
+		if err := WriteProof(&buf, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := iotest.OneByteReader(&buf)
+	for i, p := range proofs {
+		decoded, err := ReadProof(r, 64, 64)
+		if err != nil || !proofsEqual(decoded, p) {
+			t.Fatal("proof read from a shared stream does not match", i, err)
+		}
+	}
+	if _, err := ReadProof(r, 64, 64); err != io.EOF {
+		t.Error("expected io.EOF at the end of the stream, got", err)
+	}
+}
+
+// TestProofStreamPipe sends a proof over a net.Pipe.
+func TestProofStreamPipe(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	p := Proof{Root: mt.roots[15], Set: mt.proofSets[15][10], Index: 10, NumLeaves: 15}
+	client, server := net.Pipe()
+	defer server.Close()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- WriteProof(client, p)
+		client.Close()
+	}()
+	decoded, err := ReadProof(server, 64, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if !proofsEqual(decoded, p) {
+		t.Error("proof sent over a pipe does not match")
+	}
+}
+
+// TestProofStreamTruncated checks that every strict prefix of an encoded proof
+// is rejected, and that a write failing at any byte is reported.
+func TestProofStreamTruncated(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	p := Proof{Root: mt.roots[15], Set: mt.proofSets[15][10], Index: 10, NumLeaves: 15}
+	var buf bytes.Buffer
+	if err := WriteProof(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	for n := 0; n < len(encoded); n++ {
+		_, err := ReadProof(bytes.NewReader(encoded[:n]), 64, 64)
+		if n == 0 && err != io.EOF {
+			t.Error("expected io.EOF for an empty stream, got", err)
+		} else if n > 0 && err != io.ErrUnexpectedEOF {
+			t.Error("expected io.ErrUnexpectedEOF for a truncated proof", n, err)
+		}
+		if WriteProof(&limitedWriter{n: n}, p) == nil {
+			t.Error("write failure was not reported", n)
+		}
+	}
+	if WriteProof(&limitedWriter{n: len(encoded)}, p) != nil {
+		t.Error("write with exactly enough space failed")
+	}
+}
+
+// TestProofStreamLimits checks that the limits passed to ReadProof are
+// inclusive and are enforced before allocating.
+func TestProofStreamLimits(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	p := Proof{Root: mt.roots[15], Set: mt.proofSets[15][10], Index: 10, NumLeaves: 15}
+	var buf bytes.Buffer
+	if err := WriteProof(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	if _, err := ReadProof(bytes.NewReader(encoded), len(p.Set), 32); err != nil {
+		t.Error("proof at exactly the limits was rejected:", err)
+	}
+	if _, err := ReadProof(bytes.NewReader(encoded), len(p.Set)-1, 32); err != ErrTooManyElements {
+		t.Error("expected ErrTooManyElements, got", err)
+	}
+	if _, err := ReadProof(bytes.NewReader(encoded), len(p.Set), 31); err != ErrElementTooLarge {
+		t.Error("expected ErrElementTooLarge, got", err)
+	}
+
+	// A header claiming a billion elements, or an enormous root, must be
+	// rejected without reading or allocating anything else.
+	header := func(fields ...uint64) []byte {
+		b := make([]byte, 1+8*len(fields))
+		b[0] = proofVersionSingle
+		for i, f := range fields {
+			binary.LittleEndian.PutUint64(b[1+8*i:], f)
+		}
+		return b
+	}
+	if _, err := ReadProof(bytes.NewReader(header(0, 1, 0, 1e9)), 64, 64); err != ErrTooManyElements {
+		t.Error("expected ErrTooManyElements for a huge element count, got", err)
+	}
+	if _, err := ReadProof(bytes.NewReader(header(0, 1, 1<<63)), 64, 64); err != ErrElementTooLarge {
+		t.Error("expected ErrElementTooLarge for a huge root, got", err)
+	}
+	if _, err := ReadProof(bytes.NewReader(header(0, 1, 0, 1, 1<<40)), 64, 64); err != ErrElementTooLarge {
+		t.Error("expected ErrElementTooLarge for a huge element, got", err)
+	}
+}
@@ -0,0 +1,94 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"io"
+)
+
+// A rootTee is the writer returned by RootTee.
+type rootTee struct {
+	dst         io.Writer
+	hash        hash.Hash
+	segmentSize int
+	stack       *Stack
+
+	// segment holds the bytes of the leaf that is being filled. err is the
+	// first error returned by 'dst', which is returned by every later call.
+	segment []byte
+	err     error
+}
+
+// RootTee returns a writer that copies everything written to it to 'dst', and
+// computes the Merkle root of the written data split into leaves of
+// 'segmentSize' bytes, the same root that ReaderRoot computes for the data.
+// Only the bytes that 'dst' accepts are hashed. If 'dst' returns an error or
+// writes fewer bytes than it was given, the write returns the error, or
+// io.ErrShortWrite, immediately, and every later write fails with the same
+// error.
+//
+// After the last write, 'finish' returns the root, the number of leaves, and
+// the first error that the writer returned. The writer must not be used after
+// 'finish' is called.
+func RootTee(dst io.Writer, h hash.Hash, segmentSize int) (w io.Writer, finish func() (root []byte, numLeaves uint64, err error)) {
+	t := &rootTee{
+		dst:         dst,
+		hash:        h,
+		segmentSize: segmentSize,
+		stack:       NewStack(h),
+	}
+	if segmentSize <= 0 {
+		t.err = errors.New("segment size must be positive")
+	}
+	return t, t.finish
+}
+
+// Write implements io.Writer.
+func (t *rootTee) Write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	n, err := t.dst.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	t.err = err
+	t.push(p[:n])
+	return n, err
+}
+
+// push splits 'data' into leaves, filling the partial leaf left by the
+// previous write first. Whole leaves in 'data' are hashed without being
+// copied.
+func (t *rootTee) push(data []byte) {
+	if len(t.segment) > 0 {
+		n := copy(t.segment[len(t.segment):t.segmentSize], data)
+		t.segment = t.segment[:len(t.segment)+n]
+		data = data[n:]
+		if len(t.segment) < t.segmentSize {
+			return
+		}
+		t.stack.AppendLeafHash(leafSum(t.hash, t.segment))
+		t.segment = t.segment[:0]
+	}
+	for len(data) >= t.segmentSize {
+		t.stack.AppendLeafHash(leafSum(t.hash, data[:t.segmentSize]))
+		data = data[t.segmentSize:]
+	}
+	if len(data) > 0 {
+		if t.segment == nil {
+			t.segment = make([]byte, 0, t.segmentSize)
+		}
+		t.segment = append(t.segment, data...)
+	}
+}
+
+// finish pushes the partial final leaf, if there is one, and returns the root
+// of the data written so far.
+func (t *rootTee) finish() ([]byte, uint64, error) {
+	if len(t.segment) > 0 {
+		t.stack.AppendLeafHash(leafSum(t.hash, t.segment))
+		t.segment = t.segment[:0]
+	}
+	return t.stack.Root(), t.stack.NumLeaves(), t.err
+}
@@ -0,0 +1,104 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestRootTee copies data of awkward sizes through RootTee in writes of
+// varying sizes, and compares the root to ReaderRoot over the copied data.
+func TestRootTee(t *testing.T) {
+	for _, size := range []int{0, 1, 7, 8, 9, 63, 64, 65, 1000, 4097} {
+		data := fastrand.Bytes(size)
+		for _, copyBuf := range []int{1, 3, 8, 100} {
+			var dst bytes.Buffer
+			w, finish := RootTee(&dst, sha256.New(), 8)
+			if _, err := io.CopyBuffer(w, struct{ io.Reader }{bytes.NewReader(data)}, make([]byte, copyBuf)); err != nil {
+				t.Fatal(err)
+			}
+			root, numLeaves, err := finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst.Bytes(), data) {
+				t.Fatal("data was not copied", size, copyBuf)
+			}
+			expected, err := ReaderRoot(bytes.NewReader(dst.Bytes()), sha256.New(), 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(root, expected) || numLeaves != numSegments(int64(size), 8) {
+				t.Error("RootTee computed the wrong root", size, copyBuf, numLeaves)
+			}
+		}
+
+		// A single write of all of the data.
+		w, finish := RootTee(ioutil.Discard, sha256.New(), 8)
+		w.Write(data)
+		root, _, _ := finish()
+		expected, _ := ReaderRoot(bytes.NewReader(data), sha256.New(), 8)
+		if !bytes.Equal(root, expected) {
+			t.Error("RootTee computed the wrong root for a single write", size)
+		}
+	}
+}
+
+// TestRootTeeErrors checks that errors and short writes from the destination
+// are returned immediately and by 'finish', and that only the bytes that were
+// written are hashed.
+func TestRootTeeErrors(t *testing.T) {
+	data := fastrand.Bytes(100)
+
+	// A destination that accepts 50 bytes and then fails.
+	dst := &limitedWriter{n: 50}
+	w, finish := RootTee(dst, sha256.New(), 8)
+	if _, err := w.Write(data[:40]); err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Write(data[40:])
+	if err == nil || n != 10 {
+		t.Fatal("short write was not reported", n, err)
+	}
+	if _, err2 := w.Write(data[50:]); err2 != err {
+		t.Error("later write did not return the first error", err2)
+	}
+	root, numLeaves, finishErr := finish()
+	if finishErr != err {
+		t.Error("finish did not return the write error", finishErr)
+	}
+	expected, _ := ReaderRoot(bytes.NewReader(data[:50]), sha256.New(), 8)
+	if !bytes.Equal(root, expected) || numLeaves != 7 {
+		t.Error("RootTee hashed bytes that were not written")
+	}
+
+	// A destination that writes less without returning an error.
+	w, finish = RootTee(shortWriter{}, sha256.New(), 8)
+	if _, err := w.Write(data); err != io.ErrShortWrite {
+		t.Error("expected io.ErrShortWrite, got", err)
+	}
+	if _, _, err := finish(); err != io.ErrShortWrite {
+		t.Error("finish did not return io.ErrShortWrite", err)
+	}
+
+	w, finish = RootTee(ioutil.Discard, sha256.New(), 0)
+	if _, err := w.Write(data); err == nil {
+		t.Error("segment size of 0 was accepted")
+	}
+	if _, _, err := finish(); err == nil {
+		t.Error("finish did not return the segment size error")
+	}
+}
+
+// shortWriter is an io.Writer that accepts half of every write without
+// returning an error.
+type shortWriter struct{}
+
+// Write implements io.Writer.
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
@@ -0,0 +1,165 @@
+// Package testvectors generates canonical test vectors for the merkletree
+// package, so that implementations in other languages can check that they
+// build the same roots and proofs. Every vector is a tree over deterministic
+// data, with its root and the proof of every leaf. Vectors serialize to JSON
+// with every byte string hex encoded.
+package testvectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"strconv"
+
+	"github.com/NebulousLabs/merkletree"
+)
+
+// HexBytes is a byte string that is hex encoded in JSON.
+type HexBytes []byte
+
+// MarshalText implements encoding.TextMarshaler.
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (b *HexBytes) UnmarshalText(text []byte) error {
+	decoded, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// A ProofVector is the proof set of the leaf at Index, as returned by
+// Tree.Prove. The first element is the leaf data.
+type ProofVector struct {
+	Index uint64     `json:"index"`
+	Set   []HexBytes `json:"set"`
+}
+
+// A Vector is a tree of len(Leaves) leaves, its root, and the proof of every
+// leaf. The root of an empty tree is empty.
+type Vector struct {
+	Leaves []HexBytes    `json:"leaves"`
+	Root   HexBytes      `json:"root"`
+	Proofs []ProofVector `json:"proofs"`
+}
+
+// leafData returns the data of leaf 'i' of every vector: the leaf index as an
+// 8 byte big-endian integer, followed by 'i mod 3' bytes of 0xff, so that the
+// leaves are not all the same size.
+func leafData(i int) []byte {
+	data := make([]byte, 8, 8+i%3)
+	binary.BigEndian.PutUint64(data, uint64(i))
+	for j := 0; j < i%3; j++ {
+		data = append(data, 0xff)
+	}
+	return data
+}
+
+// Generate returns a vector for every tree size from 0 to 'maxLeaves'
+// leaves, hashed with hashes from 'h'.
+func Generate(h func() hash.Hash, maxLeaves int) []Vector {
+	vs := make([]Vector, 0, maxLeaves+1)
+	for numLeaves := 0; numLeaves <= maxLeaves; numLeaves++ {
+		v := Vector{
+			Leaves: make([]HexBytes, numLeaves),
+			Proofs: make([]ProofVector, numLeaves),
+		}
+		for i := range v.Leaves {
+			v.Leaves[i] = leafData(i)
+		}
+		v.Root = root(h, v.Leaves)
+		for i := range v.Proofs {
+			_, proofSet := prove(h, v.Leaves, uint64(i))
+			v.Proofs[i] = ProofVector{Index: uint64(i), Set: make([]HexBytes, len(proofSet))}
+			for j, elem := range proofSet {
+				v.Proofs[i].Set[j] = elem
+			}
+		}
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+// root returns the Merkle root of 'leaves'.
+func root(h func() hash.Hash, leaves []HexBytes) []byte {
+	tree := merkletree.New(h())
+	for _, leaf := range leaves {
+		tree.Push(leaf)
+	}
+	return tree.Root()
+}
+
+// prove returns the Merkle root of 'leaves' and the proof of the leaf at
+// 'index'.
+func prove(h func() hash.Hash, leaves []HexBytes, index uint64) ([]byte, [][]byte) {
+	tree := merkletree.New(h())
+	if err := tree.SetIndex(index); err != nil {
+		panic(err)
+	}
+	for _, leaf := range leaves {
+		tree.Push(leaf)
+	}
+	root, proofSet, _, _ := tree.Prove()
+	return root, proofSet
+}
+
+// Marshal returns the JSON encoding of 'vs'.
+func Marshal(vs []Vector) ([]byte, error) {
+	return json.MarshalIndent(vs, "", "\t")
+}
+
+// Unmarshal decodes vectors from their JSON encoding.
+func Unmarshal(b []byte) ([]Vector, error) {
+	var vs []Vector
+	if err := json.Unmarshal(b, &vs); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// Check re-verifies every vector using the public API of the merkletree
+// package, hashing with hashes from 'h'. The root must be the root of the
+// leaves, every proof must verify against it, and every proof must be the
+// proof that Tree.Prove builds. An error describing the first mismatch is
+// returned.
+func Check(h func() hash.Hash, vs []Vector) error {
+	for _, v := range vs {
+		numLeaves := strconv.Itoa(len(v.Leaves))
+		if !bytes.Equal(root(h, v.Leaves), v.Root) {
+			return errors.New("wrong root for " + numLeaves + " leaves")
+		}
+		if len(v.Proofs) != len(v.Leaves) {
+			return errors.New("wrong number of proofs for " + numLeaves + " leaves")
+		}
+		for i, p := range v.Proofs {
+			index := strconv.Itoa(i)
+			if p.Index != uint64(i) {
+				return errors.New("proof " + index + " of " + numLeaves + " leaves has the wrong index")
+			}
+			proofSet := make([][]byte, len(p.Set))
+			for j := range p.Set {
+				proofSet[j] = p.Set[j]
+			}
+			if !merkletree.VerifyProof(h(), v.Root, proofSet, p.Index, uint64(len(v.Leaves))) {
+				return errors.New("proof " + index + " of " + numLeaves + " leaves does not verify")
+			}
+			_, expected := prove(h, v.Leaves, p.Index)
+			if len(expected) != len(proofSet) {
+				return errors.New("proof " + index + " of " + numLeaves + " leaves has the wrong length")
+			}
+			for j := range expected {
+				if !bytes.Equal(expected[j], proofSet[j]) {
+					return errors.New("proof " + index + " of " + numLeaves + " leaves differs at element " + strconv.Itoa(j))
+				}
+			}
+		}
+	}
+	return nil
+}
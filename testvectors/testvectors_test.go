@@ -0,0 +1,86 @@
+package testvectors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/NebulousLabs/merkletree"
+)
+
+// TestGenerateCheck generates vectors, round trips them through JSON, and
+// checks them against the merkletree package.
+func TestGenerateCheck(t *testing.T) {
+	vs := Generate(sha256.New, 33)
+	if len(vs) != 34 {
+		t.Fatal("wrong number of vectors", len(vs))
+	}
+	b, err := Marshal(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(sha256.New, decoded); err != nil {
+		t.Fatal(err)
+	}
+	b2, err := Marshal(decoded)
+	if err != nil || !bytes.Equal(b, b2) {
+		t.Error("JSON round trip changed the vectors", err)
+	}
+
+	// The vectors are only valid for the hash that generated them.
+	if err := Check(sha512.New, decoded); err == nil {
+		t.Error("vectors checked with the wrong hash")
+	}
+	if err := Check(sha512.New, Generate(sha512.New, 9)); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCheckTampered checks that Check rejects vectors that have been
+// modified.
+func TestCheckTampered(t *testing.T) {
+	tampers := []func(vs []Vector){
+		func(vs []Vector) { vs[5].Root[0]++ },
+		func(vs []Vector) { vs[5].Leaves[2][0]++ },
+		func(vs []Vector) { vs[5].Proofs[3].Set[1][0]++ },
+		func(vs []Vector) { vs[5].Proofs[3].Set = vs[5].Proofs[3].Set[:1] },
+		func(vs []Vector) { vs[5].Proofs[3].Index = 4 },
+		func(vs []Vector) { vs[5].Proofs = vs[5].Proofs[:4] },
+	}
+	for i, tamper := range tampers {
+		vs := Generate(sha256.New, 8)
+		tamper(vs)
+		if err := Check(sha256.New, vs); err == nil {
+			t.Error("tampered vectors were accepted", i)
+		}
+	}
+	if _, err := Unmarshal([]byte(`[{"root": "zz"}]`)); err == nil {
+		t.Error("invalid hex was accepted")
+	}
+}
+
+// TestGenerateGolden pins the root of a few vectors, so that the leaf data
+// and the generated trees can't change without notice.
+func TestGenerateGolden(t *testing.T) {
+	vs := Generate(sha256.New, 5)
+	if len(vs[0].Root) != 0 {
+		t.Error("empty tree has a root")
+	}
+	if !bytes.Equal(vs[1].Root, merkletree.LeafSum(sha256.New(), leafData(0))) {
+		t.Error("root of one leaf is not its leaf sum")
+	}
+	for n, golden := range map[int]string{
+		1: "3e7077fd2f66d689e0cee6a7cf5b37bf2dca7c979af356d0a31cbc5c85605c7d",
+		5: "67354858d047854dfcba597987205469ac3d167ba1a0d968df9ac2bab6589e3b",
+	} {
+		if hex.EncodeToString(vs[n].Root) != golden {
+			t.Error("golden root changed", n, hex.EncodeToString(vs[n].Root))
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package merkletree
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"hash"
+	"math/bits"
 )
 
 // A Tree takes data as leaves and returns the Merkle root. Each call to 'Push'
@@ -14,12 +17,15 @@ type Tree struct {
 	// The Tree is stored as a stack of subtrees. Each subtree has a height,
 	// and is the Merkle root of 2^height leaves. A Tree with 11 nodes is
 	// represented as a subtree of height 3 (8 nodes), a subtree of height 1 (2
-	// nodes), and a subtree of height 0 (1 node). Head points to the smallest
-	// tree. When a new leaf is inserted, it is inserted as a subtree of height
-	// 0. If there is another subtree of the same height, both can be removed,
-	// combined, and then inserted as a subtree of height n + 1.
-	head *subTree
-	hash hash.Hash
+	// nodes), and a subtree of height 0 (1 node). The last element of the
+	// stack is the head, which is the smallest tree. When a new leaf is
+	// inserted, it is inserted as a subtree of height 0. If there is another
+	// subtree of the same height, both can be removed, combined, and then
+	// inserted as a subtree of height n + 1. The stack never holds more than
+	// one subtree per height, so it stays small and its storage is reused
+	// across pushes.
+	stack []subTree
+	hash  hash.Hash
 
 	// Helper variables used to construct proofs that the data at 'proofIndex'
 	// is in the Merkle tree. The proofSet is constructed as elements are being
@@ -36,17 +42,74 @@ type Tree struct {
 	// this flag is somewhat gross, but eliminates needing to duplicate the
 	// entire 'Push' function when writing the cached tree.
 	cachedTree bool
+
+	// hooks are the instrumentation hooks set by SetHooks, and stats counts
+	// the hashing done by the Tree.
+	hooks TreeHooks
+	stats TreeStats
+
+	// rootCallback, if set by SetRootCallback, is called with the root of
+	// the Tree after every Push and PushSubTree.
+	rootCallback func(numLeaves uint64, root []byte)
+
+	// leafCount, if set by TrackNumLeaves, keeps what ProveNumLeaves needs.
+	leafCount *numLeavesState
+
+	// indexedLeaves is set by IndexLeaves, and makes the leaf sums include
+	// the index of the leaf.
+	indexedLeaves bool
+
+	// fromEnd, if set by SetIndexFromEnd, keeps the last leaves so that the
+	// proof index can be chosen counting from the end.
+	fromEnd *fromEndState
+
+	// proofLeafHash is set by ProveLeafHashOnly, and makes Push record the
+	// leaf sum of the leaf at the proof index instead of its data.
+	proofLeafHash bool
+
+	// requireComplete is set by RequireCompleteTree, and makes RootErr,
+	// ProveErr and ReadAll fail unless the Tree holds a power of two leaves.
+	requireComplete bool
+
+	// subtreeHook, if set, is called with the sum of every subtree of height
+	// subtreeHookHeight that joinAllSubTrees puts at the head of the stack.
+	// It is only set while ReadAllFunc is running.
+	subtreeHook       func(index uint64, sum []byte)
+	subtreeHookHeight int
+
+	// scratch is a reusable buffer for sums that are consumed immediately
+	// after being computed, such as the sum of a leaf that is about to be
+	// joined and the intermediate sums of Root. A sum held in scratch must
+	// never be retained by the Tree.
+	scratch []byte
 }
 
 // A subTree contains the Merkle root of a complete (2^height leaves) subTree
-// of the Tree. 'sum' is the Merkle root of the subTree. If 'next' is not nil,
-// it will be a tree with a higher height.
+// of the Tree. 'sum' is the Merkle root of the subTree.
 type subTree struct {
-	next   *subTree
-	height int // Int is okay because a height over 300 is physically unachievable.
+	height int // Never more than MaxHeight.
 	sum    []byte
 }
 
+// MaxHeight is the greatest height of a subtree, which holds 2^MaxHeight
+// leaves. The number of leaves of a tree is a uint64, so a subtree of greater
+// height could not be counted, and the shifts that compute its number of
+// leaves would overflow.
+const MaxHeight = 63
+
+// ErrHeightTooLarge is returned when a subtree or cached node height is
+// greater than MaxHeight, or when pushing a subtree would give the tree more
+// leaves than a uint64 can count.
+var ErrHeightTooLarge = errors.New("height is greater than MaxHeight")
+
+// leafHashPrefix and nodeHashPrefix are the prefixes written to the hash
+// before leaf data and before a pair of sibling nodes respectively. They are
+// package variables so that writing them doesn't allocate.
+var (
+	leafHashPrefix = []byte{0}
+	nodeHashPrefix = []byte{1}
+)
+
 // sum returns the hash of the input data using the specified algorithm.
 func sum(h hash.Hash, data ...[]byte) []byte {
 	h.Reset()
@@ -57,33 +120,57 @@ func sum(h hash.Hash, data ...[]byte) []byte {
 	return h.Sum(nil)
 }
 
+// appendLeafSum appends the leaf sum of 'data' to 'dst'. The prefix and the
+// data are written to the hash directly, so the only allocation is the one
+// that might be needed to grow 'dst'.
+func appendLeafSum(dst []byte, h hash.Hash, data []byte) []byte {
+	if _, ok := h.(*sha256Hash); ok && len(data) <= sha256FastLeafSize {
+		return sha256LeafSum(dst, data)
+	}
+	h.Reset()
+	_, _ = h.Write(leafHashPrefix)
+	_, _ = h.Write(data)
+	return h.Sum(dst)
+}
+
+// appendNodeSum appends the node sum of 'a' and 'b' to 'dst'. Because 'a' and
+// 'b' are written to the hash before the sum is appended, it is safe for
+// either of them to share memory with 'dst'.
+func appendNodeSum(dst []byte, h hash.Hash, a, b []byte) []byte {
+	if _, ok := h.(*sha256Hash); ok && len(a) == sha256.Size && len(b) == sha256.Size {
+		return sha256NodeSum(dst, a, b)
+	}
+	h.Reset()
+	_, _ = h.Write(nodeHashPrefix)
+	_, _ = h.Write(a)
+	_, _ = h.Write(b)
+	return h.Sum(dst)
+}
+
 // leafSum returns the hash created from data inserted to form a leaf. Leaf
 // sums are calculated using:
 //		Hash(0x00 || data)
 func leafSum(h hash.Hash, data []byte) []byte {
-	return sum(h, []byte{0}, data)
+	return appendLeafSum(nil, h, data)
 }
 
 // nodeSum returns the hash created from two sibling nodes being combined into
 // a parent node. Node sums are calculated using:
 //		Hash(0x01 || left sibling sum || right sibling sum)
 func nodeSum(h hash.Hash, a, b []byte) []byte {
-	return sum(h, []byte{1}, a, b)
+	return appendNodeSum(nil, h, a, b)
 }
 
-// joinSubTrees combines two equal sized subTrees into a larger subTree.
-func joinSubTrees(h hash.Hash, a, b *subTree) *subTree {
+// joinSubTrees combines two equal sized subTrees into a larger subTree. 'a' is
+// the subTree on the left.
+func joinSubTrees(h hash.Hash, a, b subTree) subTree {
 	if DEBUG {
-		if b.next != a {
-			panic("invalid subtree join - 'a' is not paired with 'b'")
-		}
 		if a.height < b.height {
 			panic("invalid subtree presented - height mismatch")
 		}
 	}
 
-	return &subTree{
-		next:   a.next,
+	return subTree{
 		height: a.height + 1,
 		sum:    nodeSum(h, a.sum, b.sum),
 	}
@@ -101,17 +188,30 @@ func New(h hash.Hash) *Tree {
 // SetIndex) is an element of the Merkle tree. Prove will return a nil proof
 // set if used incorrectly. Prove does not modify the Tree. Prove can only be
 // called if SetIndex has been called previously.
+//
+// The elements of the proof set are shared with the Tree and with the proof
+// sets returned by other calls to Prove, so they must be treated as read-only.
+// Sharing them means that repeated calls to Prove cost O(log n) regardless of
+// the size of the proven data, and pushing more leaves after calling Prove
+// does not affect proofs that were already returned.
 func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) {
+	if t.fromEnd != nil {
+		return t.proveFromEnd()
+	}
 	if !t.proofTree {
 		panic("wrong usage: can't call prove on a tree if SetIndex wasn't called")
 	}
 
 	// Return nil if the Tree is empty, or if the proofIndex hasn't yet been
 	// reached.
-	if t.head == nil || len(t.proofSet) == 0 {
+	if t.IsEmpty() || len(t.proofSet) == 0 {
 		return t.Root(), nil, t.proofIndex, t.currentIndex
 	}
-	proofSet = t.proofSet
+	// The elements recorded so far are shared with the returned proof set
+	// rather than copied, since the Tree never modifies them. The capacity is
+	// capped so that the appends below copy only the slice of references,
+	// and can't write into space that later pushes will append to.
+	proofSet = t.proofSet[:len(t.proofSet):len(t.proofSet)]
 
 	// The set of subtrees must now be collapsed into a single root. The proof
 	// set already contains all of the elements that are members of a complete
@@ -128,16 +228,21 @@ func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64,
 	// it would be combining with the subtree that contains the proof index. We
 	// can recognize the subtree containing the proof index because the height
 	// of that subtree will be one less than the current length of the proof
-	// set.
-	current := t.head
-	for current.next != nil && current.next.height < len(proofSet)-1 {
-		current = joinSubTrees(t.hash, current.next, current)
+	// set. 'i' is the position in the stack of the next subtree that has not
+	// been combined into 'current'.
+	i := len(t.stack) - 1
+	current := t.stack[i]
+	for i > 0 && t.stack[i-1].height < len(proofSet)-1 {
+		t.countNode(t.stack[i-1].sum, current.sum)
+		current = joinSubTrees(t.hash, t.stack[i-1], current)
+		i--
 	}
+	i--
 
-	// Sanity check - check that either 'current' or 'current.next' is the
+	// Sanity check - check that either 'current' or the next subtree is the
 	// subtree containing the proof index.
 	if DEBUG {
-		if current.height != len(t.proofSet)-1 && (current.next != nil && current.next.height != len(t.proofSet)-1) {
+		if current.height != len(t.proofSet)-1 && (i >= 0 && t.stack[i].height != len(t.proofSet)-1) {
 			panic("could not find the subtree containing the proof index")
 		}
 	}
@@ -146,21 +251,17 @@ func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64,
 	// then it must be an aggregate subtree that is to the right of the subtree
 	// containing the proof index, and the next subtree is the subtree
 	// containing the proof index.
-	if current.next != nil && current.next.height == len(proofSet)-1 {
+	if i >= 0 && t.stack[i].height == len(proofSet)-1 {
 		proofSet = append(proofSet, current.sum)
-		current = current.next
+		i--
 	}
 
-	// The current subtree must be the subtree containing the proof index. This
-	// subtree does not need an entry, as the entry was created during the
-	// construction of the Tree. Instead, skip to the next subtree.
-	current = current.next
-
-	// All remaining subtrees will be added to the proof set as a left sibling,
-	// completing the proof set.
-	for current != nil {
-		proofSet = append(proofSet, current.sum)
-		current = current.next
+	// The subtree containing the proof index does not need an entry, as the
+	// entry was created during the construction of the Tree. All remaining
+	// subtrees will be added to the proof set as a left sibling, completing
+	// the proof set.
+	for ; i >= 0; i-- {
+		proofSet = append(proofSet, t.stack[i].sum)
 	}
 	return t.Root(), proofSet, t.proofIndex, t.currentIndex
 }
@@ -170,44 +271,78 @@ func (t *Tree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64,
 // log(n) elements that are necessary to build the Merkle root and keeping the
 // log(n) elements necessary to build a proof that a piece of data is in the
 // Merkle tree.
+//
+// Pushing nil is the same as pushing an empty slice: both are an empty leaf,
+// whose leaf sum is the hash of the 0x00 prefix alone. If the empty leaf is
+// at the proof index, the proof set starts with an empty, non-nil slice
+// either way, so that encoders that treat nil specially see one form.
 func (t *Tree) Push(data []byte) {
+	if data == nil {
+		data = []byte{}
+	}
+
 	// The first element of a proof is the data at the proof index. If this
 	// data is being inserted at the proof index, it is added to the proof set.
 	if t.currentIndex == t.proofIndex {
 		t.proofSet = append(t.proofSet, data)
 	}
 
+	if t.leafCount != nil {
+		if t.cachedTree {
+			t.leafCount.stop()
+		}
+		t.leafCount.pushLeaf(t.stack, data)
+	}
+
 	// Hash the data to create a subtree of height 0. The sum of the new node
 	// is going to be the data for cached trees, and is going to be the result
 	// of calling leafSum() on the data for standard trees. Doing a check here
 	// prevents needing to duplicate the entire 'Push' function for the trees.
-	t.head = &subTree{
-		next:   t.head,
-		height: 0,
-	}
+	var leaf []byte
 	if t.cachedTree {
-		t.head.sum = data
+		if DEBUG && len(data) != t.HashSize() {
+			panic("cached node root has the wrong size for the hash")
+		}
+		leaf = data
+	} else if len(t.stack) > 0 && t.stack[len(t.stack)-1].height == 0 && len(t.proofSet) != 1 {
+		// The new leaf is about to be joined with the previous leaf, and the
+		// join will not add either leaf to the proof set, so the leaf sum can
+		// live in the scratch buffer.
+		t.countLeaf(data)
+		t.scratch = t.appendLeafSum(t.scratch[:0], data)
+		leaf = t.scratch
 	} else {
-		t.head.sum = leafSum(t.hash, data)
+		t.countLeaf(data)
+		leaf = t.appendLeafSum(nil, data)
+		if t.proofLeafHash && t.currentIndex == t.proofIndex {
+			t.proofSet[0] = leaf
+		}
 	}
+	if t.hooks.OnLeaf != nil {
+		t.hooks.OnLeaf(t.currentIndex, leaf)
+	}
+	if t.fromEnd != nil {
+		t.pushFromEnd(data, leaf)
+	}
+	t.stack = append(t.stack, subTree{
+		height: 0,
+		sum:    leaf,
+	})
 
 	// Join subTrees if possible.
 	t.joinAllSubTrees()
 
 	// Update the index.
 	t.currentIndex++
+	t.callRootCallback()
 
 	// Sanity check - From head to tail of the stack, the height should be
 	// strictly increasing.
 	if DEBUG {
-		current := t.head
-		height := current.height
-		for current.next != nil {
-			current = current.next
-			if current.height <= height {
+		for i := len(t.stack) - 1; i > 0; i-- {
+			if t.stack[i-1].height <= t.stack[i].height {
 				panic("subtrees are out of order")
 			}
-			height = current.height
 		}
 	}
 }
@@ -217,102 +352,436 @@ func (t *Tree) Push(data []byte) {
 // balanced and it can't contain the element that needs to be proven.  Since we
 // can't tell if a subTree is balanced, we can't sanity check for unbalanced
 // trees. Therefore an unbalanced tree will cause silent errors, pain and
-// misery for the person who wants to debug the resulting error.
+// misery for the person who wants to debug the resulting error. A nil or empty
+// sum is rejected, the same as any sum that is not the size of the hash's
+// output.
 func (t *Tree) PushSubTree(height int, sum []byte) error {
+	if height < 0 {
+		return errors.New("subtree height is negative")
+	}
+	if height > MaxHeight {
+		return ErrHeightTooLarge
+	}
+	newIndex := t.currentIndex + 1<<uint64(height)
+	if newIndex < t.currentIndex {
+		return ErrHeightTooLarge
+	}
+
 	// Check if the cached tree that is pushed contains the element at
 	// proofIndex. This is not allowed.
-	newIndex := t.currentIndex + 1<<uint64(height)
 	if t.proofTree && (t.currentIndex == t.proofIndex ||
 		(t.currentIndex < t.proofIndex && t.proofIndex < newIndex)) {
 		return errors.New("the cached tree shouldn't contain the element to prove")
 	}
 
+	// The sum of the subtree must be the size of the hash's output.
+	if len(sum) != t.HashSize() {
+		return errors.New("subtree sum has the wrong size for the hash")
+	}
+
 	// We can only add the cached tree if its depth is <= the depth of the
 	// current subtree.
-	if t.head != nil && height > t.head.height {
+	if len(t.stack) > 0 && height > t.stack[len(t.stack)-1].height {
 		return errors.New("can't add a subtree that is larger than the smallest subtree")
 	}
 
+	// The leaves of a subtree are unknown, so they can't be kept in the
+	// window of a Tree that proves a leaf from the end.
+	if t.fromEnd != nil {
+		return errors.New("can't push a subtree into a tree that proves a leaf from the end")
+	}
+
+	// The leaves of the subtree are unknown, so the number of leaves can no
+	// longer be proven.
+	if t.leafCount != nil {
+		t.leafCount.stop()
+	}
+
 	// Insert the cached tree as the new head.
-	t.head = &subTree{
+	t.stack = append(t.stack, subTree{
 		height: height,
-		next:   t.head,
 		sum:    sum,
-	}
+	})
 
 	// Join subTrees if possible.
 	t.joinAllSubTrees()
 
 	// Update the index.
 	t.currentIndex = newIndex
+	t.callRootCallback()
 
 	// Sanity check - From head to tail of the stack, the height should be
 	// strictly increasing.
 	if DEBUG {
-		current := t.head
-		height := current.height
-		for current.next != nil {
-			current = current.next
-			if current.height <= height {
+		for i := len(t.stack) - 1; i > 0; i-- {
+			if t.stack[i-1].height <= t.stack[i].height {
 				panic("subtrees are out of order")
 			}
-			height = current.height
 		}
 	}
 	return nil
 }
 
+// PadTo appends copies of 'padLeaf' to the Tree until it holds a power of two
+// leaves, as if each copy had been pushed with Push. An empty Tree is left
+// empty. The root of a full subtree of copies only depends on its height, so
+// the padding is pushed as O(log n) subtrees whose roots are each built with
+// one hash, rather than one leaf at a time. If the proof index is among the
+// padding, the copy at the proof index is pushed with Push so that Prove can
+// prove it. For a CachedTree, 'padLeaf' is a cached node root, the same as
+// the data passed to Push.
+func (t *Tree) PadTo(padLeaf []byte) {
+	if t.IsEmpty() {
+		return
+	}
+
+	// Every copy of 'padLeaf' has a different leaf sum when the leaf sums
+	// include the index, and every copy must be kept in the window of a Tree
+	// that proves a leaf from the end, so the copies are pushed one at a
+	// time.
+	if (t.indexedLeaves || t.fromEnd != nil) && !t.cachedTree {
+		for len(t.stack) > 1 {
+			t.Push(padLeaf)
+		}
+		return
+	}
+
+	// padSums[k] is the root of a full subtree of 2^k copies of 'padLeaf'.
+	var padSums [][]byte
+	if t.cachedTree {
+		padSums = append(padSums, padLeaf)
+	} else {
+		t.countLeaf(padLeaf)
+		padSums = append(padSums, leafSum(t.hash, padLeaf))
+	}
+	padSum := func(height int) []byte {
+		for len(padSums) <= height {
+			last := padSums[len(padSums)-1]
+			t.countNode(last, last)
+			padSums = append(padSums, nodeSum(t.hash, last, last))
+		}
+		return padSums[height]
+	}
+
+	// Until the tree is complete, the largest subtree that can be pushed is
+	// the size of the smallest subtree in the stack, which is the lowest set
+	// bit of the number of leaves. Pushing it doubles that subtree.
+	for len(t.stack) > 1 {
+		height := bits.TrailingZeros64(t.currentIndex)
+		if t.proofTree && t.proofIndex >= t.currentIndex && t.proofIndex-t.currentIndex < 1<<uint(height) {
+			if t.proofIndex == t.currentIndex {
+				t.Push(padLeaf)
+				continue
+			}
+			// Push the largest subtree that ends at or before the proof
+			// index.
+			height = bits.Len64(t.proofIndex-t.currentIndex) - 1
+		}
+		if err := t.PushSubTree(height, padSum(height)); err != nil {
+			// The subtree never holds the proof index, and is never taller
+			// than the smallest subtree in the stack.
+			panic(err)
+		}
+	}
+}
+
 // Root returns the Merkle root of the data that has been pushed.
 func (t *Tree) Root() []byte {
 	// If the Tree is empty, return nil.
-	if t.head == nil {
+	if t.IsEmpty() {
 		return nil
 	}
 
 	// The root is formed by hashing together subTrees in order from least in
 	// height to greatest in height. The taller subtree is the first subtree in
-	// the join.
-	current := t.head
-	for current.next != nil {
-		current = joinSubTrees(t.hash, current.next, current)
+	// the join. The intermediate sums are only needed for the next join, so
+	// they are kept in the scratch buffer.
+	i := len(t.stack) - 1
+	if i == 0 {
+		return t.stack[0].sum
 	}
-	return current.sum
+	t.countNode(t.stack[i-1].sum, t.stack[i].sum)
+	t.scratch = appendNodeSum(t.scratch[:0], t.hash, t.stack[i-1].sum, t.stack[i].sum)
+	for i -= 2; i >= 0; i-- {
+		t.countNode(t.stack[i].sum, t.scratch)
+		t.scratch = appendNodeSum(t.scratch[:0], t.hash, t.stack[i].sum, t.scratch)
+	}
+	return append([]byte(nil), t.scratch...)
+}
+
+// ErrIncompleteTree is returned by a Tree that requires a complete tree, see
+// RequireCompleteTree, when it does not hold a power of two leaves.
+var ErrIncompleteTree = errors.New("tree does not hold a power of two leaves")
+
+// RequireCompleteTree makes RootErr, ProveErr and ReadAll return
+// ErrIncompleteTree unless the Tree is complete, meaning that it holds a
+// power of two leaves, counting every subtree pushed with PushSubTree as the
+// 2^height leaves that it contains. An empty Tree is not complete. Root and
+// Prove can't return an error, so they do not check. The requirement is kept
+// by Reset.
+func (t *Tree) RequireCompleteTree() {
+	t.requireComplete = true
+}
+
+// ProveLeafHashOnly makes the Tree record the leaf sum of the leaf at the
+// proof index, as returned by LeafSum, instead of its data, so that the Tree
+// does not hold on to a large leaf. The first element of the proof sets
+// returned by Prove is then the leaf sum, and the proof must be checked with
+// VerifyProofWithLeafHash. It must be called before the leaf at the proof
+// index is pushed. It has no effect on a CachedTree, whose proof sets start
+// with a cached node root. The setting is kept by Reset.
+func (t *Tree) ProveLeafHashOnly() {
+	t.proofLeafHash = true
+}
+
+// TreeHooks are functions that a Tree calls as it is built, so that its
+// construction can be traced or its nodes fed into an external cache. Each
+// hook is optional, and a nil hook costs nothing. Hooks are called
+// synchronously. The sums passed to a hook may live in a buffer that the Tree
+// reuses, so they are safe to read during the call but must be copied to be
+// retained.
+type TreeHooks struct {
+	// OnLeaf is called with the index and leaf sum of every leaf pushed with
+	// Push or PushReader, or read by ReadAll. Subtrees pushed with
+	// PushSubTree or PadTo are not reported. For a CachedTree, the sum is the
+	// cached node root and the index counts cached nodes.
+	OnLeaf func(index uint64, sum []byte)
+
+	// OnJoin is called every time two subtrees are joined, with the height
+	// of the new subtree and the sums of its children and of itself.
+	OnJoin func(height int, left, right, parent []byte)
+
+	// OnProofElement is called when a sibling of the proven leaf is added to
+	// the proof set while the tree is built, with the height of the sibling.
+	// The siblings that Prove takes from the subtrees that have not been
+	// joined are not reported.
+	OnProofElement func(height int, sum []byte)
+}
+
+// TreeStats counts the hashing done by a Tree. LeafHashes and NodeHashes are
+// the number of leaf sums and node sums computed, and BytesHashed is the
+// number of bytes written to the hash for them, including the one byte leaf
+// and node prefixes.
+type TreeStats struct {
+	LeafHashes  uint64
+	NodeHashes  uint64
+	BytesHashed uint64
+}
+
+// Stats returns the hashing done by the Tree so far. Every hash is counted,
+// including the hashes computed by Root and Prove, which are repeated every
+// time they are called. Hashing done by a BatchHasher is counted the same as
+// hashing done one sum at a time. The counts are kept by Reset.
+func (t *Tree) Stats() TreeStats {
+	return t.stats
+}
+
+// countLeaf counts the computation of the leaf sum of 'data'.
+func (t *Tree) countLeaf(data []byte) {
+	t.stats.LeafHashes++
+	t.stats.BytesHashed += uint64(len(leafHashPrefix) + len(data))
+	if t.indexedLeaves {
+		t.stats.BytesHashed += leafIndexSize
+	}
+}
+
+// countNode counts the computation of the node sum of 'a' and 'b'.
+func (t *Tree) countNode(a, b []byte) {
+	t.stats.NodeHashes++
+	t.stats.BytesHashed += uint64(len(nodeHashPrefix) + len(a) + len(b))
+}
+
+// SetHooks sets the instrumentation hooks of the Tree, replacing any that
+// were set before. The hooks are kept by Reset.
+func (t *Tree) SetHooks(hooks TreeHooks) {
+	t.hooks = hooks
+}
+
+// SetRootCallback makes the Tree call 'fn' after every Push and PushSubTree,
+// including the pushes made by ReadAll, PushReader and PadTo, with the number
+// of leaves and the root of the Tree at that size. For a CachedTree, the
+// number of leaves counts cached nodes. The root passed to 'fn' is a copy,
+// and may be retained. Computing it costs one node hash for every subtree in
+// the stack except the first, the same as Root, and is counted by Stats. A
+// nil 'fn' removes the callback. The callback is kept by Reset.
+func (t *Tree) SetRootCallback(fn func(numLeaves uint64, root []byte)) {
+	t.rootCallback = fn
+}
+
+// callRootCallback calls the root callback, if one is set, with the current
+// root of the Tree. Root only returns a copy when it joins subtrees, so the
+// sum of a lone subtree is copied here.
+func (t *Tree) callRootCallback() {
+	if t.rootCallback == nil {
+		return
+	}
+	root := t.Root()
+	if len(t.stack) == 1 {
+		root = append([]byte(nil), root...)
+	}
+	t.rootCallback(t.currentIndex, root)
+}
+
+// checkComplete returns ErrIncompleteTree if the Tree requires a complete tree
+// and isn't one. A Tree is complete when its stack holds a single subtree.
+func (t *Tree) checkComplete() error {
+	if t.requireComplete && len(t.stack) != 1 {
+		return ErrIncompleteTree
+	}
+	return nil
+}
+
+// RootErr is the same as Root, but returns ErrIncompleteTree if the Tree
+// requires a complete tree and isn't one.
+func (t *Tree) RootErr() ([]byte, error) {
+	if err := t.checkComplete(); err != nil {
+		return nil, err
+	}
+	return t.Root(), nil
+}
+
+// ProveErr is the same as Prove, but returns ErrIncompleteTree if the Tree
+// requires a complete tree and isn't one.
+func (t *Tree) ProveErr() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64, err error) {
+	if err := t.checkComplete(); err != nil {
+		return nil, nil, 0, 0, err
+	}
+	if t.fromEnd != nil && t.currentIndex <= t.fromEnd.k {
+		return nil, nil, 0, 0, ErrProofIndexOutOfRange
+	}
+	merkleRoot, proofSet, proofIndex, numLeaves = t.Prove()
+	return merkleRoot, proofSet, proofIndex, numLeaves, nil
 }
 
 // SetIndex will tell the Tree to create a storage proof for the leaf at the
 // input index. SetIndex must be called on an empty tree.
 func (t *Tree) SetIndex(i uint64) error {
-	if t.head != nil {
+	if !t.IsEmpty() {
 		return errors.New("cannot call SetIndex on Tree if Tree has not been reset")
 	}
 	t.proofTree = true
 	t.proofIndex = i
+	t.fromEnd = nil
 	return nil
 }
 
-// joinAllSubTrees inserts the subTree at t.head into the Tree. As long as the
-// height of the next subTree is the same as the height of the current subTree,
-// the two will be combined into a single subTree of height n+1.
+// HashSize returns the size of the sums produced by the Tree's hash, which is
+// the size of the root, of every subtree sum and of every proof set element
+// after the first.
+func (t *Tree) HashSize() int {
+	return t.hash.Size()
+}
+
+// IsEmpty returns true if nothing has been pushed into the Tree since it was
+// created or last reset. The behavior of an empty Tree is defined here, and
+// every other method defers to IsEmpty:
+//
+//	Root returns nil.
+//	Prove returns a nil root and a nil proof set.
+//	SetIndex may only be called on an empty Tree.
+func (t *Tree) IsEmpty() bool {
+	return len(t.stack) == 0
+}
+
+// CurrentIndex returns the index that the next leaf pushed into the Tree will
+// have, which is the number of leaves in the Tree. A subtree pushed with
+// PushSubTree counts as all of the leaves that it contains.
+func (t *Tree) CurrentIndex() uint64 {
+	return t.currentIndex
+}
+
+// ProofReached returns true if SetIndex has been called and the leaf at the
+// proof index has been pushed, meaning that Prove will return a proof set.
+func (t *Tree) ProofReached() bool {
+	if t.fromEnd != nil {
+		return t.currentIndex > t.fromEnd.k
+	}
+	return t.proofTree && t.currentIndex > t.proofIndex
+}
+
+// Equals returns true if 't' and 'other' hold the same stack of subtrees,
+// comparing the height and sum of every subtree, and have the same current
+// index. Two trees with the same root can still differ, for example when one
+// of them had an inner node pushed as a leaf. The hashes themselves cannot be
+// compared, but trees whose hashes have different sizes are never equal.
+// Proof state is ignored; see EqualsWithProofState.
+func (t *Tree) Equals(other *Tree) bool {
+	if t.HashSize() != other.HashSize() || t.currentIndex != other.currentIndex || len(t.stack) != len(other.stack) {
+		return false
+	}
+	for i := range t.stack {
+		if t.stack[i].height != other.stack[i].height || !bytes.Equal(t.stack[i].sum, other.stack[i].sum) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualsWithProofState returns true if 't' and 'other' are equal according to
+// Equals and are also building the same proof, meaning that they agree on
+// whether SetIndex was called, on the proof index and on the proof set
+// collected so far.
+func (t *Tree) EqualsWithProofState(other *Tree) bool {
+	if !t.Equals(other) || t.proofTree != other.proofTree || t.proofIndex != other.proofIndex || len(t.proofSet) != len(other.proofSet) {
+		return false
+	}
+	for i := range t.proofSet {
+		if !bytes.Equal(t.proofSet[i], other.proofSet[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset returns the Tree to the state it was in when it was created, keeping
+// the hash. The storage of the stack is kept so that it can be reused by the
+// next set of pushes. The proof index is cleared, so SetIndex must be called
+// again before building another proof.
+func (t *Tree) Reset() {
+	for i := range t.stack {
+		t.stack[i] = subTree{}
+	}
+	t.stack = t.stack[:0]
+	t.currentIndex = 0
+	t.proofIndex = 0
+	t.proofSet = nil
+	t.proofTree = false
+	t.fromEnd = nil
+	if t.leafCount != nil {
+		t.leafCount = &numLeavesState{broken: t.cachedTree}
+	}
+}
+
+// joinAllSubTrees inserts the subTree at the head of the stack into the Tree.
+// As long as the height of the next subTree is the same as the height of the
+// current subTree, the two will be combined into a single subTree of height
+// n+1.
 func (t *Tree) joinAllSubTrees() {
-	for t.head.next != nil && t.head.height == t.head.next.height {
+	t.callSubtreeHook()
+	for len(t.stack) > 1 && t.stack[len(t.stack)-1].height == t.stack[len(t.stack)-2].height {
+		head, next := &t.stack[len(t.stack)-1], &t.stack[len(t.stack)-2]
+
 		// Before combining subtrees, check whether one of the subtree hashes
 		// needs to be added to the proof set. This is going to be true IFF the
 		// subtrees being combined are one height higher than the previous
 		// subtree added to the proof set. The height of the previous subtree
 		// added to the proof set is equal to len(t.proofSet) - 1.
-		if t.head.height == len(t.proofSet)-1 {
+		if head.height == len(t.proofSet)-1 {
 			// One of the subtrees needs to be added to the proof set. The
 			// subtree that needs to be added is the subtree that does not
 			// contain the proofIndex. Because the subtrees being compared are
 			// the smallest and rightmost trees in the Tree, this can be
 			// determined by rounding the currentIndex down to the number of
 			// nodes in the subtree and comparing that index to the proofIndex.
-			leaves := uint64(1 << uint(t.head.height))
+			leaves := uint64(1 << uint(head.height))
 			mid := (t.currentIndex / leaves) * leaves
 			if t.proofIndex < mid {
-				t.proofSet = append(t.proofSet, t.head.sum)
+				t.proofSet = append(t.proofSet, head.sum)
 			} else {
-				t.proofSet = append(t.proofSet, t.head.next.sum)
+				t.proofSet = append(t.proofSet, next.sum)
+			}
+			if t.hooks.OnProofElement != nil {
+				t.hooks.OnProofElement(head.height, t.proofSet[len(t.proofSet)-1])
 			}
 
 			// Sanity check - the proofIndex should never be less than the
@@ -326,6 +795,29 @@ func (t *Tree) joinAllSubTrees() {
 
 		// Join the two subTrees into one subTree with a greater height. Then
 		// compare the new subTree to the next subTree.
-		t.head = joinSubTrees(t.hash, t.head.next, t.head)
+		if t.leafCount != nil {
+			t.leafCount.join(head.sum)
+		}
+		left, right := next.sum, head.sum
+		t.countNode(left, right)
+		*next = joinSubTrees(t.hash, *next, *head)
+		if t.hooks.OnJoin != nil {
+			t.hooks.OnJoin(next.height, left, right, next.sum)
+		}
+		t.stack = t.stack[:len(t.stack)-1]
+		t.callSubtreeHook()
+	}
+}
+
+// callSubtreeHook calls the subtree hook if the head of the stack has the
+// height that the hook is waiting for. The head may live in the scratch
+// buffer, so the hook is given a copy of its sum.
+func (t *Tree) callSubtreeHook() {
+	if t.subtreeHook == nil {
+		return
+	}
+	head := t.stack[len(t.stack)-1]
+	if head.height == t.subtreeHookHeight {
+		t.subtreeHook(t.currentIndex>>uint(head.height), append([]byte(nil), head.sum...))
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
 	"math/big"
 	"strconv"
 	"testing"
@@ -681,14 +683,14 @@ func TestPushSubTreeSimple(t *testing.T) {
 	tree := New(sha256.New())
 
 	// Add a subTree of height 5 to the empty tree.
-	if err := tree.PushSubTree(5, []byte{}); err != nil {
+	if err := tree.PushSubTree(5, make([]byte, sha256.Size)); err != nil {
 		t.Fatal(err)
 	}
 	if tree.Root() == nil {
 		t.Fatal("root should not be nil after adding a subTree")
 	}
 	// Add a subTree of a height >5 to the tree. This should not be possible.
-	if err := tree.PushSubTree(6, []byte{}); err == nil {
+	if err := tree.PushSubTree(6, make([]byte, sha256.Size)); err == nil {
 		t.Fatal("pushing a subTree with a larger height than the smallest subTree should fail")
 	}
 	// The current index should be 2^5
@@ -698,7 +700,7 @@ func TestPushSubTreeSimple(t *testing.T) {
 	}
 	// Add a subTree of the same height as the smallest subTree in the merkle
 	// tree and check again.
-	if err := tree.PushSubTree(5, []byte{}); err != nil {
+	if err := tree.PushSubTree(5, make([]byte, sha256.Size)); err != nil {
 		t.Fatal(err)
 	}
 	expectedIndex *= 2
@@ -714,7 +716,7 @@ func TestPushSubTreeSimple(t *testing.T) {
 		}
 	}
 	// Add a subTree of height 2 and check the index again.
-	if err := tree.PushSubTree(2, []byte{}); err != nil {
+	if err := tree.PushSubTree(2, make([]byte, sha256.Size)); err != nil {
 		t.Fatal(err)
 	}
 	expectedIndex += 4
@@ -731,7 +733,7 @@ func TestPushSubTreeSimple(t *testing.T) {
 	tree2.Push([]byte{})
 	tree2.Push([]byte{})
 	// Push a subTree of height 1. That should be fine.
-	if err := tree2.PushSubTree(1, []byte{}); err != nil {
+	if err := tree2.PushSubTree(1, make([]byte, sha256.Size)); err != nil {
 		t.Fatal(err)
 	}
 	// Create a new tree and set the proof index to 3. Afterwards we push twice
@@ -744,7 +746,7 @@ func TestPushSubTreeSimple(t *testing.T) {
 	tree3.Push([]byte{})
 	// Push a subTree of height 1. That shouldn't work since the subTree can't
 	// contain the piece for the proof.
-	if err := tree3.PushSubTree(1, []byte{}); err == nil {
+	if err := tree3.PushSubTree(1, make([]byte, sha256.Size)); err == nil {
 		t.Fatal("we shouldn't be able to push a subTree that contains the proof index")
 	}
 	// Create a new tree and set the proof index to 4. Afterwards we push twice
@@ -757,11 +759,113 @@ func TestPushSubTreeSimple(t *testing.T) {
 	tree4.Push([]byte{})
 	// Push a subTree of height 1. That shouldn't work since the subTree can't
 	// contain the piece for the proof.
-	if err := tree4.PushSubTree(1, []byte{}); err == nil {
+	if err := tree4.PushSubTree(1, make([]byte, sha256.Size)); err == nil {
 		t.Fatal("we shouldn't be able to push a subTree that contains the proof index")
 	}
 }
 
+// TestReset checks that a Tree that has been reset behaves like a new Tree,
+// and that Reset keeps the storage of the stack for reuse.
+func TestReset(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	tree := New(sha256.New())
+	if err := tree.SetIndex(3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 15; i++ {
+		tree.Push(mt.data[i])
+	}
+	root, proofSet, _, _ := tree.Prove()
+
+	tree.Reset()
+	if tree.Root() != nil {
+		t.Error("root of a reset tree should be nil")
+	}
+	if cap(tree.stack) == 0 {
+		t.Error("reset tree did not keep the storage of the stack")
+	}
+	if err := tree.SetIndex(10); err != nil {
+		t.Fatal("unable to call SetIndex after Reset:", err)
+	}
+	for i := 0; i < 15; i++ {
+		tree.Push(mt.data[i])
+	}
+	merkleRoot, proofSet2, proofIndex, numLeaves := tree.Prove()
+	if !bytes.Equal(merkleRoot, mt.roots[15]) {
+		t.Error("reset tree produced the wrong root")
+	}
+	if proofIndex != 10 || numLeaves != 15 {
+		t.Error("reset tree reported the wrong proof index or leaf count")
+	}
+	if len(proofSet2) != len(mt.proofSets[15][10]) {
+		t.Fatal("reset tree produced a proof of the wrong length")
+	}
+	for i := range proofSet2 {
+		if !bytes.Equal(proofSet2[i], mt.proofSets[15][10][i]) {
+			t.Error("reset tree produced the wrong proof at element", i)
+		}
+	}
+
+	// The proof returned before the reset should be unaffected.
+	if !VerifyProof(sha256.New(), root, proofSet, 3, 15) {
+		t.Error("proof built before the reset no longer verifies")
+	}
+}
+
+// TestPushAfterProve checks that pushing more leaves into a tree after calling
+// Prove does not modify the proof that was returned.
+func TestPushAfterProve(t *testing.T) {
+	for numLeaves := 1; numLeaves < 40; numLeaves++ {
+		for proofIndex := 0; proofIndex < numLeaves; proofIndex++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(uint64(proofIndex)); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < numLeaves; i++ {
+				tree.Push([]byte{byte(i)})
+			}
+			merkleRoot, proofSet, _, _ := tree.Prove()
+			for i := numLeaves; i < 100; i++ {
+				tree.Push([]byte{byte(i)})
+			}
+			if !VerifyProof(sha256.New(), merkleRoot, proofSet, uint64(proofIndex), uint64(numLeaves)) {
+				t.Error("pushing after Prove corrupted the proof for indices", numLeaves, proofIndex)
+			}
+
+			// The proof for the larger tree should also be correct.
+			merkleRoot, proofSet, _, _ = tree.Prove()
+			if !VerifyProof(sha256.New(), merkleRoot, proofSet, uint64(proofIndex), 100) {
+				t.Error("proof after more pushes did not verify for indices", numLeaves, proofIndex)
+			}
+		}
+	}
+}
+
+// TestProveAllocations checks that repeated calls to Prove do not copy the
+// recorded proof elements, by checking that the number of allocations does
+// not depend on the size of the proven leaf.
+func TestProveAllocations(t *testing.T) {
+	allocs := func(leafSize int) float64 {
+		tree := New(sha256.New())
+		if err := tree.SetIndex(5); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			tree.Push(make([]byte, leafSize))
+		}
+		return testing.AllocsPerRun(100, func() {
+			tree.Prove()
+		})
+	}
+	small, large := allocs(1), allocs(1<<16)
+	if small != large {
+		t.Errorf("Prove allocations depend on the leaf size: %v vs %v", small, large)
+	}
+	if small > 20 {
+		t.Errorf("Prove makes too many allocations: %v", small)
+	}
+}
+
 // BenchmarkSha256_4MB uses sha256 to hash 4mb of data.
 func BenchmarkSha256_4MB(b *testing.B) {
 	data := make([]byte, 4*1024*1024)
@@ -786,6 +890,7 @@ func BenchmarkTree64_4MB(b *testing.B) {
 	}
 	segmentSize := 64
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	tree := New(sha256.New())
 	for i := 0; i < b.N; i++ {
@@ -806,6 +911,7 @@ func BenchmarkTree4k_4MB(b *testing.B) {
 	}
 	segmentSize := 4096
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	tree := New(sha256.New())
 	for i := 0; i < b.N; i++ {
@@ -815,3 +921,801 @@ func BenchmarkTree4k_4MB(b *testing.B) {
 		tree.Root()
 	}
 }
+
+// BenchmarkProve calls Prove repeatedly on a tree of 2^20 leaves whose size is
+// not a power of two, so that every call has to collapse the stack.
+func BenchmarkProve(b *testing.B) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(12345); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1<<20-1; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Prove()
+	}
+}
+
+// BenchmarkVerifyProof benchmarks the verification of a proof in a tree with
+// 2^20 - 1 leaves.
+func BenchmarkVerifyProof(b *testing.B) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(12345); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1<<20-1; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	root, proofSet, proofIndex, numLeaves := tree.Prove()
+
+	h := sha256.New()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !VerifyProof(h, root, proofSet, proofIndex, numLeaves) {
+			b.Fatal("proof did not verify")
+		}
+	}
+}
+
+// TestCurrentIndex checks CurrentIndex and ProofReached across Push,
+// PushSubTree and Reset.
+func TestCurrentIndex(t *testing.T) {
+	tree := New(sha256.New())
+	if tree.CurrentIndex() != 0 || tree.ProofReached() {
+		t.Error("wrong state for an empty tree")
+	}
+	tree.Push([]byte{0})
+	if tree.CurrentIndex() != 1 || tree.ProofReached() {
+		t.Error("wrong state after one push without SetIndex")
+	}
+	if err := tree.PushSubTree(0, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if tree.CurrentIndex() != 2 {
+		t.Error("wrong current index after pushing a subtree:", tree.CurrentIndex())
+	}
+
+	// With a proof index, ProofReached flips once the proof leaf is pushed,
+	// even when the tree advances by whole subtrees.
+	tree.Reset()
+	if tree.CurrentIndex() != 0 || tree.ProofReached() {
+		t.Error("wrong state after Reset")
+	}
+	if err := tree.SetIndex(9); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PushSubTree(3, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if tree.CurrentIndex() != 8 || tree.ProofReached() {
+		t.Error("wrong state after pushing a subtree before the proof index")
+	}
+	tree.Push([]byte{8})
+	if tree.CurrentIndex() != 9 || tree.ProofReached() {
+		t.Error("proof reached before the proof leaf was pushed")
+	}
+	tree.Push([]byte{9})
+	if tree.CurrentIndex() != 10 || !tree.ProofReached() {
+		t.Error("proof not reached after the proof leaf was pushed")
+	}
+	if _, proofSet, _, _ := tree.Prove(); proofSet == nil {
+		t.Error("Prove returned a nil proof set after ProofReached")
+	}
+	if err := tree.PushSubTree(1, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if tree.CurrentIndex() != 12 || !tree.ProofReached() {
+		t.Error("wrong state after pushing a subtree after the proof index")
+	}
+	tree.Reset()
+	if tree.CurrentIndex() != 0 || tree.ProofReached() {
+		t.Error("wrong state after resetting a proof tree")
+	}
+}
+
+// TestIsEmpty checks that IsEmpty flips across Push, PushSubTree, ReadAll of
+// an empty reader and Reset, and that an empty tree follows the documented
+// contract.
+func TestIsEmpty(t *testing.T) {
+	tree := New(sha256.New())
+	if !tree.IsEmpty() || tree.Root() != nil {
+		t.Error("new tree is not empty")
+	}
+	if err := tree.ReadAll(bytes.NewReader(nil), 64); err != nil {
+		t.Fatal(err)
+	}
+	if !tree.IsEmpty() {
+		t.Error("tree is not empty after reading an empty reader")
+	}
+	if err := tree.SetIndex(0); err != nil {
+		t.Error("unable to call SetIndex on an empty tree:", err)
+	}
+	if root, proofSet, _, _ := tree.Prove(); root != nil || proofSet != nil {
+		t.Error("empty tree returned a proof")
+	}
+
+	tree.Push([]byte{0})
+	if tree.IsEmpty() {
+		t.Error("tree is empty after Push")
+	}
+	if tree.SetIndex(0) == nil {
+		t.Error("able to call SetIndex on a non-empty tree")
+	}
+	tree.Reset()
+	if !tree.IsEmpty() || tree.Root() != nil {
+		t.Error("tree is not empty after Reset")
+	}
+	if err := tree.PushSubTree(2, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if tree.IsEmpty() {
+		t.Error("tree is empty after PushSubTree")
+	}
+
+	ct := NewCachedTree(sha256.New(), 1)
+	if !ct.IsEmpty() {
+		t.Error("new cached tree is not empty")
+	}
+	ct.Push(make([]byte, 32))
+	if ct.IsEmpty() {
+		t.Error("cached tree is empty after Push")
+	}
+	if ct.SetIndex(0) == nil {
+		t.Error("able to call SetIndex on a non-empty cached tree")
+	}
+}
+
+// TestHashSize checks HashSize for hashes of different sizes, and that
+// PushSubTree rejects sums whose size doesn't match.
+func TestHashSize(t *testing.T) {
+	for _, h := range []hash.Hash{sha256.New(), sha512.New()} {
+		tree := New(h)
+		if tree.HashSize() != h.Size() {
+			t.Error("wrong hash size:", tree.HashSize(), h.Size())
+		}
+		tree.Push([]byte{1})
+		if len(tree.Root()) != tree.HashSize() {
+			t.Error("root size does not match HashSize")
+		}
+		if err := tree.PushSubTree(0, make([]byte, tree.HashSize())); err != nil {
+			t.Error("unable to push a subtree with a sum of the right size:", err)
+		}
+		for _, size := range []int{0, 32 + 64 - tree.HashSize(), tree.HashSize() + 1} {
+			if tree.PushSubTree(0, make([]byte, size)) == nil {
+				t.Error("able to push a subtree with a sum of the wrong size", tree.HashSize(), size)
+			}
+		}
+		if tree.CurrentIndex() != 2 {
+			t.Error("rejected subtrees changed the tree")
+		}
+	}
+}
+
+// TestEquals checks that Equals compares the structure of trees rather than
+// their roots, and that EqualsWithProofState also compares the proof being
+// built.
+func TestEquals(t *testing.T) {
+	leaves := [][]byte{{0}, {1}, {2}, {3}}
+	h := sha256.New()
+
+	// Four leaves pushed one at a time are the same as their root pushed as a
+	// subtree of height 2.
+	a := New(sha256.New())
+	for _, leaf := range leaves {
+		a.Push(leaf)
+	}
+	b := New(sha256.New())
+	if err := b.PushSubTree(2, a.Root()); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equals(b) || !b.Equals(a) {
+		t.Error("trees with the same structure are not equal")
+	}
+
+	// Pushing the sum of the first two leaves as a leaf sum gives the same
+	// root as three leaves, but a different structure.
+	c := New(sha256.New())
+	for _, leaf := range leaves[:3] {
+		c.Push(leaf)
+	}
+	d := New(sha256.New())
+	if err := d.PushSubTree(0, nodeSum(h, leafSum(h, leaves[0]), leafSum(h, leaves[1]))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PushSubTree(0, leafSum(h, leaves[2])); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.Root(), d.Root()) {
+		t.Fatal("expected the roots to match")
+	}
+	if c.Equals(d) {
+		t.Error("trees with different structures are equal")
+	}
+	if c.Equals(a) {
+		t.Error("trees with different numbers of leaves are equal")
+	}
+	if !New(sha256.New()).Equals(New(sha256.New())) {
+		t.Error("empty trees are not equal")
+	}
+	if New(sha256.New()).Equals(New(sha512.New())) {
+		t.Error("trees with different hash sizes are equal")
+	}
+
+	// Proof state is only compared by EqualsWithProofState.
+	e := New(sha256.New())
+	f := New(sha256.New())
+	if err := e.SetIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetIndex(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		e.Push(leaf)
+		f.Push(leaf)
+	}
+	if !e.Equals(f) || !e.Equals(a) {
+		t.Error("trees building different proofs are not equal")
+	}
+	if e.EqualsWithProofState(f) || e.EqualsWithProofState(a) {
+		t.Error("trees building different proofs are equal with proof state")
+	}
+	g := New(sha256.New())
+	if err := g.SetIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		g.Push(leaf)
+	}
+	if !e.EqualsWithProofState(g) {
+		t.Error("trees building the same proof are not equal with proof state")
+	}
+}
+
+// TestProveLeafHashOnly checks that a Tree recording only the leaf sum of the
+// proven leaf produces the same root and siblings as a normal Tree, and that
+// its proofs verify with VerifyProofWithLeafHash.
+func TestProveLeafHashOnly(t *testing.T) {
+	data := fastrand.Bytes(37 * 16)
+	for _, newHash := range []func() hash.Hash{sha256.New, func() hash.Hash { return newSHA256Batcher(1) }} {
+		for proofIndex := uint64(0); proofIndex < 37; proofIndex++ {
+			plain := New(newHash())
+			hashed := New(newHash())
+			hashed.ProveLeafHashOnly()
+			for _, tree := range []*Tree{plain, hashed} {
+				if err := tree.SetIndex(proofIndex); err != nil {
+					t.Fatal(err)
+				}
+				if err := tree.ReadAll(bytes.NewReader(data), 16); err != nil {
+					t.Fatal(err)
+				}
+			}
+			root, proofSet, _, numLeaves := plain.Prove()
+			hashedRoot, hashedSet, _, _ := hashed.Prove()
+			if !bytes.Equal(root, hashedRoot) || len(proofSet) != len(hashedSet) {
+				t.Fatal("leaf hash tree does not match the plain tree", proofIndex)
+			}
+			if !bytes.Equal(hashedSet[0], LeafSum(sha256.New(), proofSet[0])) {
+				t.Error("leaf hash tree did not record the leaf sum", proofIndex)
+			}
+			for i := 1; i < len(proofSet); i++ {
+				if !bytes.Equal(proofSet[i], hashedSet[i]) {
+					t.Error("leaf hash tree has different siblings", proofIndex, i)
+				}
+			}
+			if !VerifyProof(sha256.New(), root, proofSet, proofIndex, numLeaves) ||
+				!VerifyProofWithLeafHash(sha256.New(), root, hashedSet[0], hashedSet[1:], proofIndex, numLeaves) {
+				t.Error("proofs did not verify", proofIndex)
+			}
+		}
+	}
+
+	// The setting is kept by Reset.
+	tree := New(sha256.New())
+	tree.ProveLeafHashOnly()
+	tree.Reset()
+	if err := tree.SetIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	tree.Push([]byte{0})
+	tree.Push([]byte{1})
+	if _, proofSet, _, _ := tree.Prove(); !bytes.Equal(proofSet[0], LeafSum(sha256.New(), []byte{1})) {
+		t.Error("Reset cleared ProveLeafHashOnly")
+	}
+}
+
+// TestRequireCompleteTree checks that a Tree requiring a complete tree only
+// returns roots and proofs for powers of two leaves.
+func TestRequireCompleteTree(t *testing.T) {
+	tree := New(sha256.New())
+	tree.RequireCompleteTree()
+	if _, err := tree.RootErr(); err != ErrIncompleteTree {
+		t.Error("empty tree should be incomplete, got", err)
+	}
+	for i := uint64(1); i <= 1025; i++ {
+		tree.Push([]byte{byte(i)})
+		complete := i&(i-1) == 0
+		root, err := tree.RootErr()
+		if complete && (err != nil || !bytes.Equal(root, tree.Root())) {
+			t.Error("complete tree returned an error", i, err)
+		} else if !complete && err != ErrIncompleteTree {
+			t.Error("incomplete tree did not return ErrIncompleteTree", i, err)
+		}
+	}
+
+	// PushSubTree counts as all of the leaves in the subtree.
+	tree.Reset()
+	if err := tree.PushSubTree(3, make([]byte, sha256.Size)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.RootErr(); err != nil {
+		t.Error("tree of one subtree of height 3 is incomplete", err)
+	}
+	if err := tree.PushSubTree(3, make([]byte, sha256.Size)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.RootErr(); err != nil {
+		t.Error("tree of two subtrees of height 3 is incomplete", err)
+	}
+	if err := tree.PushSubTree(1, make([]byte, sha256.Size)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.RootErr(); err != ErrIncompleteTree {
+		t.Error("tree of 18 leaves is complete", err)
+	}
+
+	// ProveErr, ReadAll and ReaderRootComplete check the same way.
+	tree = New(sha256.New())
+	tree.RequireCompleteTree()
+	if err := tree.SetIndex(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ReadAll(bytes.NewReader(make([]byte, 3)), 1); err != ErrIncompleteTree {
+		t.Error("ReadAll of 3 leaves did not return ErrIncompleteTree", err)
+	}
+	if _, _, _, _, err := tree.ProveErr(); err != ErrIncompleteTree {
+		t.Error("ProveErr of 3 leaves did not return ErrIncompleteTree", err)
+	}
+	tree.Push([]byte{0})
+	root, proofSet, proofIndex, numLeaves, err := tree.ProveErr()
+	if err != nil || !VerifyProof(sha256.New(), root, proofSet, proofIndex, numLeaves) {
+		t.Error("ProveErr of 4 leaves failed", err)
+	}
+	for _, size := range []int{1023, 1025} {
+		if _, err := ReaderRootComplete(bytes.NewReader(make([]byte, size)), sha256.New(), 1); err != ErrIncompleteTree {
+			t.Error("ReaderRootComplete accepted an incomplete tree", size, err)
+		}
+	}
+	if _, err := ReaderRootComplete(bytes.NewReader(make([]byte, 1024)), sha256.New(), 1); err != nil {
+		t.Error("ReaderRootComplete rejected a complete tree", err)
+	}
+
+	// A Tree that does not require a complete tree never returns the error.
+	tree = New(sha256.New())
+	tree.Push([]byte{0})
+	tree.Push([]byte{1})
+	tree.Push([]byte{2})
+	if _, err := tree.RootErr(); err != nil {
+		t.Error("RootErr returned an error without RequireCompleteTree", err)
+	}
+}
+
+// TestPadTo checks that PadTo produces the same roots and proofs as pushing
+// the padding leaves one at a time.
+func TestPadTo(t *testing.T) {
+	for _, pad := range [][]byte{nil, {0}, fastrand.Bytes(64)} {
+		for numLeaves := uint64(1); numLeaves < 70; numLeaves++ {
+			padded := uint64(1)
+			for padded < numLeaves {
+				padded *= 2
+			}
+			naive := New(sha256.New())
+			for i := uint64(0); i < padded; i++ {
+				if i < numLeaves {
+					naive.Push([]byte{byte(i)})
+				} else {
+					naive.Push(pad)
+				}
+			}
+
+			for index := uint64(0); index < padded; index++ {
+				tree := New(sha256.New())
+				if err := tree.SetIndex(index); err != nil {
+					t.Fatal(err)
+				}
+				for i := uint64(0); i < numLeaves; i++ {
+					tree.Push([]byte{byte(i)})
+				}
+				tree.PadTo(pad)
+				root, proofSet, proofIndex, n := tree.Prove()
+				if !bytes.Equal(root, naive.Root()) || n != padded {
+					t.Fatal("padded tree does not match the naive tree", numLeaves, index)
+				}
+				if !VerifyProof(sha256.New(), root, proofSet, proofIndex, n) {
+					t.Error("proof of a padded tree does not verify", numLeaves, index)
+				}
+				if index >= numLeaves && !bytes.Equal(proofSet[0], pad) {
+					t.Error("proof of a padding leaf has the wrong data", numLeaves, index)
+				}
+			}
+		}
+	}
+
+	// Padding a huge tree only takes a few hashes.
+	ch := &countingHash{Hash: sha256.New()}
+	tree := New(ch)
+	if err := tree.PushSubTree(40, make([]byte, sha256.Size)); err != nil {
+		t.Fatal(err)
+	}
+	tree.Push([]byte{1})
+	ch.writes = 0
+	tree.PadTo([]byte{0})
+	if tree.CurrentIndex() != 1<<41 || len(tree.stack) != 1 {
+		t.Error("tree was not padded to the next power of two")
+	}
+	// Each sum is three writes. Building the padding roots and joining them
+	// into the tree each take one sum per height.
+	if ch.writes > 3*(2*41+1) {
+		t.Error("padding took too many hashes:", ch.writes)
+	}
+
+	// Empty and complete trees are unchanged.
+	tree = New(sha256.New())
+	tree.PadTo([]byte{0})
+	if !tree.IsEmpty() {
+		t.Error("padding an empty tree added leaves")
+	}
+	tree.Push([]byte{0})
+	tree.Push([]byte{1})
+	tree.PadTo([]byte{0})
+	if tree.CurrentIndex() != 2 {
+		t.Error("padding a complete tree added leaves")
+	}
+}
+
+// TestTreeHooks installs hooks on trees of the MerkleTester data, and checks
+// the observed leaves, joins and proof elements against the expected
+// construction.
+func TestTreeHooks(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	n := func(a, b []byte) []byte { return nodeSum(sha256.New(), a, b) }
+	n01, n23, n45 := n(mt.leaves[0], mt.leaves[1]), n(mt.leaves[2], mt.leaves[3]), n(mt.leaves[4], mt.leaves[5])
+
+	type join struct {
+		height              int
+		left, right, parent []byte
+	}
+	type element struct {
+		height int
+		sum    []byte
+	}
+	tests := []struct {
+		numLeaves, proofIndex uint64
+		joins                 []join
+		elements              []element
+	}{
+		{1, 0, nil, nil},
+		{2, 1, []join{{1, mt.leaves[0], mt.leaves[1], mt.roots[2]}}, []element{{0, mt.leaves[0]}}},
+		{5, 4, []join{
+			{1, mt.leaves[0], mt.leaves[1], n01},
+			{1, mt.leaves[2], mt.leaves[3], n23},
+			{2, n01, n23, mt.roots[4]},
+		}, nil},
+		{7, 5, []join{
+			{1, mt.leaves[0], mt.leaves[1], n01},
+			{1, mt.leaves[2], mt.leaves[3], n23},
+			{2, n01, n23, mt.roots[4]},
+			{1, mt.leaves[4], mt.leaves[5], n45},
+		}, []element{{0, mt.leaves[4]}}},
+		{6, 2, []join{
+			{1, mt.leaves[0], mt.leaves[1], n01},
+			{1, mt.leaves[2], mt.leaves[3], n23},
+			{2, n01, n23, mt.roots[4]},
+			{1, mt.leaves[4], mt.leaves[5], n45},
+		}, []element{{0, mt.leaves[3]}, {1, n01}}},
+	}
+	for _, test := range tests {
+		for _, batched := range []bool{false, true} {
+			var leaves [][]byte
+			var joins []join
+			var elements []element
+			copyOf := func(b []byte) []byte { return append([]byte(nil), b...) }
+			var tree *Tree
+			if batched {
+				tree = New(newSHA256Batcher(1))
+			} else {
+				tree = New(sha256.New())
+			}
+			tree.SetHooks(TreeHooks{
+				OnLeaf: func(index uint64, sum []byte) {
+					if index != uint64(len(leaves)) {
+						t.Fatal("leaf reported out of order", index)
+					}
+					leaves = append(leaves, copyOf(sum))
+				},
+				OnJoin: func(height int, left, right, parent []byte) {
+					joins = append(joins, join{height, copyOf(left), copyOf(right), copyOf(parent)})
+				},
+				OnProofElement: func(height int, sum []byte) {
+					elements = append(elements, element{height, copyOf(sum)})
+				},
+			})
+			if err := tree.SetIndex(test.proofIndex); err != nil {
+				t.Fatal(err)
+			}
+			if batched {
+				var data []byte
+				for _, d := range mt.data[:test.numLeaves] {
+					data = append(data, d...)
+				}
+				if err := tree.ReadAll(bytes.NewReader(data), len(mt.data[0])); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				for _, d := range mt.data[:test.numLeaves] {
+					tree.Push(d)
+				}
+			}
+
+			if uint64(len(leaves)) != test.numLeaves {
+				t.Fatal("wrong number of leaves reported", test.numLeaves, batched)
+			}
+			for i := range leaves {
+				if !bytes.Equal(leaves[i], mt.leaves[i]) {
+					t.Error("wrong leaf sum reported", test.numLeaves, batched, i)
+				}
+			}
+			if len(joins) != len(test.joins) {
+				t.Fatal("wrong number of joins reported", test.numLeaves, batched, len(joins))
+			}
+			for i, j := range joins {
+				exp := test.joins[i]
+				if j.height != exp.height || !bytes.Equal(j.left, exp.left) || !bytes.Equal(j.right, exp.right) || !bytes.Equal(j.parent, exp.parent) {
+					t.Error("wrong join reported", test.numLeaves, batched, i)
+				}
+			}
+			if len(elements) != len(test.elements) {
+				t.Fatal("wrong number of proof elements reported", test.numLeaves, batched, len(elements))
+			}
+			for i, e := range elements {
+				if e.height != test.elements[i].height || !bytes.Equal(e.sum, test.elements[i].sum) {
+					t.Error("wrong proof element reported", test.numLeaves, batched, i)
+				}
+			}
+			if !bytes.Equal(tree.Root(), mt.roots[int(test.numLeaves)]) {
+				t.Error("hooks changed the root", test.numLeaves, batched)
+			}
+		}
+	}
+}
+
+// TestTreeStats checks the hashing counted by Stats for small trees, where
+// the counts can be derived by hand.
+func TestTreeStats(t *testing.T) {
+	// 7 one byte leaves: 7 leaf hashes, 4 joins while pushing and 2 more to
+	// bag the subtrees of heights 2, 1 and 0 in Root.
+	tree := New(sha256.New())
+	for i := 0; i < 7; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	tree.Root()
+	exp := TreeStats{LeafHashes: 7, NodeHashes: 6, BytesHashed: 7*2 + 6*65}
+	if tree.Stats() != exp {
+		t.Error("wrong stats for 7 leaves", tree.Stats())
+	}
+
+	// Every call to Root is counted. A tree of 8 leaves needs no bagging.
+	tree.Root()
+	exp.NodeHashes += 2
+	exp.BytesHashed += 2 * 65
+	if tree.Stats() != exp {
+		t.Error("second call to Root was not counted", tree.Stats())
+	}
+	tree.Push([]byte{7})
+	tree.Root()
+	exp.LeafHashes++
+	exp.NodeHashes += 3
+	exp.BytesHashed += 2 + 3*65
+	if tree.Stats() != exp {
+		t.Error("wrong stats for 8 leaves", tree.Stats())
+	}
+
+	// Reading 7 leaves of 3 bytes, with and without a BatchHasher. Leaf 6 has
+	// no subtrees to its right, so proving it hashes nothing beyond Root.
+	data := fastrand.Bytes(21)
+	root, stats, err := ReaderRootStats(bytes.NewReader(data), sha256.New(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = TreeStats{LeafHashes: 7, NodeHashes: 6, BytesHashed: 7*4 + 6*65}
+	if stats != exp {
+		t.Error("wrong stats from ReaderRootStats", stats)
+	}
+	expRoot, _ := ReaderRoot(bytes.NewReader(data), sha256.New(), 3)
+	if !bytes.Equal(root, expRoot) {
+		t.Error("ReaderRootStats returned the wrong root")
+	}
+	for _, h := range []hash.Hash{sha256.New(), newSHA256Batcher(1)} {
+		_, proofSet, numLeaves, stats, err := BuildReaderProofStats(bytes.NewReader(data), h, 3, 6)
+		if err != nil || !VerifyProof(sha256.New(), expRoot, proofSet, 6, numLeaves) {
+			t.Fatal("BuildReaderProofStats built an invalid proof", err)
+		}
+		// The proven leaf is left out of the batch, so it is only hashed
+		// once with either hash.
+		if stats != exp {
+			t.Error("wrong stats from BuildReaderProofStats", stats)
+		}
+	}
+}
+
+// TestRootCallback checks that the root callback is called after every push
+// with the root of a tree built from that prefix of the leaves.
+func TestRootCallback(t *testing.T) {
+	leaves := make([][]byte, 300)
+	for i := range leaves {
+		leaves[i] = fastrand.Bytes(fastrand.Intn(100))
+	}
+	var roots [][]byte
+	tree := New(sha256.New())
+	tree.SetRootCallback(func(numLeaves uint64, root []byte) {
+		if numLeaves != uint64(len(roots)+1) {
+			t.Fatal("callback called with the wrong number of leaves", numLeaves)
+		}
+		roots = append(roots, root)
+	})
+	for _, leaf := range leaves {
+		tree.Push(leaf)
+	}
+	if len(roots) != len(leaves) {
+		t.Fatal("wrong number of callbacks", len(roots))
+	}
+	for n := 1; n <= len(leaves); n++ {
+		prefix := New(sha256.New())
+		for _, leaf := range leaves[:n] {
+			prefix.Push(leaf)
+		}
+		if !bytes.Equal(roots[n-1], prefix.Root()) {
+			t.Error("callback root does not match the prefix root", n)
+		}
+	}
+
+	// The roots must be copies, so that the callers can retain them.
+	roots[1][0]++
+	single := New(sha256.New())
+	single.Push(leaves[0])
+	single.Push(leaves[1])
+	if bytes.Equal(single.Root(), roots[1]) {
+		t.Error("root passed to the callback is not a copy")
+	}
+
+	// PushSubTree calls the callback with the size including the subtree.
+	tree = New(sha256.New())
+	var sizes []uint64
+	tree.SetRootCallback(func(numLeaves uint64, root []byte) {
+		sizes = append(sizes, numLeaves)
+	})
+	tree.Push(leaves[0])
+	if err := tree.PushSubTree(0, leafSum(sha256.New(), leaves[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PushSubTree(1, single.Root()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 3 || sizes[0] != 1 || sizes[1] != 2 || sizes[2] != 4 {
+		t.Error("wrong sizes passed to the callback", sizes)
+	}
+	tree.SetRootCallback(nil)
+	tree.Push(leaves[2])
+	if len(sizes) != 3 {
+		t.Error("callback called after it was removed")
+	}
+}
+
+// TestNilLeaves checks that nil and empty leaf data are the same leaf at every
+// entry point, and that nil sums are rejected.
+func TestNilLeaves(t *testing.T) {
+	// Push treats nil and empty data as the same leaf, and proves either as
+	// an empty, non-nil slice.
+	for _, index := range []uint64{0, 1, 2} {
+		nilTree, emptyTree := New(sha256.New()), New(sha256.New())
+		if err := nilTree.SetIndex(index); err != nil {
+			t.Fatal(err)
+		}
+		if err := emptyTree.SetIndex(index); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			nilTree.Push(nil)
+			emptyTree.Push([]byte{})
+		}
+		nilRoot, nilProof, _, _ := nilTree.Prove()
+		emptyRoot, emptyProof, _, _ := emptyTree.Prove()
+		if !bytes.Equal(nilRoot, emptyRoot) || len(nilProof) != len(emptyProof) {
+			t.Fatal("nil and empty leaves produce different trees", index)
+		}
+		if nilProof[0] == nil || len(nilProof[0]) != 0 {
+			t.Error("proof of a nil leaf does not start with an empty slice", index)
+		}
+		if !VerifyProof(sha256.New(), nilRoot, nilProof, index, 3) {
+			t.Error("proof of a nil leaf does not verify", index)
+		}
+
+		// A nil first element is verified as an empty leaf.
+		nilProof[0] = nil
+		if !VerifyProof(sha256.New(), nilRoot, nilProof, index, 3) {
+			t.Error("nil leaf data does not verify as an empty leaf", index)
+		}
+
+		// A nil sibling is rejected before it is hashed.
+		nilProof[1] = nil
+		if _, ok := VerifyProofErr(sha256.New(), nilRoot, nilProof, index, 3).(*ElementSizeError); !ok {
+			t.Error("nil sibling was not rejected as the wrong size", index)
+		}
+	}
+	if err := VerifyProofErr(sha256.New(), make([]byte, sha256.Size), nil, 0, 1); err != ErrProofTooShort {
+		t.Error("nil proof set was not rejected", err)
+	}
+
+	// ReadAll never pushes an empty final segment.
+	for _, size := range []int{0, 64, 128} {
+		tree := New(sha256.New())
+		if err := tree.ReadAll(bytes.NewReader(make([]byte, size)), 64); err != nil {
+			t.Fatal(err)
+		}
+		if tree.CurrentIndex() != uint64(size/64) {
+			t.Error("ReadAll pushed an empty segment", size, tree.CurrentIndex())
+		}
+	}
+
+	// PushSubTree rejects nil and empty sums.
+	tree := New(sha256.New())
+	if err := tree.PushSubTree(0, nil); err == nil {
+		t.Error("nil subtree sum was accepted")
+	}
+	if err := tree.PushSubTree(0, []byte{}); err == nil {
+		t.Error("empty subtree sum was accepted")
+	}
+	if !tree.IsEmpty() {
+		t.Error("rejected subtree modified the tree")
+	}
+}
+
+// TestPushSubTreeMaxHeight checks that PushSubTree accepts a subtree of
+// height MaxHeight, and rejects greater heights and subtrees that would
+// overflow the number of leaves.
+func TestPushSubTreeMaxHeight(t *testing.T) {
+	sum := make([]byte, sha256.Size)
+	tree := New(sha256.New())
+	if err := tree.PushSubTree(MaxHeight+1, sum); err != ErrHeightTooLarge {
+		t.Error("wrong error for a height past MaxHeight:", err)
+	}
+	if err := tree.PushSubTree(3000, sum); err != ErrHeightTooLarge {
+		t.Error("wrong error for a height of 3000:", err)
+	}
+	if err := tree.PushSubTree(-1, sum); err == nil {
+		t.Error("negative height was accepted")
+	}
+	if !tree.IsEmpty() {
+		t.Fatal("rejected subtrees changed the tree")
+	}
+	if err := tree.PushSubTree(MaxHeight, sum); err != nil {
+		t.Fatal("subtree of height MaxHeight was rejected:", err)
+	}
+	if tree.CurrentIndex() != 1<<MaxHeight {
+		t.Error("wrong number of leaves:", tree.CurrentIndex())
+	}
+
+	// A second subtree of height MaxHeight would give the tree 2^64 leaves,
+	// but smaller subtrees still fit.
+	if err := tree.PushSubTree(MaxHeight, sum); err != ErrHeightTooLarge {
+		t.Error("wrong error for a subtree that overflows the number of leaves:", err)
+	}
+	if err := tree.PushSubTree(MaxHeight-1, sum); err != nil {
+		t.Error("subtree that fits was rejected:", err)
+	}
+}
@@ -0,0 +1,99 @@
+package merkletree
+
+import (
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// ErrProofNotUpdatable is returned by UpdateProof when the appended leaves
+// join a subtree to the right of the proven leaf that the old proof holds
+// only the sum of. The sums of the smaller subtrees inside it are needed to
+// build the new proof, and only a party holding the leaves can provide them.
+var ErrProofNotUpdatable = errors.New("appended leaves split a subtree that the proof holds only the sum of")
+
+// UpdateProof updates a proof for a tree that has grown by the leaves with
+// the leaf sums 'appendedLeafHashes', as returned by LeafSum, returning a
+// proof of the same leaf against the root of the grown tree. The siblings to
+// the left of the leaf are kept, and only the siblings to its right are
+// rebuilt, from the old siblings and the appended leaf sums, so that the
+// update costs O(k + log(n)) hashes for k appended leaves instead of hashing
+// the whole tree again.
+//
+// The old proof must be canonical: it must verify with VerifyProofStrict.
+// If the old tree had a ragged right edge, the last sibling of the old proof
+// is the sum of a subtree that the appended leaves may join, in which case
+// the new proof needs sums that the old proof does not hold, and
+// ErrProofNotUpdatable is returned. A proof of a leaf in the last complete
+// subtree of its tree, such as a proof of the last leaf, can always be
+// updated. The new proof set shares its elements with the old one.
+func UpdateProof(h hash.Hash, old Proof, appendedLeafHashes [][]byte) (Proof, error) {
+	if !VerifyProofStrict(h, old.Root, old.Set, old.Index, old.NumLeaves) {
+		return Proof{}, errors.New("old proof is not a canonical proof of its root")
+	}
+	for _, sum := range appendedLeafHashes {
+		if len(sum) != h.Size() {
+			return Proof{}, errors.New("appended leaf hash has the wrong size for the hash")
+		}
+	}
+	index, n := old.Index, old.NumLeaves
+	m := n + uint64(len(appendedLeafHashes))
+	if m < n {
+		return Proof{}, errors.New("too many leaves appended")
+	}
+
+	// Every sibling of the new proof that was a sibling of the old proof,
+	// which includes every sibling to the left of the leaf, is unchanged.
+	known := make(map[nodeRange][]byte)
+	for i, r := range proofNodeRanges(index, n) {
+		known[r] = old.Set[i+1]
+	}
+	proofSet := [][]byte{old.Set[0]}
+	for _, r := range proofNodeRanges(index, m) {
+		sum, err := rangeSum(h, known, appendedLeafHashes, n, r)
+		if err != nil {
+			return Proof{}, err
+		}
+		proofSet = append(proofSet, sum)
+	}
+	root, err := proofRoot(h, nil, proofSet, index, m)
+	if err != nil {
+		return Proof{}, err
+	}
+	return Proof{
+		Root:      root,
+		Set:       proofSet,
+		Index:     index,
+		NumLeaves: m,
+	}, nil
+}
+
+// rangeSum returns the sum of the node covering the leaves in 'r', which is
+// built from the nodes in 'known', which cover leaves before 'n', and the leaf
+// sums 'appended' of the leaves from 'n' on. Like the whole tree, a node is
+// split after the largest power of two leaves smaller than its size.
+func rangeSum(h hash.Hash, known map[nodeRange][]byte, appended [][]byte, n uint64, r nodeRange) ([]byte, error) {
+	if r.start >= n {
+		s := NewStack(h)
+		for _, sum := range appended[r.start-n : r.end-n] {
+			s.AppendLeafHash(sum)
+		}
+		return s.Root(), nil
+	}
+	if sum, ok := known[r]; ok {
+		return sum, nil
+	}
+	if r.end <= n {
+		return nil, ErrProofNotUpdatable
+	}
+	mid := r.start + 1<<uint(bits.Len64(r.end-r.start-1)-1)
+	a, err := rangeSum(h, known, appended, n, nodeRange{r.start, mid})
+	if err != nil {
+		return nil, err
+	}
+	b, err := rangeSum(h, known, appended, n, nodeRange{mid, r.end})
+	if err != nil {
+		return nil, err
+	}
+	return nodeSum(h, a, b), nil
+}
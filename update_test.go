@@ -0,0 +1,126 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// buildProof returns the proof of the leaf at 'index' of a tree of 'leaves'.
+func buildProof(t *testing.T, leaves [][]byte, index uint64) Proof {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		tree.Push(leaf)
+	}
+	root, proofSet, _, numLeaves := tree.Prove()
+	return Proof{Root: root, Set: proofSet, Index: index, NumLeaves: numLeaves}
+}
+
+// updatable returns true if the proof of the leaf at 'index' in a tree of 'n'
+// leaves has no sibling to its right that covers a ragged subtree of more
+// than one complete subtree.
+func updatable(index, n uint64) bool {
+	for height := uint(0); height < 64 && 1<<height < 2*n; height++ {
+		if index>>height&1 == 1 {
+			continue
+		}
+		start := (index>>height + 1) << height
+		if size := n - start; start < n && size < 1<<height && size&(size-1) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestUpdateProof checks updated proofs against proofs built from scratch for
+// random trees, indices and numbers of appended leaves.
+func TestUpdateProof(t *testing.T) {
+	trials, maxLeaves := referenceSizes()
+	for i := 0; i < trials*4; i++ {
+		n := fastrand.Intn(maxLeaves) + 1
+		k := fastrand.Intn(maxLeaves)
+		index := uint64(fastrand.Intn(n))
+		leaves := randomLeaves(n+k, 16)
+		var appended [][]byte
+		for _, leaf := range leaves[n:] {
+			appended = append(appended, LeafSum(sha256.New(), leaf))
+		}
+
+		old := buildProof(t, leaves[:n], index)
+		p, err := UpdateProof(sha256.New(), old, appended)
+		if k > 0 && !updatable(index, uint64(n)) {
+			if err != ErrProofNotUpdatable {
+				t.Fatal("proof should not be updatable", n, index, k, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatal(err, n, index, k)
+		}
+
+		expected := buildProof(t, leaves, index)
+		if !bytes.Equal(p.Root, expected.Root) || p.NumLeaves != expected.NumLeaves || p.Index != index || len(p.Set) != len(expected.Set) {
+			t.Fatal("updated proof does not match", n, index, k)
+		}
+		for j := range expected.Set {
+			if !bytes.Equal(p.Set[j], expected.Set[j]) {
+				t.Fatal("updated proof element does not match", n, index, k, j)
+			}
+		}
+	}
+}
+
+// TestUpdateProofLastLeaf checks that a proof of the last leaf of a tree can
+// always be updated.
+func TestUpdateProofLastLeaf(t *testing.T) {
+	leaves := randomLeaves(70, 16)
+	var appended [][]byte
+	for _, leaf := range leaves {
+		appended = append(appended, LeafSum(sha256.New(), leaf))
+	}
+	for n := 1; n < len(leaves); n++ {
+		p, err := UpdateProof(sha256.New(), buildProof(t, leaves[:n], uint64(n-1)), appended[n:])
+		if err != nil {
+			t.Fatal(err, n)
+		}
+		if !bytes.Equal(p.Root, buildProof(t, leaves, 0).Root) || !p.Verify(sha256.New()) {
+			t.Fatal("updated proof is wrong", n)
+		}
+	}
+}
+
+// TestUpdateProofInvalid checks that UpdateProof rejects proofs that don't
+// verify strictly and leaf hashes of the wrong size.
+func TestUpdateProofInvalid(t *testing.T) {
+	leaves := randomLeaves(7, 16)
+	p := buildProof(t, leaves, 6)
+	appended := [][]byte{LeafSum(sha256.New(), []byte{1})}
+
+	bad := p
+	bad.Root = append([]byte(nil), p.Root...)
+	bad.Root[0]++
+	if _, err := UpdateProof(sha256.New(), bad, appended); err == nil {
+		t.Error("proof with the wrong root updated")
+	}
+	// A proof that leaves out the sibling of the first four leaves verifies
+	// against the root of the last three, but is not canonical.
+	bad = p
+	bad.Set = p.Set[:2]
+	bad.Root = buildProof(t, leaves[4:], 2).Root
+	if !VerifyProof(sha256.New(), bad.Root, bad.Set, bad.Index, bad.NumLeaves) {
+		t.Fatal("short proof does not verify")
+	}
+	if _, err := UpdateProof(sha256.New(), bad, appended); err == nil {
+		t.Error("short proof updated")
+	}
+	if _, err := UpdateProof(sha256.New(), p, [][]byte{{1, 2, 3}}); err == nil {
+		t.Error("leaf hash of the wrong size accepted")
+	}
+	if _, err := UpdateProof(sha256.New(), p, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,87 @@
+package merkletree
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Validate checks the internal invariants of the Tree, and returns an error
+// describing the first one that is violated. It is meant for tests, and for
+// checking a Tree that was filled by PushSubTree from an untrusted source.
+// The invariants are:
+//   - the subtrees of the stack are strictly decreasing in height from the
+//     tail to the head, and each sum is the size of the hash's output
+//   - the subtrees hold exactly CurrentIndex leaves between them
+//   - the proof set is empty until the proof index is reached, and after that
+//     holds the leaf data and one sibling per level of the subtree that
+//     contains the proof index
+//
+// Validate can't check that the sums themselves are correct.
+func (t *Tree) Validate() error {
+	if t.hash == nil {
+		return errors.New("tree has no hash")
+	}
+
+	var leaves, proofHeight uint64
+	proofHeight = 64
+	for i, st := range t.stack {
+		if st.height < 0 || st.height > MaxHeight {
+			return errors.New("subtree " + strconv.Itoa(i) + " has invalid height " + strconv.Itoa(st.height))
+		}
+		if i > 0 && t.stack[i-1].height <= st.height {
+			return errors.New("subtrees are out of order: subtree " + strconv.Itoa(i-1) + " has height " + strconv.Itoa(t.stack[i-1].height) +
+				", subtree " + strconv.Itoa(i) + " has height " + strconv.Itoa(st.height))
+		}
+		if len(st.sum) != t.HashSize() {
+			return errors.New("subtree " + strconv.Itoa(i) + " has a sum of " + strconv.Itoa(len(st.sum)) + " bytes, expected " + strconv.Itoa(t.HashSize()))
+		}
+		begin, end := leaves, leaves+1<<uint(st.height)
+		if t.proofIndex >= begin && t.proofIndex < end {
+			proofHeight = uint64(st.height)
+		}
+		leaves = end
+	}
+	if leaves != t.currentIndex {
+		return errors.New("subtrees hold " + strconv.FormatUint(leaves, 10) + " leaves, but the current index is " + strconv.FormatUint(t.currentIndex, 10))
+	}
+
+	if !t.proofTree || t.currentIndex <= t.proofIndex {
+		if len(t.proofSet) != 0 {
+			return errors.New("proof set has " + strconv.Itoa(len(t.proofSet)) + " elements before the proof index " +
+				strconv.FormatUint(t.proofIndex, 10) + " was reached")
+		}
+		return nil
+	}
+	if uint64(len(t.proofSet)) != proofHeight+1 {
+		return errors.New("proof set has " + strconv.Itoa(len(t.proofSet)) + " elements, but the proof index " +
+			strconv.FormatUint(t.proofIndex, 10) + " is in a subtree of height " + strconv.FormatUint(proofHeight, 10))
+	}
+	for i, elem := range t.proofSet {
+		if (i > 0 || t.cachedTree) && len(elem) != t.HashSize() {
+			return errors.New("proof set element " + strconv.Itoa(i) + " is " + strconv.Itoa(len(elem)) + " bytes, expected " + strconv.Itoa(t.HashSize()))
+		}
+	}
+	return nil
+}
+
+// Validate checks the invariants of the embedded Tree, and that the fields of
+// the CachedTree are consistent with it: the cached node height must be less
+// than 64, the Tree must be marked as cached, the number of leaves of its
+// cached nodes must fit in a uint64, and the proof index of the Tree must be
+// the index of the cached node that contains the true proof index.
+func (ct *CachedTree) Validate() error {
+	if ct.cachedNodeHeight > MaxHeight {
+		return errors.New("cached node height " + strconv.FormatUint(ct.cachedNodeHeight, 10) + " is too large")
+	}
+	if !ct.cachedTree {
+		return errors.New("cached tree is not marked as cached")
+	}
+	if ct.currentIndex > ct.maxCachedNodes() {
+		return errors.New("cached tree holds " + strconv.FormatUint(ct.currentIndex, 10) + " cached nodes, which is too many leaves to count")
+	}
+	if ct.proofTree && ct.trueProofIndex>>ct.cachedNodeHeight != ct.proofIndex {
+		return errors.New("true proof index " + strconv.FormatUint(ct.trueProofIndex, 10) + " is not in cached node " +
+			strconv.FormatUint(ct.proofIndex, 10))
+	}
+	return ct.Tree.Validate()
+}
@@ -0,0 +1,115 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// TestValidate checks that Validate accepts every state that the Tree and
+// CachedTree reach through their methods.
+func TestValidate(t *testing.T) {
+	for numLeaves := uint64(0); numLeaves < 40; numLeaves++ {
+		for _, proofIndex := range []uint64{0, numLeaves / 2, numLeaves + 3} {
+			tree := New(sha256.New())
+			ct := NewCachedTree(sha256.New(), 2)
+			if err := tree.SetIndex(proofIndex); err != nil {
+				t.Fatal(err)
+			}
+			if err := ct.SetIndex(proofIndex); err != nil {
+				t.Fatal(err)
+			}
+			for i := uint64(0); i < numLeaves; i++ {
+				tree.Push([]byte{byte(i)})
+				ct.Push(tree.Root())
+				if err := tree.Validate(); err != nil {
+					t.Fatal("valid tree was rejected:", numLeaves, proofIndex, i, err)
+				}
+				if err := ct.Validate(); err != nil {
+					t.Fatal("valid cached tree was rejected:", numLeaves, proofIndex, i, err)
+				}
+			}
+			tree.PadTo([]byte{0})
+			if err := tree.Validate(); err != nil {
+				t.Error("padded tree was rejected:", numLeaves, proofIndex, err)
+			}
+			tree.Reset()
+			if err := tree.Validate(); err != nil {
+				t.Error("reset tree was rejected:", err)
+			}
+		}
+	}
+}
+
+// TestValidateCorrupt corrupts the fields of a Tree and a CachedTree one at a
+// time, and checks that Validate catches each corruption.
+func TestValidateCorrupt(t *testing.T) {
+	// newTree returns a Tree of 11 leaves proving leaf 9, whose stack holds
+	// subtrees of heights 3, 1 and 0.
+	newTree := func() *Tree {
+		tree := New(sha256.New())
+		if err := tree.SetIndex(9); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 11; i++ {
+			tree.Push([]byte{byte(i)})
+		}
+		if err := tree.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+
+	corruptions := []func(*Tree){
+		func(tree *Tree) { tree.hash = nil },
+		func(tree *Tree) { tree.stack[1].height = 3 },
+		func(tree *Tree) { tree.stack[0], tree.stack[2] = tree.stack[2], tree.stack[0] },
+		func(tree *Tree) { tree.stack[2].height = -1 },
+		func(tree *Tree) { tree.stack[2].height = 64 },
+		func(tree *Tree) { tree.stack[1].sum = tree.stack[1].sum[:31] },
+		func(tree *Tree) { tree.currentIndex++ },
+		func(tree *Tree) { tree.stack = tree.stack[:2] },
+		func(tree *Tree) { tree.proofSet = tree.proofSet[:1] },
+		func(tree *Tree) { tree.proofSet = append(tree.proofSet, tree.stack[0].sum) },
+		func(tree *Tree) { tree.proofSet[1] = nil },
+		func(tree *Tree) { tree.proofIndex = 2 },
+		func(tree *Tree) { tree.proofIndex = 11 },
+		func(tree *Tree) { tree.proofTree = false },
+	}
+	for i, corrupt := range corruptions {
+		tree := newTree()
+		corrupt(tree)
+		if err := tree.Validate(); err == nil {
+			t.Error("corrupt tree was accepted", i)
+		}
+	}
+
+	// A CachedTree of 3 cached nodes of height 2, proving leaf 5.
+	newCachedTree := func() *CachedTree {
+		ct := NewCachedTree(sha256.New(), 2)
+		if err := ct.SetIndex(5); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			ct.Push(sum(sha256.New(), []byte{byte(i)}))
+		}
+		if err := ct.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return ct
+	}
+	cachedCorruptions := []func(*CachedTree){
+		func(ct *CachedTree) { ct.cachedNodeHeight = 64 },
+		func(ct *CachedTree) { ct.cachedTree = false },
+		func(ct *CachedTree) { ct.trueProofIndex = 8 },
+		func(ct *CachedTree) { ct.proofIndex = 0 },
+		func(ct *CachedTree) { ct.proofSet[0] = []byte{1} },
+		func(ct *CachedTree) { ct.currentIndex = 4 },
+	}
+	for i, corrupt := range cachedCorruptions {
+		ct := newCachedTree()
+		corrupt(ct)
+		if err := ct.Validate(); err == nil {
+			t.Error("corrupt cached tree was accepted", i)
+		}
+	}
+}
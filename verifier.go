@@ -0,0 +1,77 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// ErrLeafTooLarge is returned by a Verifier when the leaf data of a proof is
+// larger than the Verifier's MaxLeafSize.
+var ErrLeafTooLarge = errors.New("proof leaf data is larger than the maximum leaf size")
+
+// A Verifier verifies proofs with the same results as VerifyProof and
+// VerifyProofErr, but keeps its hash and the buffer used to build the root
+// between calls, so that verifying a valid proof does not allocate. A Verifier
+// must not be used concurrently; create one per goroutine instead.
+type Verifier struct {
+	// MaxLeafSize, if not zero, is the largest leaf data that the Verifier
+	// will hash. Proofs with larger leaves are rejected with ErrLeafTooLarge
+	// before anything is hashed, which keeps a peer from making the Verifier
+	// hash an arbitrarily large leaf.
+	MaxLeafSize int
+
+	hash     hash.Hash
+	buf      []byte
+	prefixes sumPrefixes
+}
+
+// NewVerifier creates a Verifier that hashes with the hash returned by
+// 'newHash'.
+func NewVerifier(newHash func() hash.Hash) *Verifier {
+	return &Verifier{
+		hash: newHash(),
+	}
+}
+
+// SetPrefixes makes the Verifier check proofs of trees whose leaf sums are
+// H(leafPrefix || data) and whose node sums are H(nodePrefix || a || b),
+// instead of using the 0x00 and 0x01 prefixes of the Tree. A nil prefix keeps
+// the default. The prefixes are copied.
+func (v *Verifier) SetPrefixes(leafPrefix, nodePrefix []byte) {
+	v.prefixes = sumPrefixes{}
+	if leafPrefix != nil {
+		v.prefixes.leaf = append([]byte{}, leafPrefix...)
+	}
+	if nodePrefix != nil {
+		v.prefixes.node = append([]byte{}, nodePrefix...)
+	}
+}
+
+// VerifyProof is the same as the VerifyProof function, using the Verifier's
+// hash.
+func (v *Verifier) VerifyProof(merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) bool {
+	return v.VerifyProofErr(merkleRoot, proofSet, proofIndex, numLeaves) == nil
+}
+
+// VerifyProofErr is the same as the VerifyProofErr function, using the
+// Verifier's hash.
+func (v *Verifier) VerifyProofErr(merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) error {
+	if merkleRoot == nil {
+		return ErrNilRoot
+	}
+	if v.MaxLeafSize != 0 && len(proofSet) > 0 && len(proofSet[0]) > v.MaxLeafSize {
+		return ErrLeafTooLarge
+	}
+	sum, err := proofRootFrom(v.hash, v.buf, v.prefixes, nil, proofSet, proofIndex, numLeaves)
+	if err != nil {
+		return err
+	}
+	v.buf = sum
+	if !bytes.Equal(sum, merkleRoot) {
+		// The computed root lives in the Verifier's buffer, so the error
+		// needs its own copy.
+		return &RootMismatchError{Computed: append([]byte(nil), sum...), Expected: merkleRoot}
+	}
+	return nil
+}
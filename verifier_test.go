@@ -0,0 +1,162 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// TestVerifierCompatibility runs every proof of every tree up to 'max' leaves
+// through a single Verifier, checking that it agrees with VerifyProofErr for
+// the correct index and for every other index.
+func TestVerifierCompatibility(t *testing.T) {
+	max := uint64(65)
+	if testing.Short() {
+		max = 17
+	}
+	v := NewVerifier(sha256.New)
+	for i := uint64(1); i < max; i++ {
+		for j := uint64(0); j < i; j++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(j); err != nil {
+				t.Fatal(err)
+			}
+			for k := uint64(0); k < i; k++ {
+				tree.Push([]byte{byte(k)})
+			}
+			merkleRoot, proofSet, proofIndex, numLeaves := tree.Prove()
+			if !v.VerifyProof(merkleRoot, proofSet, proofIndex, numLeaves) {
+				t.Error("Verifier rejected a valid proof for indices", i, j)
+			}
+			for k := uint64(0); k < i; k++ {
+				if k == j {
+					continue
+				}
+				verr := v.VerifyProofErr(merkleRoot, proofSet, k, numLeaves)
+				err := VerifyProofErr(sha256.New(), merkleRoot, proofSet, k, numLeaves)
+				if (verr == nil) != (err == nil) || (verr != nil && verr.Error() != err.Error()) {
+					t.Error("Verifier disagrees with VerifyProofErr for indices", i, j, k)
+				}
+			}
+		}
+	}
+}
+
+// TestVerifierBadInputs checks that the Verifier rejects the bad inputs that
+// VerifyProof rejects.
+func TestVerifierBadInputs(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	v := NewVerifier(sha256.New)
+	if v.VerifyProofErr(nil, mt.proofSets[1][0], 0, 1) != ErrNilRoot {
+		t.Error("Verifier should return ErrNilRoot for a nil merkle root")
+	}
+	if v.VerifyProof([]byte{1}, nil, 0, 1) {
+		t.Error("Verifier should reject a nil proof set")
+	}
+	if v.VerifyProofErr(mt.roots[15], mt.proofSets[15][10][:2], 10, 15) != ErrProofTooShort {
+		t.Error("Verifier should return ErrProofTooShort for a too-short proof set")
+	}
+	if v.VerifyProofErr(mt.roots[15], mt.proofSets[15][10], 15, 0) != ErrProofIndexOutOfRange {
+		t.Error("Verifier should return ErrProofIndexOutOfRange when numLeaves is 0")
+	}
+
+	// A mismatch error must not share memory with the Verifier's buffer.
+	err := v.VerifyProofErr(mt.roots[15], mt.proofSets[15][10], 9, 15)
+	rme, ok := err.(*RootMismatchError)
+	if !ok {
+		t.Fatal("expected a RootMismatchError, got", err)
+	}
+	computed := string(rme.Computed)
+	if !v.VerifyProof(mt.roots[15], mt.proofSets[15][10], 10, 15) {
+		t.Fatal("Verifier rejected a valid proof")
+	}
+	if string(rme.Computed) != computed {
+		t.Error("RootMismatchError was modified by a later verification")
+	}
+}
+
+// BenchmarkVerifier verifies a proof in a tree with 2^20 - 1 leaves using a
+// prefixedProof returns the root of the tree of 'leaves' built with the
+// given prefixes, and the proof set of the leaf at 'index'.
+func prefixedProof(leaves [][]byte, index int, leafPrefix, nodePrefix []byte) (root []byte, proofSet [][]byte) {
+	if len(leaves) == 1 {
+		return sum(sha256.New(), leafPrefix, leaves[0]), [][]byte{leaves[0]}
+	}
+	// The left subtree holds the largest power of two leaves that is less
+	// than the number of leaves.
+	k := 1
+	for 2*k < len(leaves) {
+		k *= 2
+	}
+	var left, right []byte
+	if index < k {
+		left, proofSet = prefixedProof(leaves[:k], index, leafPrefix, nodePrefix)
+		right, _ = prefixedProof(leaves[k:], 0, leafPrefix, nodePrefix)
+		proofSet = append(proofSet, right)
+	} else {
+		left, _ = prefixedProof(leaves[:k], 0, leafPrefix, nodePrefix)
+		right, proofSet = prefixedProof(leaves[k:], index-k, leafPrefix, nodePrefix)
+		proofSet = append(proofSet, left)
+	}
+	return sum(sha256.New(), nodePrefix, left, right), proofSet
+}
+
+// TestVerifierPrefixes checks that a Verifier with custom prefixes verifies
+// the proofs of a tree built with those prefixes, and only those proofs.
+func TestVerifierPrefixes(t *testing.T) {
+	leafPrefix, nodePrefix := []byte("leaf"), []byte("node")
+	custom := NewVerifier(sha256.New)
+	custom.SetPrefixes(leafPrefix, nodePrefix)
+	explicit := NewVerifier(sha256.New)
+	explicit.SetPrefixes([]byte{0}, []byte{1})
+	standard := NewVerifier(sha256.New)
+
+	var leaves [][]byte
+	for n := 1; n <= 17; n++ {
+		leaves = append(leaves, []byte{byte(n)})
+		for i := 0; i < n; i++ {
+			root, proofSet := prefixedProof(leaves, i, leafPrefix, nodePrefix)
+			if err := custom.VerifyProofErr(root, proofSet, uint64(i), uint64(n)); err != nil {
+				t.Error("custom prefix proof did not verify:", n, i, err)
+			}
+			if standard.VerifyProof(root, proofSet, uint64(i), uint64(n)) {
+				t.Error("custom prefix proof verified with the default prefixes", n, i)
+			}
+
+			root, proofSet = prefixedProof(leaves, i, []byte{0}, []byte{1})
+			if !explicit.VerifyProof(root, proofSet, uint64(i), uint64(n)) || !standard.VerifyProof(root, proofSet, uint64(i), uint64(n)) {
+				t.Error("default prefix proof did not verify", n, i)
+			}
+			if custom.VerifyProof(root, proofSet, uint64(i), uint64(n)) {
+				t.Error("default prefix proof verified with custom prefixes", n, i)
+			}
+		}
+	}
+
+	// Resetting the prefixes restores the defaults.
+	custom.SetPrefixes(nil, nil)
+	root, proofSet := prefixedProof(leaves, 3, []byte{0}, []byte{1})
+	if !custom.VerifyProof(root, proofSet, 3, uint64(len(leaves))) {
+		t.Error("proof did not verify after resetting the prefixes")
+	}
+}
+
+// Verifier, for comparison with BenchmarkVerifyProof.
+func BenchmarkVerifier(b *testing.B) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(12345); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1<<20-1; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	root, proofSet, proofIndex, numLeaves := tree.Prove()
+
+	v := NewVerifier(sha256.New)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !v.VerifyProof(root, proofSet, proofIndex, numLeaves) {
+			b.Fatal("proof did not verify")
+		}
+	}
+}
@@ -2,23 +2,206 @@ package merkletree
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"hash"
+	"math"
+	"strconv"
 )
 
+// The errors returned by VerifyProofErr for proofs that can't be checked
+// against the Merkle root at all.
+var (
+	ErrNilRoot              = errors.New("merkle root is nil")
+	ErrProofIndexOutOfRange = errors.New("proof index is not less than the number of leaves")
+	ErrProofTooShort        = errors.New("proof set is too short for the proof index and number of leaves")
+	ErrProofTooLong         = errors.New("proof set is too long for the proof index and number of leaves")
+)
+
+// A RootMismatchError is returned by VerifyProofErr when a proof is well
+// formed, but the root it produces is not the expected Merkle root. This is
+// the result of a corrupted leaf or sibling, of siblings in the wrong order,
+// or of the wrong number of leaves.
+type RootMismatchError struct {
+	Computed []byte
+	Expected []byte
+}
+
+// Error implements the error interface.
+func (e *RootMismatchError) Error() string {
+	return "proof produces root " + hex.EncodeToString(e.Computed) + ", expected " + hex.EncodeToString(e.Expected)
+}
+
+// An ElementSizeError is returned by VerifyProofErr when an element of the
+// proof set after the leaf data is not the size of the hash's output.
+type ElementSizeError struct {
+	Index    int
+	Size     int
+	Expected int
+}
+
+// Error implements the error interface.
+func (e *ElementSizeError) Error() string {
+	return "proof set element " + strconv.Itoa(e.Index) + " is " + strconv.Itoa(e.Size) + " bytes, expected " + strconv.Itoa(e.Expected)
+}
+
 // VerifyProof takes a Merkle root, a proofSet, and a proofIndex and returns
 // true if the first element of the proof set is a leaf of data in the Merkle
 // root. False is returned if the proof set or Merkle root is nil, and if
-// 'numLeaves' equals 0.
+// 'numLeaves' equals 0. A nil first element is the data of an empty leaf, the
+// same as an empty slice, since Push treats them the same. Every other
+// element is a sibling sum, so a nil or empty one is rejected rather than
+// hashed. VerifyProof is VerifyProofErr with the reason for the failure
+// discarded.
 func VerifyProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) bool {
-	// Return false for nonsense input. A switch statement is used so that the
-	// cover tool will reveal if a case is not covered by the test suite. This
-	// would not be possible using a single if statement due to the limitations
-	// of the cover tool.
+	return VerifyProofErr(h, merkleRoot, proofSet, proofIndex, numLeaves) == nil
+}
+
+// VerifyProofErr is the same as VerifyProof, but explains why a proof was
+// rejected. It returns nil if the proof is valid, ErrNilRoot,
+// ErrProofIndexOutOfRange, ErrProofTooShort or ErrProofTooLong if the inputs
+// are inconsistent, an *ElementSizeError if a sibling is not the size of the
+// hash's output, and a *RootMismatchError if the proof produces the wrong
+// root.
+func VerifyProofErr(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) error {
+	// Return an error for nonsense input.
 	if merkleRoot == nil {
-		return false
+		return ErrNilRoot
+	}
+	sum, err := proofRoot(h, nil, proofSet, proofIndex, numLeaves)
+	if err != nil {
+		return err
+	}
+
+	// Compare our calculated Merkle root to the desired Merkle root.
+	if !bytes.Equal(sum, merkleRoot) {
+		return &RootMismatchError{Computed: sum, Expected: merkleRoot}
+	}
+	return nil
+}
+
+// proofLen returns the number of elements in the proof set of the leaf at
+// 'proofIndex' in a tree with 'numLeaves' leaves: the leaf data, one sibling
+// per level of the largest complete subtree containing the leaf, the elevated
+// orphan to its right if there is one, and one sibling per larger subtree to
+// its left. 'proofIndex' must be less than 'numLeaves'.
+func proofLen(proofIndex, numLeaves uint64) int {
+	_, max := proofLenRange(proofIndex, numLeaves)
+	return max
+}
+
+// proofLenRange returns the range of proof set lengths that VerifyProof
+// accepts for the leaf at 'proofIndex' in a tree with 'numLeaves' leaves.
+// 'max' is the length of the full proof set, as returned by proofLen.
+// VerifyProof does not require the siblings of the larger subtrees to the
+// left, so 'min' is the length without any of them. 'proofIndex' must be less
+// than 'numLeaves'.
+func proofLenRange(proofIndex, numLeaves uint64) (min, max int) {
+	min, max = 1, 1
+	walkProofNodes(proofIndex, numLeaves, func(_ nodeRange, kind proofNodeKind) {
+		if kind != outerSibling {
+			min++
+		}
+		max++
+	})
+	return min, max
+}
+
+// NumLeavesRange returns the inclusive range of tree sizes [min, max] for
+// which the proof set of the leaf at 'proofIndex', as built by Tree.Prove,
+// has exactly 'proofSetLen' elements. The length of a proof set never shrinks as
+// leaves are added to the tree, so the sizes always form a single range. An
+// error is returned if no tree size produces a proof set of that length.
+func NumLeavesRange(proofSetLen int, proofIndex uint64) (min, max uint64, err error) {
+	if proofIndex == math.MaxUint64 {
+		return 0, 0, errors.New("no tree has a leaf at the proof index")
+	}
+	// firstAtLeast returns the smallest tree size containing the proof index
+	// whose proof set has at least 'n' elements, and false if there is none.
+	firstAtLeast := func(n int) (uint64, bool) {
+		lo, hi := proofIndex+1, uint64(math.MaxUint64)
+		if proofLen(proofIndex, hi) < n {
+			return 0, false
+		}
+		for lo < hi {
+			mid := lo + (hi-lo)/2
+			if proofLen(proofIndex, mid) >= n {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		return lo, true
+	}
+
+	min, ok := firstAtLeast(proofSetLen)
+	if !ok || proofLen(proofIndex, min) != proofSetLen {
+		return 0, 0, errors.New("no tree size has a proof set of that length for the proof index")
+	}
+	if next, ok := firstAtLeast(proofSetLen + 1); ok {
+		return min, next - 1, nil
+	}
+	return min, math.MaxUint64, nil
+}
+
+// proofRoot computes the Merkle root that a proof set produces for
+// 'proofIndex' and 'numLeaves'. The root is built in 'buf', which may be nil,
+// and returned. ErrProofIndexOutOfRange, ErrProofTooShort, ErrProofTooLong or
+// an *ElementSizeError is returned if the proof set can't produce a root.
+func proofRoot(h hash.Hash, buf []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) ([]byte, error) {
+	return proofRootFrom(h, buf, sumPrefixes{}, nil, proofSet, proofIndex, numLeaves)
+}
+
+// sumPrefixes are the prefixes written to the hash before the data of a leaf
+// and before the children of a node. A nil prefix is the prefix of the Tree,
+// 0x00 for leaves and 0x01 for nodes.
+type sumPrefixes struct {
+	leaf, node []byte
+}
+
+// appendLeafSum is the same as the appendLeafSum function, using the leaf
+// prefix.
+func (p sumPrefixes) appendLeafSum(dst []byte, h hash.Hash, data []byte) []byte {
+	if p.leaf == nil {
+		return appendLeafSum(dst, h, data)
 	}
+	h.Reset()
+	_, _ = h.Write(p.leaf)
+	_, _ = h.Write(data)
+	return h.Sum(dst)
+}
+
+// appendNodeSum is the same as the appendNodeSum function, using the node
+// prefix.
+func (p sumPrefixes) appendNodeSum(dst []byte, h hash.Hash, a, b []byte) []byte {
+	if p.node == nil {
+		return appendNodeSum(dst, h, a, b)
+	}
+	h.Reset()
+	_, _ = h.Write(p.node)
+	_, _ = h.Write(a)
+	_, _ = h.Write(b)
+	return h.Sum(dst)
+}
+
+// proofRootFrom is the same as proofRoot, but hashes with the prefixes in
+// 'prefixes', and if 'leafHash' is not nil, it is used as the leaf sum of the
+// proven leaf and the first element of the proof set is ignored.
+func proofRootFrom(h hash.Hash, buf []byte, prefixes sumPrefixes, leafHash []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) ([]byte, error) {
 	if proofIndex >= numLeaves {
-		return false
+		return nil, ErrProofIndexOutOfRange
+	}
+	// Reject proof sets that are too long before hashing anything, so that a
+	// proof padded with millions of elements costs nothing to reject.
+	if len(proofSet) > proofLen(proofIndex, numLeaves) {
+		return nil, ErrProofTooLong
+	}
+	// Every element after the leaf data is a sibling sum, so it must be the
+	// size of the hash's output. The leaf data may have any size.
+	for i := 1; i < len(proofSet); i++ {
+		if len(proofSet[i]) != h.Size() {
+			return nil, &ElementSizeError{Index: i, Size: len(proofSet[i]), Expected: h.Size()}
+		}
 	}
 
 	// In a Merkle tree, every node except the root node has a sibling.
@@ -49,15 +232,23 @@ func VerifyProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex u
 	//
 	// One vulnerability with the proof verification is that the proofSet may
 	// not be long enough. Before looking at an element of proofSet, a check
-	// needs to be made that the element exists.
+	// needs to be made that the element exists. A proofSet that is too long
+	// has already been rejected above.
 
 	// The first element of the set is the original data. A sibling at height 1
-	// is created by getting the leafSum of the original data.
+	// is created by getting the leafSum of the original data, unless the
+	// caller supplied the leaf sum. Every following node sum is written over
+	// 'sum', so verification allocates at most once.
 	height := 0
 	if len(proofSet) <= height {
-		return false
+		return nil, ErrProofTooShort
+	}
+	var sum []byte
+	if leafHash != nil {
+		sum = append(buf[:0], leafHash...)
+	} else {
+		sum = prefixes.appendLeafSum(buf[:0], h, proofSet[height])
 	}
-	sum := leafSum(h, proofSet[height])
 	height++
 
 	// While the current subtree (of height 'height') is complete, determine
@@ -65,9 +256,11 @@ func VerifyProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex u
 	// 'stableEnd' tells us the ending index of the last full subtree. It gets
 	// initialized to 'proofIndex' because the first full subtree was the
 	// subtree of height 1, created above (and had an ending index of
-	// 'proofIndex').
+	// 'proofIndex'. A subtree above MaxHeight would have more leaves than
+	// a tree can hold, so it is never complete, and stopping there keeps
+	// 1 << 'height' from overflowing to zero.
 	stableEnd := proofIndex
-	for {
+	for height <= MaxHeight {
 		// Determine if the subtree is complete. This is accomplished by
 		// rounding down the proofIndex to the nearest 1 << 'height', adding 1
 		// << 'height', and comparing the result to the number of leaves in the
@@ -85,12 +278,12 @@ func VerifyProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex u
 		// Determine if the proofIndex is in the first or the second half of
 		// the subtree.
 		if len(proofSet) <= height {
-			return false
+			return nil, ErrProofTooShort
 		}
 		if proofIndex-subTreeStartIndex < 1<<uint(height-1) {
-			sum = nodeSum(h, sum, proofSet[height])
+			sum = prefixes.appendNodeSum(sum[:0], h, sum, proofSet[height])
 		} else {
-			sum = nodeSum(h, proofSet[height], sum)
+			sum = prefixes.appendNodeSum(sum[:0], h, proofSet[height], sum)
 		}
 		height++
 	}
@@ -100,21 +293,121 @@ func VerifyProof(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex u
 	// is equal to the number of leaves in the Merkle tree.
 	if stableEnd != numLeaves-1 {
 		if len(proofSet) <= height {
-			return false
+			return nil, ErrProofTooShort
 		}
-		sum = nodeSum(h, sum, proofSet[height])
+		sum = prefixes.appendNodeSum(sum[:0], h, sum, proofSet[height])
 		height++
 	}
 
 	// All remaining elements in the proof set will belong to a left sibling.
 	for height < len(proofSet) {
-		sum = nodeSum(h, proofSet[height], sum)
+		sum = prefixes.appendNodeSum(sum[:0], h, proofSet[height], sum)
 		height++
 	}
+	return sum, nil
+}
 
-	// Compare our calculated Merkle root to the desired Merkle root.
-	if bytes.Equal(sum, merkleRoot) {
-		return true
+// LeafSum returns the leaf sum of 'data', H(0x00 || data), which is the sum
+// of the leaf that the Tree creates when 'data' is pushed.
+func LeafSum(h hash.Hash, data []byte) []byte {
+	return leafSum(h, data)
+}
+
+// VerifyProofWithLeafHash is the same as VerifyProof, but starts from the
+// leaf sum of the proven leaf, as returned by LeafSum, instead of its data.
+// 'siblings' is the proof set without its first element. The proof only shows
+// that 'leafHash' is in the tree: the caller is responsible for binding
+// 'leafHash' to the leaf data, for example by hashing data that it already
+// holds, or by trusting the party that revealed the leaf sum.
+func VerifyProofWithLeafHash(h hash.Hash, merkleRoot []byte, leafHash []byte, siblings [][]byte, proofIndex uint64, numLeaves uint64) bool {
+	if merkleRoot == nil || len(leafHash) != h.Size() {
+		return false
+	}
+	proofSet := append([][]byte{nil}, siblings...)
+	sum, err := proofRootFrom(h, nil, sumPrefixes{}, leafHash, proofSet, proofIndex, numLeaves)
+	return err == nil && bytes.Equal(sum, merkleRoot)
+}
+
+// VerifyProofLenient is the same as VerifyProof, except that elements at the
+// end of the proof set beyond those needed to reconstruct the root are
+// ignored instead of causing the proof to be rejected. It exists for proofs
+// from producers that append extra data, such as the root itself, to the
+// proof set.
+//
+// The ignored elements are not authenticated by the root, so they can be
+// anything: an attacker can attach arbitrary trailing data to a valid proof
+// and it will still be accepted. Callers must not read the trailing
+// elements, and must not treat a proof as unique by its encoding, for
+// example by hashing it to deduplicate or to identify it. VerifyProof should
+// be used unless the extra elements are known to be harmless.
+func VerifyProofLenient(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) bool {
+	if proofIndex < numLeaves && len(proofSet) > proofLen(proofIndex, numLeaves) {
+		proofSet = proofSet[:proofLen(proofIndex, numLeaves)]
+	}
+	return VerifyProof(h, merkleRoot, proofSet, proofIndex, numLeaves)
+}
+
+// VerifyProofStrict is the same as VerifyProof, except that the proof set
+// must have exactly the number of elements that Tree.Prove produces for
+// 'proofIndex' and 'numLeaves'. VerifyProof accepts a proof set that leaves
+// out some of the siblings of the larger subtrees to the left of the leaf,
+// checking it against the root of a smaller subtree instead of the root of
+// the whole tree, but such a proof was not built by a conforming producer.
+// The length is checked before anything is hashed.
+func VerifyProofStrict(h hash.Hash, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) bool {
+	if proofIndex >= numLeaves || len(proofSet) != proofLen(proofIndex, numLeaves) {
+		return false
+	}
+	return VerifyProof(h, merkleRoot, proofSet, proofIndex, numLeaves)
+}
+
+// ComputeSubrootFromProof folds the first part of a proof set into the root
+// of the subtree at 'height' that contains the leaf at 'proofIndex', and
+// returns that root along with the proof elements that were not used, so that
+// the caller can check the subtree root against a trusted value and continue
+// folding later. The subtree covers the leaves [s, s+2^height), where 's' is
+// 'proofIndex' rounded down to a multiple of 2^height. When the tree is
+// ragged and the subtree would extend past the last leaf, it covers the
+// leaves [s, numLeaves) instead, which is also a node of the tree. The proof
+// set only needs to be long enough to reach the subtree root.
+func ComputeSubrootFromProof(h hash.Hash, proofSet [][]byte, proofIndex, numLeaves uint64, height int) (subroot []byte, restOfProof [][]byte, err error) {
+	if height < 0 || height > MaxHeight {
+		return nil, nil, errors.New("height must be between 0 and MaxHeight")
+	}
+	if proofIndex >= numLeaves {
+		return nil, nil, ErrProofIndexOutOfRange
+	}
+	ranges := proofNodeRanges(proofIndex, numLeaves)
+	if len(proofSet) > len(ranges)+1 {
+		return nil, nil, ErrProofTooLong
+	}
+
+	// Fold the siblings for as long as they lie within the subtree.
+	subTreeStart := proofIndex >> uint(height) << uint(height)
+	subTreeEnd := subTreeStart + 1<<uint(height)
+	if subTreeEnd > numLeaves || subTreeEnd < subTreeStart {
+		subTreeEnd = numLeaves
+	}
+	if len(proofSet) < 1 {
+		return nil, nil, ErrProofTooShort
+	}
+	sum := leafSum(h, proofSet[0])
+	start := proofIndex
+	i := 0
+	for ; i < len(ranges) && subTreeStart <= ranges[i].start && ranges[i].end <= subTreeEnd; i++ {
+		if len(proofSet) <= i+1 {
+			return nil, nil, ErrProofTooShort
+		}
+		sibling := proofSet[i+1]
+		if len(sibling) != h.Size() {
+			return nil, nil, &ElementSizeError{Index: i + 1, Size: len(sibling), Expected: h.Size()}
+		}
+		if ranges[i].end <= start {
+			sum = appendNodeSum(sum[:0], h, sibling, sum)
+			start = ranges[i].start
+		} else {
+			sum = appendNodeSum(sum[:0], h, sum, sibling)
+		}
 	}
-	return false
+	return sum, proofSet[i+1:], nil
 }
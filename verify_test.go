@@ -0,0 +1,524 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestVerifyProofErr checks that VerifyProofErr returns the expected error
+// for each kind of corruption that can be applied to a valid proof.
+func TestVerifyProofErr(t *testing.T) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 13; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	root, proofSet, proofIndex, numLeaves := tree.Prove()
+	if err := VerifyProofErr(sha256.New(), root, proofSet, proofIndex, numLeaves); err != nil {
+		t.Fatal("valid proof was rejected:", err)
+	}
+
+	// copyProof returns a copy of the proof set whose elements can be
+	// modified.
+	copyProof := func() [][]byte {
+		c := make([][]byte, len(proofSet))
+		for i := range proofSet {
+			c[i] = append([]byte(nil), proofSet[i]...)
+		}
+		return c
+	}
+	isMismatch := func(err error) bool {
+		_, ok := err.(*RootMismatchError)
+		return ok
+	}
+
+	if err := VerifyProofErr(sha256.New(), nil, proofSet, proofIndex, numLeaves); err != ErrNilRoot {
+		t.Error("expected ErrNilRoot, got", err)
+	}
+	for i := 0; i < len(proofSet); i++ {
+		if err := VerifyProofErr(sha256.New(), root, proofSet[:i], proofIndex, numLeaves); err != ErrProofTooShort {
+			t.Error("expected ErrProofTooShort for a proof truncated to", i, "elements, got", err)
+		}
+	}
+
+	swapped := copyProof()
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	if err := VerifyProofErr(sha256.New(), root, swapped, proofIndex, numLeaves); !isMismatch(err) {
+		t.Error("expected a RootMismatchError for swapped siblings, got", err)
+	}
+
+	flipped := copyProof()
+	flipped[0][0] ^= 1
+	err := VerifyProofErr(sha256.New(), root, flipped, proofIndex, numLeaves)
+	if !isMismatch(err) {
+		t.Fatal("expected a RootMismatchError for a flipped leaf bit, got", err)
+	}
+	rme := err.(*RootMismatchError)
+	if !bytes.Equal(rme.Expected, root) || bytes.Equal(rme.Computed, root) {
+		t.Error("RootMismatchError has the wrong roots")
+	}
+	if !strings.Contains(err.Error(), "expected") || len(err.Error()) < 4*sha256.Size {
+		t.Error("RootMismatchError does not include both roots in hex:", err)
+	}
+
+	if err := VerifyProofErr(sha256.New(), root, proofSet, proofIndex, proofIndex); err != ErrProofIndexOutOfRange {
+		t.Error("expected ErrProofIndexOutOfRange, got", err)
+	}
+	if err := VerifyProofErr(sha256.New(), root, proofSet, proofIndex, 7); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong for a smaller number of leaves, got", err)
+	}
+	if err := VerifyProofErr(sha256.New(), root, proofSet, proofIndex, 64); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort for a much larger number of leaves, got", err)
+	}
+}
+
+// TestVerifyProofLenient checks that VerifyProofLenient accepts proofs with
+// trailing elements while VerifyProof rejects them, and that it still rejects
+// invalid proofs.
+func TestVerifyProofLenient(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	for numLeaves, proofSets := range mt.proofSets {
+		for proofIndex, proofSet := range proofSets {
+			root := mt.roots[numLeaves]
+			oneExtra := append(append([][]byte(nil), proofSet...), root)
+			severalExtra := append(append([][]byte(nil), oneExtra...), []byte{1}, make([]byte, 32))
+			for _, extended := range [][][]byte{proofSet, oneExtra, severalExtra} {
+				if !VerifyProofLenient(sha256.New(), root, extended, uint64(proofIndex), uint64(numLeaves)) {
+					t.Error("lenient verification rejected a proof with", len(extended)-len(proofSet), "extra elements", numLeaves, proofIndex)
+				}
+			}
+			for _, extended := range [][][]byte{oneExtra, severalExtra} {
+				if VerifyProof(sha256.New(), root, extended, uint64(proofIndex), uint64(numLeaves)) {
+					t.Error("strict verification accepted a proof with extra elements", numLeaves, proofIndex)
+				}
+			}
+			if numLeaves > 1 && VerifyProofLenient(sha256.New(), root, oneExtra, uint64(proofIndex+1)%uint64(numLeaves), uint64(numLeaves)) {
+				t.Error("lenient verification accepted a proof for the wrong index", numLeaves, proofIndex)
+			}
+		}
+	}
+	if VerifyProofLenient(sha256.New(), mt.roots[15], mt.proofSets[15][10][:2], 10, 15) {
+		t.Error("lenient verification accepted a proof that is too short")
+	}
+	if VerifyProofLenient(sha256.New(), mt.roots[15], mt.proofSets[15][10], 15, 15) {
+		t.Error("lenient verification accepted an index outside of the tree")
+	}
+}
+
+// TestVerifyProofStrict checks that VerifyProofStrict agrees with
+// VerifyProof on canonical proofs, and rejects proofs with a non-canonical
+// number of elements without hashing them.
+func TestVerifyProofStrict(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	for numLeaves, proofSets := range mt.proofSets {
+		for proofIndex, proofSet := range proofSets {
+			root := mt.roots[numLeaves]
+			if !VerifyProofStrict(sha256.New(), root, proofSet, uint64(proofIndex), uint64(numLeaves)) {
+				t.Error("strict verification rejected a canonical proof", numLeaves, proofIndex)
+			}
+			if !VerifyProof(sha256.New(), root, proofSet, uint64(proofIndex), uint64(numLeaves)) {
+				t.Error("verification rejected a canonical proof", numLeaves, proofIndex)
+			}
+
+			// A padded proof is rejected by both.
+			padded := append(append([][]byte(nil), proofSet...), root)
+			if VerifyProofStrict(sha256.New(), root, padded, uint64(proofIndex), uint64(numLeaves)) ||
+				VerifyProof(sha256.New(), root, padded, uint64(proofIndex), uint64(numLeaves)) {
+				t.Error("padded proof was accepted", numLeaves, proofIndex)
+			}
+
+			// A proof without its trailing siblings to the left may still
+			// verify, but is never strictly valid.
+			ch := &countingHash{Hash: sha256.New()}
+			for n := 1; n < len(proofSet); n++ {
+				if VerifyProofStrict(ch, root, proofSet[:n], uint64(proofIndex), uint64(numLeaves)) {
+					t.Error("strict verification accepted a truncated proof", numLeaves, proofIndex, n)
+				}
+			}
+			if ch.writes != 0 {
+				t.Error("strict verification hashed a proof of the wrong length", numLeaves, proofIndex)
+			}
+		}
+	}
+
+	// The leaf at index 4 of a 6 leaf tree has a sibling covering leaves
+	// [0, 4). Without it, VerifyProof accepts the proof against the root of
+	// leaves [4, 6).
+	subRoot := nodeSum(sha256.New(), mt.leaves[4], mt.leaves[5])
+	if !VerifyProof(sha256.New(), subRoot, mt.proofSets[6][4][:2], 4, 6) {
+		t.Fatal("expected VerifyProof to accept a proof without its trailing left sibling")
+	}
+	if VerifyProofStrict(sha256.New(), subRoot, mt.proofSets[6][4][:2], 4, 6) {
+		t.Error("strict verification accepted a proof without its trailing left sibling")
+	}
+	if VerifyProofStrict(sha256.New(), mt.roots[6], mt.proofSets[6][4], 6, 6) {
+		t.Error("strict verification accepted an index outside of the tree")
+	}
+}
+
+// TestVerifyProofWithLeafHash checks that verifying from the leaf sum agrees
+// with verifying from the leaf data.
+func TestVerifyProofWithLeafHash(t *testing.T) {
+	for numLeaves := uint64(1); numLeaves < 34; numLeaves++ {
+		for proofIndex := uint64(0); proofIndex < numLeaves; proofIndex++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(proofIndex); err != nil {
+				t.Fatal(err)
+			}
+			for i := uint64(0); i < numLeaves; i++ {
+				tree.Push(bytes.Repeat([]byte{byte(i)}, int(i%5)))
+			}
+			root, proofSet, _, _ := tree.Prove()
+			leafHash := LeafSum(sha256.New(), proofSet[0])
+			if !VerifyProofWithLeafHash(sha256.New(), root, leafHash, proofSet[1:], proofIndex, numLeaves) {
+				t.Error("valid leaf hash proof was rejected", numLeaves, proofIndex)
+			}
+
+			// A wrong leaf hash, the leaf data in place of its hash, an extra
+			// sibling and the wrong index are all rejected.
+			for _, bad := range []struct {
+				leafHash []byte
+				siblings [][]byte
+				index, n uint64
+			}{
+				{LeafSum(sha256.New(), append(proofSet[0], 1)), proofSet[1:], proofIndex, numLeaves},
+				{proofSet[0], proofSet[1:], proofIndex, numLeaves},
+				{leafHash, append(proofSet[1:len(proofSet):len(proofSet)], root), proofIndex, numLeaves},
+				{leafHash, proofSet[1:], proofIndex + 1, numLeaves},
+				{leafHash, proofSet[1:], numLeaves, numLeaves},
+			} {
+				if VerifyProofWithLeafHash(sha256.New(), root, bad.leafHash, bad.siblings, bad.index, bad.n) {
+					t.Error("corrupt leaf hash proof was accepted", numLeaves, proofIndex)
+				}
+			}
+		}
+	}
+	if VerifyProofWithLeafHash(sha256.New(), nil, LeafSum(sha256.New(), nil), nil, 0, 1) {
+		t.Error("nil root was accepted")
+	}
+}
+
+// TestNumLeavesRange checks NumLeavesRange against proofLen for every proof
+// index and tree size up to 2000.
+func TestNumLeavesRange(t *testing.T) {
+	const maxLeaves = 2000
+	if testing.Short() {
+		t.SkipNow()
+	}
+	for proofIndex := uint64(0); proofIndex < maxLeaves; proofIndex++ {
+		// Collect the range of tree sizes for every proof length.
+		ranges := make(map[int][2]uint64)
+		for numLeaves := proofIndex + 1; numLeaves <= maxLeaves; numLeaves++ {
+			n := proofLen(proofIndex, numLeaves)
+			r, ok := ranges[n]
+			if !ok {
+				r[0] = numLeaves
+			} else if r[1] != numLeaves-1 {
+				t.Fatal("tree sizes for a proof length are not contiguous", proofIndex, n)
+			}
+			r[1] = numLeaves
+			ranges[n] = r
+		}
+		for n, r := range ranges {
+			min, max, err := NumLeavesRange(n, proofIndex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The range of the longest proof may continue past maxLeaves.
+			if min != r[0] || (max != r[1] && r[1] != maxLeaves) || max < r[1] {
+				t.Error("wrong range", proofIndex, n, min, max, r)
+			}
+		}
+		for n := 0; n < 70; n++ {
+			if _, ok := ranges[n]; ok {
+				continue
+			}
+			if min, _, err := NumLeavesRange(n, proofIndex); err == nil && min <= maxLeaves {
+				t.Error("range returned for an impossible proof length", proofIndex, n, min)
+			}
+		}
+	}
+
+	// A large index, checked at the edges of its range.
+	n := proofLen(1000000, 1000001)
+	min, max, err := NumLeavesRange(n, 1000000)
+	if err != nil || min > 1000001 || max < 1000001 || proofLen(1000000, min) != n || proofLen(1000000, max) != n ||
+		proofLen(1000000, max+1) == n {
+		t.Error("wrong range for a large index", min, max, err)
+	}
+	if _, _, err := NumLeavesRange(1, 5); err == nil {
+		t.Error("range returned for a proof that is too short")
+	}
+	if _, _, err := NumLeavesRange(1, math.MaxUint64); err == nil {
+		t.Error("range returned for an index that can't be in a tree")
+	}
+}
+
+// countingHash is a hash.Hash that counts the calls to Write.
+type countingHash struct {
+	hash.Hash
+	writes int
+}
+
+// Write implements hash.Hash.
+func (ch *countingHash) Write(p []byte) (int, error) {
+	ch.writes++
+	return ch.Hash.Write(p)
+}
+
+// TestProofLen checks that proofLen agrees with the length of real proofs.
+func TestProofLen(t *testing.T) {
+	for numLeaves := uint64(1); numLeaves < 70; numLeaves++ {
+		for index := uint64(0); index < numLeaves; index++ {
+			if proofLen(index, numLeaves) != len(proofNodeRanges(index, numLeaves))+1 {
+				t.Error("proofLen disagrees with proofNodeRanges", numLeaves, index)
+			}
+		}
+	}
+	mt := CreateMerkleTester(t)
+	for numLeaves, proofSets := range mt.proofSets {
+		for proofIndex, proofSet := range proofSets {
+			if proofLen(uint64(proofIndex), uint64(numLeaves)) != len(proofSet) {
+				t.Error("proofLen disagrees with the MerkleTester", numLeaves, proofIndex)
+			}
+		}
+	}
+}
+
+// TestVerifyProofTooLong checks that absurdly long proof sets are rejected
+// without hashing anything, and that the longest legitimate proofs still
+// verify.
+func TestVerifyProofTooLong(t *testing.T) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1<<10; i++ {
+		tree.Push([]byte{byte(i)})
+	}
+	root, proofSet, proofIndex, numLeaves := tree.Prove()
+	if !VerifyProof(sha256.New(), root, proofSet, proofIndex, numLeaves) {
+		t.Fatal("maximal proof for a full tree did not verify")
+	}
+
+	long := make([][]byte, 1e6)
+	copy(long, proofSet)
+	for i := len(proofSet); i < len(long); i++ {
+		long[i] = root
+	}
+	ch := &countingHash{Hash: sha256.New()}
+	if err := VerifyProofErr(ch, root, long, proofIndex, numLeaves); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong, got", err)
+	}
+	if ch.writes != 0 {
+		t.Error("a proof that is too long was hashed before being rejected:", ch.writes)
+	}
+	if err := VerifyProofErr(sha256.New(), root, proofSet, proofIndex, numLeaves); err != nil {
+		t.Error("proof of exactly the right length was rejected:", err)
+	}
+	if err := VerifyProofErr(sha256.New(), root, append(proofSet[:len(proofSet):len(proofSet)], root), proofIndex, numLeaves); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong for one extra element, got", err)
+	}
+}
+
+// TestVerifierMaxLeafSize checks that a Verifier rejects leaves larger than
+// its MaxLeafSize without hashing them.
+func TestVerifierMaxLeafSize(t *testing.T) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		tree.Push(make([]byte, 64))
+	}
+	root, proofSet, proofIndex, numLeaves := tree.Prove()
+
+	v := NewVerifier(sha256.New)
+	v.MaxLeafSize = 64
+	if err := v.VerifyProofErr(root, proofSet, proofIndex, numLeaves); err != nil {
+		t.Error("leaf of exactly MaxLeafSize was rejected:", err)
+	}
+	big := append([][]byte{make([]byte, 1<<20)}, proofSet[1:]...)
+	if err := v.VerifyProofErr(root, big, proofIndex, numLeaves); err != ErrLeafTooLarge {
+		t.Error("expected ErrLeafTooLarge, got", err)
+	}
+}
+
+// TestVerifyProofElementSize checks that siblings of the wrong size are
+// rejected with an ElementSizeError naming the element, and that the leaf
+// data may have any size.
+func TestVerifyProofElementSize(t *testing.T) {
+	tree := New(sha256.New())
+	if err := tree.SetIndex(5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 13; i++ {
+		tree.Push(make([]byte, 100+i))
+	}
+	root, proofSet, proofIndex, numLeaves := tree.Prove()
+	if err := VerifyProofErr(sha256.New(), root, proofSet, proofIndex, numLeaves); err != nil {
+		t.Fatal("proof with large leaf data was rejected:", err)
+	}
+
+	for i := 1; i < len(proofSet); i++ {
+		for _, size := range []int{0, 1, sha256.Size - 1, sha256.Size + 1} {
+			bad := append([][]byte(nil), proofSet...)
+			bad[i] = make([]byte, size)
+			err := VerifyProofErr(sha256.New(), root, bad, proofIndex, numLeaves)
+			ese, ok := err.(*ElementSizeError)
+			if !ok {
+				t.Fatal("expected an ElementSizeError, got", err)
+			}
+			if ese.Index != i || ese.Size != size || ese.Expected != sha256.Size {
+				t.Error("ElementSizeError has the wrong fields:", ese)
+			}
+			if !strings.Contains(err.Error(), "element "+strconv.Itoa(i)) {
+				t.Error("ElementSizeError does not name the element:", err)
+			}
+			if NewVerifier(sha256.New).VerifyProof(root, bad, proofIndex, numLeaves) {
+				t.Error("Verifier accepted an element of the wrong size", i, size)
+			}
+		}
+	}
+}
+
+// TestComputeSubrootFromProof checks the subroots computed from proofs
+// against the roots of the corresponding subtrees, including subtrees that
+// are cut short by the end of a ragged tree, and checks the unused proof
+// elements against the cached level proofs of a CachedTree.
+func TestComputeSubrootFromProof(t *testing.T) {
+	leaf := func(i uint64) []byte { return []byte{byte(i), byte(i >> 8)} }
+	for numLeaves := uint64(1); numLeaves < 40; numLeaves++ {
+		for proofIndex := uint64(0); proofIndex < numLeaves; proofIndex++ {
+			tree := New(sha256.New())
+			if err := tree.SetIndex(proofIndex); err != nil {
+				t.Fatal(err)
+			}
+			for i := uint64(0); i < numLeaves; i++ {
+				tree.Push(leaf(i))
+			}
+			root, proofSet, _, _ := tree.Prove()
+
+			for height := 0; height < 7; height++ {
+				start := proofIndex >> uint(height) << uint(height)
+				end := start + 1<<uint(height)
+				if end > numLeaves {
+					end = numLeaves
+				}
+				subTree := New(sha256.New())
+				for i := start; i < end; i++ {
+					subTree.Push(leaf(i))
+				}
+
+				subroot, rest, err := ComputeSubrootFromProof(sha256.New(), proofSet, proofIndex, numLeaves, height)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(subroot, subTree.Root()) {
+					t.Error("wrong subroot", numLeaves, proofIndex, height)
+				}
+				if len(rest) > len(proofSet)-1 || (len(rest) > 0 && &rest[len(rest)-1] != &proofSet[len(proofSet)-1]) {
+					t.Error("rest of proof is not a suffix of the proof set", numLeaves, proofIndex, height)
+				}
+				if start == 0 && end == numLeaves && (len(rest) != 0 || !bytes.Equal(subroot, root)) {
+					t.Error("subtree covering the whole tree should produce the root", numLeaves, proofIndex, height)
+				}
+			}
+		}
+	}
+
+	// When the subtree is a cached node, the rest of the proof is the proof
+	// of the cached node within the CachedTree.
+	for height := uint64(0); height < 4; height++ {
+		n := uint64(1) << height
+		for numNodes := uint64(1); numNodes < 9; numNodes++ {
+			for proofIndex := uint64(0); proofIndex < numNodes*n; proofIndex++ {
+				tree := New(sha256.New())
+				if err := tree.SetIndex(proofIndex); err != nil {
+					t.Fatal(err)
+				}
+				cachedTree := NewCachedTree(sha256.New(), height)
+				if err := cachedTree.SetIndex(proofIndex); err != nil {
+					t.Fatal(err)
+				}
+				var cachedRoot []byte
+				for k := uint64(0); k < numNodes; k++ {
+					subtree := addSubTree(height, []byte{byte(k)}, 0, tree)
+					cachedTree.Push(subtree.Root())
+					if k == proofIndex/n {
+						cachedRoot = subtree.Root()
+					}
+				}
+				_, proofSet, _, numLeaves := tree.Prove()
+				_, cachedProof, _, _ := cachedTree.Tree.Prove()
+
+				subroot, rest, err := ComputeSubrootFromProof(sha256.New(), proofSet, proofIndex, numLeaves, int(height))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(subroot, cachedRoot) {
+					t.Error("subroot does not match the cached root", height, numNodes, proofIndex)
+				}
+				if len(rest) != len(cachedProof)-1 {
+					t.Fatal("rest of proof has the wrong length", height, numNodes, proofIndex)
+				}
+				for i := range rest {
+					if !bytes.Equal(rest[i], cachedProof[i+1]) {
+						t.Error("rest of proof does not match the cached proof", height, numNodes, proofIndex, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestComputeSubrootFromProofBadInputs checks that bad inputs are rejected.
+func TestComputeSubrootFromProofBadInputs(t *testing.T) {
+	mt := CreateMerkleTester(t)
+	proofSet := mt.proofSets[15][10]
+	if _, _, err := ComputeSubrootFromProof(sha256.New(), proofSet, 10, 15, -1); err == nil {
+		t.Error("able to compute a subroot at a negative height")
+	}
+	if _, _, err := ComputeSubrootFromProof(sha256.New(), proofSet, 15, 15, 1); err != ErrProofIndexOutOfRange {
+		t.Error("expected ErrProofIndexOutOfRange, got", err)
+	}
+	if _, _, err := ComputeSubrootFromProof(sha256.New(), proofSet[:2], 10, 15, 3); err != ErrProofTooShort {
+		t.Error("expected ErrProofTooShort, got", err)
+	}
+	if _, _, err := ComputeSubrootFromProof(sha256.New(), append(proofSet[:len(proofSet):len(proofSet)], proofSet[1]), 10, 15, 1); err != ErrProofTooLong {
+		t.Error("expected ErrProofTooLong, got", err)
+	}
+	if _, _, err := ComputeSubrootFromProof(sha256.New(), [][]byte{proofSet[0], {1}}, 10, 15, 1); err == nil {
+		t.Error("able to compute a subroot from a sibling of the wrong size")
+	}
+	// A short proof is fine as long as it reaches the requested height.
+	subroot, rest, err := ComputeSubrootFromProof(sha256.New(), proofSet[:2], 10, 15, 1)
+	if err != nil || len(rest) != 0 || !bytes.Equal(subroot, mt.join(mt.leaves[10], mt.leaves[11])) {
+		t.Error("unable to compute a subroot from a proof that only reaches the requested height", err)
+	}
+}
+
+// TestVerifyProofMaxLeaves checks that proofs for the largest possible tree,
+// whose height reaches past MaxHeight, are rejected instead of overflowing
+// the subtree shifts.
+func TestVerifyProofMaxLeaves(t *testing.T) {
+	for _, index := range []uint64{0, 1 << MaxHeight, math.MaxUint64 - 1} {
+		proofSet := make([][]byte, proofLen(index, math.MaxUint64))
+		for i := range proofSet {
+			proofSet[i] = make([]byte, sha256.Size)
+		}
+		if VerifyProof(sha256.New(), make([]byte, sha256.Size), proofSet, index, math.MaxUint64) {
+			t.Error("garbage proof was accepted", index)
+		}
+		if _, ok := proofSides(len(proofSet), index, math.MaxUint64); !ok {
+			t.Error("no sides for a proof of the largest tree", index)
+		}
+	}
+}
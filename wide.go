@@ -0,0 +1,246 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// A WideTree is a Merkle tree in which every node has up to 'arity' children
+// instead of two, where the arity is a power of two. A node sum is
+// Hash(0x01 || child 1 sum || ... || child A sum), and leaf sums are the same
+// as in a Tree. The leaves are grouped level by
+// level: every 'arity' consecutive nodes of a level are the children of one
+// node of the level above, and the last group of a level may be smaller. A
+// group of a single node is promoted to the level above unchanged, as an
+// orphan is in a Tree, so a WideTree with arity 2 has the same roots and
+// proofs as a Tree.
+//
+// Like a Tree, a WideTree is built by streaming the leaves through a stack of
+// complete subtrees, holding fewer than 'arity' subtrees of every height, so
+// its memory footprint grows in O(arity * log(n)). Its proof set is the data
+// of the leaf, then for every level from the bottom, the sums of the other
+// nodes in the group of the node on the path to the root, in order. Levels
+// where that node is promoted have no siblings.
+type WideTree struct {
+	hash      hash.Hash
+	arity     int
+	arityBits uint
+
+	stack        []wideSubTree
+	currentIndex uint64
+
+	proofIndex uint64
+	proofSet   [][]byte
+	proofTree  bool
+}
+
+// wideSubTree is a complete subtree of a WideTree, holding arity^height leaves
+// from the leaf at 'start' on.
+type wideSubTree struct {
+	height int
+	start  uint64
+	sum    []byte
+}
+
+// validArity returns the number of bits of the arity 'arity' if it is a power
+// of two of at least 2, or 0 otherwise.
+func validArity(arity int) uint {
+	if arity < 2 || arity&(arity-1) != 0 {
+		return 0
+	}
+	return uint(bits.TrailingZeros64(uint64(arity)))
+}
+
+// wideNodeSum returns the node sum of the children 'children'.
+func wideNodeSum(h hash.Hash, children [][]byte) []byte {
+	return sum(h, append([][]byte{nodeHashPrefix}, children...)...)
+}
+
+// NewWide creates a new WideTree whose nodes have up to 'arity' children. The
+// provided hash will be used for all hashing operations within the WideTree.
+// NewWide panics if the arity is not a power of two of at least 2.
+func NewWide(h hash.Hash, arity int) *WideTree {
+	arityBits := validArity(arity)
+	if arityBits == 0 {
+		panic("wrong usage: arity must be a power of two of at least 2")
+	}
+	return &WideTree{
+		hash:      h,
+		arity:     arity,
+		arityBits: arityBits,
+	}
+}
+
+// SetIndex must be called on an empty tree, and establishes the index of the
+// leaf that will be proven by Prove.
+func (wt *WideTree) SetIndex(i uint64) error {
+	if wt.currentIndex != 0 {
+		return errors.New("cannot call SetIndex on WideTree if WideTree has not been reset")
+	}
+	wt.proofTree = true
+	wt.proofIndex = i
+	return nil
+}
+
+// appendProofSiblings appends the sums of the nodes of 'group', which are the
+// children of one node, to 'proofSet' if the node contains the proven leaf,
+// leaving out the child that contains it. Every group ends at the last leaf
+// that has been pushed.
+func (wt *WideTree) appendProofSiblings(proofSet [][]byte, group []wideSubTree) [][]byte {
+	if !wt.proofTree || wt.proofIndex < group[0].start || wt.proofIndex >= wt.currentIndex {
+		return proofSet
+	}
+	for i, st := range group {
+		if wt.proofIndex < st.start || (i+1 < len(group) && wt.proofIndex >= group[i+1].start) {
+			proofSet = append(proofSet, st.sum)
+		}
+	}
+	return proofSet
+}
+
+// joinGroup joins the subtrees of 'group' into their parent node of 'height'.
+func (wt *WideTree) joinGroup(group []wideSubTree, height int) wideSubTree {
+	sums := make([][]byte, len(group))
+	for i := range group {
+		sums[i] = group[i].sum
+	}
+	return wideSubTree{
+		height: height,
+		start:  group[0].start,
+		sum:    wideNodeSum(wt.hash, sums),
+	}
+}
+
+// Push will add data to the set, building out the Merkle tree and root. The
+// tree does not remember all elements that are added, instead only keeping
+// the subtrees needed to compute the root and the proof set.
+func (wt *WideTree) Push(data []byte) {
+	if data == nil {
+		data = []byte{}
+	}
+	if wt.proofTree && wt.currentIndex == wt.proofIndex {
+		wt.proofSet = append(wt.proofSet, data)
+	}
+	wt.stack = append(wt.stack, wideSubTree{
+		height: 0,
+		start:  wt.currentIndex,
+		sum:    leafSum(wt.hash, data),
+	})
+	wt.currentIndex++
+
+	// Join the last 'arity' subtrees for as long as they have the same
+	// height.
+	for len(wt.stack) >= wt.arity {
+		group := wt.stack[len(wt.stack)-wt.arity:]
+		if group[0].height != group[len(group)-1].height {
+			break
+		}
+		wt.proofSet = wt.appendProofSiblings(wt.proofSet, group)
+		joined := wt.joinGroup(group, group[0].height+1)
+		wt.stack = append(wt.stack[:len(wt.stack)-wt.arity], joined)
+	}
+}
+
+// finish joins the subtrees of the stack into the root, from the bottom up,
+// as the last group of every level. If 'prove' is set, the siblings of the
+// proven leaf in those groups are appended to 'proofSet'. The stack is not
+// modified.
+func (wt *WideTree) finish(prove bool) (root []byte, proofSet [][]byte) {
+	if len(wt.stack) == 0 {
+		return nil, nil
+	}
+	if prove {
+		proofSet = append([][]byte(nil), wt.proofSet...)
+	}
+
+	// The carry is the last node of the level being joined. It starts as the
+	// shortest subtree, and ends as the root.
+	i := len(wt.stack) - 1
+	carry := wt.stack[i]
+	for height := carry.height; i > 0; height++ {
+		j := i
+		for j > 0 && wt.stack[j-1].height == height {
+			j--
+		}
+		if j == i {
+			continue
+		}
+		group := append(append([]wideSubTree(nil), wt.stack[j:i]...), carry)
+		if prove {
+			proofSet = wt.appendProofSiblings(proofSet, group)
+		}
+		carry = wt.joinGroup(group, height+1)
+		i = j
+	}
+	return carry.sum, proofSet
+}
+
+// Root returns the Merkle root of the data that has been pushed. The root of
+// an empty WideTree is nil.
+func (wt *WideTree) Root() []byte {
+	root, _ := wt.finish(false)
+	return root
+}
+
+// Prove creates a proof that the leaf at the established index (established
+// by SetIndex) is an element of the WideTree. Prove returns the Merkle root,
+// the proof set, the proof index, and the number of leaves in the WideTree. If
+// the leaf at the proof index has not been pushed, the proof set is nil.
+// Prove does not modify the WideTree.
+func (wt *WideTree) Prove() (merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) {
+	if !wt.proofTree {
+		panic("wrong usage: can't call prove on a tree if SetIndex wasn't called")
+	}
+	if wt.currentIndex <= wt.proofIndex {
+		return wt.Root(), nil, wt.proofIndex, wt.currentIndex
+	}
+	merkleRoot, proofSet = wt.finish(true)
+	return merkleRoot, proofSet, wt.proofIndex, wt.currentIndex
+}
+
+// VerifyWideProof takes a Merkle root, a proof set, and a proof index, and
+// returns true if the proof set is a proof that the leaf at the proof index is
+// an element of the WideTree with 'arity' and 'numLeaves' leaves whose root is
+// 'merkleRoot'. The proof set must hold exactly the elements that
+// WideTree.Prove produces. VerifyWideProof with arity 2 accepts the proofs
+// that VerifyProofStrict accepts.
+func VerifyWideProof(h hash.Hash, arity int, merkleRoot []byte, proofSet [][]byte, proofIndex uint64, numLeaves uint64) bool {
+	arityBits := validArity(arity)
+	if arityBits == 0 || merkleRoot == nil || proofIndex >= numLeaves || len(proofSet) == 0 {
+		return false
+	}
+	for _, sibling := range proofSet[1:] {
+		if len(sibling) != h.Size() {
+			return false
+		}
+	}
+	sum := leafSum(h, proofSet[0])
+	next := 1
+	position, width := proofIndex, numLeaves
+	children := make([][]byte, 0, arity)
+	for width > 1 {
+		// The group of the node at 'position' in a level of 'width' nodes
+		// starts at 'first' and is cut short at the end of the level.
+		first := position &^ uint64(arity-1)
+		size := width - first
+		if size > uint64(arity) {
+			size = uint64(arity)
+		}
+		if size > 1 {
+			if uint64(len(proofSet)-next) < size-1 {
+				return false
+			}
+			children = children[:0]
+			children = append(children, proofSet[next:next+int(position-first)]...)
+			children = append(children, sum)
+			children = append(children, proofSet[next+int(position-first):next+int(size-1)]...)
+			next += int(size - 1)
+			sum = wideNodeSum(h, children)
+		}
+		position >>= arityBits
+		width = (width-1)>>arityBits + 1
+	}
+	return next == len(proofSet) && bytes.Equal(sum, merkleRoot)
+}
@@ -0,0 +1,180 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"testing"
+)
+
+// wideReference is a naive WideTree that keeps every node of every level,
+// grouping each level into runs of 'arity' nodes.
+type wideReference struct {
+	arity  int
+	leaves [][]byte
+	levels [][][]byte
+}
+
+// newWideReference builds the full node matrix of a wide tree over 'leaves'.
+func newWideReference(h hash.Hash, arity int, leaves [][]byte) *wideReference {
+	wr := &wideReference{arity: arity, leaves: leaves}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = leafSum(h, leaf)
+	}
+	wr.levels = append(wr.levels, level)
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += arity {
+			end := i + arity
+			if end > len(level) {
+				end = len(level)
+			}
+			if end-i == 1 {
+				next = append(next, level[i])
+			} else {
+				next = append(next, sum(h, append([][]byte{{1}}, level[i:end]...)...))
+			}
+		}
+		wr.levels = append(wr.levels, next)
+		level = next
+	}
+	return wr
+}
+
+// root returns the root of the tree.
+func (wr *wideReference) root() []byte {
+	return wr.levels[len(wr.levels)-1][0]
+}
+
+// prove returns the proof set of the leaf at 'index'.
+func (wr *wideReference) prove(index int) [][]byte {
+	proofSet := [][]byte{wr.leaves[index]}
+	for _, level := range wr.levels[:len(wr.levels)-1] {
+		first := index - index%wr.arity
+		for i := first; i < first+wr.arity && i < len(level); i++ {
+			if i != index {
+				proofSet = append(proofSet, level[i])
+			}
+		}
+		index /= wr.arity
+	}
+	return proofSet
+}
+
+// TestWideTree compares the roots and proofs of WideTree against the naive
+// reference for several arities and awkward numbers of leaves.
+func TestWideTree(t *testing.T) {
+	sizes := []int{1, 2, 3, 4, 5, 7, 8, 9, 15, 16, 17, 31, 33, 63, 64, 65, 66, 100, 127, 129}
+	for _, arity := range []int{4, 8, 16} {
+		for _, n := range sizes {
+			leaves := randomLeaves(n, 20)
+			wr := newWideReference(sha256.New(), arity, leaves)
+			wt := NewWide(sha256.New(), arity)
+			for _, leaf := range leaves {
+				wt.Push(leaf)
+			}
+			if !bytes.Equal(wt.Root(), wr.root()) {
+				t.Fatal("root does not match the reference", arity, n)
+			}
+
+			for index := 0; index < n; index++ {
+				wt := NewWide(sha256.New(), arity)
+				if err := wt.SetIndex(uint64(index)); err != nil {
+					t.Fatal(err)
+				}
+				for _, leaf := range leaves {
+					wt.Push(leaf)
+				}
+				root, proofSet, proofIndex, numLeaves := wt.Prove()
+				expected := wr.prove(index)
+				if !bytes.Equal(root, wr.root()) || proofIndex != uint64(index) || numLeaves != uint64(n) || len(proofSet) != len(expected) {
+					t.Fatal("proof does not match the reference", arity, n, index)
+				}
+				for i := range expected {
+					if !bytes.Equal(proofSet[i], expected[i]) {
+						t.Fatal("proof element does not match the reference", arity, n, index, i)
+					}
+				}
+				if !VerifyWideProof(sha256.New(), arity, root, proofSet, uint64(index), uint64(n)) {
+					t.Fatal("proof does not verify", arity, n, index)
+				}
+				corrupt := append([][]byte(nil), proofSet...)
+				corrupt[0] = append([]byte{1}, proofSet[0]...)
+				if VerifyWideProof(sha256.New(), arity, root, corrupt, uint64(index), uint64(n)) {
+					t.Error("corrupted proof verifies", arity, n, index)
+				}
+				if len(proofSet) > 1 && VerifyWideProof(sha256.New(), arity, root, proofSet[:len(proofSet)-1], uint64(index), uint64(n)) {
+					t.Error("short proof verifies", arity, n, index)
+				}
+			}
+		}
+	}
+}
+
+// TestWideTreeBinary checks that a WideTree with arity 2 has the same roots
+// and proofs as a Tree.
+func TestWideTreeBinary(t *testing.T) {
+	for n := 1; n < 40; n++ {
+		leaves := randomLeaves(n, 20)
+		index := uint64(n / 3)
+		wt := NewWide(sha256.New(), 2)
+		tree := New(sha256.New())
+		if err := wt.SetIndex(index); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.SetIndex(index); err != nil {
+			t.Fatal(err)
+		}
+		for _, leaf := range leaves {
+			wt.Push(leaf)
+			tree.Push(leaf)
+		}
+		root, proofSet, _, _ := wt.Prove()
+		eRoot, eSet, _, _ := tree.Prove()
+		if !bytes.Equal(root, eRoot) || len(proofSet) != len(eSet) {
+			t.Fatal("wide tree does not match tree", n)
+		}
+		for i := range eSet {
+			if !bytes.Equal(proofSet[i], eSet[i]) {
+				t.Fatal("proof element does not match tree", n, i)
+			}
+		}
+		if !VerifyWideProof(sha256.New(), 2, eRoot, eSet, index, uint64(n)) {
+			t.Error("tree proof does not verify as a wide proof", n)
+		}
+	}
+}
+
+// TestWideTreeUsage checks invalid arities, and proving a leaf that has not
+// been pushed.
+func TestWideTreeUsage(t *testing.T) {
+	for _, arity := range []int{-2, 0, 1, 3, 6} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("invalid arity accepted", arity)
+				}
+			}()
+			NewWide(sha256.New(), arity)
+		}()
+		if VerifyWideProof(sha256.New(), arity, []byte{}, [][]byte{{}}, 0, 1) {
+			t.Error("proof with invalid arity verifies", arity)
+		}
+	}
+
+	wt := NewWide(sha256.New(), 4)
+	if root := wt.Root(); root != nil {
+		t.Error("empty tree has a root")
+	}
+	if err := wt.SetIndex(3); err != nil {
+		t.Fatal(err)
+	}
+	wt.Push([]byte{1})
+	if _, proofSet, _, _ := wt.Prove(); proofSet != nil {
+		t.Error("proof set returned for a leaf that was not pushed")
+	}
+	if wt.SetIndex(0) == nil {
+		t.Error("SetIndex accepted on a non-empty tree")
+	}
+}